@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/metapox/trav/pkg/s3"
+	"github.com/spf13/cobra"
+)
+
+// addAmplifyFlags は負荷試験のためにリプレイする変更を増幅するフラグを追加します
+func addAmplifyFlags(cmd *cobra.Command) {
+	cmd.Flags().Int("amplify", 1, "各変更を反映する回数 (負荷試験用。2以上の場合は宛先のキーを変えて複製)")
+	cmd.Flags().String("amplify-key-prefix", "", "複製の宛先のキーに付ける接頭辞 ({copy}、{loop} を番号に置き換え。接尾辞も省略した場合は "+s3.DefaultAmplifyKeyPrefix+")")
+	cmd.Flags().String("amplify-key-suffix", "", "複製の宛先のキーに付ける接尾辞 ({copy}、{loop} を番号に置き換え)")
+	cmd.Flags().Duration("amplify-time-shift", 0, "複製ごとに予定の時刻を遅らせる時間 (例: 100ms。n番目の複製は n倍遅らせる)")
+	cmd.Flags().Int("loops", 1, "変更リストを繰り返す回数 (1以上)")
+	cmd.Flags().Bool("loop-forever", false, "中断されるまで変更リストを繰り返す (--events-file が必要)")
+}
+
+// amplifyOptionsFromFlags はフラグから変更を増幅するオプションを取得します
+func amplifyOptionsFromFlags(cmd *cobra.Command) (s3.AmplifyOptions, error) {
+	copies, _ := cmd.Flags().GetInt("amplify")
+	keyPrefix, _ := cmd.Flags().GetString("amplify-key-prefix")
+	keySuffix, _ := cmd.Flags().GetString("amplify-key-suffix")
+	timeShift, _ := cmd.Flags().GetDuration("amplify-time-shift")
+	loops, _ := cmd.Flags().GetInt("loops")
+	forever, _ := cmd.Flags().GetBool("loop-forever")
+
+	if loops < 1 {
+		return s3.AmplifyOptions{}, errors.New("--loops には1以上を指定してください（中断されるまで繰り返す場合は --loop-forever）")
+	}
+
+	return s3.AmplifyOptions{
+		Copies:    copies,
+		KeyPrefix: keyPrefix,
+		KeySuffix: keySuffix,
+		TimeShift: timeShift,
+		Loops:     loops,
+		Forever:   forever,
+	}, nil
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"log/slog"
+
+	"github.com/metapox/trav/pkg/s3"
+	"github.com/spf13/cobra"
+)
+
+// addClientFlags は接続設定のフラグを追加します
+func addClientFlags(cmd *cobra.Command, prefix, description string) {
+	cmd.Flags().String(prefix+"-profile", "", description+"の接続に使用する共有設定ファイルのプロファイル")
+	cmd.Flags().String(prefix+"-role-arn", "", description+"の接続に引き受けるIAMロールのARN")
+	cmd.Flags().String(prefix+"-region", "", description+"のリージョン")
+	cmd.Flags().String(prefix+"-endpoint", "", description+"のエンドポイントURL (S3互換ストレージなど)")
+}
+
+// clientOptionsFromFlags はフラグから接続設定を取得します
+func clientOptionsFromFlags(cmd *cobra.Command, prefix string) s3.ClientOptions {
+	profile, _ := cmd.Flags().GetString(prefix + "-profile")
+	roleARN, _ := cmd.Flags().GetString(prefix + "-role-arn")
+	region, _ := cmd.Flags().GetString(prefix + "-region")
+	endpoint, _ := cmd.Flags().GetString(prefix + "-endpoint")
+
+	return s3.ClientOptions{
+		Profile:  profile,
+		RoleARN:  roleARN,
+		Region:   region,
+		Endpoint: endpoint,
+	}
+}
+
+// backendFromFlag はフラグにローカルのディレクトリが指定されている場合にLocalBackendを作成します
+// 指定されていない場合はnil（S3を使用）を返します
+func backendFromFlag(cmd *cobra.Command, name string) (s3.Backend, bool) {
+	root, _ := cmd.Flags().GetString(name)
+	if root == "" {
+		return nil, true
+	}
+
+	backend, err := s3.NewLocalBackend(root)
+	if err != nil {
+		slog.Error("ローカルストレージの準備に失敗しました", "error", err, name, root)
+		return nil, false
+	}
+
+	slog.Info("ローカルのディレクトリをストレージとして使用します", "root", root)
+	return backend, true
+}
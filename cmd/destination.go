@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/metapox/trav/pkg/s3"
+)
+
+// destinationConfig は宛先の設定ファイルの1件の宛先
+type destinationConfig struct {
+	Name        string   `json:"name"`        // 結果に記録する宛先の名前（省略時はバケット名）
+	Bucket      string   `json:"bucket"`      // 宛先のバケット
+	Rewrite     []string `json:"rewrite"`     // 宛先のキーの書き換えルール
+	Profile     string   `json:"profile"`     // 共有設定ファイルのプロファイル
+	RoleARN     string   `json:"roleArn"`     // 引き受けるIAMロールのARN
+	Region      string   `json:"region"`      // リージョン
+	Endpoint    string   `json:"endpoint"`    // エンドポイントURL
+	LocalRoot   string   `json:"localRoot"`   // S3の代わりに使用するローカルのディレクトリ
+	EventSink   string   `json:"eventSink"`   // イベント通知の送信先
+	EventRegion string   `json:"eventRegion"` // イベント通知のリージョン
+}
+
+// loadDestinations は宛先の設定ファイルを読み込み、リプレイの宛先の一覧を作成します
+// 作成したイベントの送信先も返すため、呼び出し側でリプレイの後に閉じてください
+func loadDestinations(ctx context.Context, filePath string, eventClient s3.ClientOptions) ([]s3.ReplayDestination, []s3.EventSink, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("宛先の設定ファイルの読み込みに失敗しました: %w", err)
+	}
+
+	var configs []destinationConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, nil, fmt.Errorf("宛先の設定ファイルのデコードに失敗しました: %w", err)
+	}
+	if len(configs) == 0 {
+		return nil, nil, fmt.Errorf("宛先の設定ファイル %s に宛先がありません", filePath)
+	}
+
+	var destinations []s3.ReplayDestination
+	var sinks []s3.EventSink
+	for _, config := range configs {
+		dest, err := newDestination(ctx, config, eventClient)
+		if err != nil {
+			closeEventSinks(sinks)
+			return nil, nil, fmt.Errorf("宛先 %s: %w", config.Bucket, err)
+		}
+		if dest.EventSink != nil {
+			sinks = append(sinks, dest.EventSink)
+		}
+		destinations = append(destinations, dest)
+	}
+
+	return destinations, sinks, nil
+}
+
+// newDestination は宛先の設定からリプレイの宛先を作成します
+func newDestination(ctx context.Context, config destinationConfig, eventClient s3.ClientOptions) (s3.ReplayDestination, error) {
+	keyRewriter, err := s3.NewKeyRewriter(config.Rewrite)
+	if err != nil {
+		return s3.ReplayDestination{}, err
+	}
+
+	dest := s3.ReplayDestination{
+		Name:        config.Name,
+		Bucket:      config.Bucket,
+		KeyRewriter: keyRewriter,
+		Client: s3.ClientOptions{
+			Profile:  config.Profile,
+			RoleARN:  config.RoleARN,
+			Region:   config.Region,
+			Endpoint: config.Endpoint,
+		},
+		EventRegion: config.EventRegion,
+	}
+
+	if config.LocalRoot != "" {
+		dest.Backend, err = s3.NewLocalBackend(config.LocalRoot)
+		if err != nil {
+			return s3.ReplayDestination{}, err
+		}
+	}
+
+	// イベントのリージョンは指定がなければ --event-region、それもなければ宛先のリージョンを使用
+	if dest.EventRegion == "" {
+		dest.EventRegion = eventClient.Region
+	}
+	if dest.EventRegion == "" {
+		dest.EventRegion = config.Region
+	}
+
+	if config.EventSink != "" {
+		dest.EventSink, err = s3.NewEventSink(ctx, config.EventSink, eventClient)
+		if err != nil {
+			return s3.ReplayDestination{}, err
+		}
+	}
+
+	return dest, nil
+}
+
+// closeEventSinks はイベントの送信先を閉じます
+func closeEventSinks(sinks []s3.EventSink) {
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			slog.Error("イベントの送信先を閉じる際にエラーが発生しました", "error", err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/metapox/trav/pkg/s3"
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "2つの時点または2つのバケットのS3オブジェクトの差分を表示します",
+	Long: `diffコマンドは、比較元と比較先のオブジェクトの状態をキーごとに比較し、
+追加・削除・変更されたオブジェクト（バージョンIDとETag）と変更のないオブジェクトの数を表示します。
+
+比較元は --bucket、--prefix、--from で指定します。--from を省略すると現在の状態になります。
+比較先は --to-bucket、--to-prefix、--to で指定します。省略した場合は比較元と同じバケット、
+プレフィックスの --to の時点（省略時は現在）の状態になります。
+キーはそれぞれのプレフィックスからの相対パスで比較されます。
+
+時点を指定した場合は、その時間より前の最新のバージョンの状態になります（rollbackコマンドと同じ基準）。
+ロールバック前に --from にロールバック先の時間を指定すると、ロールバックで変わる内容を確認できます。
+
+--from-manifest、--to-manifest を指定すると、バケットの代わりにマニフェストの状態と比較します。
+--save-manifest を指定すると、比較元の状態をマニフェストとして保存します。
+リプレイ前に期待する状態を保存しておき、リプレイ後に宛先バケットと比較することで結果を検証できます。
+マニフェストには s3://bucket/key 形式のURIや、拡張子が .gz、.zst のファイルも指定できます。
+
+比較元の接続設定は --from-profile、--from-role-arn、--from-region、--from-endpoint で、
+比較先の接続設定は --to-profile などで指定します。比較先の接続設定をいずれも省略した場合は比較元と同じ設定を使用するため、
+別のアカウントやS3互換ストレージにリプレイした宛先バケットとも比較できます。
+--local-root、--to-local-root を指定すると、S3の代わりにローカルのディレクトリ（replayコマンドの
+--dest-local-root で書き込んだもの）の状態を取得します。--to-local-root を省略した場合は --local-root と同じです。
+
+状態は削除されたキーを含むキー一覧から各キーのバージョン履歴を --concurrency の並列数で取得します。
+一時的なエラーで失敗した取得は --max-attempts の回数まで再実行し、--requests-per-second などで
+リクエスト数を制限できます。`,
+	Run: func(cmd *cobra.Command, args []string) {
+		bucket, _ := cmd.Flags().GetString("bucket")
+		prefix, _ := cmd.Flags().GetString("prefix")
+		fromStr, _ := cmd.Flags().GetString("from")
+		fromManifest, _ := cmd.Flags().GetString("from-manifest")
+		toBucket, _ := cmd.Flags().GetString("to-bucket")
+		toPrefix, _ := cmd.Flags().GetString("to-prefix")
+		toStr, _ := cmd.Flags().GetString("to")
+		toManifest, _ := cmd.Flags().GetString("to-manifest")
+		saveManifest, _ := cmd.Flags().GetString("save-manifest")
+		format, _ := cmd.Flags().GetString("format")
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		fromClient := clientOptionsFromFlags(cmd, "from")
+		toClient := clientOptionsFromFlags(cmd, "to")
+		if toClient == (s3.ClientOptions{}) {
+			toClient = fromClient
+		}
+
+		fromBackend, ok := backendFromFlag(cmd, "local-root")
+		if !ok {
+			return
+		}
+		toBackend := fromBackend
+		if cmd.Flags().Changed("to-local-root") {
+			if toBackend, ok = backendFromFlag(cmd, "to-local-root"); !ok {
+				return
+			}
+		}
+
+		if bucket == "" && fromManifest == "" {
+			slog.Error("必須パラメータが不足しています。--bucket または --from-manifest を指定してください", "bucket", bucket)
+			cmd.Help()
+			return
+		}
+
+		if format != "text" && format != "json" {
+			slog.Error("出力形式が無効です", "format", format)
+			return
+		}
+
+		rateLimit, err := rateLimitFromFlags(cmd)
+		if err != nil {
+			slog.Error("リクエスト数の制限が無効です", "error", err)
+			return
+		}
+
+		from, ok := parseDiffTimestamp(fromStr)
+		if !ok {
+			return
+		}
+		to, ok := parseDiffTimestamp(toStr)
+		if !ok {
+			return
+		}
+
+		if toBucket == "" {
+			toBucket = bucket
+		}
+		if !cmd.Flags().Changed("to-prefix") {
+			toPrefix = prefix
+		}
+
+		if toBucket == "" && toManifest == "" {
+			slog.Error("必須パラメータが不足しています。--to-bucket または --to-manifest を指定してください")
+			cmd.Help()
+			return
+		}
+
+		opts := s3.DiffOptions{
+			From: s3.DiffSource{
+				Bucket:    bucket,
+				Prefix:    prefix,
+				Timestamp: from,
+				Manifest:  fromManifest,
+				Client:    fromClient,
+				Backend:   fromBackend,
+			},
+			To: s3.DiffSource{
+				Bucket:    toBucket,
+				Prefix:    toPrefix,
+				Timestamp: to,
+				Manifest:  toManifest,
+				Client:    toClient,
+				Backend:   toBackend,
+			},
+			SaveManifest: saveManifest,
+			Concurrency:  concurrency,
+			Retry:        retryPolicyFromFlags(cmd),
+			RateLimit:    rateLimit,
+		}
+
+		slog.Info("差分の取得を開始します", "from", opts.From.String(), "to", opts.To.String())
+
+		result, err := s3.Diff(cmd.Context(), opts)
+		if err != nil {
+			slog.Error("差分の取得中にエラーが発生しました", "error", err)
+			return
+		}
+
+		if format == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(result); err != nil {
+				slog.Error("差分結果の出力に失敗しました", "error", err)
+			}
+			return
+		}
+
+		s3.PrintDiffResult(result, os.Stdout)
+	},
+}
+
+// parseDiffTimestamp は比較する時点を解析します（空の場合は現在の状態を表すゼロ値）
+func parseDiffTimestamp(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, true
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		slog.Error("タイムスタンプの形式が無効です", "error", err, "timestamp", value)
+		slog.Info("有効な形式: YYYY-MM-DDThh:mm:ssZ (例: 2023-01-01T12:00:00Z)")
+		return time.Time{}, false
+	}
+
+	return timestamp, true
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringP("bucket", "b", "", "比較元のS3バケット名 (--from-manifest を指定しない場合は必須)")
+	diffCmd.Flags().StringP("prefix", "p", "", "比較元のS3オブジェクトのプレフィックス")
+	diffCmd.Flags().String("from", "", "比較元の時点 (ISO 8601形式: YYYY-MM-DDThh:mm:ssZ) (省略時は現在)")
+	diffCmd.Flags().String("from-manifest", "", "比較元として使用するマニフェスト")
+	diffCmd.Flags().String("to-bucket", "", "比較先のS3バケット名 (省略時は比較元と同じ)")
+	diffCmd.Flags().String("to-prefix", "", "比較先のS3オブジェクトのプレフィックス (省略時は比較元と同じ)")
+	diffCmd.Flags().String("to", "", "比較先の時点 (ISO 8601形式: YYYY-MM-DDThh:mm:ssZ) (省略時は現在)")
+	diffCmd.Flags().String("to-manifest", "", "比較先として使用するマニフェスト")
+	diffCmd.Flags().String("save-manifest", "", "比較元の状態を保存するマニフェストのパスまたは s3://bucket/key")
+	diffCmd.Flags().String("format", "text", "出力形式 (text, json)")
+	diffCmd.Flags().IntP("concurrency", "c", s3.DefaultConcurrency, "キー一覧とバージョン一覧を取得する並列数")
+	diffCmd.Flags().String("local-root", "", "比較元にS3の代わりに使用するローカルのディレクトリ")
+	diffCmd.Flags().String("to-local-root", "", "比較先にS3の代わりに使用するローカルのディレクトリ (省略時は --local-root と同じ)")
+	addClientFlags(diffCmd, "from", "比較元")
+	addClientFlags(diffCmd, "to", "比較先")
+	addRetryFlags(diffCmd)
+	addRateLimitFlags(diffCmd)
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/metapox/trav/pkg/s3"
+	"github.com/spf13/cobra"
+)
+
+// addFilterFlags はリプレイする変更の絞り込みのフラグを追加します
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("include-type", nil, "リプレイする変更の種類 (CREATE, UPDATE, DELETE, RECREATE, UNDELETE。カンマ区切りまたは複数指定可)")
+	cmd.Flags().StringSlice("exclude-type", nil, "リプレイしない変更の種類 (カンマ区切りまたは複数指定可)")
+	cmd.Flags().StringArray("include-key", nil, "リプレイするキーのパターン (glob:、regex: 形式、形式を省略した場合はグロブ。複数指定可)")
+	cmd.Flags().StringArray("exclude-key", nil, "リプレイしないキーのパターン (glob:、regex: 形式、形式を省略した場合はグロブ。複数指定可)")
+	cmd.Flags().String("min-size", "", "リプレイするオブジェクトサイズの下限 (例: 1KiB。DELETEには適用しない)")
+	cmd.Flags().String("max-size", "", "リプレイするオブジェクトサイズの上限 (例: 100MiB。DELETEには適用しない)")
+	cmd.Flags().String("from", "", "リプレイする変更時刻の範囲の開始 (ISO 8601形式: YYYY-MM-DDThh:mm:ssZ、この時刻を含む)")
+	cmd.Flags().String("to", "", "リプレイする変更時刻の範囲の終了 (ISO 8601形式: YYYY-MM-DDThh:mm:ssZ、この時刻を含まない)")
+}
+
+// changeFilterFromFlags はフラグからリプレイする変更の絞り込みの条件を取得します
+// 条件を指定しない場合はnilを返します
+func changeFilterFromFlags(cmd *cobra.Command) (*s3.ChangeFilter, error) {
+	includeTypes, _ := cmd.Flags().GetStringSlice("include-type")
+	excludeTypes, _ := cmd.Flags().GetStringSlice("exclude-type")
+	includeKeys, _ := cmd.Flags().GetStringArray("include-key")
+	excludeKeys, _ := cmd.Flags().GetStringArray("exclude-key")
+	minSizeStr, _ := cmd.Flags().GetString("min-size")
+	maxSizeStr, _ := cmd.Flags().GetString("max-size")
+	fromStr, _ := cmd.Flags().GetString("from")
+	toStr, _ := cmd.Flags().GetString("to")
+
+	if len(includeTypes) == 0 && len(excludeTypes) == 0 && len(includeKeys) == 0 && len(excludeKeys) == 0 &&
+		minSizeStr == "" && maxSizeStr == "" && fromStr == "" && toStr == "" {
+		return nil, nil
+	}
+
+	opts := s3.ChangeFilterOptions{
+		IncludeKeys: includeKeys,
+		ExcludeKeys: excludeKeys,
+	}
+	for _, changeType := range includeTypes {
+		opts.IncludeTypes = append(opts.IncludeTypes, s3.ChangeType(changeType))
+	}
+	for _, changeType := range excludeTypes {
+		opts.ExcludeTypes = append(opts.ExcludeTypes, s3.ChangeType(changeType))
+	}
+
+	if minSizeStr != "" {
+		size, err := s3.ParseByteSize(minSizeStr)
+		if err != nil {
+			return nil, err
+		}
+		opts.MinSize = int64(size)
+	}
+	if maxSizeStr != "" {
+		size, err := s3.ParseByteSize(maxSizeStr)
+		if err != nil {
+			return nil, err
+		}
+		opts.MaxSize = int64(size)
+	}
+
+	var err error
+	if opts.From, err = parseFilterTimestamp("from", fromStr); err != nil {
+		return nil, err
+	}
+	if opts.To, err = parseFilterTimestamp("to", toStr); err != nil {
+		return nil, err
+	}
+
+	return s3.NewChangeFilter(opts)
+}
+
+// parseFilterTimestamp は変更時刻の範囲を解析します（空の場合はゼロ値）
+func parseFilterTimestamp(flag, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("--%s のタイムスタンプの形式が無効です（有効な形式: YYYY-MM-DDThh:mm:ssZ）: %w", flag, err)
+	}
+	return timestamp, nil
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"github.com/metapox/trav/pkg/s3"
+	"github.com/spf13/cobra"
+)
+
+// addRateLimitFlags はリクエスト数と転送量の制限のフラグを追加します
+func addRateLimitFlags(cmd *cobra.Command) {
+	cmd.Flags().Float64("requests-per-second", 0, "全体の1秒あたりのリクエスト数の上限 (0で無制限)")
+	cmd.Flags().Float64("prefix-requests-per-second", 0, "プレフィックスごとの1秒あたりのリクエスト数の上限 (0で無制限)")
+	cmd.Flags().Int("prefix-depth", s3.DefaultPrefixDepth, "--prefix-requests-per-second でプレフィックスとみなすキーの階層数")
+	cmd.Flags().String("bytes-per-second", "", "1秒あたりのコピーするバイト数の上限 (例: 50MiB、1GB。省略時は無制限)")
+}
+
+// rateLimitFromFlags はフラグからリクエスト数と転送量の制限を取得します
+func rateLimitFromFlags(cmd *cobra.Command) (s3.RateLimitOptions, error) {
+	requestsPerSecond, _ := cmd.Flags().GetFloat64("requests-per-second")
+	prefixRequestsPerSecond, _ := cmd.Flags().GetFloat64("prefix-requests-per-second")
+	prefixDepth, _ := cmd.Flags().GetInt("prefix-depth")
+	bytesPerSecondStr, _ := cmd.Flags().GetString("bytes-per-second")
+
+	var bytesPerSecond float64
+	if bytesPerSecondStr != "" {
+		var err error
+		bytesPerSecond, err = s3.ParseByteSize(bytesPerSecondStr)
+		if err != nil {
+			return s3.RateLimitOptions{}, err
+		}
+	}
+
+	return s3.RateLimitOptions{
+		RequestsPerSecond:       requestsPerSecond,
+		PrefixRequestsPerSecond: prefixRequestsPerSecond,
+		PrefixDepth:             prefixDepth,
+		BytesPerSecond:          bytesPerSecond,
+	}, nil
+}
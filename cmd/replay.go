@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
@@ -18,35 +19,333 @@ S3イベントを再現します。
 
 イベントは元の時間間隔を保ちながら実行され、同一ファイルへの操作は
 直列で処理されます。異なるファイルへの操作は並列で処理されます。
+スケジューラーが各イベントを予定の時刻に空いているワーカーに渡し、同一の宛先のキーのイベントは
+前のイベントの完了後に変更リストの順で実行します。予定の時刻から実行を開始するまでの遅延は
+各イベントの結果の lag に記録され、平均と最大の遅延が結果に出力されます。
 
 --speed-factorオプションで再生速度を調整できます。例えば、2.0を指定すると
 2倍速で再生されます。
 
---dry-runオプションを指定すると、実際に変更を適用せずに実行できます。`,
-	Run: func(cmd *cobra.Command, args []string) {
+--max-gap を指定すると、再生時の変更の間隔を指定した時間までに縮めます。夜間のような長い空白を
+数秒に縮めながら、バーストは元の間隔のまま再生できます（例: --max-gap 5s）。
+--speed-profile で、最初の変更からの元の時間ごとに再生速度を変えられます。
+<元の時間>=<倍率> をカンマ区切りで指定し、最初の区間より前は --speed-factor を使用します。
+例: --speed-profile '0s=1,2h=60,8h=1'（最初の2時間は実時間、8時間目までは60倍速、以降は実時間）
+間隔の上限は再生速度を適用した後の間隔に適用します。
+--jitter を指定すると、各イベントの予定の時刻を前後にランダムにばらつかせます。
+ばらつきは後続のイベントの予定の時刻には影響せず、同一の宛先のキーのイベントは変更リストの順に実行されます。
+
+--dry-runオプションを指定すると、実際に変更を適用せずに実行できます。
+
+--skip-applied を指定すると、各変更を反映する前に宛先の現在のオブジェクトを HeadObject で確認し、
+既に反映されている変更を実行せずに SKIPPED として数えます（理由は各イベントの結果の skipReason に記録されます）。
+削除は宛先にオブジェクトが存在しない場合、コピーは宛先のオブジェクトのETagとサイズが変更と一致する場合か、
+メタデータ x-amz-meta-trav-source-version-id に記録された変更元のバージョンIDが一致する場合に反映済みとします。
+ストリーミングコピーではETagが変わることがあるため、--skip-applied を指定した場合はコピーしたオブジェクトに
+変更元のバージョンIDを記録します。部分的に失敗したリプレイを最初から再実行する場合などに使用します。
+イベント通知のみの宛先には適用しません。
+
+--verify を指定すると、リプレイの完了後に変更リストから宛先のキーごとの最終的な状態を求め、
+宛先のオブジェクトを HeadObject で確認して一致しないキーを報告します。削除されたキーはオブジェクトが存在しないこと、
+それ以外のキーはオブジェクトが存在し、サイズと、変更元のバージョンID（trav が記録した場合）またはETagが一致することを確認します。
+マルチパートアップロードのETagはパートの分割により変わるため比較しません。--verify を指定した場合も
+ストリーミングコピーしたオブジェクトに変更元のバージョンIDを記録します。検証の結果は結果の verification に出力され、
+JUnit XMLのレポートでは不一致のキーを failure のテストケースとして追加します。
+ドライラン、中断した場合、イベント通知のみの宛先では検証しません。
+失敗したイベントや一致しないキーがある場合、リプレイ中にエラーが発生した場合は、結果を出力した後に終了コード1で終了します。
+検証では宛先のキーごとの最後の変更をメモリに保持するため、使用するメモリは変更リストの異なるキーの数に比例します。
+数千万キーを超える変更リストでは、--include-key などで対象を絞って検証してください。
+
+--source-fileには s3://bucket/key 形式のURIも指定できます。s3:// の変更リストは --source-profile などの
+変更元の接続設定で読み込みます。gzip、zstdで圧縮された変更リストは自動的に伸長されます。
+
+実行中にCtrl-C（SIGINT）またはSIGTERMを受信すると、新しいイベントの実行を停止し、
+実行中のイベントの完了を--drain-timeoutの時間まで待ってから、途中までの結果を出力します。
+--result-fileを指定すると、結果をJSON形式で保存します。
+
+--progress-fileを指定すると、各イベントの完了・失敗を進捗ファイルに定期的に記録します。
+中断した後に同じ変更リストと --progress-file、--resume を指定して実行すると、
+完了したイベントを除き、未実行のイベントと失敗したイベントを再実行します。
+再開時のタイミングは最初の未実行のイベントを基準にし、残りのイベントの間隔を保ちます。
+変更リストまたは宛先バケットが進捗ファイルと異なる場合はエラーになります。
+
+--rewriteで宛先のキーの書き換えルールを指定できます。変更元からは元のキーとバージョンを読み込み、
+書き換えたキーに書き込みます。複数指定した場合は指定した順に適用されます。
+  prefix:<元のプレフィックス>=><新しいプレフィックス>  プレフィックスを置き換え
+  regex:<正規表現>=><置き換え後>                       一致した部分を置き換え（$1、${name} でキャプチャグループを参照）
+  template:<テンプレート>                               テンプレートからキーを生成
+置き換え後の文字列とテンプレートには {{.Key}}、{{.SourceKey}}、{{.Dir}}、{{.Base}}、{{.VersionID}}、
+{{.ChangeType}}、{{.Timestamp.Format "2006-01-02"}} などの変更の情報を埋め込めます。
+例: --rewrite 'prefix:prod/=>staging/replay-{{.Timestamp.Format "2006-01"}}/'
+
+--transform を指定すると、コピーするオブジェクトの内容を宛先に書き込む前に変換します。個人情報の匿名化や
+大きなファイルの切り詰めなど、本番の内容をそのまま別の環境に書き込めない場合に使用します（複数指定した場合は指定した順に適用）。
+  exec:<コマンド> [引数...]  オブジェクトごとにコマンドを実行し、標準入力に内容を渡して標準出力を書き込む
+                             （環境変数 TRAV_SOURCE_BUCKET、TRAV_SOURCE_KEY、TRAV_VERSION_ID、TRAV_DEST_BUCKET、
+                             TRAV_DEST_KEY、TRAV_CONTENT_TYPE でオブジェクトの情報を渡します）
+  truncate:<バイト数>        先頭から指定したバイト数までに切り詰める（例: truncate:1MiB）
+内容は変換しながら宛先に書き込むため、オブジェクト全体をメモリやディスクに保持しません。
+コマンドが0以外の終了コードで終了した場合はイベントを失敗とし、宛先には書き込みません。
+変換した内容を書き込むため、サーバーサイドコピーは使用せずに常にストリーミングコピーします。
+メタデータは変更元のまま引き継ぎます。変換したオブジェクトはサイズとETagが変わるため、--skip-applied と --verify は
+trav が記録した変更元のバージョンIDで判定します。
+例: --transform 'exec:./scripts/mask-pii.py' --transform truncate:10MiB
+
+変更元と宛先が別のAWSアカウントやリージョンにある場合は、--source-profile、--source-role-arn、
+--source-region、--source-endpoint と --dest-profile、--dest-role-arn、--dest-region、--dest-endpoint で
+それぞれの接続設定を指定できます。コピーはまず宛先の認証情報でサーバーサイドコピー（CopyObject）を試し、
+変更元を読み込めない場合やオブジェクトが大きすぎる場合など、サーバーサイドコピーができない場合は
+変更元から読み込んで宛先に書き込むストリーミングコピー（大きなオブジェクトはマルチパートアップロード）に
+自動的に切り替えます。エンドポイントが異なる場合は最初からストリーミングコピーを使用します。
+
+--dest-local-root を指定すると、S3の代わりにローカルのディレクトリに書き込みます。
+本番の変更リストを手元のディレクトリにリプレイする場合などに使用します。
+バケットはディレクトリ直下のディレクトリになり、各バージョンはファイルとして、
+バージョンの一覧とメタデータはキーごとの index.json に保存されます。
+--source-local-root を指定すると、変更元もローカルのディレクトリから読み込みます。
+
+--event-sink を指定すると、オブジェクトを変更せずに、各変更をS3イベント通知（Records 形式のJSON）として
+送信するイベントのみのモードで実行します。S3のイベント通知を受け取るコンシューマーのテストに使用します。
+  sqs:<キューのURL>                       SQS（またはElasticMQなどの互換キュー）に送信
+  sns:<トピックのARN>                     SNSのトピックに発行
+  http://... または https://...          WebhookにPOST（2xx以外の応答は失敗）
+  ndjson:<ファイルパスまたは s3://...>    1行に1件のJSONとして書き込み
+  lambda:<URL>                            Lambda Runtime Interface Emulator互換のエンドポイントで関数を呼び出し
+                                          （パスを省略した場合は /2015-03-31/functions/function/invocations）
+  exec:<コマンド> [引数...]               イベントごとにコマンドを実行し、標準入力にイベントのJSONを渡す
+SQS、SNSの接続設定は --event-profile、--event-role-arn、--event-region、--event-endpoint で指定します。
+イベントの eventTime は変更が発生した時刻、バケットは宛先バケット、キーは --rewrite で書き換えた後のキーになります。
+イベント名は CREATE、UPDATE、RECREATE が ObjectCreated:Put（マルチパートのETagの場合は
+ObjectCreated:CompleteMultipartUpload）、DELETE が ObjectRemoved:DeleteMarkerCreated、
+UNDELETE（削除マーカーの削除）が ObjectRemoved:Delete です。
+lambda: と exec: では、関数の応答（HTTPのステータスコードと本文）またはコマンドの終了コードと出力を
+各イベントの結果の handler に記録します。関数がエラーを返した場合（X-Amz-Function-Error ヘッダーまたは
+errorType、errorMessage を含む応答）や、コマンドの終了コードが0以外の場合はイベントを失敗として扱います。
+ローカルで実行している関数に本番の変更を元の時間間隔で流し、障害を再現する場合などに使用します。
+例: --event-sink lambda:http://localhost:9000 --dest-bucket prod
+awsRegion には --event-region、指定しない場合は --dest-region、それもなければ us-east-1 を使用します。
+
+イベントの実行が一時的なエラー（503 SlowDown、5xx、ネットワークのエラーなど）で失敗した場合は、
+--max-attempts の回数まで再実行します。待機時間は --retry-initial-backoff から試行ごとに2倍になり
+（最大 --retry-max-backoff）、ランダムにばらつかせます。スロットリング（SlowDownなど）の場合は、
+新しいプレフィックスのパーティションが分割されるまで通常のエラーより長く待機します。
+各イベントの試行回数は結果の attempts に記録されます。同一キーの後続のイベントは再実行の完了を待ちます。
+
+共有のバケットで本番の読み込みを妨げないように、--concurrency に加えてトークンバケットで負荷を制限できます。
+  --requests-per-second         全体の1秒あたりのリクエスト数
+  --prefix-requests-per-second  宛先のキーのプレフィックス（先頭から --prefix-depth 階層）ごとの1秒あたりのリクエスト数
+  --bytes-per-second            1秒あたりのコピーするバイト数（変更リストのオブジェクトサイズで計算）
+制限に達したイベントは予定の時刻より遅れて実行されます。1秒あたりの上限より大きいオブジェクトもコピーでき、
+その分だけ後続のコピーが待機します。
+
+変更リストは全体をメモリに読み込まずに先頭から順に読み込むため、数千万件の変更リストもリプレイできます。
+ObjectChange の配列、ヘッダーを持つエンベロープ形式、1行に1件の ObjectChange を書いたNDJSONに対応し、
+形式はファイルの先頭から自動的に判定します。変更リストは --lookahead の件数だけ先読みして時刻の順に
+並べ替えます。先読みの範囲を超えて順序が逆転している変更は警告を出力し、予定より遅れて実行します
+（件数は結果の outOfOrderEvents に記録されます）。エンベロープ形式の変更リストは、途中で切れたり
+書き換えられたりした変更リストの一部を宛先に反映しないように、リプレイの前に全体を一度読み込んでサマリーの
+件数とチェックサムを検証し、一致しない場合は変更を実行せずにエラーを返します。変更リストを2回読み込む
+（s3:// の場合は2回ダウンロードする）ため、--skip-prevalidation で省略できます。省略した場合は最後の変更を
+読み込んだ後に検証し、一致しない場合はそれまでの結果とともにエラーを返します。サマリーを持たない配列形式と
+NDJSONは検証しません。--progress-file を指定した場合は、進捗ファイルと照合するためにリプレイの前に
+変更リストを一度読み込みます（この読み込みでサマリーも検証します）。
+--events-file を指定すると、各イベントの結果をメモリに保持せずに1行に1件のJSONとして書き込み、
+結果には件数と遅延の集計のみを出力します（s3://bucket/key 形式、.gz、.zst の拡張子での圧縮にも対応）。
+
+--report を指定すると、CIなどで処理できる形式でリプレイ結果のレポートを出力します（複数指定可）。
+  json:<パス>   全てのイベントの結果（予定の時刻からの遅延 lag、実行にかかった時間 duration を含む）を含むReplayResult
+  csv:<パス>    1行に1件のイベントの結果
+  junit:<パス>  1件のイベントを1つのテストケースとするJUnit XML（失敗したイベントは failure、
+                ドライラン、反映済みのためスキップしたイベントと中断で打ち切られたイベントは skipped、中断された場合は error のテストケースを追加）
+形式を省略した場合は拡張子（.json、.csv、.xml）から判定します。s3://bucket/key 形式、.gz、.zst の拡張子での
+圧縮にも対応します。--events-file を指定した場合も、そのファイルから読み込んで全てのイベントを出力します。
+例: --report junit:replay-report.xml --report replay-events.csv
+
+変更リストを読み込む際に、リプレイする変更を絞り込めます。条件を組み合わせた場合は全てに一致する変更のみをリプレイします。
+  --include-type、--exclude-type  変更の種類（例: --include-type DELETE）
+  --include-key、--exclude-key    キーのパターン。glob:<グロブ>（* は / を含まず、** は / を含む任意の文字列に一致）
+                                  または regex:<正規表現> で指定し、形式を省略した場合はグロブとみなします
+  --min-size、--max-size          オブジェクトサイズの範囲（DELETEには適用しません）
+  --from、--to                    変更時刻の範囲（--from の時刻を含み、--to の時刻を含まない）
+タイミングは絞り込んだ後の最初の変更を基準にします。除外した変更の件数は結果の filteredEvents に記録されます。
+--progress-file を指定した場合、進捗は絞り込んだ後の変更リストに対して記録されるため、再開時は同じ条件を指定してください。
+例: --include-type DELETE --from 2025-06-05T10:00:00Z --to 2025-06-05T11:00:00Z --include-key 'tenant-a/**'
+
+--destinations-file を指定すると、--dest-bucket の代わりに複数の宛先に同じ変更をリプレイします。
+各変更は全ての宛先に同じ予定の時刻で渡されるため、別々にリプレイした場合のように宛先ごとのタイミングがずれません。
+同一キーの操作は宛先ごとに変更リストの順で直列に実行されます。
+設定ファイルは宛先の配列のJSONで、宛先ごとにキーの書き換えルール、接続設定、ローカルのディレクトリ、
+イベントの送信先を指定できます（--rewrite、--dest-* の代わりに使用します。SQS、SNSの接続設定は --event-* を使用します）。
+  [
+    {"name": "stg1", "bucket": "staging-1", "profile": "stg1"},
+    {"name": "stg2", "bucket": "staging-2", "rewrite": ["prefix:prod/=>stg2/"], "region": "ap-northeast-1"},
+    {"name": "local", "bucket": "prod", "localRoot": "./replay-data"},
+    {"name": "consumer", "bucket": "prod", "eventSink": "sqs:https://sqs.ap-northeast-1.amazonaws.com/123456789012/events"}
+  ]
+各イベントの結果の destination に宛先の名前（省略時はバケット名）が記録され、宛先ごとの件数が結果の destinations に出力されます。
+リクエスト数と転送量の制限は全ての宛先で共有します。--progress-file を指定した場合は、全ての宛先で完了した変更のみを完了、
+いずれかの宛先で失敗した変更を失敗として記録し、再開時は未実行の変更を全ての宛先に再度リプレイします。
+
+S3のイベントで起動するパイプラインの負荷試験のために、本番のトラフィックの形を保ったまま変更を増幅できます。
+  --amplify              各変更を反映する回数。2以上の場合は宛先のキーに接頭辞・接尾辞を付けて複製します
+  --amplify-key-prefix   複製のキーの接頭辞（省略時は接尾辞も省略した場合に amplify-{copy}/）
+  --amplify-key-suffix   複製のキーの接尾辞
+  --amplify-time-shift   複製ごとに予定の時刻を遅らせる時間（n番目の複製は n倍遅らせる。--ignore-time-windows では無視）
+  --loops                変更リストを繰り返す回数（1以上）
+  --loop-forever         中断されるまで変更リストを繰り返す（結果をメモリに保持しないように --events-file が必要）
+接頭辞と接尾辞の {copy} は複製の番号（0から）、{loop} は繰り返した回数（0から）に置き換えられ、同じ変更リストからは
+常に同じキーになります。複製する場合はキーが重複しないように {copy} を含めてください。宛先のキーは --rewrite で
+書き換えた後に接頭辞・接尾辞を付けます。繰り返す場合は前の回の最後の変更の予定の時刻から次の回を始めます。
+各イベントの結果の copy と loop に複製の番号と繰り返した回数が記録されます。
+--progress-file と --verify は同時に指定できません。大きな --amplify-time-shift を指定する場合は、
+予定の時刻が先のイベントを保持できるように --lookahead を増やしてください。
+例: --amplify 10 --amplify-key-prefix 'load/{copy}/' --amplify-time-shift 50ms --loop-forever --events-file events.ndjson`,
+	// 結果を出力した後のエラーで使い方を表示せず、エラーはExecuteでログに出力する
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		sourceBucket, _ := cmd.Flags().GetString("source-bucket")
 		destBucket, _ := cmd.Flags().GetString("dest-bucket")
 		sourceFile, _ := cmd.Flags().GetString("source-file")
 		concurrency, _ := cmd.Flags().GetInt("concurrency")
 		speedFactor, _ := cmd.Flags().GetFloat64("speed-factor")
+		speedProfileStr, _ := cmd.Flags().GetString("speed-profile")
+		maxGap, _ := cmd.Flags().GetDuration("max-gap")
+		jitter, _ := cmd.Flags().GetDuration("jitter")
 		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		skipApplied, _ := cmd.Flags().GetBool("skip-applied")
+		verify, _ := cmd.Flags().GetBool("verify")
 		ignoreTimeWindows, _ := cmd.Flags().GetBool("ignore-time-windows")
+		compressionStr, _ := cmd.Flags().GetString("compression")
+		drainTimeout, _ := cmd.Flags().GetDuration("drain-timeout")
+		resultFile, _ := cmd.Flags().GetString("result-file")
+		progressFile, _ := cmd.Flags().GetString("progress-file")
+		resume, _ := cmd.Flags().GetBool("resume")
+		rewriteRules, _ := cmd.Flags().GetStringArray("rewrite")
+		transformSpecs, _ := cmd.Flags().GetStringArray("transform")
+		sourceClient := clientOptionsFromFlags(cmd, "source")
+		destClient := clientOptionsFromFlags(cmd, "dest")
+		eventSinkSpec, _ := cmd.Flags().GetString("event-sink")
+		eventClient := clientOptionsFromFlags(cmd, "event")
+		lookahead, _ := cmd.Flags().GetInt("lookahead")
+		eventsFile, _ := cmd.Flags().GetString("events-file")
+		reportSpecs, _ := cmd.Flags().GetStringArray("report")
+		skipPrevalidation, _ := cmd.Flags().GetBool("skip-prevalidation")
+
+		sourceBackend, ok := backendFromFlag(cmd, "source-local-root")
+		if !ok {
+			return nil
+		}
+		destBackend, ok := backendFromFlag(cmd, "dest-local-root")
+		if !ok {
+			return nil
+		}
 
 		if sourceFile == "" {
 			slog.Error("必須パラメータが不足しています", "source-file", sourceFile)
 			cmd.Help()
-			return
+			return nil
 		}
 
-		if destBucket == "" {
+		destinationsFile, _ := cmd.Flags().GetString("destinations-file")
+		if destBucket == "" && destinationsFile == "" {
 			slog.Error("必須パラメータが不足しています", "dest-bucket", destBucket)
 			cmd.Help()
-			return
+			return nil
+		}
+
+		if destBucket != "" && destinationsFile != "" {
+			slog.Error("--dest-bucket と --destinations-file は同時に指定できません")
+			return nil
+		}
+
+		if resume && progressFile == "" {
+			slog.Error("--resume を指定する場合は --progress-file も指定してください")
+			cmd.Help()
+			return nil
+		}
+
+		amplify, err := amplifyOptionsFromFlags(cmd)
+		if err != nil {
+			slog.Error("増幅のオプションが無効です", "error", err)
+			return nil
+		}
+
+		keyRewriter, err := s3.NewKeyRewriter(rewriteRules)
+		if err != nil {
+			slog.Error("キーの書き換えルールが無効です", "error", err)
+			return nil
+		}
+
+		transformer, err := s3.NewObjectTransformer(transformSpecs)
+		if err != nil {
+			slog.Error("オブジェクトの変換の指定が無効です", "error", err)
+			return nil
+		}
+
+		var reports []s3.ReportSpec
+		for _, spec := range reportSpecs {
+			report, err := s3.ParseReportSpec(spec)
+			if err != nil {
+				slog.Error("レポートの出力先が無効です", "error", err)
+				return nil
+			}
+			reports = append(reports, report)
+		}
+
+		speedProfile, err := s3.ParseSpeedProfile(speedProfileStr)
+		if err != nil {
+			slog.Error("再生速度のプロファイルが無効です", "error", err)
+			return nil
+		}
+
+		filter, err := changeFilterFromFlags(cmd)
+		if err != nil {
+			slog.Error("変更の絞り込みの条件が無効です", "error", err)
+			return nil
+		}
+
+		compression, err := s3.ParseCompressionType(compressionStr)
+		if err != nil {
+			slog.Error("圧縮形式が無効です", "error", err, "compression", compressionStr)
+			return nil
+		}
+
+		var eventSink s3.EventSink
+		if eventSinkSpec != "" {
+			eventSink, err = s3.NewEventSink(cmd.Context(), eventSinkSpec, eventClient)
+			if err != nil {
+				slog.Error("イベントの送信先を作成できませんでした", "error", err, "eventSink", eventSinkSpec)
+				return nil
+			}
+			defer func() {
+				if err := eventSink.Close(); err != nil {
+					slog.Error("イベントの送信先を閉じる際にエラーが発生しました", "error", err)
+				}
+			}()
+		}
+
+		// イベントのリージョンは指定がなければ宛先のリージョンを使用
+		eventRegion := eventClient.Region
+		if eventRegion == "" {
+			eventRegion = destClient.Region
+		}
+
+		var destinations []s3.ReplayDestination
+		if destinationsFile != "" {
+			var sinks []s3.EventSink
+			destinations, sinks, err = loadDestinations(cmd.Context(), destinationsFile, eventClient)
+			if err != nil {
+				slog.Error("宛先の設定が無効です", "error", err, "file", destinationsFile)
+				return nil
+			}
+			defer closeEventSinks(sinks)
 		}
 
-		// ソースバケットが指定されていない場合は宛先バケットと同じにする
-		if sourceBucket == "" {
-			sourceBucket = destBucket
+		rateLimit, err := rateLimitFromFlags(cmd)
+		if err != nil {
+			slog.Error("転送量の制限が無効です", "error", err)
+			return nil
 		}
 
 		slog.Info("リプレイを開始します", 
@@ -62,29 +361,93 @@ S3イベントを再現します。
 			SourceBucket:      sourceBucket,
 			DestBucket:        destBucket,
 			SourceFile:        sourceFile,
+			Compression:       compression,
 			Concurrency:       concurrency,
 			SpeedFactor:       speedFactor,
 			DryRun:            dryRun,
 			StartTime:         time.Now(),
 			IgnoreTimeWindows: ignoreTimeWindows,
+			DrainTimeout:      drainTimeout,
+			ProgressFile:      progressFile,
+			Resume:            resume,
+			KeyRewriter:       keyRewriter,
+			Source:            sourceClient,
+			Dest:              destClient,
+			SourceBackend:     sourceBackend,
+			DestBackend:       destBackend,
+			EventSink:         eventSink,
+			EventRegion:       eventRegion,
+			Retry:             retryPolicyFromFlags(cmd),
+			RateLimit:         rateLimit,
+			Lookahead:         lookahead,
+			EventsFile:        eventsFile,
+			Filter:            filter,
+			MaxGap:            maxGap,
+			SpeedProfile:      speedProfile,
+			Jitter:            jitter,
+			Destinations:      destinations,
+			SkipApplied:       skipApplied,
+			Verify:            verify,
+			Transformer:       transformer,
+			Amplify:           amplify,
+			SkipPrevalidation: skipPrevalidation,
 		}
 
-		result, err := s3.Replay(opts)
-		if err != nil {
-			slog.Error("リプレイ中にエラーが発生しました", "error", err)
-			return
+		result, err := s3.Replay(cmd.Context(), opts)
+		if result == nil {
+			return fmt.Errorf("リプレイ中にエラーが発生しました: %w", err)
 		}
 
-		// 結果を出力
+		// 結果を出力（中断された場合も途中までの結果を出力する）
 		s3.PrintReplayResult(result, os.Stdout)
 
+		// 中断された場合も結果を保存するため、結果の書き込みは中断しない
+		outputCtx := context.WithoutCancel(cmd.Context())
+
+		// 詳細な結果をファイルに出力
+		outputFile, _ := cmd.Flags().GetString("output")
+		if outputFile != "" {
+			writeReplayResultText(outputFile, result)
+		}
+
+		if resultFile != "" {
+			if err := s3.SaveReplayResult(outputCtx, resultFile, result); err != nil {
+				slog.Error("リプレイ結果の保存に失敗しました", "file", resultFile, "error", err)
+			} else {
+				slog.Info("リプレイ結果を保存しました", "file", resultFile)
+			}
+		}
+
+		for _, report := range reports {
+			if err := s3.WriteReplayReport(outputCtx, report, result); err != nil {
+				slog.Error("レポートの出力に失敗しました", "file", report.Path, "format", report.Format, "error", err)
+			} else {
+				slog.Info("レポートを出力しました", "file", report.Path, "format", report.Format)
+			}
+		}
+
+		if result.Interrupted {
+			slog.Warn("リプレイが中断されました",
+				"total", result.TotalEvents,
+				"success", result.SuccessEvents,
+				"failed", result.FailedEvents,
+				"canceled", result.CanceledEvents)
+			return nil
+		}
+
+		// 失敗したイベントや宛先の状態の不一致がある場合は、CIなどで検出できるように終了コードを0以外にする
+		if err != nil {
+			return fmt.Errorf("リプレイ中にエラーが発生しました: %w", err)
+		}
+
 		if result.FailedEvents > 0 {
-			slog.Error("リプレイが完了しましたが、一部のイベントが失敗しました", 
-				"total", result.TotalEvents, 
-				"success", result.SuccessEvents, 
-				"failed", result.FailedEvents, 
-				"skipped", result.SkippedEvents)
-			return
+			return fmt.Errorf("リプレイが完了しましたが、一部のイベントが失敗しました (全 %d 件, 成功 %d 件, 失敗 %d 件, スキップ %d 件)",
+				result.TotalEvents, result.SuccessEvents, result.FailedEvents, result.SkippedEvents)
+		}
+
+		if result.Verification != nil && result.Verification.MismatchedKeys > 0 {
+			return fmt.Errorf("リプレイが完了しましたが、宛先の状態が変更リストと一致しないキーがあります (検証 %d 件, 不一致 %d 件)",
+				result.Verification.CheckedKeys, result.Verification.MismatchedKeys)
 		}
 
 		if dryRun {
@@ -96,37 +459,63 @@ S3イベントを再現します。
 				"total", result.TotalEvents, 
 				"success", result.SuccessEvents)
 		}
+		return nil
+	},
+}
 
-		// 詳細な結果をファイルに出力するオプションを追加することも可能
-		outputFile, _ := cmd.Flags().GetString("output")
-		if outputFile != "" {
-			file, err := os.Create(outputFile)
-			if err != nil {
-				slog.Error("結果ファイルの作成に失敗しました", "file", outputFile, "error", err)
-				return
-			}
-			defer file.Close()
+// writeReplayResultText はリプレイ結果をテキスト形式でファイルに出力します
+func writeReplayResultText(outputFile string, result *s3.ReplayResult) {
+	file, err := os.Create(outputFile)
+	if err != nil {
+		slog.Error("結果ファイルの作成に失敗しました", "file", outputFile, "error", err)
+		return
+	}
+	defer file.Close()
 
-			fmt.Fprintf(file, "リプレイ詳細結果\n")
-			fmt.Fprintf(file, "実行日時: %s\n\n", time.Now().Format(time.RFC3339))
-			s3.PrintReplayResult(result, file)
-			slog.Info("詳細結果をファイルに保存しました", "file", outputFile)
-		}
-	},
+	fmt.Fprintf(file, "リプレイ詳細結果\n")
+	fmt.Fprintf(file, "実行日時: %s\n\n", time.Now().Format(time.RFC3339))
+	s3.PrintReplayResult(result, file)
+	slog.Info("詳細結果をファイルに保存しました", "file", outputFile)
 }
 
 func init() {
 	rootCmd.AddCommand(replayCmd)
 
-	replayCmd.Flags().StringP("source-file", "f", "", "変更リストのファイルパス (必須)")
-	replayCmd.Flags().StringP("source-bucket", "s", "", "変更元のバケット (指定しない場合は宛先バケットと同じ)")
-	replayCmd.Flags().StringP("dest-bucket", "b", "", "変更先のバケット (必須)")
+	replayCmd.Flags().StringP("source-file", "f", "", "変更リストのファイルパスまたは s3://bucket/key (必須)")
+	replayCmd.Flags().StringP("source-bucket", "s", "", "変更元のバケット (指定しない場合は変更リストのヘッダー、なければ宛先バケットと同じ)")
+	replayCmd.Flags().StringP("dest-bucket", "b", "", "変更先のバケット (--destinations-file を指定しない場合は必須)")
+	replayCmd.Flags().String("destinations-file", "", "複数の宛先にリプレイする場合の宛先の設定ファイル (JSON)")
 	replayCmd.Flags().IntP("concurrency", "c", 10, "並列処理数")
 	replayCmd.Flags().Float64P("speed-factor", "x", 1.0, "再生速度の倍率 (1.0 = 実時間、2.0 = 2倍速)")
 	replayCmd.Flags().BoolP("dry-run", "n", false, "実際に変更を適用せずに実行")
+	replayCmd.Flags().Bool("skip-applied", false, "宛先に既に反映されている変更をスキップ")
+	replayCmd.Flags().Bool("verify", false, "リプレイの完了後に宛先の状態が変更リストと一致するかを検証")
 	replayCmd.Flags().Bool("ignore-time-windows", false, "時間間隔を無視して即時実行")
+	replayCmd.Flags().Duration("max-gap", 0, "再生時の変更の間隔の上限 (例: 5s。0で無制限)")
+	replayCmd.Flags().String("speed-profile", "", "最初の変更からの元の時間ごとの再生速度 (例: 0s=1,2h=60,8h=1)")
+	replayCmd.Flags().Duration("jitter", 0, "各イベントの予定の時刻を前後にばらつかせる最大の時間 (例: 200ms)")
 	replayCmd.Flags().StringP("output", "o", "", "詳細結果の出力ファイルパス")
+	replayCmd.Flags().String("result-file", "", "リプレイ結果をJSON形式で保存するファイルパスまたは s3://bucket/key (中断した場合も途中までの結果を保存)")
+	replayCmd.Flags().Duration("drain-timeout", s3.DefaultDrainTimeout, "中断時に実行中のイベントの完了を待つ時間")
+	replayCmd.Flags().String("progress-file", "", "リプレイの進捗を記録するファイルパス")
+	replayCmd.Flags().Bool("resume", false, "進捗ファイルの未実行・失敗したイベントからリプレイを再開")
+	replayCmd.Flags().StringArray("rewrite", nil, "宛先のキーの書き換えルール (prefix:、regex:、template: 形式、複数指定可)")
+	replayCmd.Flags().StringArray("transform", nil, "コピーするオブジェクトの内容を書き込む前に変換 (exec:、truncate: 形式、複数指定可)")
+	addClientFlags(replayCmd, "source", "変更元")
+	addClientFlags(replayCmd, "dest", "宛先")
+	replayCmd.Flags().String("source-local-root", "", "変更元としてS3の代わりに使用するローカルのディレクトリ")
+	replayCmd.Flags().String("dest-local-root", "", "宛先としてS3の代わりに使用するローカルのディレクトリ")
+	replayCmd.Flags().String("event-sink", "", "オブジェクトを変更せずにS3イベント通知を送信する送信先 (sqs:、sns:、ndjson:、lambda:、exec:、http(s):// 形式)")
+	addClientFlags(replayCmd, "event", "イベントの送信先")
+	addRetryFlags(replayCmd)
+	addRateLimitFlags(replayCmd)
+	addFilterFlags(replayCmd)
+	addAmplifyFlags(replayCmd)
+	replayCmd.Flags().Int("lookahead", s3.DefaultLookahead, "変更リストを時刻の順に並べ替えるために先読みする変更の件数")
+	replayCmd.Flags().String("events-file", "", "各イベントの結果を1行に1件のJSONとして書き込むファイルパスまたは s3://bucket/key (指定した場合は結果をメモリに保持しない)")
+	replayCmd.Flags().StringArray("report", nil, "リプレイ結果のレポートの出力先 (json:、csv:、junit: 形式、形式を省略した場合は拡張子から判定、複数指定可)")
+	replayCmd.Flags().Bool("skip-prevalidation", false, "エンベロープ形式の変更リストのサマリーをリプレイの前に検証しない (変更リストの読み込みが1回になる)")
+	replayCmd.Flags().String("compression", "auto", "変更リストの圧縮形式 (auto, none, gzip, zstd)。autoの場合は拡張子またはファイル先頭から判定")
 
 	replayCmd.MarkFlagRequired("source-file")
-	replayCmd.MarkFlagRequired("dest-bucket")
 }
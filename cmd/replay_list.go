@@ -17,10 +17,29 @@ var replayListCmd = &cobra.Command{
 後でリプレイしやすいフォーマットで出力します。
 
 出力はJSONフォーマットで、各オブジェクトの変更履歴が含まれます。
+変更の種類はキーの全バージョン履歴から判定され、CREATE（作成）、UPDATE（更新）、
+DELETE（削除マーカーの作成）、RECREATE（削除後の再作成）、UNDELETE（削除マーカーの削除による復元）のいずれかになります。
+削除マーカーの削除はバージョン一覧に残らないため、UNDELETEは--since-listまたは--state-fileで
+前回の取得結果と比較した場合にのみ検出されます。
 この出力は後でreplayコマンドで使用することができます。
 
 大量のオブジェクトを処理する場合は、--concurrencyオプションで並列処理数を
---batch-sizeオプションでバッチサイズを調整することができます。`,
+--batch-sizeオプションでバッチサイズを調整することができます。
+オブジェクト一覧の取得もプレフィックスやキー範囲ごとに分割し、--concurrencyの並列数で行います。
+
+--outputには s3://bucket/key 形式のURIも指定できます。
+出力ファイルの拡張子が .gz または .zst の場合は、それぞれgzip、zstdで圧縮されます。
+--compressionオプションで圧縮形式を明示的に指定することもできます。
+
+--since-listに前回出力した変更リストを指定すると、その続きから新しい変更のみを取得します。
+--state-fileを指定すると、取得結果の到達点を状態ファイルに保存し、次回はその続きから取得します。
+これらを指定した場合、--timestampは省略できます。
+
+--local-root を指定すると、S3の代わりにローカルのディレクトリ（replayコマンドの --dest-local-root で
+書き込んだもの）から変更を取得します。バケットはディレクトリ直下のディレクトリになります。
+
+オブジェクト一覧やバージョン一覧の取得が一時的なエラー（503 SlowDown、5xx、ネットワークのエラーなど）で
+失敗した場合は、--max-attempts の回数まで待機時間を延ばしながら再実行します。`,
 	Run: func(cmd *cobra.Command, args []string) {
 		bucket, _ := cmd.Flags().GetString("bucket")
 		prefix, _ := cmd.Flags().GetString("prefix")
@@ -28,61 +47,137 @@ var replayListCmd = &cobra.Command{
 		outputFile, _ := cmd.Flags().GetString("output")
 		concurrency, _ := cmd.Flags().GetInt("concurrency")
 		batchSize, _ := cmd.Flags().GetInt("batch-size")
+		compressionStr, _ := cmd.Flags().GetString("compression")
+		sinceList, _ := cmd.Flags().GetString("since-list")
+		stateFile, _ := cmd.Flags().GetString("state-file")
+		overlap, _ := cmd.Flags().GetDuration("overlap")
 
-		if bucket == "" || timestampStr == "" {
-			slog.Error("必須パラメータが不足しています", "bucket", bucket, "timestamp", timestampStr)
+		backend, ok := backendFromFlag(cmd, "local-root")
+		if !ok {
+			return
+		}
+
+		if bucket == "" {
+			slog.Error("必須パラメータが不足しています", "bucket", bucket)
 			cmd.Help()
 			return
 		}
 
-		timestamp, err := time.Parse(time.RFC3339, timestampStr)
+		var timestamp time.Time
+		if timestampStr != "" {
+			var err error
+			timestamp, err = time.Parse(time.RFC3339, timestampStr)
+			if err != nil {
+				slog.Error("タイムスタンプの形式が無効です", "error", err, "timestamp", timestampStr)
+				slog.Info("有効な形式: YYYY-MM-DDThh:mm:ssZ (例: 2023-01-01T12:00:00Z)")
+				return
+			}
+		}
+
+		compression, err := s3.ParseCompressionType(compressionStr)
 		if err != nil {
-			slog.Error("タイムスタンプの形式が無効です", "error", err, "timestamp", timestampStr)
-			slog.Info("有効な形式: YYYY-MM-DDThh:mm:ssZ (例: 2023-01-01T12:00:00Z)")
+			slog.Error("圧縮形式が無効です", "error", err, "compression", compressionStr)
+			return
+		}
+
+		// 前回の取得結果を読み込む
+		var since *s3.Watermark
+		if sinceList != "" {
+			since, err = s3.WatermarkFromChangeList(cmd.Context(), sinceList, s3.ChangesFileOptions{}, overlap)
+			if err != nil {
+				slog.Error("前回の変更リストの読み込みに失敗しました", "file", sinceList, "error", err)
+				return
+			}
+		} else if stateFile != "" {
+			since, err = s3.LoadWatermark(stateFile)
+			if err != nil {
+				slog.Error("状態ファイルの読み込みに失敗しました", "file", stateFile, "error", err)
+				return
+			}
+			if since != nil && cmd.Flags().Changed("overlap") {
+				since.Overlap = overlap
+			}
+		}
+
+		if since == nil && timestampStr == "" {
+			slog.Error("必須パラメータが不足しています。--timestamp、--since-list、既存の --state-file のいずれかを指定してください", "timestamp", timestampStr)
+			cmd.Help()
 			return
 		}
 
-		slog.Info("変更リストの取得を開始します", "bucket", bucket, "prefix", prefix, "timestamp", timestamp.Format(time.RFC3339))
-		
 		opts := s3.ReplayListOptions{
 			Bucket:      bucket,
 			Prefix:      prefix,
 			Timestamp:   timestamp,
 			Concurrency: concurrency,
 			BatchSize:   batchSize,
+			Since:       since,
+			Backend:     backend,
+			Retry:       retryPolicyFromFlags(cmd),
 		}
+
+		// 今回の取得結果の到達点（前回の続きとして更新する）
+		var next *s3.Watermark
+		if since != nil {
+			next = since.Clone()
+		} else {
+			next = s3.NewWatermark(bucket, prefix, timestamp, overlap)
+		}
+
+		windowEnd := time.Now().UTC()
+		fileOpts := s3.ChangesFileOptions{
+			Compression: compression,
+			Header: &s3.ChangeListHeader{
+				SourceType:   s3.SourceTypeListObjectVersions,
+				SourceBucket: bucket,
+				SourcePrefix: prefix,
+				WindowStart:  opts.EffectiveTimestamp(),
+				WindowEnd:    windowEnd,
+				ToolVersion:  version,
+			},
+		}
+
+		slog.Info("変更リストの取得を開始します", "bucket", bucket, "prefix", prefix, "timestamp", opts.EffectiveTimestamp().Format(time.RFC3339))
 		
 		// 出力先の設定
 		var writer s3.ChangesWriter
 		
 		if outputFile != "" {
 			// ファイルに出力
-			fileWriter, err := s3.NewFileChangesWriter(outputFile)
+			fileWriter, err := s3.NewFileChangesWriterWithOptions(cmd.Context(), outputFile, fileOpts)
 			if err != nil {
 				slog.Error("出力ファイルの作成に失敗しました", "file", outputFile, "error", err)
 				return
 			}
-			defer fileWriter.Close()
 			writer = fileWriter
 			
 			// ストリーミング処理を実行
-			err = s3.ProcessChangesStreaming(opts, func(changes []s3.ObjectChange) error {
+			err = s3.ProcessChangesStreaming(cmd.Context(), opts, func(changes []s3.ObjectChange) error {
+				next.Advance(changes)
 				return writer.WriteChanges(changes)
 			})
 			
 			if err != nil {
+				writer.Close()
 				slog.Error("変更リストの処理中にエラーが発生しました", "error", err)
 				return
 			}
 			
+			// S3へのアップロードや圧縮の完了はCloseで確定するため、エラーを確認する
+			if err := writer.Close(); err != nil {
+				slog.Error("出力ファイルの書き込みに失敗しました", "file", outputFile, "error", err)
+				return
+			}
+			
 			slog.Info("変更リストをファイルに保存しました", "file", outputFile)
 		} else {
 			// メモリに全て読み込んでから標準出力に出力
-			changes, err := s3.GetChangesList(opts)
+			changes, err := s3.GetChangesList(cmd.Context(), opts)
 			if err != nil {
 				slog.Error("変更リストの取得中にエラーが発生しました", "error", err)
 				return
 			}
+			next.Advance(changes)
 			
 			// 一時ファイルに書き込んでから標準出力にコピー
 			tempFile, err := os.CreateTemp("", "trav-changes-*.json")
@@ -92,7 +187,7 @@ var replayListCmd = &cobra.Command{
 			}
 			defer os.Remove(tempFile.Name())
 			
-			fileWriter, err := s3.NewFileChangesWriter(tempFile.Name())
+			fileWriter, err := s3.NewFileChangesWriterWithOptions(cmd.Context(), tempFile.Name(), fileOpts)
 			if err != nil {
 				slog.Error("一時ファイルの作成に失敗しました", "error", err)
 				return
@@ -104,7 +199,10 @@ var replayListCmd = &cobra.Command{
 				return
 			}
 			
-			fileWriter.Close()
+			if err := fileWriter.Close(); err != nil {
+				slog.Error("一時ファイルへの書き込みに失敗しました", "error", err)
+				return
+			}
 			
 			// 一時ファイルを標準出力にコピー
 			data, err := os.ReadFile(tempFile.Name())
@@ -116,6 +214,16 @@ var replayListCmd = &cobra.Command{
 			os.Stdout.Write(data)
 			slog.Info("変更リストを標準出力に出力しました", "changes", len(changes))
 		}
+
+		// 出力が完了した場合のみ状態ファイルを更新する
+		if stateFile != "" {
+			next.AdvanceTo(windowEnd)
+			if err := next.Save(stateFile); err != nil {
+				slog.Error("状態ファイルの保存に失敗しました", "file", stateFile, "error", err)
+				return
+			}
+			slog.Info("状態ファイルを保存しました", "file", stateFile, "watermark", next.Timestamp.Format(time.RFC3339))
+		}
 	},
 }
 
@@ -124,11 +232,16 @@ func init() {
 
 	replayListCmd.Flags().StringP("bucket", "b", "", "S3バケット名 (必須)")
 	replayListCmd.Flags().StringP("prefix", "p", "", "S3オブジェクトのプレフィックス")
-	replayListCmd.Flags().StringP("timestamp", "t", "", "取得開始時間 (ISO 8601形式: YYYY-MM-DDThh:mm:ssZ) (必須)")
-	replayListCmd.Flags().StringP("output", "o", "", "出力ファイルパス (指定しない場合は標準出力)")
+	replayListCmd.Flags().StringP("timestamp", "t", "", "取得開始時間 (ISO 8601形式: YYYY-MM-DDThh:mm:ssZ) (--since-list、--state-file を指定しない場合は必須)")
+	replayListCmd.Flags().StringP("output", "o", "", "出力ファイルパスまたは s3://bucket/key (指定しない場合は標準出力)")
 	replayListCmd.Flags().IntP("concurrency", "c", 10, "並列処理数")
 	replayListCmd.Flags().Int("batch-size", 1000, "バッチサイズ (一度に処理するオブジェクト数)")
+	replayListCmd.Flags().String("compression", "auto", "出力の圧縮形式 (auto, none, gzip, zstd)。autoの場合は拡張子から判定")
+	replayListCmd.Flags().String("since-list", "", "前回出力した変更リスト (指定した場合はその続きから取得)")
+	replayListCmd.Flags().String("state-file", "", "取得結果の到達点を保存する状態ファイル (存在する場合はその続きから取得)")
+	replayListCmd.Flags().Duration("overlap", s3.DefaultWatermarkOverlap, "前回の到達点から遡って再取得する期間 (取得済みの変更は除外されます)")
+	replayListCmd.Flags().String("local-root", "", "S3の代わりに使用するローカルのディレクトリ")
+	addRetryFlags(replayListCmd)
 	
 	replayListCmd.MarkFlagRequired("bucket")
-	replayListCmd.MarkFlagRequired("timestamp")
 }
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"github.com/metapox/trav/pkg/s3"
+	"github.com/spf13/cobra"
+)
+
+// addRetryFlags はリトライのフラグを追加します
+func addRetryFlags(cmd *cobra.Command) {
+	cmd.Flags().Int("max-attempts", s3.DefaultMaxAttempts, "一時的なエラー（503 SlowDown、5xx、ネットワークのエラーなど）で失敗した操作の最大試行回数 (1で再実行しない)")
+	cmd.Flags().Duration("retry-initial-backoff", s3.DefaultInitialBackoff, "最初の再実行までの待機時間 (試行ごとに2倍、スロットリングの場合はさらに長く待機)")
+	cmd.Flags().Duration("retry-max-backoff", s3.DefaultMaxBackoff, "再実行までの待機時間の上限")
+}
+
+// retryPolicyFromFlags はフラグからリトライの方針を取得します
+func retryPolicyFromFlags(cmd *cobra.Command) s3.RetryPolicy {
+	maxAttempts, _ := cmd.Flags().GetInt("max-attempts")
+	initialBackoff, _ := cmd.Flags().GetDuration("retry-initial-backoff")
+	maxBackoff, _ := cmd.Flags().GetDuration("retry-max-backoff")
+
+	return s3.RetryPolicy{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: initialBackoff,
+		MaxBackoff:     maxBackoff,
+	}
+}
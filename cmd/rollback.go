@@ -15,15 +15,36 @@ var rollbackCmd = &cobra.Command{
 指定された時間以前の最新バージョンにロールバックします。
 --prefix を指定すると、そのプレフィックスに一致するオブジェクトのみを処理します。
 --prefix を省略すると、バケット内の全てのオブジェクトを処理します。
+オブジェクト一覧はプレフィックスやキー範囲ごとに分割し、--concurrencyの並列数で取得します。
 
 指定された時間以降に変更がない場合は何もしません。
 指定された時間以降に最初に作成された場合は削除します。
-バージョニングが有効なバケットで使用できます。`,
+バージョニングが有効なバケットで使用できます。
+
+実行中にCtrl-C（SIGINT）またはSIGTERMを受信すると、新しいオブジェクトの処理を停止し、
+処理中のオブジェクトの完了を--drain-timeoutの時間まで待ってから終了します。
+
+--local-root を指定すると、S3の代わりにローカルのディレクトリ（replayコマンドの --dest-local-root で
+書き込んだもの）を対象にします。バケットはディレクトリ直下のディレクトリになります。
+
+一覧の取得やバージョンのコピーが一時的なエラー（503 SlowDown、5xx、ネットワークのエラーなど）で
+失敗した場合は、--max-attempts の回数まで再実行します。待機時間は --retry-initial-backoff から
+試行ごとに2倍になり（最大 --retry-max-backoff）、ランダムにばらつかせます。
+スロットリング（SlowDownなど）の場合は、通常のエラーより長く待機します。
+
+--requests-per-second、--prefix-requests-per-second、--bytes-per-second を指定すると、
+トークンバケットで1秒あたりのリクエスト数（全体とプレフィックスごと）とコピーするバイト数を制限します。`,
 	Run: func(cmd *cobra.Command, args []string) {
 		bucket, _ := cmd.Flags().GetString("bucket")
 		prefix, _ := cmd.Flags().GetString("prefix")
 		timestampStr, _ := cmd.Flags().GetString("timestamp")
 		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		drainTimeout, _ := cmd.Flags().GetDuration("drain-timeout")
+
+		backend, ok := backendFromFlag(cmd, "local-root")
+		if !ok {
+			return
+		}
 
 		if bucket == "" || timestampStr == "" {
 			slog.Error("必須パラメータが不足しています", "bucket", bucket, "timestamp", timestampStr)
@@ -38,6 +59,12 @@ var rollbackCmd = &cobra.Command{
 			return
 		}
 
+		rateLimit, err := rateLimitFromFlags(cmd)
+		if err != nil {
+			slog.Error("転送量の制限が無効です", "error", err)
+			return
+		}
+
 		slog.Info("ロールバック処理を開始します", 
 			"bucket", bucket, 
 			"prefix", prefix, 
@@ -45,13 +72,17 @@ var rollbackCmd = &cobra.Command{
 			"concurrency", concurrency)
 		
 		opts := s3.RollbackOptions{
-			Bucket:      bucket,
-			Prefix:      prefix,
-			Timestamp:   timestamp,
-			Concurrency: concurrency,
+			Bucket:       bucket,
+			Prefix:       prefix,
+			Timestamp:    timestamp,
+			Concurrency:  concurrency,
+			DrainTimeout: drainTimeout,
+			Backend:      backend,
+			Retry:        retryPolicyFromFlags(cmd),
+			RateLimit:    rateLimit,
 		}
 		
-		if err := s3.Rollback(opts); err != nil {
+		if err := s3.Rollback(cmd.Context(), opts); err != nil {
 			slog.Error("ロールバック処理中にエラーが発生しました", "error", err)
 			return
 		}
@@ -67,6 +98,10 @@ func init() {
 	rollbackCmd.Flags().StringP("prefix", "p", "", "S3オブジェクトのプレフィックス (省略時はバケット全体)")
 	rollbackCmd.Flags().StringP("timestamp", "t", "", "ロールバック先の時間 (ISO 8601形式: YYYY-MM-DDThh:mm:ssZ) (必須)")
 	rollbackCmd.Flags().IntP("concurrency", "c", 10, "並列処理数 (デフォルト: 10)")
+	rollbackCmd.Flags().Duration("drain-timeout", s3.DefaultDrainTimeout, "中断時に実行中のロールバックの完了を待つ時間")
+	rollbackCmd.Flags().String("local-root", "", "S3の代わりに使用するローカルのディレクトリ")
+	addRetryFlags(rollbackCmd)
+	addRateLimitFlags(rollbackCmd)
 	
 	rollbackCmd.MarkFlagRequired("bucket")
 	rollbackCmd.MarkFlagRequired("timestamp")
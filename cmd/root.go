@@ -1,15 +1,23 @@
 package cmd
 
 import (
+	"context"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 )
 
+// version はtravのバージョン
+// リリースビルドでは -ldflags "-X github.com/metapox/trav/cmd.version=v1.2.3" で設定します
+var version = "dev"
+
 var rootCmd = &cobra.Command{
-	Use:   "trav",
-	Short: "trav - S3イベントを再現するためのツール",
+	Use:     "trav",
+	Version: version,
+	Short:   "trav - S3イベントを再現するためのツール",
 	Long: `trav はAmazon S3のイベントを再現するためのコマンドラインツールです。
 様々なS3操作をシミュレートし、イベントを再現することができます。`,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -21,12 +29,31 @@ func Execute() {
 	// ログの初期化
 	setupLogger()
 
-	if err := rootCmd.Execute(); err != nil {
+	if err := rootCmd.ExecuteContext(signalContext()); err != nil {
 		slog.Error("コマンド実行中にエラーが発生しました", "error", err)
 		os.Exit(1)
 	}
 }
 
+// signalContext はSIGINT/SIGTERMを受信すると中断されるコンテキストを返します
+// 各コマンドは中断されると新しい処理を開始せず、実行中の処理の完了を待って終了します
+// 2回目のシグナルではデフォルトの動作に戻り、直ちに終了します
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-sigCh
+		signal.Stop(sigCh)
+		slog.Warn("中断シグナルを受信しました。実行中の処理の完了を待って終了します（もう一度送信すると直ちに終了します）", "signal", sig)
+		cancel()
+	}()
+
+	return ctx
+}
+
 func setupLogger() {
 	// デバッグモードの取得
 	debug, _ := rootCmd.PersistentFlags().GetBool("debug")
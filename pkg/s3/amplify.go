@@ -0,0 +1,105 @@
+package s3
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 複製のキーの接頭辞・接尾辞に埋め込む番号のプレースホルダー
+const (
+	AmplifyCopyPlaceholder = "{copy}" // 複製の番号（0から）
+	AmplifyLoopPlaceholder = "{loop}" // 変更リストを繰り返した回数（0から）
+)
+
+// DefaultAmplifyKeyPrefix は接頭辞と接尾辞を指定せずに複製した場合の宛先のキーの接頭辞
+const DefaultAmplifyKeyPrefix = "amplify-" + AmplifyCopyPlaceholder + "/"
+
+// AmplifyOptions は負荷試験のためにリプレイする変更を増幅するオプション
+// 各変更を Copies 回、キーの接頭辞・接尾辞を変えて反映し、変更リストを Loops 回（Forever の場合は中断されるまで）繰り返します
+type AmplifyOptions struct {
+	Copies    int           // 各変更を反映する回数（1以下の場合は複製しない）
+	KeyPrefix string        // 複製の宛先のキーに付ける接頭辞（{copy}、{loop} は複製の番号と繰り返した回数に置き換え）
+	KeySuffix string        // 複製の宛先のキーに付ける接尾辞（{copy}、{loop} は接頭辞と同じ）
+	TimeShift time.Duration // 複製ごとに予定の時刻を遅らせる時間（n番目の複製は n×TimeShift 遅らせる）
+	Loops     int           // 変更リストを繰り返す回数（0の場合は1回）
+	Forever   bool          // 中断されるまで変更リストを繰り返す（Loops は無視）
+}
+
+// copies は各変更を反映する回数を返します
+func (o AmplifyOptions) copies() int {
+	if o.Copies < 1 {
+		return 1
+	}
+	return o.Copies
+}
+
+// loops は変更リストを繰り返す回数を返します（Forever の場合は使用しません）
+func (o AmplifyOptions) loops() int {
+	if o.Loops < 1 {
+		return 1
+	}
+	return o.Loops
+}
+
+// enabled は変更を増幅するかどうかを返します
+func (o AmplifyOptions) enabled() bool {
+	return o.copies() > 1 || o.loops() > 1 || o.Forever
+}
+
+// withDefaults は複製する場合に接頭辞と接尾辞が指定されていなければ DefaultAmplifyKeyPrefix を設定します
+func (o AmplifyOptions) withDefaults() AmplifyOptions {
+	if o.copies() > 1 && o.KeyPrefix == "" && o.KeySuffix == "" {
+		o.KeyPrefix = DefaultAmplifyKeyPrefix
+	}
+	return o
+}
+
+// validate はオプションの組み合わせを検証します
+// 増幅したイベントは変更リストのエントリと対応しないため、進捗ファイルと検証は使用できません
+// 中断されるまで繰り返す場合は、イベントの結果をメモリに保持し続けないように EventsFile が必要です
+func (o AmplifyOptions) validate(opts ReplayOptions) error {
+	if o.Loops < 0 {
+		return errors.New("変更リストを繰り返す回数には0以上を指定してください")
+	}
+	if !o.enabled() {
+		return nil
+	}
+	if o.copies() > 1 && !strings.Contains(o.KeyPrefix+o.KeySuffix, AmplifyCopyPlaceholder) {
+		return errors.New("複製のキーが重複しないように、キーの接頭辞または接尾辞に {copy} を含めてください")
+	}
+	if o.TimeShift < 0 {
+		return errors.New("複製ごとに予定の時刻を遅らせる時間には0以上を指定してください")
+	}
+	if opts.ProgressFile != "" {
+		return errors.New("変更を増幅する場合は進捗ファイルを使用できません")
+	}
+	if opts.Verify {
+		return errors.New("変更を増幅する場合はリプレイ後の検証を使用できません")
+	}
+	if o.Forever && opts.EventsFile == "" {
+		return errors.New("中断されるまで繰り返す場合は、イベントの結果を書き込むファイルを指定してください")
+	}
+	return nil
+}
+
+// variantKey は宛先のキーに複製の番号と繰り返した回数を埋め込んだ接頭辞・接尾辞を付けたキーを返します
+func (o AmplifyOptions) variantKey(destKey string, copyNum, loop int) string {
+	if o.KeyPrefix == "" && o.KeySuffix == "" {
+		return destKey
+	}
+	replacer := strings.NewReplacer(
+		AmplifyCopyPlaceholder, strconv.Itoa(copyNum),
+		AmplifyLoopPlaceholder, strconv.Itoa(loop),
+	)
+	return replacer.Replace(o.KeyPrefix) + destKey + replacer.Replace(o.KeySuffix)
+}
+
+// shift は複製の予定の時刻を返します（即時に実行するイベントは遅らせない）
+func (o AmplifyOptions) shift(scheduledAt time.Time, copyNum int) time.Time {
+	if scheduledAt.IsZero() {
+		return scheduledAt
+	}
+	return scheduledAt.Add(time.Duration(copyNum) * o.TimeShift)
+}
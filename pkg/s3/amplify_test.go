@@ -0,0 +1,167 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAmplifyOptions(t *testing.T) {
+	opts := AmplifyOptions{Copies: 3, KeyPrefix: "load/{copy}/", KeySuffix: ".{loop}"}
+	if got := opts.variantKey("tenant/a.txt", 2, 1); got != "load/2/tenant/a.txt.1" {
+		t.Errorf("variantKey() = %q", got)
+	}
+	if got := (AmplifyOptions{Loops: 3}).variantKey("a.txt", 0, 2); got != "a.txt" {
+		t.Errorf("接頭辞・接尾辞を指定しない場合の variantKey() = %q, want a.txt", got)
+	}
+	if got := (AmplifyOptions{Copies: 2}).withDefaults().variantKey("a.txt", 1, 0); got != "amplify-1/a.txt" {
+		t.Errorf("デフォルトの接頭辞の variantKey() = %q", got)
+	}
+
+	tests := []struct {
+		name    string
+		amplify AmplifyOptions
+		opts    ReplayOptions
+	}{
+		{"キーに複製の番号がない", AmplifyOptions{Copies: 2, KeySuffix: "-copy"}, ReplayOptions{}},
+		{"負の時間", AmplifyOptions{Copies: 2, KeyPrefix: "{copy}/", TimeShift: -time.Second}, ReplayOptions{}},
+		{"進捗ファイル", AmplifyOptions{Loops: 2}, ReplayOptions{ProgressFile: "progress.json"}},
+		{"負の繰り返し回数", AmplifyOptions{Loops: -1}, ReplayOptions{}},
+		{"中断されるまで繰り返す場合に結果のファイルがない", AmplifyOptions{Forever: true}, ReplayOptions{}},
+		{"検証", AmplifyOptions{Copies: 2, KeyPrefix: "{copy}/"}, ReplayOptions{Verify: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.amplify.validate(tt.opts); err == nil {
+				t.Error("validate() error = nil, want error")
+			}
+		})
+	}
+
+	// 増幅しない場合は他のオプションを制限しない
+	if err := (AmplifyOptions{Copies: 1}).validate(ReplayOptions{ProgressFile: "progress.json", Verify: true}); err != nil {
+		t.Errorf("validate() error = %v", err)
+	}
+	if err := (AmplifyOptions{Forever: true}).validate(ReplayOptions{EventsFile: "events.ndjson"}); err != nil {
+		t.Errorf("結果のファイルを指定して中断されるまで繰り返す場合の validate() error = %v", err)
+	}
+}
+
+// TestReplayAmplify は各変更を複製の数だけ別のキーに反映し、変更リストを繰り返すことを確認します
+func TestReplayAmplify(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	source := newTestLocalBackend(t, filepath.Join(dir, "source"))
+	dest := newTestLocalBackend(t, filepath.Join(dir, "dest"))
+
+	start := time.Now().UTC()
+	putLocalObject(t, source, "prod", "a.txt", "a1")
+	putLocalObject(t, source, "prod", "b.txt", "b1")
+
+	changes, changesFile := writeLocalChangesFile(t, source, "prod", start, dir)
+
+	opts := ReplayOptions{
+		SourceBucket:  "prod",
+		DestBucket:    "staging",
+		SourceFile:    changesFile,
+		SourceBackend: source,
+		DestBackend:   dest,
+		Amplify:       AmplifyOptions{Copies: 3, KeyPrefix: "load/{copy}/", KeySuffix: ".{loop}", TimeShift: 20 * time.Millisecond, Loops: 2},
+	}
+	result, err := Replay(ctx, opts)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+
+	want := len(changes) * 3 * 2
+	if result.TotalEvents != want || result.SuccessEvents != want || result.Loops != 2 {
+		t.Fatalf("結果 = 総数 %d、成功 %d、繰り返し %d, want %d件を2回", result.TotalEvents, result.SuccessEvents, result.Loops, want)
+	}
+	for _, key := range []string{"load/0/a.txt.0", "load/2/b.txt.0", "load/1/a.txt.1"} {
+		if got := readLocalObject(t, dest, "staging", key); got == "" {
+			t.Errorf("複製 %s が書き込まれていません", key)
+		}
+	}
+
+	// 複製ごとに予定の時刻をずらし、2回目は1回目の後に予定する
+	scheduled := make(map[[3]int]time.Time)
+	for _, event := range result.Events {
+		scheduled[[3]int{event.Index % len(changes), event.Copy, event.Loop}] = event.ScheduledAt
+	}
+	if shift := scheduled[[3]int{0, 2, 0}].Sub(scheduled[[3]int{0, 0, 0}]); shift != 40*time.Millisecond {
+		t.Errorf("2番目の複製の遅れ = %s, want 40ms", shift)
+	}
+	if scheduled[[3]int{0, 0, 1}].Before(scheduled[[3]int{len(changes) - 1, 0, 0}]) {
+		t.Error("2回目の変更が1回目の最後の変更より前に予定されています")
+	}
+}
+
+// TestReplayAmplifyLoopUntilCanceled は繰り返しの回数を指定しない場合に中断されるまで繰り返すことを確認します
+func TestReplayAmplifyLoopUntilCanceled(t *testing.T) {
+	dir := t.TempDir()
+	changesFile := filepath.Join(dir, "changes.json")
+	data, _ := json.Marshal([]ObjectChange{{Key: "a.txt", VersionID: "v1", ChangeType: ChangeTypeCreate, Timestamp: time.Now().UTC()}})
+	if err := os.WriteFile(changesFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest := newTestLocalBackend(t, filepath.Join(dir, "dest"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	result, err := Replay(ctx, ReplayOptions{
+		DestBucket:        "staging",
+		SourceFile:        changesFile,
+		IgnoreTimeWindows: true,
+		DryRun:            true,
+		SourceBackend:     dest,
+		DestBackend:       dest,
+		EventsFile:        filepath.Join(dir, "events.ndjson"),
+		Amplify:           AmplifyOptions{Forever: true},
+	})
+	if err == nil || !result.Interrupted {
+		t.Fatalf("Replay() error = %v, want 中断", err)
+	}
+	if result.Loops < 2 {
+		t.Errorf("繰り返した回数 = %d, want 2回以上", result.Loops)
+	}
+}
+
+// TestReplayAmplifyLoopCounts は絞り込んだ変更と順序の逆転を繰り返した全ての回で数えることを確認します
+func TestReplayAmplifyLoopCounts(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2025, 6, 5, 10, 0, 0, 0, time.UTC)
+	changesFile := filepath.Join(dir, "changes.ndjson")
+	writeTestNDJSON(t, changesFile, []ObjectChange{
+		{Key: "a.txt", VersionID: "a1", ChangeType: ChangeTypeCreate, Timestamp: base.Add(2 * time.Second)},
+		{Key: "b.txt", VersionID: "b1", ChangeType: ChangeTypeCreate, Timestamp: base.Add(3 * time.Second)},
+		{Key: "c.txt", VersionID: "c1", ChangeType: ChangeTypeDelete, Timestamp: base.Add(4 * time.Second)},
+		// 先読みの範囲を超えて逆転した変更
+		{Key: "d.txt", VersionID: "d1", ChangeType: ChangeTypeCreate, Timestamp: base},
+	})
+	filter, err := NewChangeFilter(ChangeFilterOptions{ExcludeTypes: []ChangeType{ChangeTypeDelete}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := newTestLocalBackend(t, filepath.Join(dir, "dest"))
+
+	result, err := Replay(context.Background(), ReplayOptions{
+		DestBucket:        "staging",
+		SourceFile:        changesFile,
+		IgnoreTimeWindows: true,
+		DryRun:            true,
+		Lookahead:         1,
+		Filter:            filter,
+		SourceBackend:     dest,
+		DestBackend:       dest,
+		Amplify:           AmplifyOptions{Loops: 3},
+	})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if result.Loops != 3 || result.FilteredEvents != 3 || result.OutOfOrderEvents != 3 {
+		t.Errorf("繰り返し %d回、絞り込み %d件、逆転 %d件, want 3回、3件、3件", result.Loops, result.FilteredEvents, result.OutOfOrderEvents)
+	}
+}
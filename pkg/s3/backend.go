@@ -0,0 +1,81 @@
+package s3
+
+import (
+	"context"
+	"io"
+)
+
+// Backend はrollback、replay-list、replayが使用するオブジェクトストレージの操作
+// S3のほか、ローカルのディレクトリにバージョンを保存するLocalBackendを使用できます
+type Backend interface {
+	// ListKeys はプレフィックスに一致する現在のオブジェクト（最新が削除マーカーでないもの）のキーをキー順に返します
+	ListKeys(ctx context.Context, bucket, prefix string, concurrency int) ([]string, error)
+
+	// ListVersionedKeys はプレフィックスに一致するバージョンまたは削除マーカーを持つ全てのキーをキー順に返します
+	// ListKeys と異なり、最新が削除マーカーのキー（削除されたオブジェクト）も含みます
+	ListVersionedKeys(ctx context.Context, bucket, prefix string, concurrency int) ([]string, error)
+
+	// ListVersions はキーに完全一致する全てのバージョンと削除マーカーを返します
+	ListVersions(ctx context.Context, bucket, key string) (KeyVersions, error)
+
+	// VersioningEnabled はバケットのバージョニングが有効かを返します
+	VersioningEnabled(ctx context.Context, bucket string) (bool, error)
+
+	// GetObject はオブジェクトのバージョンの内容を返します（versionIDが空の場合は最新のバージョン）
+	// 呼び出し側は Body を閉じる必要があります
+	GetObject(ctx context.Context, bucket, key, versionID string) (*ObjectContent, error)
+
+	// HeadObject はオブジェクトの最新のバージョンの情報を返します（存在しない場合や最新が削除マーカーの場合はnil）
+	HeadObject(ctx context.Context, bucket, key string) (*ObjectInfo, error)
+
+	// PutObject はオブジェクトの新しいバージョンを書き込みます
+	PutObject(ctx context.Context, bucket, key string, body io.Reader, metadata ObjectMetadata) error
+
+	// CopyObject は同じストレージ内でオブジェクトのバージョンをコピーします（versionIDが空の場合は最新のバージョン）
+	CopyObject(ctx context.Context, sourceBucket, sourceKey, versionID, destBucket, destKey string) error
+
+	// DeleteObject はオブジェクトを削除します（バージョニングが有効な場合は削除マーカーを作成）
+	DeleteObject(ctx context.Context, bucket, key string) error
+}
+
+// ObjectMetadata はコピー時に引き継ぐオブジェクトのメタデータ
+type ObjectMetadata struct {
+	ContentType        string            `json:"contentType,omitempty"`
+	ContentEncoding    string            `json:"contentEncoding,omitempty"`
+	ContentDisposition string            `json:"contentDisposition,omitempty"`
+	ContentLanguage    string            `json:"contentLanguage,omitempty"`
+	CacheControl       string            `json:"cacheControl,omitempty"`
+	UserMetadata       map[string]string `json:"userMetadata,omitempty"` // x-amz-meta-* のユーザー定義メタデータ
+}
+
+// ObjectContent はオブジェクトのバージョンの内容
+type ObjectContent struct {
+	Body     io.ReadCloser
+	Metadata ObjectMetadata
+}
+
+// ObjectInfo はオブジェクトの最新のバージョンの情報
+type ObjectInfo struct {
+	Size     int64
+	ETag     string
+	Metadata ObjectMetadata
+}
+
+// supportsServerSideCopy は変更元から宛先へ CopyObject でコピーできる可能性があるかを返します
+// 同じストレージの場合か、接続設定（プロファイル、ロール、リージョン、エンドポイント）が全て同じS3の場合に試します
+// 認証情報が異なる場合は、宛先の認証情報で変更元を読み込めるとは限らないためストリーミングコピーします
+func supportsServerSideCopy(source, dest Backend) bool {
+	if source == dest {
+		return true
+	}
+
+	sourceS3, ok := source.(*S3Backend)
+	if !ok {
+		return false
+	}
+	destS3, ok := dest.(*S3Backend)
+	if !ok {
+		return false
+	}
+	return sourceS3.options == destS3.options
+}
@@ -0,0 +1,24 @@
+package s3
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultDrainTimeout は中断時に実行中の処理の完了を待つ時間のデフォルト値
+const DefaultDrainTimeout = 30 * time.Second
+
+// drainContext は ctx が中断されてもすぐには中断されず、そこから timeout が経過した時点で中断されるコンテキストを返します
+// 中断時に新しい処理は開始せず、実行中のS3操作は途中で打ち切らずに完了させるために使用します
+func drainContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	drainCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(timeout, cancel)
+	})
+
+	return drainCtx, func() {
+		stop()
+		cancel()
+	}
+}
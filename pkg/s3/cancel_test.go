@@ -0,0 +1,69 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDrainContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	drainCtx, stop := drainContext(ctx, 50*time.Millisecond)
+	defer stop()
+
+	cancel()
+
+	// 中断直後は実行中の処理を続けられる
+	select {
+	case <-drainCtx.Done():
+		t.Fatalf("中断直後にコンテキストが終了しました")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// 猶予時間が経過すると中断される
+	select {
+	case <-drainCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("猶予時間が経過してもコンテキストが終了しません")
+	}
+}
+
+// TestProcessChangesStreamingCancel は中断で戻った後にコールバックが呼ばれないことを確認します
+func TestProcessChangesStreamingCancel(t *testing.T) {
+	backend := newTestLocalBackend(t, t.TempDir())
+	for i := 0; i < 100; i++ {
+		putLocalObject(t, backend, "bucket", fmt.Sprintf("key%02d", i), "v1")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var returned atomic.Bool
+	var lateCalls atomic.Int32
+	err := ProcessChangesStreaming(ctx, ReplayListOptions{
+		Bucket:      "bucket",
+		Timestamp:   time.Now().UTC().Add(-time.Hour),
+		Concurrency: 2,
+		BatchSize:   1,
+		Backend:     backend,
+	}, func(changes []ObjectChange) error {
+		if returned.Load() {
+			lateCalls.Add(1)
+		}
+		cancel()
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	returned.Store(true)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ProcessChangesStreaming() error = %v, want context.Canceled", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := lateCalls.Load(); n > 0 {
+		t.Errorf("戻った後にコールバックが %d 回呼ばれました", n)
+	}
+}
@@ -0,0 +1,387 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+)
+
+// ChangeListSchemaVersion は現在書き出している変更リストのスキーマバージョン
+//
+//	1: ObjectChange の配列のみ（ヘッダーなし）
+//	2: ヘッダーとサマリーを持つエンベロープ形式
+const ChangeListSchemaVersion = 2
+
+// SourceTypeListObjectVersions はListObjectVersionsから生成された変更リストを表すソース種別
+const SourceTypeListObjectVersions = "s3-list-object-versions"
+
+// checksumAlgorithm は変更リストのチェックサムに使用するアルゴリズム名
+const checksumAlgorithm = "sha256"
+
+// ChangeListHeader は変更リストの生成元を表すヘッダー
+type ChangeListHeader struct {
+	SchemaVersion int       `json:"-"`                      // スキーマバージョン（エンベロープの schemaVersion）
+	SourceType    string    `json:"sourceType,omitempty"`   // 変更リストの生成元の種別
+	SourceBucket  string    `json:"sourceBucket,omitempty"` // 変更元のバケット
+	SourcePrefix  string    `json:"sourcePrefix,omitempty"` // 変更元のプレフィックス
+	WindowStart   time.Time `json:"windowStart"`            // 取得対象期間の開始時刻（この時刻を含む）
+	WindowEnd     time.Time `json:"windowEnd"`              // 取得対象期間の終了時刻（一覧取得の開始時刻）
+	GeneratedAt   time.Time `json:"generatedAt"`            // 変更リストの生成時刻
+	ToolVersion   string    `json:"toolVersion,omitempty"`  // 変更リストを生成したtravのバージョン
+	RecordCount   int       `json:"-"`                      // 変更の件数（サマリーの recordCount）
+	Checksum      string    `json:"-"`                      // 変更のチェックサム（サマリーの checksum）
+}
+
+// changeListSummary は変更リストの末尾に書き込まれるサマリー
+type changeListSummary struct {
+	RecordCount int    `json:"recordCount"`
+	Checksum    string `json:"checksum"`
+}
+
+// ChangeList は読み込んだ変更リストを表す構造体
+type ChangeList struct {
+	Header  ChangeListHeader
+	Changes []ObjectChange
+}
+
+// changeListChecksum は変更リストのチェックサムを計算する構造体
+// 各レコードのJSON表現に改行を付けたものを順に連結したバイト列のハッシュを計算します
+type changeListChecksum struct {
+	h hash.Hash
+}
+
+func newChangeListChecksum() *changeListChecksum {
+	return &changeListChecksum{h: sha256.New()}
+}
+
+func (c *changeListChecksum) add(record []byte) {
+	c.h.Write(record)
+	c.h.Write([]byte("\n"))
+}
+
+func (c *changeListChecksum) String() string {
+	return checksumAlgorithm + ":" + hex.EncodeToString(c.h.Sum(nil))
+}
+
+// writeChangeListPreamble は変更リストのエンベロープの先頭（ヘッダーと変更配列の開始）を書き込みます
+func writeChangeListPreamble(w io.Writer, header ChangeListHeader) error {
+	headerData, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "{\n\"schemaVersion\": %d,\n\"header\": %s,\n\"changes\": [\n", ChangeListSchemaVersion, headerData)
+	return err
+}
+
+// writeChangeListTrailer は変更リストのエンベロープの末尾（変更配列の終了とサマリー）を書き込みます
+func writeChangeListTrailer(w io.Writer, recordCount int, checksum string) error {
+	summaryData, err := json.Marshal(changeListSummary{RecordCount: recordCount, Checksum: checksum})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "\n],\n\"summary\": %s\n}\n", summaryData)
+	return err
+}
+
+// changeListFormat は変更リストのファイル形式
+type changeListFormat int
+
+const (
+	changeListFormatArray    changeListFormat = iota // ObjectChange の配列（スキーマバージョン1）
+	changeListFormatEnvelope                         // ヘッダーとサマリーを持つエンベロープ形式（スキーマバージョン2以降）
+	changeListFormatNDJSON                           // 1行に1件の ObjectChange（ヘッダーなし）
+)
+
+// envelopeFields はエンベロープ形式の変更リストのフィールド
+// 先頭のオブジェクトの最初のフィールドがこれらのいずれかであればエンベロープ形式、それ以外はNDJSONとみなします
+var envelopeFields = map[string]bool{"schemaVersion": true, "header": true, "changes": true, "summary": true}
+
+// formatDetectionSize は形式の判定のために先読みするバイト数
+const formatDetectionSize = 4096
+
+// changeListReader は変更リストを先頭から1件ずつ読み込みます
+// 変更リスト全体をメモリに読み込まないため、大きな変更リストも一定のメモリで読み込めます
+// エンベロープ形式の件数とチェックサムは、最後の変更を読み込んだ後に検証します
+type changeListReader struct {
+	Header ChangeListHeader
+
+	decoder   *json.Decoder
+	closer    io.Closer
+	format    changeListFormat
+	checksum  *changeListChecksum
+	summary   *changeListSummary
+	count     int
+	inChanges bool // エンベロープの changes の配列を読み込み中かどうか
+	done      bool
+}
+
+// openChangeListReader はファイルまたは s3://bucket/key の変更リストを読み込むchangeListReaderを作成します
+func openChangeListReader(ctx context.Context, filePath string, opts ChangesFileOptions) (*changeListReader, error) {
+	file, err := openChangesInput(ctx, filePath, opts)
+	if err != nil {
+		return nil, fmt.Errorf("ファイルのオープンに失敗しました: %w", err)
+	}
+
+	reader, err := newChangeListReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	reader.closer = file
+	return reader, nil
+}
+
+// newChangeListReader は変更リストの形式を判定し、最初の変更の直前まで読み込みます
+// エンベロープ形式で changes より前にヘッダーがある場合は、この時点で Header が設定されます
+func newChangeListReader(r io.Reader) (*changeListReader, error) {
+	buffered := bufio.NewReaderSize(r, formatDetectionSize)
+	format, err := detectChangeListFormat(buffered)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &changeListReader{
+		decoder:  json.NewDecoder(buffered),
+		format:   format,
+		checksum: newChangeListChecksum(),
+	}
+
+	switch format {
+	case changeListFormatArray:
+		// ヘッダーを持たないバージョン1は先頭が配列
+		reader.Header.SchemaVersion = 1
+		if _, err := reader.decoder.Token(); err != nil {
+			return nil, fmt.Errorf("JSONのデコードに失敗しました: %w", err)
+		}
+	case changeListFormatNDJSON:
+		reader.Header.SchemaVersion = 1
+	case changeListFormatEnvelope:
+		if _, err := reader.decoder.Token(); err != nil {
+			return nil, fmt.Errorf("JSONのデコードに失敗しました: %w", err)
+		}
+		if err := reader.advanceEnvelope(); err != nil {
+			return nil, err
+		}
+	}
+
+	return reader, nil
+}
+
+// detectChangeListFormat はファイルの先頭から変更リストの形式を判定します
+func detectChangeListFormat(r *bufio.Reader) (changeListFormat, error) {
+	head, err := r.Peek(formatDetectionSize)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return 0, fmt.Errorf("JSONのデコードに失敗しました: %w", err)
+	}
+
+	trimmed := bytes.TrimLeft(head, " \t\r\n")
+	if len(trimmed) == 0 {
+		return 0, fmt.Errorf("JSONのデコードに失敗しました: %w", io.EOF)
+	}
+
+	switch trimmed[0] {
+	case '[':
+		return changeListFormatArray, nil
+	case '{':
+		// 最初のフィールド名でエンベロープ形式とNDJSONを判別する
+		rest := bytes.TrimLeft(trimmed[1:], " \t\r\n")
+		if len(rest) > 0 && rest[0] == '"' {
+			if end := bytes.IndexByte(rest[1:], '"'); end >= 0 && !envelopeFields[string(rest[1:end+1])] {
+				return changeListFormatNDJSON, nil
+			}
+		}
+		return changeListFormatEnvelope, nil
+	default:
+		return 0, fmt.Errorf("変更リストの形式が不正です: 先頭が配列またはオブジェクトではありません")
+	}
+}
+
+// Next は次の変更を返します。全ての変更を読み込んだ場合は io.EOF を返します
+func (r *changeListReader) Next() (ObjectChange, error) {
+	for !r.done {
+		switch r.format {
+		case changeListFormatArray:
+			if r.decoder.More() {
+				return r.decodeChange()
+			}
+			if _, err := r.decoder.Token(); err != nil {
+				return ObjectChange{}, fmt.Errorf("JSONのデコードに失敗しました: %w", err)
+			}
+			r.finish()
+		case changeListFormatNDJSON:
+			if r.decoder.More() {
+				return r.decodeChange()
+			}
+			r.finish()
+		case changeListFormatEnvelope:
+			if r.inChanges {
+				if r.decoder.More() {
+					return r.decodeChange()
+				}
+				if _, err := r.decoder.Token(); err != nil {
+					return ObjectChange{}, fmt.Errorf("JSONのデコードに失敗しました: %w", err)
+				}
+				r.inChanges = false
+			}
+			if err := r.advanceEnvelope(); err != nil {
+				return ObjectChange{}, err
+			}
+		}
+	}
+	return ObjectChange{}, io.EOF
+}
+
+// Close は変更リストのファイルを閉じます
+func (r *changeListReader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// decodeChange は1件の変更を読み込み、チェックサムに加えます
+func (r *changeListReader) decodeChange() (ObjectChange, error) {
+	var raw json.RawMessage
+	if err := r.decoder.Decode(&raw); err != nil {
+		return ObjectChange{}, fmt.Errorf("JSONのデコードに失敗しました: %w", err)
+	}
+	r.checksum.add(raw)
+
+	var change ObjectChange
+	if err := json.Unmarshal(raw, &change); err != nil {
+		return ObjectChange{}, fmt.Errorf("変更のデコードに失敗しました: %w", err)
+	}
+	r.count++
+	return change, nil
+}
+
+// advanceEnvelope はエンベロープのフィールドを changes の配列の先頭またはエンベロープの末尾まで読み込みます
+// 末尾まで読み込んだ場合は、サマリーの件数とチェックサムを検証します
+func (r *changeListReader) advanceEnvelope() error {
+	for r.decoder.More() {
+		tok, err := r.decoder.Token()
+		if err != nil {
+			return fmt.Errorf("JSONのデコードに失敗しました: %w", err)
+		}
+
+		field, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("変更リストの形式が不正です: 不正なフィールド %v", tok)
+		}
+
+		switch field {
+		case "schemaVersion":
+			if err := r.decoder.Decode(&r.Header.SchemaVersion); err != nil {
+				return fmt.Errorf("schemaVersionのデコードに失敗しました: %w", err)
+			}
+			if r.Header.SchemaVersion > ChangeListSchemaVersion {
+				return fmt.Errorf("サポートされていないスキーマバージョンです: %d (このバージョンのtravは %d まで対応しています)", r.Header.SchemaVersion, ChangeListSchemaVersion)
+			}
+		case "header":
+			schemaVersion := r.Header.SchemaVersion
+			if err := r.decoder.Decode(&r.Header); err != nil {
+				return fmt.Errorf("ヘッダーのデコードに失敗しました: %w", err)
+			}
+			r.Header.SchemaVersion = schemaVersion
+		case "changes":
+			tok, err := r.decoder.Token()
+			if err != nil {
+				return fmt.Errorf("JSONのデコードに失敗しました: %w", err)
+			}
+			if tok != json.Delim('[') {
+				return fmt.Errorf("変更リストの形式が不正です: changesが配列ではありません")
+			}
+			r.inChanges = true
+			return nil
+		case "summary":
+			r.summary = &changeListSummary{}
+			if err := r.decoder.Decode(r.summary); err != nil {
+				return fmt.Errorf("サマリーのデコードに失敗しました: %w", err)
+			}
+		default:
+			// 将来追加されるフィールドは読み飛ばす
+			var skip json.RawMessage
+			if err := r.decoder.Decode(&skip); err != nil {
+				return fmt.Errorf("JSONのデコードに失敗しました: %w", err)
+			}
+		}
+	}
+
+	if _, err := r.decoder.Token(); err != nil {
+		return fmt.Errorf("JSONのデコードに失敗しました: %w", err)
+	}
+
+	if r.Header.SchemaVersion == 0 {
+		return fmt.Errorf("変更リストの形式が不正です: schemaVersionがありません")
+	}
+
+	if r.summary == nil {
+		return fmt.Errorf("変更リストのサマリーがありません。ファイルが途中で切れている可能性があります")
+	}
+
+	if r.summary.RecordCount != r.count {
+		return fmt.Errorf("変更リストの件数が一致しません: サマリー %d 件, 実際 %d 件", r.summary.RecordCount, r.count)
+	}
+
+	if err := verifyChecksum(r.summary.Checksum, r.checksum.String()); err != nil {
+		return err
+	}
+
+	r.Header.Checksum = r.summary.Checksum
+	r.finish()
+	return nil
+}
+
+// finish は全ての変更を読み込んだことを記録します
+func (r *changeListReader) finish() {
+	r.Header.RecordCount = r.count
+	r.done = true
+}
+
+// readChangeList は変更リストを全て読み込み、スキーマバージョンに応じて検証します
+func readChangeList(r io.Reader) (*ChangeList, error) {
+	reader, err := newChangeListReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &ChangeList{}
+	for {
+		change, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		list.Changes = append(list.Changes, change)
+	}
+
+	list.Header = reader.Header
+	return list, nil
+}
+
+// verifyChecksum はサマリーに記録されたチェックサムと計算したチェックサムを比較します
+func verifyChecksum(expected, actual string) error {
+	algorithm, _, _ := strings.Cut(expected, ":")
+	if algorithm != checksumAlgorithm {
+		return fmt.Errorf("サポートされていないチェックサムのアルゴリズムです: %s", algorithm)
+	}
+
+	if expected != actual {
+		return fmt.Errorf("変更リストのチェックサムが一致しません: 期待値 %s, 実際 %s", expected, actual)
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package s3
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangeListHeaderRoundTrip(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "changes.json")
+	header := &ChangeListHeader{
+		SourceType:   SourceTypeListObjectVersions,
+		SourceBucket: "source-bucket",
+		SourcePrefix: "prod/",
+		WindowStart:  time.Date(2025, 6, 5, 0, 0, 0, 0, time.UTC),
+		WindowEnd:    time.Date(2025, 6, 6, 0, 0, 0, 0, time.UTC),
+		ToolVersion:  "v1.2.3",
+	}
+	want := writeTestChangesFile(t, filePath, ChangesFileOptions{Header: header})
+
+	list, err := loadChangeList(context.Background(), filePath, ChangesFileOptions{})
+	if err != nil {
+		t.Fatalf("変更リストの読み込みに失敗しました: %v", err)
+	}
+
+	got := list.Header
+	if got.SchemaVersion != ChangeListSchemaVersion {
+		t.Errorf("SchemaVersion = %d, want %d", got.SchemaVersion, ChangeListSchemaVersion)
+	}
+	if got.SourceType != header.SourceType || got.SourceBucket != header.SourceBucket || got.SourcePrefix != header.SourcePrefix {
+		t.Errorf("ヘッダーの生成元が期待と異なります: %+v", got)
+	}
+	if !got.WindowStart.Equal(header.WindowStart) || !got.WindowEnd.Equal(header.WindowEnd) {
+		t.Errorf("ヘッダーの期間が期待と異なります: %+v", got)
+	}
+	if got.GeneratedAt.IsZero() {
+		t.Errorf("GeneratedAtが設定されていません")
+	}
+	if got.ToolVersion != header.ToolVersion {
+		t.Errorf("ToolVersion = %s, want %s", got.ToolVersion, header.ToolVersion)
+	}
+	if got.RecordCount != len(want) {
+		t.Errorf("RecordCount = %d, want %d", got.RecordCount, len(want))
+	}
+	if !strings.HasPrefix(got.Checksum, "sha256:") {
+		t.Errorf("Checksumの形式が期待と異なります: %s", got.Checksum)
+	}
+	if len(list.Changes) != len(want) {
+		t.Fatalf("変更リストの長さが期待と異なります: got %d, want %d", len(list.Changes), len(want))
+	}
+}
+
+func TestLoadChangeList_V1(t *testing.T) {
+	// ヘッダーを持たない旧形式のファイルも読み込めること
+	filePath := createTestChangesList(t)
+
+	list, err := loadChangeList(context.Background(), filePath, ChangesFileOptions{})
+	if err != nil {
+		t.Fatalf("変更リストの読み込みに失敗しました: %v", err)
+	}
+
+	if list.Header.SchemaVersion != 1 {
+		t.Errorf("SchemaVersion = %d, want 1", list.Header.SchemaVersion)
+	}
+	if list.Header.SourceBucket != "" {
+		t.Errorf("SourceBucket = %s, want empty", list.Header.SourceBucket)
+	}
+	if len(list.Changes) != 3 {
+		t.Errorf("変更リストの長さが期待と異なります: got %d, want %d", len(list.Changes), 3)
+	}
+}
+
+func TestLoadChangeList_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "将来のスキーマバージョン",
+			content: `{"schemaVersion": 99, "header": {}, "changes": [],
+"summary": {"recordCount": 0, "checksum": "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}}`,
+			wantErr: "サポートされていないスキーマバージョン",
+		},
+		{
+			name:    "サマリーなし（途中で切れたファイル）",
+			content: `{"schemaVersion": 2, "header": {}, "changes": [{"key": "a", "versionId": "v1", "changeType": "CREATE", "timestamp": "2025-06-05T10:00:00Z", "isDeleteMarker": false}]}`,
+			wantErr: "サマリーがありません",
+		},
+		{
+			name: "件数の不一致",
+			content: `{"schemaVersion": 2, "header": {}, "changes": [],
+"summary": {"recordCount": 1, "checksum": "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}}`,
+			wantErr: "件数が一致しません",
+		},
+		{
+			name: "チェックサムの不一致",
+			content: `{"schemaVersion": 2, "header": {}, "changes": [{"key": "a", "versionId": "v1", "changeType": "CREATE", "timestamp": "2025-06-05T10:00:00Z", "isDeleteMarker": false}],
+"summary": {"recordCount": 1, "checksum": "sha256:0000"}}`,
+			wantErr: "チェックサムが一致しません",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "changes.json")
+			if err := os.WriteFile(filePath, []byte(tt.content), 0o644); err != nil {
+				t.Fatalf("ファイルの書き込みに失敗しました: %v", err)
+			}
+
+			_, err := loadChangeList(context.Background(), filePath, ChangesFileOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadChangeList() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadChangeList_DetectsModifiedRecord(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "changes.json")
+	writeTestChangesFile(t, filePath, ChangesFileOptions{})
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("ファイルの読み込みに失敗しました: %v", err)
+	}
+
+	// レコードを書き換えるとチェックサムの検証で失敗すること
+	tampered := strings.Replace(string(data), `"size":100`, `"size":101`, 1)
+	if tampered == string(data) {
+		t.Fatalf("テストデータの書き換えに失敗しました")
+	}
+	if err := os.WriteFile(filePath, []byte(tampered), 0o644); err != nil {
+		t.Fatalf("ファイルの書き込みに失敗しました: %v", err)
+	}
+
+	if _, err := loadChangeList(context.Background(), filePath, ChangesFileOptions{}); err == nil || !strings.Contains(err.Error(), "チェックサム") {
+		t.Errorf("loadChangeList() error = %v, want checksum error", err)
+	}
+}
+
+func TestLoadChangeList_NDJSON(t *testing.T) {
+	// 1行に1件の ObjectChange を書いたファイルも読み込めること
+	filePath := filepath.Join(t.TempDir(), "changes.ndjson")
+	content := `{"key": "a", "versionId": "v1", "changeType": "CREATE", "timestamp": "2025-06-05T10:00:00Z"}
+{"key": "b", "versionId": "v2", "changeType": "UPDATE", "timestamp": "2025-06-05T10:01:00Z"}
+
+{"key": "a", "versionId": "v3", "changeType": "DELETE", "timestamp": "2025-06-05T10:02:00Z", "isDeleteMarker": true}
+`
+	if err := os.WriteFile(filePath, []byte(content), 0o644); err != nil {
+		t.Fatalf("ファイルの書き込みに失敗しました: %v", err)
+	}
+
+	list, err := loadChangeList(context.Background(), filePath, ChangesFileOptions{})
+	if err != nil {
+		t.Fatalf("変更リストの読み込みに失敗しました: %v", err)
+	}
+	if list.Header.SchemaVersion != 1 {
+		t.Errorf("SchemaVersion = %d, want 1", list.Header.SchemaVersion)
+	}
+	if len(list.Changes) != 3 || list.Changes[2].ChangeType != ChangeTypeDelete {
+		t.Errorf("変更リストが期待と異なります: %+v", list.Changes)
+	}
+}
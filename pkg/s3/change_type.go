@@ -0,0 +1,201 @@
+package s3
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// keyState はキーの現在の状態
+type keyState int
+
+const (
+	keyStateAbsent  keyState = iota // バージョンが存在しない
+	keyStateLive                    // 最新が通常のバージョン
+	keyStateDeleted                 // 最新が削除マーカー
+)
+
+// versionEntry はキーのバージョン履歴の1件
+type versionEntry struct {
+	VersionID      string
+	Timestamp      time.Time
+	IsDeleteMarker bool
+	IsLatest       bool
+	Size           int64
+	ETag           string
+	// Removed は削除マーカーが削除されたことを表します
+	// 削除された削除マーカーはバージョン一覧に残らないため、前回の一覧との比較やログから判明したものです
+	Removed bool
+}
+
+// keyStateMachine はキーのバージョン履歴を時間順に適用し、変更の種類を判定するステートマシン
+//
+//	存在しない → 通常のバージョン: CREATE
+//	通常のバージョン → 通常のバージョン: UPDATE
+//	削除マーカー → 通常のバージョン: RECREATE
+//	任意 → 削除マーカー: DELETE
+//	最新の削除マーカーの削除で通常のバージョンが最新に戻る: UNDELETE
+type keyStateMachine struct {
+	key   string
+	stack []versionEntry // 現存するバージョン（古い順）
+}
+
+// newKeyStateMachine は新しいkeyStateMachineを作成します
+func newKeyStateMachine(key string) *keyStateMachine {
+	return &keyStateMachine{key: key}
+}
+
+// state はキーの現在の状態を返します
+func (m *keyStateMachine) state() keyState {
+	if len(m.stack) == 0 {
+		return keyStateAbsent
+	}
+	if m.stack[len(m.stack)-1].IsDeleteMarker {
+		return keyStateDeleted
+	}
+	return keyStateLive
+}
+
+// latest は現在の最新のバージョンを返します
+func (m *keyStateMachine) latest() versionEntry {
+	return m.stack[len(m.stack)-1]
+}
+
+// apply はバージョン履歴の1件を適用し、対応する変更を返します
+// 最新の状態が変わらない場合（最新でない削除マーカーの削除など）は false を返します
+func (m *keyStateMachine) apply(entry versionEntry) (ObjectChange, bool) {
+	if entry.Removed {
+		return m.removeDeleteMarker(entry)
+	}
+
+	change := ObjectChange{
+		Key:            m.key,
+		VersionID:      entry.VersionID,
+		Timestamp:      entry.Timestamp,
+		Size:           entry.Size,
+		ETag:           entry.ETag,
+		IsDeleteMarker: entry.IsDeleteMarker,
+	}
+
+	previous := m.state()
+	if previous == keyStateLive {
+		change.PreviousVersionID = m.latest().VersionID
+	}
+
+	switch {
+	case entry.IsDeleteMarker:
+		change.ChangeType = ChangeTypeDelete
+	case previous == keyStateLive:
+		change.ChangeType = ChangeTypeUpdate
+	case previous == keyStateDeleted:
+		change.ChangeType = ChangeTypeRecreate
+	default:
+		change.ChangeType = ChangeTypeCreate
+	}
+
+	m.stack = append(m.stack, entry)
+	return change, true
+}
+
+// removeDeleteMarker は削除マーカーの削除を適用します
+func (m *keyStateMachine) removeDeleteMarker(entry versionEntry) (ObjectChange, bool) {
+	index := -1
+	for i, v := range m.stack {
+		if v.IsDeleteMarker && v.VersionID == entry.VersionID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return ObjectChange{}, false
+	}
+
+	wasLatest := index == len(m.stack)-1
+	m.stack = append(m.stack[:index], m.stack[index+1:]...)
+
+	// 最新の削除マーカーが削除され、通常のバージョンが最新に戻った場合のみ復元になる
+	if !wasLatest || m.state() != keyStateLive {
+		return ObjectChange{}, false
+	}
+
+	restored := m.latest()
+	return ObjectChange{
+		Key:               m.key,
+		VersionID:         entry.VersionID,
+		ChangeType:        ChangeTypeUndelete,
+		Timestamp:         entry.Timestamp,
+		Size:              restored.Size,
+		ETag:              restored.ETag,
+		PreviousVersionID: restored.VersionID,
+	}, true
+}
+
+// inferChanges はキーの全バージョン履歴から、指定された時間以降（その時間を含む）の変更を求めます
+// 指定された時間より前の履歴は状態の判定にのみ使用します
+func inferChanges(key string, history []versionEntry, timestamp time.Time) []ObjectChange {
+	machine := newKeyStateMachine(key)
+
+	var changes []ObjectChange
+	for _, entry := range history {
+		change, ok := machine.apply(entry)
+		if ok && !entry.Timestamp.Before(timestamp) {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes
+}
+
+// versionHistory はキーのバージョンと削除マーカーを時間順のバージョン履歴にします
+func versionHistory(keyVersions KeyVersions) []versionEntry {
+	var history []versionEntry
+
+	for _, v := range keyVersions.Versions {
+		entry := versionEntry{
+			VersionID: aws.ToString(v.VersionId),
+			Timestamp: aws.ToTime(v.LastModified),
+			IsLatest:  v.IsLatest != nil && *v.IsLatest,
+		}
+		if v.Size != nil {
+			entry.Size = *v.Size
+		}
+		if v.ETag != nil {
+			entry.ETag = *v.ETag
+		}
+		history = append(history, entry)
+	}
+
+	for _, dm := range keyVersions.DeleteMarkers {
+		history = append(history, deleteMarkerEntry(dm))
+	}
+
+	sortVersionHistory(history)
+	return history
+}
+
+// deleteMarkerEntry は削除マーカーをバージョン履歴の1件にします
+func deleteMarkerEntry(dm s3types.DeleteMarkerEntry) versionEntry {
+	return versionEntry{
+		VersionID:      aws.ToString(dm.VersionId),
+		Timestamp:      aws.ToTime(dm.LastModified),
+		IsDeleteMarker: true,
+		IsLatest:       dm.IsLatest != nil && *dm.IsLatest,
+	}
+}
+
+// sortVersionHistory はバージョン履歴を時間順に並べます
+// 同じ時刻の場合は最新のバージョンを後ろにし、削除マーカーの削除は同時刻の他の履歴より後にします
+func sortVersionHistory(history []versionEntry) {
+	sort.SliceStable(history, func(i, j int) bool {
+		a, b := history[i], history[j]
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+		if a.Removed != b.Removed {
+			return b.Removed
+		}
+		return !a.IsLatest && b.IsLatest
+	})
+}
@@ -0,0 +1,143 @@
+package s3
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestKeyStateMachineTransitions(t *testing.T) {
+	base := time.Date(2025, 6, 5, 10, 0, 0, 0, time.UTC)
+	put := func(versionID string, minutes int) versionEntry {
+		return versionEntry{VersionID: versionID, Timestamp: base.Add(time.Duration(minutes) * time.Minute)}
+	}
+	marker := func(versionID string, minutes int) versionEntry {
+		return versionEntry{VersionID: versionID, Timestamp: base.Add(time.Duration(minutes) * time.Minute), IsDeleteMarker: true}
+	}
+	removed := func(versionID string, minutes int) versionEntry {
+		return versionEntry{VersionID: versionID, Timestamp: base.Add(time.Duration(minutes) * time.Minute), IsDeleteMarker: true, Removed: true}
+	}
+
+	// change は期待する変更（種類、バージョンID、前のバージョンID）
+	type change struct {
+		changeType ChangeType
+		versionID  string
+		previous   string
+	}
+
+	tests := []struct {
+		name    string
+		history []versionEntry
+		want    []change
+	}{
+		{
+			name:    "存在しない → 作成: CREATE",
+			history: []versionEntry{put("v1", 0)},
+			want:    []change{{ChangeTypeCreate, "v1", ""}},
+		},
+		{
+			name:    "通常のバージョン → 通常のバージョン: UPDATE",
+			history: []versionEntry{put("v1", 0), put("v2", 1)},
+			want:    []change{{ChangeTypeCreate, "v1", ""}, {ChangeTypeUpdate, "v2", "v1"}},
+		},
+		{
+			name:    "通常のバージョン → 削除マーカー: DELETE",
+			history: []versionEntry{put("v1", 0), marker("d1", 1)},
+			want:    []change{{ChangeTypeCreate, "v1", ""}, {ChangeTypeDelete, "d1", "v1"}},
+		},
+		{
+			name:    "削除マーカー → 新しいバージョン: RECREATE",
+			history: []versionEntry{put("v1", 0), marker("d1", 1), put("v2", 2)},
+			want:    []change{{ChangeTypeCreate, "v1", ""}, {ChangeTypeDelete, "d1", "v1"}, {ChangeTypeRecreate, "v2", ""}},
+		},
+		{
+			name:    "最新の削除マーカーの削除: UNDELETE",
+			history: []versionEntry{put("v1", 0), marker("d1", 1), removed("d1", 2)},
+			want:    []change{{ChangeTypeCreate, "v1", ""}, {ChangeTypeDelete, "d1", "v1"}, {ChangeTypeUndelete, "d1", "v1"}},
+		},
+		{
+			name:    "最新でない削除マーカーの削除は変更なし",
+			history: []versionEntry{put("v1", 0), marker("d1", 1), put("v2", 2), removed("d1", 3)},
+			want:    []change{{ChangeTypeCreate, "v1", ""}, {ChangeTypeDelete, "d1", "v1"}, {ChangeTypeRecreate, "v2", ""}},
+		},
+		{
+			name:    "削除マーカーが重なっている場合の削除は変更なし",
+			history: []versionEntry{put("v1", 0), marker("d1", 1), marker("d2", 2), removed("d2", 3)},
+			want:    []change{{ChangeTypeCreate, "v1", ""}, {ChangeTypeDelete, "d1", "v1"}, {ChangeTypeDelete, "d2", ""}},
+		},
+		{
+			name:    "存在しない → 削除マーカー: DELETE",
+			history: []versionEntry{marker("d1", 0)},
+			want:    []change{{ChangeTypeDelete, "d1", ""}},
+		},
+		{
+			name:    "履歴にない削除マーカーの削除は無視",
+			history: []versionEntry{put("v1", 0), removed("d1", 1)},
+			want:    []change{{ChangeTypeCreate, "v1", ""}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []change
+			for _, c := range inferChanges("key", tt.history, time.Time{}) {
+				got = append(got, change{c.ChangeType, c.VersionID, c.PreviousVersionID})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("inferChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInferChangesWindow(t *testing.T) {
+	base := time.Date(2025, 6, 5, 10, 0, 0, 0, time.UTC)
+	history := []versionEntry{
+		{VersionID: "v1", Timestamp: base},
+		{VersionID: "d1", Timestamp: base.Add(time.Minute), IsDeleteMarker: true},
+		{VersionID: "v2", Timestamp: base.Add(2 * time.Minute), Size: 10, ETag: "etag2"},
+		{VersionID: "v3", Timestamp: base.Add(3 * time.Minute)},
+	}
+
+	// 指定時間より前の履歴も状態の判定に使われる
+	got := inferChanges("key", history, base.Add(2*time.Minute))
+	if len(got) != 2 {
+		t.Fatalf("inferChanges() = %+v, want 2 changes", got)
+	}
+	if got[0].ChangeType != ChangeTypeRecreate || got[0].Size != 10 || got[0].ETag != "etag2" {
+		t.Errorf("1つ目の変更が期待と異なります: %+v", got[0])
+	}
+	if got[1].ChangeType != ChangeTypeUpdate || got[1].PreviousVersionID != "v2" {
+		t.Errorf("2つ目の変更が期待と異なります: %+v", got[1])
+	}
+
+	if got := inferChanges("key", nil, base); len(got) != 0 {
+		t.Errorf("空の履歴の変更 = %+v, want none", got)
+	}
+}
+
+func TestVersionHistory(t *testing.T) {
+	base := time.Date(2025, 6, 5, 10, 0, 0, 0, time.UTC)
+
+	// 同じ時刻の場合は最新のものが後になる
+	history := versionHistory(KeyVersions{
+		Versions: []s3types.ObjectVersion{
+			{Key: aws.String("key"), VersionId: aws.String("v2"), LastModified: aws.Time(base.Add(time.Minute)), IsLatest: aws.Bool(true)},
+			{Key: aws.String("key"), VersionId: aws.String("v1"), LastModified: aws.Time(base)},
+		},
+		DeleteMarkers: []s3types.DeleteMarkerEntry{
+			{Key: aws.String("key"), VersionId: aws.String("d1"), LastModified: aws.Time(base.Add(time.Minute)), IsLatest: aws.Bool(false)},
+		},
+	})
+
+	var order []string
+	for _, entry := range history {
+		order = append(order, entry.VersionID)
+	}
+	if want := []string{"v1", "d1", "v2"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("versionHistory() order = %v, want %v", order, want)
+	}
+}
@@ -0,0 +1,340 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionType は変更リストファイルの圧縮形式を表す列挙型
+type CompressionType string
+
+const (
+	CompressionAuto CompressionType = "auto" // 拡張子（読み込み時はファイル先頭のマジックナンバー）から判定
+	CompressionNone CompressionType = "none" // 圧縮なし
+	CompressionGzip CompressionType = "gzip" // gzip
+	CompressionZstd CompressionType = "zstd" // zstd
+)
+
+// s3URIScheme はS3上のファイルを表すURIのスキーム
+const s3URIScheme = "s3://"
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// ChangesFileOptions は変更リストファイルの読み書きのオプション
+type ChangesFileOptions struct {
+	Compression CompressionType   // 圧縮形式（空の場合はauto）
+	Header      *ChangeListHeader // 書き込み時にファイル先頭に記録するヘッダー
+	Client      ClientOptions     // s3:// のパスを読み書きする接続設定（空の場合はデフォルトの設定）
+}
+
+// ParseCompressionType は文字列から圧縮形式を解析します
+func ParseCompressionType(s string) (CompressionType, error) {
+	switch CompressionType(strings.ToLower(s)) {
+	case "", CompressionAuto:
+		return CompressionAuto, nil
+	case CompressionNone:
+		return CompressionNone, nil
+	case CompressionGzip, "gz":
+		return CompressionGzip, nil
+	case CompressionZstd, "zst":
+		return CompressionZstd, nil
+	default:
+		return "", fmt.Errorf("不明な圧縮形式です: %s (auto, none, gzip, zstd のいずれかを指定してください)", s)
+	}
+}
+
+// compressionFromPath は拡張子から圧縮形式を判定します
+func compressionFromPath(path string) CompressionType {
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".gz"), strings.HasSuffix(lower, ".gzip"):
+		return CompressionGzip
+	case strings.HasSuffix(lower, ".zst"), strings.HasSuffix(lower, ".zstd"):
+		return CompressionZstd
+	default:
+		return CompressionNone
+	}
+}
+
+// IsS3URI はパスが s3://bucket/key 形式かどうかを判定します
+func IsS3URI(path string) bool {
+	return strings.HasPrefix(path, s3URIScheme)
+}
+
+// ParseS3URI は s3://bucket/key 形式のURIをバケットとキーに分解します
+func ParseS3URI(uri string) (bucket, key string, err error) {
+	if !IsS3URI(uri) {
+		return "", "", fmt.Errorf("S3 URIではありません: %s", uri)
+	}
+
+	bucket, key, _ = strings.Cut(strings.TrimPrefix(uri, s3URIScheme), "/")
+	if bucket == "" || key == "" {
+		return "", "", fmt.Errorf("S3 URIにはバケットとキーの両方が必要です: %s", uri)
+	}
+
+	return bucket, key, nil
+}
+
+// createChangesOutput は変更リストの出力先を作成します
+// s3:// で始まるパスの場合はS3へのストリーミングアップロードになります
+func createChangesOutput(ctx context.Context, path string, opts ChangesFileOptions) (io.WriteCloser, error) {
+	var output io.WriteCloser
+	if IsS3URI(path) {
+		bucket, key, err := ParseS3URI(path)
+		if err != nil {
+			return nil, err
+		}
+
+		output, err = newS3ObjectWriter(ctx, opts.Client, bucket, key)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		output = file
+	}
+
+	compression := opts.Compression
+	if compression == "" || compression == CompressionAuto {
+		compression = compressionFromPath(path)
+	}
+
+	writer, err := newCompressWriter(output, compression)
+	if err != nil {
+		output.Close()
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// openChangesInput は変更リストの入力元を開き、必要に応じて伸長します
+// s3:// で始まるパスの場合はS3からストリーミングで読み込みます
+func openChangesInput(ctx context.Context, path string, opts ChangesFileOptions) (io.ReadCloser, error) {
+	var input io.ReadCloser
+	if IsS3URI(path) {
+		bucket, key, err := ParseS3URI(path)
+		if err != nil {
+			return nil, err
+		}
+
+		input, err = openS3Object(ctx, opts.Client, bucket, key)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		input = file
+	}
+
+	// 拡張子から判定できない場合は auto のままにし、マジックナンバーで判定する
+	compression := opts.Compression
+	if compression == "" || compression == CompressionAuto {
+		if fromPath := compressionFromPath(path); fromPath != CompressionNone {
+			compression = fromPath
+		}
+	}
+
+	reader, err := newDecompressReader(input, compression)
+	if err != nil {
+		input.Close()
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// compressWriter は圧縮ストリームと出力先をまとめて閉じるためのWriter
+type compressWriter struct {
+	buf        *bufio.Writer
+	compressor io.WriteCloser // 圧縮なしの場合はnil
+	output     io.WriteCloser
+}
+
+// newCompressWriter は指定された圧縮形式で書き込むWriterを作成します
+func newCompressWriter(output io.WriteCloser, compression CompressionType) (io.WriteCloser, error) {
+	w := &compressWriter{output: output}
+
+	switch compression {
+	case CompressionNone, CompressionAuto, "":
+		w.buf = bufio.NewWriter(output)
+	case CompressionGzip:
+		w.compressor = gzip.NewWriter(output)
+		w.buf = bufio.NewWriter(w.compressor)
+	case CompressionZstd:
+		encoder, err := zstd.NewWriter(output)
+		if err != nil {
+			return nil, fmt.Errorf("zstdエンコーダーの作成に失敗しました: %w", err)
+		}
+		w.compressor = encoder
+		w.buf = bufio.NewWriter(encoder)
+	default:
+		return nil, fmt.Errorf("不明な圧縮形式です: %s", compression)
+	}
+
+	return w, nil
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+// Close はバッファと圧縮ストリームをフラッシュしてから出力先を閉じます
+func (w *compressWriter) Close() error {
+	if err := w.buf.Flush(); err != nil {
+		w.output.Close()
+		return err
+	}
+
+	if w.compressor != nil {
+		if err := w.compressor.Close(); err != nil {
+			w.output.Close()
+			return err
+		}
+	}
+
+	return w.output.Close()
+}
+
+// decompressReader は伸長ストリームと入力元をまとめて閉じるためのReader
+type decompressReader struct {
+	io.Reader
+	decompressor io.Closer // 圧縮なしの場合はnil
+	input        io.Closer
+}
+
+// newDecompressReader は指定された圧縮形式で読み込むReaderを作成します
+// auto の場合はファイル先頭のマジックナンバーで判定し、none の場合は判定せずにそのまま読み込みます
+func newDecompressReader(input io.ReadCloser, compression CompressionType) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(input)
+
+	if compression == CompressionAuto || compression == "" {
+		head, _ := buffered.Peek(len(zstdMagic))
+		switch {
+		case bytes.HasPrefix(head, gzipMagic):
+			compression = CompressionGzip
+		case bytes.HasPrefix(head, zstdMagic):
+			compression = CompressionZstd
+		default:
+			compression = CompressionNone
+		}
+	}
+
+	r := &decompressReader{input: input}
+
+	switch compression {
+	case CompressionNone:
+		r.Reader = buffered
+	case CompressionGzip:
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("gzipストリームの読み込みに失敗しました: %w", err)
+		}
+		r.Reader = gz
+		r.decompressor = gz
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("zstdストリームの読み込みに失敗しました: %w", err)
+		}
+		rc := decoder.IOReadCloser()
+		r.Reader = rc
+		r.decompressor = rc
+	default:
+		return nil, fmt.Errorf("不明な圧縮形式です: %s", compression)
+	}
+
+	return r, nil
+}
+
+// Close は伸長ストリームと入力元を閉じます
+func (r *decompressReader) Close() error {
+	if r.decompressor != nil {
+		r.decompressor.Close()
+	}
+	return r.input.Close()
+}
+
+// s3ObjectWriter はS3オブジェクトへマルチパートアップロードでストリーミング書き込みするWriter
+type s3ObjectWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// newS3ObjectWriter は新しいs3ObjectWriterを作成します
+// ctx が中断されるとアップロードを中止します
+func newS3ObjectWriter(ctx context.Context, clientOpts ClientOptions, bucket, key string) (*s3ObjectWriter, error) {
+	client, err := NewClient(ctx, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	uploader := manager.NewUploader(client)
+	pr, pw := io.Pipe()
+	w := &s3ObjectWriter{pw: pw, done: make(chan error, 1)}
+
+	go func() {
+		_, err := uploader.Upload(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   pr,
+		})
+		if err != nil {
+			err = fmt.Errorf("s3://%s/%s へのアップロードに失敗しました: %w", bucket, key, err)
+		}
+		// アップロードが途中で失敗した場合に書き込み側がブロックしないようにする
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+
+	return w, nil
+}
+
+func (w *s3ObjectWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close は書き込みを終了し、アップロードの完了を待機します
+func (w *s3ObjectWriter) Close() error {
+	w.pw.Close()
+	return <-w.done
+}
+
+// openS3Object はS3オブジェクトを読み込み用に開きます
+// ctx が中断されると読み込みを中止します
+func openS3Object(ctx context.Context, clientOpts ClientOptions, bucket, key string) (io.ReadCloser, error) {
+	client, err := NewClient(ctx, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3://%s/%s の取得に失敗しました: %w", bucket, key, err)
+	}
+
+	return resp.Body, nil
+}
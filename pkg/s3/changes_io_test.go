@@ -0,0 +1,165 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestChangesFile はFileChangesWriterでテスト用の変更リストを書き込みます
+func writeTestChangesFile(t *testing.T, filePath string, opts ChangesFileOptions) []ObjectChange {
+	t.Helper()
+
+	changes := []ObjectChange{
+		{
+			Key:        "test/file1.txt",
+			VersionID:  "v1",
+			ChangeType: ChangeTypeCreate,
+			Timestamp:  time.Date(2025, 6, 5, 10, 0, 0, 0, time.UTC),
+			Size:       100,
+			ETag:       "etag1",
+		},
+		{
+			Key:            "test/file1.txt",
+			VersionID:      "v2",
+			ChangeType:     ChangeTypeDelete,
+			Timestamp:      time.Date(2025, 6, 5, 10, 5, 0, 0, time.UTC),
+			IsDeleteMarker: true,
+		},
+	}
+
+	writer, err := NewFileChangesWriterWithOptions(context.Background(), filePath, opts)
+	if err != nil {
+		t.Fatalf("FileChangesWriterの作成に失敗しました: %v", err)
+	}
+	if err := writer.WriteChanges(changes); err != nil {
+		t.Fatalf("変更リストの書き込みに失敗しました: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("FileChangesWriterのクローズに失敗しました: %v", err)
+	}
+
+	return changes
+}
+
+func TestChangesFileCompressionRoundTrip(t *testing.T) {
+	tests := []struct {
+		name        string
+		fileName    string
+		compression CompressionType
+		wantMagic   []byte
+	}{
+		{name: "圧縮なし", fileName: "changes.json"},
+		{name: "拡張子からgzipを判定", fileName: "changes.json.gz", wantMagic: gzipMagic},
+		{name: "拡張子からzstdを判定", fileName: "changes.json.zst", wantMagic: zstdMagic},
+		{name: "フラグでgzipを指定", fileName: "changes.json", compression: CompressionGzip, wantMagic: gzipMagic},
+		{name: "フラグでzstdを指定", fileName: "changes.bin", compression: CompressionZstd, wantMagic: zstdMagic},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), tt.fileName)
+			want := writeTestChangesFile(t, filePath, ChangesFileOptions{Compression: tt.compression})
+
+			data, err := os.ReadFile(filePath)
+			if err != nil {
+				t.Fatalf("ファイルの読み込みに失敗しました: %v", err)
+			}
+			if tt.wantMagic != nil && (len(data) < len(tt.wantMagic) || string(data[:len(tt.wantMagic)]) != string(tt.wantMagic)) {
+				t.Errorf("ファイルが期待した形式で圧縮されていません: % x", data[:min(len(data), 4)])
+			}
+
+			// 拡張子から判定できない場合もマジックナンバーで伸長できること
+			list, err := loadChangeList(context.Background(), filePath, ChangesFileOptions{})
+			if err != nil {
+				t.Fatalf("変更リストの読み込みに失敗しました: %v", err)
+			}
+			got := list.Changes
+			if len(got) != len(want) {
+				t.Fatalf("変更リストの長さが期待と異なります: got %d, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i].Key != want[i].Key || got[i].VersionID != want[i].VersionID || !got[i].Timestamp.Equal(want[i].Timestamp) {
+					t.Errorf("%d番目の変更が期待と異なります: got %+v, want %+v", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestOpenChangesInputCompressionNone は none を指定した場合に圧縮されたファイルも伸長せずに読み込むことを確認します
+func TestOpenChangesInputCompressionNone(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "changes.json")
+	writeTestChangesFile(t, filePath, ChangesFileOptions{Compression: CompressionGzip})
+
+	input, err := openChangesInput(context.Background(), filePath, ChangesFileOptions{Compression: CompressionNone})
+	if err != nil {
+		t.Fatalf("openChangesInput() error = %v", err)
+	}
+	defer input.Close()
+	data, err := io.ReadAll(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, gzipMagic) {
+		t.Errorf("none を指定したファイルが伸長されました: % x", data[:min(len(data), 4)])
+	}
+}
+
+func TestParseS3URI(t *testing.T) {
+	tests := []struct {
+		uri        string
+		wantBucket string
+		wantKey    string
+		wantErr    bool
+	}{
+		{uri: "s3://bucket/changes.json.gz", wantBucket: "bucket", wantKey: "changes.json.gz"},
+		{uri: "s3://bucket/path/to/changes.json", wantBucket: "bucket", wantKey: "path/to/changes.json"},
+		{uri: "s3://bucket", wantErr: true},
+		{uri: "s3://bucket/", wantErr: true},
+		{uri: "/tmp/changes.json", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			bucket, key, err := ParseS3URI(tt.uri)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseS3URI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if bucket != tt.wantBucket || key != tt.wantKey {
+				t.Errorf("ParseS3URI() = (%s, %s), want (%s, %s)", bucket, key, tt.wantBucket, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestParseCompressionType(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    CompressionType
+		wantErr bool
+	}{
+		{input: "", want: CompressionAuto},
+		{input: "auto", want: CompressionAuto},
+		{input: "none", want: CompressionNone},
+		{input: "GZIP", want: CompressionGzip},
+		{input: "zst", want: CompressionZstd},
+		{input: "bzip2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseCompressionType(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCompressionType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseCompressionType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
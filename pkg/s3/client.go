@@ -0,0 +1,74 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// roleSessionName はロールを引き受ける際のセッション名
+const roleSessionName = "trav"
+
+// ClientOptions はS3クライアントの接続設定
+// 空のフィールドは環境変数や共有設定ファイルのデフォルトを使用します
+type ClientOptions struct {
+	Profile  string // 共有設定ファイルのプロファイル名
+	RoleARN  string // 引き受けるIAMロールのARN（別アカウントへのアクセスなど）
+	Region   string // リージョン
+	Endpoint string // エンドポイントURL（S3互換ストレージなど。指定した場合はパス形式でアクセス）
+}
+
+// String はログ出力用に接続設定を文字列で返します
+func (o ClientOptions) String() string {
+	if o == (ClientOptions{}) {
+		return "default"
+	}
+	return fmt.Sprintf("profile=%s roleArn=%s region=%s endpoint=%s", o.Profile, o.RoleARN, o.Region, o.Endpoint)
+}
+
+// NewClient は接続設定からS3クライアントを作成します
+func NewClient(ctx context.Context, opts ClientOptions) (*s3.Client, error) {
+	cfg, err := loadAWSConfig(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
+			o.UsePathStyle = true
+		}
+	}), nil
+}
+
+// loadAWSConfig は接続設定からAWSの設定を読み込みます
+// エンドポイントはサービスごとに異なるため、呼び出し側でクライアントに設定します
+func loadAWSConfig(ctx context.Context, opts ClientOptions) (aws.Config, error) {
+	var loadOpts []func(*config.LoadOptions) error
+	if opts.Profile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(opts.Profile))
+	}
+	if opts.Region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(opts.Region))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("AWS設定の読み込みに失敗しました: %w", err)
+	}
+
+	// ロールが指定されている場合は、読み込んだ認証情報でロールを引き受ける
+	if opts.RoleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), opts.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = roleSessionName
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,128 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/aws/smithy-go"
+)
+
+// serverSideCopyUnsupportedCodes はリージョンやストレージをまたぐためにサーバーサイドコピーができないことを表すエラーコード
+// どのオブジェクトでも同じ結果になるため、以降のコピーではサーバーサイドコピーを使用しません
+var serverSideCopyUnsupportedCodes = map[string]bool{
+	"PermanentRedirect":            true,
+	"AuthorizationHeaderMalformed": true,
+	"NotImplemented":               true,
+}
+
+// serverSideCopyFallbackCodes はそのオブジェクトのサーバーサイドコピーができないことを表すエラーコード
+// 宛先の認証情報で一部の変更元を読み込めない場合や、5GBを超えるオブジェクトの場合などに返されるため、
+// そのコピーだけストリーミングコピーに切り替えます
+var serverSideCopyFallbackCodes = map[string]bool{
+	"AccessDenied":   true,
+	"NoSuchBucket":   true,
+	"InvalidRequest": true,
+	"EntityTooLarge": true,
+}
+
+// objectCopier は変更元から宛先へオブジェクトのバージョンをコピーします
+// サーバーサイドコピー（CopyObject）ができない場合は、変更元から読み込んで宛先に書き込むストリーミングコピーに切り替えます
+type objectCopier struct {
+	source Backend
+	dest   Backend
+
+	// サーバーサイドコピーを試すかどうか（リージョンやストレージをまたぐことがわかった場合は以降のコピーでも使用しない）
+	serverSide atomic.Bool
+
+	// ストリーミングコピーで書き込むオブジェクトに変更元のバージョンIDを記録するかどうか
+	markSourceVersion bool
+
+	// 宛先に書き込む前に内容を変換する場合の変換（nilの場合は変換しない）
+	transform ObjectTransformer
+}
+
+// newObjectCopier は変更元と宛先のBackendからobjectCopierを作成します
+func newObjectCopier(source, dest Backend) *objectCopier {
+	c := &objectCopier{
+		source: source,
+		dest:   dest,
+	}
+	c.serverSide.Store(supportsServerSideCopy(source, dest))
+	return c
+}
+
+// setTransform は宛先に書き込む前に内容を変換するようにします
+// 変換した内容を書き込むため、サーバーサイドコピーは使用しません
+func (c *objectCopier) setTransform(transform ObjectTransformer) {
+	if transform == nil {
+		return
+	}
+	c.transform = transform
+	c.serverSide.Store(false)
+}
+
+// copyVersion は変更元のバージョンを宛先のキーにコピーします
+func (c *objectCopier) copyVersion(ctx context.Context, sourceBucket, sourceKey, versionID, destBucket, destKey string) error {
+	if c.serverSide.Load() {
+		err := c.dest.CopyObject(ctx, sourceBucket, sourceKey, versionID, destBucket, destKey)
+		switch code := serverSideCopyErrorCode(err); {
+		case err == nil:
+			return nil
+		case serverSideCopyUnsupportedCodes[code]:
+			if c.serverSide.CompareAndSwap(true, false) {
+				slog.Warn("サーバーサイドコピーができないため、ストリーミングコピーに切り替えます", "key", sourceKey, "error", err)
+			}
+		case serverSideCopyFallbackCodes[code]:
+			slog.Debug("このオブジェクトはサーバーサイドコピーができないため、ストリーミングコピーします", "key", sourceKey, "error", err)
+		default:
+			return err
+		}
+	}
+
+	return c.streamCopy(ctx, sourceBucket, sourceKey, versionID, destBucket, destKey)
+}
+
+// streamCopy は変更元から読み込んだ内容を宛先に書き込みます
+func (c *objectCopier) streamCopy(ctx context.Context, sourceBucket, sourceKey, versionID, destBucket, destKey string) error {
+	content, err := c.source.GetObject(ctx, sourceBucket, sourceKey, versionID)
+	if err != nil {
+		return fmt.Errorf("変更元のオブジェクトの取得に失敗しました: %w", err)
+	}
+	defer content.Body.Close()
+
+	if c.transform != nil {
+		content, err = c.transform.Transform(ctx, TransformObject{
+			SourceBucket: sourceBucket,
+			SourceKey:    sourceKey,
+			VersionID:    versionID,
+			DestBucket:   destBucket,
+			DestKey:      destKey,
+		}, content)
+		if err != nil {
+			return fmt.Errorf("オブジェクトの変換に失敗しました: %w", err)
+		}
+		defer content.Body.Close()
+	}
+
+	metadata := content.Metadata
+	if c.markSourceVersion {
+		metadata = withSourceVersion(metadata, versionID)
+	}
+	if err := c.dest.PutObject(ctx, destBucket, destKey, content.Body, metadata); err != nil {
+		return fmt.Errorf("宛先への書き込みに失敗しました: %w", err)
+	}
+
+	return nil
+}
+
+// serverSideCopyErrorCode はサーバーサイドコピーのエラーコードを返します（APIのエラーでない場合は空文字列）
+func serverSideCopyErrorCode(err error) string {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return ""
+	}
+	return apiErr.ErrorCode()
+}
@@ -0,0 +1,123 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeCopyServer はCopyObject、GetObject、PutObjectを受け付けるS3互換のサーバー
+type fakeCopyServer struct {
+	mu           sync.Mutex
+	denyCode     string // CopyObjectに返すエラーコード（空文字列の場合はコピーする）
+	copyRequests int
+	getRequests  int
+	objects      map[string]string // パス → 内容
+}
+
+func (f *fakeCopyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		f.copyRequests++
+		if f.denyCode != "" {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>`+f.denyCode+`</Code><Message>denied</Message></Error>`)
+			return
+		}
+		io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><CopyObjectResult><ETag>"copied"</ETag></CopyObjectResult>`)
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = string(body)
+		w.Header().Set("ETag", `"put"`)
+	case r.Method == http.MethodGet:
+		f.getRequests++
+		if r.URL.Query().Get("versionId") != "v1" {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchVersion</Code></Error>`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"source":true}`)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newFakeCopyClient(t *testing.T, handler http.Handler) *s3.Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return s3.New(s3.Options{
+		Region:                     "us-east-1",
+		BaseEndpoint:               aws.String(server.URL),
+		UsePathStyle:               true,
+		Credentials:                credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		RetryMaxAttempts:           1,
+	})
+}
+
+func TestObjectCopier(t *testing.T) {
+	tests := []struct {
+		name     string
+		denyCode string
+		options  ClientOptions // 変更元の接続設定
+		wantCopy int
+		wantGet  int
+	}{
+		{name: "サーバーサイドコピー", wantCopy: 2},
+		{name: "コピーが拒否された場合はそのオブジェクトだけストリーミングコピー", denyCode: "AccessDenied", wantCopy: 2, wantGet: 2},
+		{name: "リージョンをまたぐ場合はストリーミングコピーに切り替え", denyCode: "PermanentRedirect", wantCopy: 1, wantGet: 2},
+		{name: "エンドポイントが異なる場合はストリーミングコピー", options: ClientOptions{Endpoint: "http://other"}, wantGet: 2},
+		{name: "プロファイルが異なる場合はストリーミングコピー", options: ClientOptions{Profile: "prod"}, wantGet: 2},
+		{name: "ロールが異なる場合はストリーミングコピー", options: ClientOptions{RoleARN: "arn:aws:iam::123456789012:role/prod"}, wantGet: 2},
+		{name: "リージョンが異なる場合はストリーミングコピー", options: ClientOptions{Region: "us-west-2"}, wantGet: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &fakeCopyServer{denyCode: tt.denyCode, objects: make(map[string]string)}
+			client := newFakeCopyClient(t, server)
+			source := newS3Backend(client)
+			source.options = tt.options
+			copier := newObjectCopier(source, newS3Backend(client))
+
+			for _, destKey := range []string{"staging/a.json", "staging/b.json"} {
+				if err := copier.copyVersion(context.Background(), "src", "prod/a.json", "v1", "dest", destKey); err != nil {
+					t.Fatalf("copyVersion() error = %v", err)
+				}
+			}
+
+			if server.copyRequests != tt.wantCopy || server.getRequests != tt.wantGet {
+				t.Errorf("CopyObject %d回, GetObject %d回, want %d回, %d回", server.copyRequests, server.getRequests, tt.wantCopy, tt.wantGet)
+			}
+			if tt.wantGet > 0 && server.objects["/dest/staging/b.json"] != `{"source":true}` {
+				t.Errorf("宛先の内容 = %v", server.objects)
+			}
+		})
+	}
+}
+
+func TestObjectCopierSourceError(t *testing.T) {
+	server := &fakeCopyServer{denyCode: "AccessDenied", objects: make(map[string]string)}
+	client := newFakeCopyClient(t, server)
+	copier := newObjectCopier(newS3Backend(client), newS3Backend(client))
+
+	// 変更元のバージョンが存在しない場合はストリーミングコピーのエラーを返す
+	if err := copier.copyVersion(context.Background(), "src", "prod/a.json", "missing", "dest", "a.json"); err == nil {
+		t.Fatal("存在しないバージョンのコピーでエラーになりません")
+	}
+	if len(server.objects) != 0 {
+		t.Errorf("宛先に書き込まれています: %v", server.objects)
+	}
+}
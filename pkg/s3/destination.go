@@ -0,0 +1,194 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ReplayDestination はリプレイの宛先
+// 複数の宛先を指定した場合、各変更は全ての宛先に同じ予定の時刻で反映されます
+type ReplayDestination struct {
+	Name        string        // 結果に記録する宛先の名前（空の場合はバケット名）
+	Bucket      string        // 宛先のバケット
+	KeyRewriter *KeyRewriter  // 宛先のキーの書き換えルール（nilの場合は変更元と同じキー）
+	Client      ClientOptions // 宛先の接続設定
+	Backend     Backend       // 宛先のストレージ（nilの場合はClientの設定のS3）
+	EventSink   EventSink     // イベント通知の送信先（指定した場合はオブジェクトを変更せずにイベント通知のみを送信）
+	EventRegion string        // イベント通知のリージョン（空の場合はDefaultEventRegion）
+}
+
+// DestinationResult は宛先ごとのリプレイの結果
+type DestinationResult struct {
+	Name           string `json:"name"`
+	Bucket         string `json:"bucket"`
+	SuccessEvents  int    `json:"successEvents"`
+	FailedEvents   int    `json:"failedEvents"`
+	SkippedEvents  int    `json:"skippedEvents"`
+	CanceledEvents int    `json:"canceledEvents"`
+}
+
+// record はイベントの結果を宛先の件数に加えます
+func (r *DestinationResult) record(event ReplayEvent) {
+	switch event.Status {
+	case "SUCCESS":
+		r.SuccessEvents++
+	case "FAILED":
+		r.FailedEvents++
+	case "DRYRUN", "SKIPPED":
+		r.SkippedEvents++
+	case "CANCELED":
+		r.CanceledEvents++
+	}
+}
+
+// cancelRemaining は実行されなかったイベントを中断により未実行として数えます
+// 各宛先には同じ件数のイベントを渡すため、宛先ごとの未実行の件数は全体の件数を宛先の数で割って求めます
+func (r *ReplayResult) cancelRemaining(processed int) {
+	r.CanceledEvents += r.TotalEvents - processed
+	if len(r.Destinations) == 0 {
+		return
+	}
+
+	perDestination := r.TotalEvents / len(r.Destinations)
+	for i := range r.Destinations {
+		dest := &r.Destinations[i]
+		executed := dest.SuccessEvents + dest.FailedEvents + dest.SkippedEvents + dest.CanceledEvents
+		dest.CanceledEvents += perDestination - executed
+	}
+}
+
+// replayTarget はリプレイの宛先と、その宛先への変更の反映方法
+type replayTarget struct {
+	ReplayDestination
+	execute      replayExecutor
+	dest         Backend        // 宛先のストレージ（イベント通知のみの宛先ではnil）
+	checkApplied appliedChecker // 宛先に反映済みかの確認（nilの場合は確認しない）
+}
+
+// replayDestinations はオプションからリプレイの宛先の一覧を返します
+// Destinations を指定しない場合は DestBucket などの設定を1つの宛先とします
+func replayDestinations(opts ReplayOptions) ([]ReplayDestination, error) {
+	if len(opts.Destinations) == 0 {
+		return []ReplayDestination{{
+			Bucket:      opts.DestBucket,
+			KeyRewriter: opts.KeyRewriter,
+			Client:      opts.Dest,
+			Backend:     opts.DestBackend,
+			EventSink:   opts.EventSink,
+			EventRegion: opts.EventRegion,
+		}}, nil
+	}
+
+	destinations := make([]ReplayDestination, len(opts.Destinations))
+	names := make(map[string]bool)
+	for i, dest := range opts.Destinations {
+		if dest.Bucket == "" {
+			return nil, fmt.Errorf("%d番目の宛先のバケットが指定されていません", i+1)
+		}
+		if dest.Name == "" {
+			dest.Name = dest.Bucket
+		}
+		if names[dest.Name] {
+			return nil, fmt.Errorf("宛先の名前 %s が重複しています。同じバケットに複数回リプレイする場合は名前を指定してください", dest.Name)
+		}
+		names[dest.Name] = true
+		destinations[i] = dest
+	}
+	return destinations, nil
+}
+
+// newReplayTargets は各宛先への変更の反映方法を準備します
+// 接続設定が同じ宛先と変更元は同じクライアントを使用します
+func newReplayTargets(ctx context.Context, opts ReplayOptions) ([]*replayTarget, error) {
+	destinations, err := replayDestinations(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	backends := &backendCache{ctx: ctx, backends: make(map[ClientOptions]Backend)}
+	targets := make([]*replayTarget, len(destinations))
+	for i, dest := range destinations {
+		execute, backend, err := newReplayExecutor(ctx, opts, dest, backends)
+		if err != nil {
+			return nil, err
+		}
+		targets[i] = &replayTarget{ReplayDestination: dest, execute: execute, dest: backend}
+		if opts.SkipApplied && backend != nil {
+			targets[i].checkApplied = newAppliedChecker(backend, dest.Bucket)
+		}
+	}
+	return targets, nil
+}
+
+// destinationsLabel は進捗ファイルに記録する宛先のバケットを返します（複数の場合はカンマ区切り）
+func destinationsLabel(targets []*replayTarget) string {
+	buckets := make([]string, len(targets))
+	for i, target := range targets {
+		buckets[i] = target.Bucket
+	}
+	return strings.Join(buckets, ",")
+}
+
+// backendCache は接続設定ごとにS3のストレージを1つだけ作成します
+type backendCache struct {
+	ctx      context.Context
+	backends map[ClientOptions]Backend
+}
+
+// get は接続設定のストレージを返します（初めての接続設定の場合は作成します）
+func (c *backendCache) get(opts ClientOptions) (Backend, error) {
+	if backend, ok := c.backends[opts]; ok {
+		return backend, nil
+	}
+	backend, err := NewS3Backend(c.ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.backends[opts] = backend
+	return backend, nil
+}
+
+// entryOutcome は変更リストのエントリの全ての宛先での実行結果
+type entryOutcome struct {
+	remaining int
+	event     ReplayEvent
+}
+
+// entryOutcomes は宛先ごとのイベントの結果を変更リストのエントリごとにまとめます
+// 全ての宛先で完了したエントリのみを完了、いずれかの宛先で失敗したエントリを失敗として進捗に記録するために使用します
+type entryOutcomes struct {
+	destinations int
+	pending      map[int]*entryOutcome
+}
+
+func newEntryOutcomes(destinations int) *entryOutcomes {
+	return &entryOutcomes{destinations: destinations, pending: make(map[int]*entryOutcome)}
+}
+
+// add はイベントの結果を追加し、エントリの全ての宛先の結果が揃った場合にまとめた結果を返します
+func (o *entryOutcomes) add(event ReplayEvent) (ReplayEvent, bool) {
+	if o.destinations <= 1 {
+		return event, true
+	}
+
+	outcome, ok := o.pending[event.Index]
+	if !ok {
+		outcome = &entryOutcome{remaining: o.destinations, event: event}
+		o.pending[event.Index] = outcome
+	}
+	outcome.remaining--
+
+	// 失敗を優先し、成功と反映済み以外（ドライラン、中断）が含まれる場合は成功にしない
+	switch {
+	case outcome.event.Status == "FAILED":
+	case event.Status != "SUCCESS" && event.Status != "SKIPPED":
+		outcome.event = event
+	}
+
+	if outcome.remaining > 0 {
+		return ReplayEvent{}, false
+	}
+	delete(o.pending, event.Index)
+	return outcome.event, true
+}
@@ -0,0 +1,111 @@
+package s3
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestReplayDestinations は各変更を全ての宛先に反映し、宛先ごとの結果を集計することを確認します
+func TestReplayDestinations(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	source := newTestLocalBackend(t, filepath.Join(dir, "source"))
+	dest1 := newTestLocalBackend(t, filepath.Join(dir, "dest1"))
+	dest2 := newTestLocalBackend(t, filepath.Join(dir, "dest2"))
+
+	start := time.Now().UTC()
+	putLocalObject(t, source, "prod", "tenant/a.txt", "a1")
+	putLocalObject(t, source, "prod", "tenant/b.txt", "b1")
+	putLocalObject(t, source, "prod", "tenant/a.txt", "a2")
+
+	changes, changesFile := writeLocalChangesFile(t, source, "prod", start, dir)
+
+	rewriter, _ := NewKeyRewriter([]string{"prefix:tenant/=>stg2/"})
+	result, err := Replay(ctx, ReplayOptions{
+		SourceBucket:      "prod",
+		SourceFile:        changesFile,
+		Concurrency:       4,
+		IgnoreTimeWindows: true,
+		SourceBackend:     source,
+		ProgressFile:      filepath.Join(dir, "progress.json"),
+		Destinations: []ReplayDestination{
+			{Bucket: "staging", Backend: dest1},
+			{Name: "stg2", Bucket: "staging", KeyRewriter: rewriter, Backend: dest2},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+
+	if result.TotalEvents != 2*len(changes) || result.SuccessEvents != 2*len(changes) {
+		t.Fatalf("イベント数 = %d (成功 %d), want %d: %+v", result.TotalEvents, result.SuccessEvents, 2*len(changes), result.Events)
+	}
+	if len(result.Destinations) != 2 {
+		t.Fatalf("宛先ごとの結果 = %+v", result.Destinations)
+	}
+	for i, name := range []string{"staging", "stg2"} {
+		dest := result.Destinations[i]
+		if dest.Name != name || dest.SuccessEvents != len(changes) {
+			t.Errorf("Destinations[%d] = %+v, want %s の成功 %d", i, dest, name, len(changes))
+		}
+	}
+	for _, event := range result.Events {
+		if event.Destination != "staging" && event.Destination != "stg2" {
+			t.Errorf("イベントの宛先 = %q", event.Destination)
+		}
+	}
+
+	if got := readLocalObject(t, dest1, "staging", "tenant/a.txt"); got != "a2" {
+		t.Errorf("dest1 tenant/a.txt = %q, want a2", got)
+	}
+	if got := readLocalObject(t, dest2, "staging", "stg2/a.txt"); got != "a2" {
+		t.Errorf("dest2 stg2/a.txt = %q, want a2", got)
+	}
+	if got := readLocalObject(t, dest2, "staging", "stg2/b.txt"); got != "b1" {
+		t.Errorf("dest2 stg2/b.txt = %q, want b1", got)
+	}
+
+	// 進捗は変更リストのエントリごとに全ての宛先の結果をまとめて記録する
+	progress, err := LoadReplayProgress(filepath.Join(dir, "progress.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.DestBucket != "staging,staging" || progress.TotalEvents != len(changes) || progress.Completed != len(changes) {
+		t.Errorf("進捗 = %+v", progress)
+	}
+}
+
+func TestReplayDestinationsDuplicateName(t *testing.T) {
+	_, err := replayDestinations(ReplayOptions{Destinations: []ReplayDestination{{Bucket: "a"}, {Bucket: "a"}}})
+	if err == nil {
+		t.Error("replayDestinations() error = nil, want duplicate name error")
+	}
+}
+
+func TestEntryOutcomes(t *testing.T) {
+	outcomes := newEntryOutcomes(3)
+
+	// 全ての宛先の結果が揃うまで返さず、失敗を優先する
+	for _, status := range []string{"SUCCESS", "FAILED"} {
+		if _, ok := outcomes.add(ReplayEvent{Index: 0, Status: status}); ok {
+			t.Fatalf("%s の後に結果が返されました", status)
+		}
+	}
+	event, ok := outcomes.add(ReplayEvent{Index: 0, Status: "SUCCESS"})
+	if !ok || event.Status != "FAILED" {
+		t.Errorf("add() = %+v, %v, want FAILED", event, ok)
+	}
+
+	// 中断された宛先がある場合は成功にしない
+	outcomes.add(ReplayEvent{Index: 1, Status: "SUCCESS"})
+	outcomes.add(ReplayEvent{Index: 1, Status: "CANCELED"})
+	event, ok = outcomes.add(ReplayEvent{Index: 1, Status: "SUCCESS"})
+	if !ok || event.Status != "CANCELED" {
+		t.Errorf("add() = %+v, %v, want CANCELED", event, ok)
+	}
+	if len(outcomes.pending) != 0 {
+		t.Errorf("結果が揃ったエントリが残っています: %d件", len(outcomes.pending))
+	}
+}
@@ -0,0 +1,379 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiffType は差分の種類を表す列挙型
+type DiffType string
+
+const (
+	DiffTypeAdded    DiffType = "ADDED"    // 比較先にのみ存在する
+	DiffTypeRemoved  DiffType = "REMOVED"  // 比較元にのみ存在する
+	DiffTypeModified DiffType = "MODIFIED" // 両方に存在し内容が異なる
+)
+
+// DiffSource は比較する一方の状態の取得元
+type DiffSource struct {
+	Bucket    string
+	Prefix    string
+	Timestamp time.Time     // この時間より前の状態（ゼロ値の場合は現在の状態）
+	Manifest  string        // スナップショットのマニフェスト（指定した場合はバケットの代わりに使用）
+	Client    ClientOptions // バケットの接続設定
+	Backend   Backend       // バケットのストレージ（nilの場合はClientの設定のS3）
+}
+
+// String は取得元を表す文字列を返します
+func (s DiffSource) String() string {
+	if s.Manifest != "" {
+		return s.Manifest
+	}
+
+	uri := fmt.Sprintf("s3://%s/%s", s.Bucket, s.Prefix)
+	if !s.Timestamp.IsZero() {
+		uri += "@" + s.Timestamp.Format(time.RFC3339)
+	}
+	return uri
+}
+
+// DiffOptions は差分取得のオプション
+type DiffOptions struct {
+	From         DiffSource
+	To           DiffSource
+	SaveManifest string           // 比較元の状態を保存するマニフェストのパス
+	Concurrency  int              // キー一覧とバージョン一覧を取得する並列数（0以下の場合はDefaultConcurrency）
+	Retry        RetryPolicy      // 一時的なエラーで失敗した一覧の取得の再実行の方針
+	RateLimit    RateLimitOptions // リクエスト数の制限（比較元と比較先で共有）
+}
+
+// ObjectState はある時点でのオブジェクトの状態
+type ObjectState struct {
+	Key          string    `json:"key"`
+	VersionID    string    `json:"versionId,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// DiffEntry はキーごとの差分
+// キーは比較元・比較先それぞれのプレフィックスからの相対パスです
+type DiffEntry struct {
+	Key  string       `json:"key"`
+	Type DiffType     `json:"type"`
+	From *ObjectState `json:"from,omitempty"`
+	To   *ObjectState `json:"to,omitempty"`
+}
+
+// DiffResult は差分取得の結果
+type DiffResult struct {
+	From      string      `json:"from"`
+	To        string      `json:"to"`
+	Added     int         `json:"added"`
+	Removed   int         `json:"removed"`
+	Modified  int         `json:"modified"`
+	Unchanged int         `json:"unchanged"`
+	Entries   []DiffEntry `json:"entries"`
+}
+
+// Manifest はバケットのある時点での状態を保存するスナップショット
+type Manifest struct {
+	Bucket      string        `json:"bucket"`
+	Prefix      string        `json:"prefix"`
+	Timestamp   time.Time     `json:"timestamp,omitempty"` // ゼロ値の場合は作成時点の状態
+	GeneratedAt time.Time     `json:"generatedAt"`
+	Objects     []ObjectState `json:"objects"`
+}
+
+// Diff は2つの状態の差分を取得します
+func Diff(ctx context.Context, opts DiffOptions) (*DiffResult, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	limiter := NewRateLimiter(opts.RateLimit)
+
+	from, fromPrefix, err := loadDiffState(ctx, opts, opts.From, limiter)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.SaveManifest != "" {
+		manifest := &Manifest{
+			Bucket:      opts.From.Bucket,
+			Prefix:      fromPrefix,
+			Timestamp:   opts.From.Timestamp,
+			GeneratedAt: time.Now().UTC(),
+			Objects:     from,
+		}
+		if err := SaveManifest(ctx, opts.SaveManifest, manifest); err != nil {
+			return nil, err
+		}
+		slog.Info("マニフェストを保存しました", "file", opts.SaveManifest, "objects", len(from))
+	}
+
+	to, toPrefix, err := loadDiffState(ctx, opts, opts.To, limiter)
+	if err != nil {
+		return nil, err
+	}
+
+	result := diffStates(from, fromPrefix, to, toPrefix)
+	result.From = opts.From.String()
+	result.To = opts.To.String()
+	return result, nil
+}
+
+// loadDiffState は取得元の状態と、キーの相対パスの基準にするプレフィックスを読み込みます
+func loadDiffState(ctx context.Context, opts DiffOptions, source DiffSource, limiter *RateLimiter) ([]ObjectState, string, error) {
+	if source.Manifest != "" {
+		manifest, err := LoadManifest(ctx, source.Manifest)
+		if err != nil {
+			return nil, "", err
+		}
+
+		prefix := source.Prefix
+		if prefix == "" {
+			prefix = manifest.Prefix
+		}
+		return manifest.Objects, prefix, nil
+	}
+
+	backend := source.Backend
+	if backend == nil {
+		s3Backend, err := NewS3Backend(ctx, source.Client)
+		if err != nil {
+			slog.Error("AWS設定の読み込みに失敗しました", "error", err)
+			return nil, "", fmt.Errorf("AWS設定の読み込みに失敗しました: %w", err)
+		}
+		backend = s3Backend
+	}
+	// 一覧の取得はリクエストごとに制限を受け、スロットリングなどで失敗した場合は再実行する
+	backend = withRetry(withRateLimit(backend, limiter), opts.Retry)
+
+	slog.Info("バケットの状態を取得します", "source", source.String())
+	states, err := listObjectStates(ctx, backend, source.Bucket, source.Prefix, source.Timestamp, opts.Concurrency)
+	return states, source.Prefix, err
+}
+
+// listObjectStates はプレフィックスに一致するオブジェクトの、指定された時間より前の状態を取得します
+// 現在は削除されているキーも対象にするため、削除されたキーを含むキー一覧から各キーのバージョン履歴を並列で取得します
+func listObjectStates(ctx context.Context, backend Backend, bucket, prefix string, timestamp time.Time, concurrency int) ([]ObjectState, error) {
+	keys, err := backend.ListVersionedKeys(ctx, bucket, prefix, concurrency)
+	if err != nil {
+		return nil, fmt.Errorf("オブジェクト一覧の取得に失敗しました: %w", err)
+	}
+
+	// キーの順序を保つため、各キーの結果はキーと同じ位置に格納する
+	states := make([]ObjectState, len(keys))
+	exists := make([]bool, len(keys))
+	indexCh := make(chan int)
+	errCh := make(chan error, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexCh {
+				keyVersions, err := backend.ListVersions(ctx, bucket, keys[index])
+				if err != nil {
+					errCh <- fmt.Errorf("%s のバージョン一覧の取得に失敗しました: %w", keys[index], err)
+					return
+				}
+				states[index], exists[index] = resolveObjectState(keys[index], versionHistory(keyVersions), timestamp)
+			}
+		}()
+	}
+
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		defer close(indexCh)
+		for index := range keys {
+			select {
+			case indexCh <- index:
+			case <-listCtx.Done():
+				return
+			}
+		}
+	}()
+
+	// 最初のエラーで残りのキーの送信を止める
+	go func() {
+		wg.Wait()
+		close(errCh)
+	}()
+	if err, ok := <-errCh; ok {
+		cancel()
+		wg.Wait()
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var result []ObjectState
+	for i, state := range states {
+		if exists[i] {
+			result = append(result, state)
+		}
+	}
+	return result, nil
+}
+
+// resolveObjectState はバージョン履歴から指定された時間より前のオブジェクトの状態を求めます
+// その時点で存在しない（バージョンがない、または最新が削除マーカー）場合は false を返します
+func resolveObjectState(key string, history []versionEntry, timestamp time.Time) (ObjectState, bool) {
+	entry, ok := versionAtTimestamp(history, timestamp)
+	if !ok || entry.IsDeleteMarker {
+		return ObjectState{}, false
+	}
+
+	return ObjectState{
+		Key:          key,
+		VersionID:    entry.VersionID,
+		ETag:         entry.ETag,
+		Size:         entry.Size,
+		LastModified: entry.Timestamp,
+	}, true
+}
+
+// versionAtTimestamp は時間順のバージョン履歴から、指定された時間より前の最新の履歴を返します
+// timestamp がゼロ値の場合は最新の履歴を返します
+func versionAtTimestamp(history []versionEntry, timestamp time.Time) (versionEntry, bool) {
+	var latest versionEntry
+	var found bool
+
+	for _, entry := range history {
+		if !timestamp.IsZero() && !entry.Timestamp.Before(timestamp) {
+			break
+		}
+		latest = entry
+		found = true
+	}
+
+	return latest, found
+}
+
+// diffStates は2つの状態をプレフィックスからの相対パスで比較します
+func diffStates(from []ObjectState, fromPrefix string, to []ObjectState, toPrefix string) *DiffResult {
+	fromByKey := make(map[string]ObjectState, len(from))
+	for _, state := range from {
+		fromByKey[strings.TrimPrefix(state.Key, fromPrefix)] = state
+	}
+	toByKey := make(map[string]ObjectState, len(to))
+	for _, state := range to {
+		toByKey[strings.TrimPrefix(state.Key, toPrefix)] = state
+	}
+
+	keys := make([]string, 0, len(fromByKey)+len(toByKey))
+	for key := range fromByKey {
+		keys = append(keys, key)
+	}
+	for key := range toByKey {
+		if _, ok := fromByKey[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := &DiffResult{}
+	for _, key := range keys {
+		fromState, inFrom := fromByKey[key]
+		toState, inTo := toByKey[key]
+
+		switch {
+		case !inFrom:
+			result.Added++
+			result.Entries = append(result.Entries, DiffEntry{Key: key, Type: DiffTypeAdded, To: &toState})
+		case !inTo:
+			result.Removed++
+			result.Entries = append(result.Entries, DiffEntry{Key: key, Type: DiffTypeRemoved, From: &fromState})
+		case sameContent(fromState, toState):
+			result.Unchanged++
+		default:
+			result.Modified++
+			result.Entries = append(result.Entries, DiffEntry{Key: key, Type: DiffTypeModified, From: &fromState, To: &toState})
+		}
+	}
+
+	return result
+}
+
+// sameContent は2つのオブジェクトの内容が同じかを判定します
+// 別のバケットではバージョンIDが異なるため、ETagとサイズで比較します
+func sameContent(a, b ObjectState) bool {
+	if a.VersionID != "" && a.VersionID == b.VersionID && a.Key == b.Key {
+		return true
+	}
+	return a.ETag == b.ETag && a.Size == b.Size
+}
+
+// LoadManifest はマニフェストを読み込みます
+// 変更リストと同様に s3://bucket/key 形式のURIと、gzip/zstdでの圧縮に対応しています
+func LoadManifest(ctx context.Context, filePath string) (*Manifest, error) {
+	file, err := openChangesInput(ctx, filePath, ChangesFileOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var manifest Manifest
+	if err := json.NewDecoder(file).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("マニフェストのデコードに失敗しました: %w", err)
+	}
+
+	return &manifest, nil
+}
+
+// SaveManifest はマニフェストを保存します
+func SaveManifest(ctx context.Context, filePath string, manifest *Manifest) error {
+	file, err := createChangesOutput(ctx, filePath, ChangesFileOptions{})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		file.Close()
+		return fmt.Errorf("マニフェストの書き込みに失敗しました: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("マニフェストの書き込みに失敗しました: %w", err)
+	}
+
+	return nil
+}
+
+// PrintDiffResult は差分取得の結果を出力します
+func PrintDiffResult(result *DiffResult, writer io.Writer) {
+	fmt.Fprintf(writer, "差分結果:\n")
+	fmt.Fprintf(writer, "  比較元: %s\n", result.From)
+	fmt.Fprintf(writer, "  比較先: %s\n", result.To)
+	fmt.Fprintf(writer, "  追加: %d\n", result.Added)
+	fmt.Fprintf(writer, "  削除: %d\n", result.Removed)
+	fmt.Fprintf(writer, "  変更: %d\n", result.Modified)
+	fmt.Fprintf(writer, "  変更なし: %d\n", result.Unchanged)
+
+	if len(result.Entries) > 0 {
+		fmt.Fprintf(writer, "\n詳細:\n")
+	}
+	for _, entry := range result.Entries {
+		switch entry.Type {
+		case DiffTypeAdded:
+			fmt.Fprintf(writer, "  + %s (versionId: %s, etag: %s)\n", entry.Key, entry.To.VersionID, entry.To.ETag)
+		case DiffTypeRemoved:
+			fmt.Fprintf(writer, "  - %s (versionId: %s, etag: %s)\n", entry.Key, entry.From.VersionID, entry.From.ETag)
+		case DiffTypeModified:
+			fmt.Fprintf(writer, "  ~ %s (versionId: %s -> %s, etag: %s -> %s)\n", entry.Key,
+				entry.From.VersionID, entry.To.VersionID, entry.From.ETag, entry.To.ETag)
+		}
+	}
+}
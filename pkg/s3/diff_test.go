@@ -0,0 +1,151 @@
+package s3
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListObjectStates(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestLocalBackend(t, t.TempDir())
+
+	putLocalObject(t, backend, "bucket", "p/a", "a1")
+	putLocalObject(t, backend, "bucket", "p/b", "b1")
+	putLocalObject(t, backend, "bucket", "other", "o1")
+	beforeUpdate := time.Now().UTC()
+	putLocalObject(t, backend, "bucket", "p/b", "b22")
+	beforeDelete := time.Now().UTC()
+	deleteLocalObject(t, backend, "bucket", "p/a")
+	putLocalObject(t, backend, "bucket", "p/c", "c1")
+
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		want      map[string]int64 // キー → サイズ
+	}{
+		{name: "現在の状態", want: map[string]int64{"p/b": 3, "p/c": 2}},
+		{name: "削除前の状態", timestamp: beforeDelete, want: map[string]int64{"p/a": 2, "p/b": 3}},
+		{name: "更新前の状態", timestamp: beforeUpdate, want: map[string]int64{"p/a": 2, "p/b": 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			states, err := listObjectStates(ctx, backend, "bucket", "p/", tt.timestamp, 2)
+			if err != nil {
+				t.Fatalf("listObjectStates() error = %v", err)
+			}
+
+			got := make(map[string]int64)
+			for i, state := range states {
+				got[state.Key] = state.Size
+				if i > 0 && states[i-1].Key >= state.Key {
+					t.Errorf("キー順に並んでいません: %v", states)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listObjectStates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := listObjectStates(ctx, backend, "missing", "p/", time.Time{}, 2); err == nil {
+		t.Error("存在しないバケットでエラーになりません")
+	}
+}
+
+// TestDiffLocalBackends は別々のストレージのバケットを比較することを確認します
+func TestDiffLocalBackends(t *testing.T) {
+	dir := t.TempDir()
+	source := newTestLocalBackend(t, filepath.Join(dir, "source"))
+	dest := newTestLocalBackend(t, filepath.Join(dir, "dest"))
+
+	putLocalObject(t, source, "prod", "tenant/a.txt", "a1")
+	putLocalObject(t, source, "prod", "tenant/b.txt", "b1")
+	putLocalObject(t, source, "prod", "tenant/c.txt", "c1")
+	putLocalObject(t, dest, "staging", "copy/a.txt", "a1")
+	putLocalObject(t, dest, "staging", "copy/b.txt", "b2")
+	putLocalObject(t, dest, "staging", "copy/d.txt", "d1")
+
+	result, err := Diff(context.Background(), DiffOptions{
+		From: DiffSource{Bucket: "prod", Prefix: "tenant/", Backend: source},
+		To:   DiffSource{Bucket: "staging", Prefix: "copy/", Backend: dest},
+	})
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if result.Added != 1 || result.Removed != 1 || result.Modified != 1 || result.Unchanged != 1 {
+		t.Errorf("結果 = 追加 %d、削除 %d、変更 %d、変更なし %d, want 1件ずつ: %+v", result.Added, result.Removed, result.Modified, result.Unchanged, result.Entries)
+	}
+}
+
+func TestDiffStates(t *testing.T) {
+	from := []ObjectState{
+		{Key: "src/same", VersionID: "s1", ETag: "e1", Size: 1},
+		{Key: "src/changed", VersionID: "c1", ETag: "e1", Size: 1},
+		{Key: "src/removed", VersionID: "r1", ETag: "e1", Size: 1},
+	}
+	to := []ObjectState{
+		{Key: "dst/same", VersionID: "x1", ETag: "e1", Size: 1},
+		{Key: "dst/changed", VersionID: "x2", ETag: "e2", Size: 2},
+		{Key: "dst/added", VersionID: "x3", ETag: "e3", Size: 3},
+	}
+
+	result := diffStates(from, "src/", to, "dst/")
+
+	if result.Added != 1 || result.Removed != 1 || result.Modified != 1 || result.Unchanged != 1 {
+		t.Fatalf("diffStates() = %+v", result)
+	}
+
+	want := []struct {
+		key      string
+		diffType DiffType
+	}{
+		{"added", DiffTypeAdded},
+		{"changed", DiffTypeModified},
+		{"removed", DiffTypeRemoved},
+	}
+	for i, w := range want {
+		entry := result.Entries[i]
+		if entry.Key != w.key || entry.Type != w.diffType {
+			t.Errorf("Entries[%d] = %s %s, want %s %s", i, entry.Type, entry.Key, w.diffType, w.key)
+		}
+	}
+	if modified := result.Entries[1]; modified.From.VersionID != "c1" || modified.To.VersionID != "x2" {
+		t.Errorf("変更のバージョンIDが期待と異なります: %+v", modified)
+	}
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "manifest.json.gz")
+	manifest := &Manifest{
+		Bucket:      "bucket",
+		Prefix:      "p/",
+		GeneratedAt: time.Date(2025, 6, 5, 10, 0, 0, 0, time.UTC),
+		Objects:     []ObjectState{{Key: "p/a", VersionID: "a1", ETag: "ea1", Size: 3}},
+	}
+
+	if err := SaveManifest(context.Background(), filePath, manifest); err != nil {
+		t.Fatalf("SaveManifest() error = %v", err)
+	}
+
+	loaded, err := LoadManifest(context.Background(), filePath)
+	if err != nil {
+		t.Fatalf("LoadManifest() error = %v", err)
+	}
+	if loaded.Bucket != "bucket" || len(loaded.Objects) != 1 || loaded.Objects[0] != manifest.Objects[0] {
+		t.Errorf("LoadManifest() = %+v", loaded)
+	}
+
+	result := &DiffResult{From: filePath, To: "s3://bucket/p/", Modified: 1, Entries: []DiffEntry{
+		{Key: "a", Type: DiffTypeModified, From: &loaded.Objects[0], To: &ObjectState{Key: "p/a", VersionID: "a2", ETag: "ea2"}},
+	}}
+	var output strings.Builder
+	PrintDiffResult(result, &output)
+	if !strings.Contains(output.String(), "~ a (versionId: a1 -> a2, etag: ea1 -> ea2)") {
+		t.Errorf("PrintDiffResult() = %s", output.String())
+	}
+}
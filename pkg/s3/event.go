@@ -0,0 +1,150 @@
+package s3
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3イベント通知のイベント名
+const (
+	EventNameObjectCreatedPut                     = "ObjectCreated:Put"
+	EventNameObjectCreatedCompleteMultipartUpload = "ObjectCreated:CompleteMultipartUpload"
+	EventNameObjectRemovedDelete                  = "ObjectRemoved:Delete"
+	EventNameObjectRemovedDeleteMarkerCreated     = "ObjectRemoved:DeleteMarkerCreated"
+)
+
+// DefaultEventRegion はイベント通知のリージョンが指定されていない場合に使用するリージョン
+const DefaultEventRegion = "us-east-1"
+
+// eventPrincipalID はリプレイで生成したイベントの実行者
+const eventPrincipalID = "trav-replay"
+
+// S3EventNotification はS3イベント通知のメッセージ
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/notification-content-structure.html
+type S3EventNotification struct {
+	Records []S3EventRecord `json:"Records"`
+}
+
+// S3EventRecord はS3イベント通知の1件のレコード
+type S3EventRecord struct {
+	EventVersion      string            `json:"eventVersion"`
+	EventSource       string            `json:"eventSource"`
+	AWSRegion         string            `json:"awsRegion"`
+	EventTime         string            `json:"eventTime"`
+	EventName         string            `json:"eventName"`
+	UserIdentity      S3EventIdentity   `json:"userIdentity"`
+	RequestParameters map[string]string `json:"requestParameters"`
+	ResponseElements  map[string]string `json:"responseElements"`
+	S3                S3EventEntity     `json:"s3"`
+}
+
+// S3EventIdentity はイベントの実行者またはバケットの所有者
+type S3EventIdentity struct {
+	PrincipalID string `json:"principalId"`
+}
+
+// S3EventEntity はイベントの対象のバケットとオブジェクト
+type S3EventEntity struct {
+	SchemaVersion   string        `json:"s3SchemaVersion"`
+	ConfigurationID string        `json:"configurationId"`
+	Bucket          S3EventBucket `json:"bucket"`
+	Object          S3EventObject `json:"object"`
+}
+
+// S3EventBucket はイベントの対象のバケット
+type S3EventBucket struct {
+	Name          string          `json:"name"`
+	OwnerIdentity S3EventIdentity `json:"ownerIdentity"`
+	ARN           string          `json:"arn"`
+}
+
+// S3EventObject はイベントの対象のオブジェクト
+type S3EventObject struct {
+	Key       string `json:"key"` // URLエンコードされたキー
+	Size      *int64 `json:"size,omitempty"`
+	ETag      string `json:"eTag,omitempty"`
+	VersionID string `json:"versionId,omitempty"`
+	Sequencer string `json:"sequencer"`
+}
+
+// newS3EventNotification は変更からS3イベント通知を作成します
+// イベントの時刻は変更が発生した時刻になります
+func newS3EventNotification(change ObjectChange, bucket, key, region string) (S3EventNotification, error) {
+	eventName, err := eventNameForChange(change)
+	if err != nil {
+		return S3EventNotification{}, err
+	}
+
+	if region == "" {
+		region = DefaultEventRegion
+	}
+
+	object := S3EventObject{
+		Key:       encodeEventKey(key),
+		VersionID: change.VersionID,
+		Sequencer: eventSequencer(change.Timestamp),
+	}
+	if strings.HasPrefix(eventName, "ObjectCreated:") {
+		size := change.Size
+		object.Size = &size
+		object.ETag = strings.Trim(change.ETag, `"`)
+	}
+
+	record := S3EventRecord{
+		EventVersion:      "2.1",
+		EventSource:       "aws:s3",
+		AWSRegion:         region,
+		EventTime:         change.Timestamp.UTC().Format("2006-01-02T15:04:05.000Z"),
+		EventName:         eventName,
+		UserIdentity:      S3EventIdentity{PrincipalID: eventPrincipalID},
+		RequestParameters: map[string]string{"sourceIPAddress": "127.0.0.1"},
+		ResponseElements: map[string]string{
+			"x-amz-request-id": object.Sequencer,
+			"x-amz-id-2":       eventPrincipalID,
+		},
+		S3: S3EventEntity{
+			SchemaVersion:   "1.0",
+			ConfigurationID: eventPrincipalID,
+			Bucket: S3EventBucket{
+				Name:          bucket,
+				OwnerIdentity: S3EventIdentity{PrincipalID: eventPrincipalID},
+				ARN:           "arn:aws:s3:::" + bucket,
+			},
+			Object: object,
+		},
+	}
+
+	return S3EventNotification{Records: []S3EventRecord{record}}, nil
+}
+
+// eventNameForChange は変更タイプに対応するイベント名を返します
+// 削除マーカーの削除（UNDELETE）は、S3と同じく削除マーカーのバージョンの削除として通知します
+func eventNameForChange(change ObjectChange) (string, error) {
+	switch change.ChangeType {
+	case ChangeTypeCreate, ChangeTypeUpdate, ChangeTypeRecreate:
+		// マルチパートアップロードで作成されたオブジェクトのETagには -<パート数> が付く
+		if isMultipartETag(change.ETag) {
+			return EventNameObjectCreatedCompleteMultipartUpload, nil
+		}
+		return EventNameObjectCreatedPut, nil
+	case ChangeTypeDelete:
+		return EventNameObjectRemovedDeleteMarkerCreated, nil
+	case ChangeTypeUndelete:
+		return EventNameObjectRemovedDelete, nil
+	default:
+		return "", fmt.Errorf("不明な変更タイプです: %s", change.ChangeType)
+	}
+}
+
+// encodeEventKey はS3イベント通知と同じ形式でキーをURLエンコードします（スペースは + になり、/ はそのまま）
+func encodeEventKey(key string) string {
+	return strings.ReplaceAll(url.QueryEscape(key), "%2F", "/")
+}
+
+// eventSequencer は同じキーのイベントの順序を表す値を返します
+// 変更の時刻から生成するため、同じキーのイベントは時刻の順に大きくなります
+func eventSequencer(timestamp time.Time) string {
+	return fmt.Sprintf("%016X", timestamp.UnixNano())
+}
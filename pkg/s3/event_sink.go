@@ -0,0 +1,206 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// イベントの送信先の種類（--event-sink の接頭辞）
+const (
+	EventSinkSQS    = "sqs"    // sqs:<キューのURL>
+	EventSinkSNS    = "sns"    // sns:<トピックのARN>
+	EventSinkNDJSON = "ndjson" // ndjson:<ファイルパスまたは s3://bucket/key>
+)
+
+// EventSink はリプレイで生成したS3イベント通知の送信先
+type EventSink interface {
+	// Send はイベント通知を送信します
+	Send(ctx context.Context, notification S3EventNotification) error
+
+	// Close は送信先を閉じます
+	Close() error
+}
+
+// NewEventSink は送信先の指定からEventSinkを作成します
+//
+// 送信先は次のいずれかの形式で指定します:
+//
+//	sqs:<キューのURL>
+//	sns:<トピックのARN>
+//	http://... または https://...（Webhook）
+//	ndjson:<ファイルパスまたは s3://bucket/key>
+//	lambda:<Runtime Interface EmulatorのURL>（HandlerSink）
+//	exec:<コマンド> [引数...]（HandlerSink）
+//
+// SQS、SNSの接続には clientOpts を使用します（ElasticMQなどを使用する場合はエンドポイントを指定）
+func NewEventSink(ctx context.Context, spec string, clientOpts ClientOptions) (EventSink, error) {
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		return &webhookEventSink{url: spec, client: http.DefaultClient}, nil
+	}
+
+	kind, target, ok := strings.Cut(spec, ":")
+	if !ok || target == "" {
+		return nil, fmt.Errorf("イベントの送信先 %q の形式が無効です。sqs:、sns:、ndjson:、lambda:、exec: または http(s):// で指定してください", spec)
+	}
+
+	switch kind {
+	case EventSinkSQS:
+		cfg, err := loadAWSConfig(ctx, clientOpts)
+		if err != nil {
+			return nil, err
+		}
+		client := sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+			if clientOpts.Endpoint != "" {
+				o.BaseEndpoint = aws.String(clientOpts.Endpoint)
+			}
+		})
+		return &sqsEventSink{client: client, queueURL: target}, nil
+	case EventSinkSNS:
+		cfg, err := loadAWSConfig(ctx, clientOpts)
+		if err != nil {
+			return nil, err
+		}
+		client := sns.NewFromConfig(cfg, func(o *sns.Options) {
+			if clientOpts.Endpoint != "" {
+				o.BaseEndpoint = aws.String(clientOpts.Endpoint)
+			}
+		})
+		return &snsEventSink{client: client, topicARN: target}, nil
+	case EventSinkNDJSON:
+		// 中断された場合も送信済みのイベントを書き込むため、アップロードは中断しない
+		output, err := createChangesOutput(context.WithoutCancel(ctx), target, ChangesFileOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &ndjsonEventSink{output: output}, nil
+	case EventSinkLambda:
+		return newLambdaHandlerSink(target)
+	case EventSinkExec:
+		return newExecHandlerSink(target)
+	default:
+		return nil, fmt.Errorf("イベントの送信先の種類 %s が無効です。sqs、sns、ndjson、lambda、exec、http(s) のいずれかを指定してください", kind)
+	}
+}
+
+// sqsEventSink はSQS互換のキューにイベント通知を送信します
+type sqsEventSink struct {
+	client   *sqs.Client
+	queueURL string
+}
+
+func (s *sqsEventSink) Send(ctx context.Context, notification S3EventNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(s.queueURL),
+		MessageBody: aws.String(string(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("SQSへのイベントの送信に失敗しました: %w", err)
+	}
+	return nil
+}
+
+func (s *sqsEventSink) Close() error {
+	return nil
+}
+
+// snsEventSink はSNS互換のトピックにイベント通知を発行します
+type snsEventSink struct {
+	client   *sns.Client
+	topicARN string
+}
+
+func (s *snsEventSink) Send(ctx context.Context, notification S3EventNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(s.topicARN),
+		Subject:  aws.String("Amazon S3 Notification"),
+		Message:  aws.String(string(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("SNSへのイベントの発行に失敗しました: %w", err)
+	}
+	return nil
+}
+
+func (s *snsEventSink) Close() error {
+	return nil
+}
+
+// webhookEventSink はHTTPのエンドポイントにイベント通知をPOSTします
+type webhookEventSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *webhookEventSink) Send(ctx context.Context, notification S3EventNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Webhookへのイベントの送信に失敗しました: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhookへのイベントの送信に失敗しました: %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *webhookEventSink) Close() error {
+	return nil
+}
+
+// ndjsonEventSink はイベント通知を1行に1件のJSONとしてファイルに書き込みます
+type ndjsonEventSink struct {
+	mu     sync.Mutex
+	output io.WriteCloser
+}
+
+func (s *ndjsonEventSink) Send(ctx context.Context, notification S3EventNotification) error {
+	line, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.output.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("イベントの書き込みに失敗しました: %w", err)
+	}
+	return nil
+}
+
+func (s *ndjsonEventSink) Close() error {
+	return s.output.Close()
+}
@@ -0,0 +1,192 @@
+package s3
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewS3EventNotification(t *testing.T) {
+	ts := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		change    ObjectChange
+		wantName  string
+		wantSize  bool
+		wantError bool
+	}{
+		{name: "作成", change: ObjectChange{ChangeType: ChangeTypeCreate, ETag: `"abc"`, Size: 10}, wantName: EventNameObjectCreatedPut, wantSize: true},
+		{name: "マルチパートでの更新", change: ObjectChange{ChangeType: ChangeTypeUpdate, ETag: `"abc-3"`, Size: 10}, wantName: EventNameObjectCreatedCompleteMultipartUpload, wantSize: true},
+		{name: "再作成", change: ObjectChange{ChangeType: ChangeTypeRecreate, ETag: `"abc"`}, wantName: EventNameObjectCreatedPut, wantSize: true},
+		{name: "削除", change: ObjectChange{ChangeType: ChangeTypeDelete}, wantName: EventNameObjectRemovedDeleteMarkerCreated},
+		{name: "削除の取り消し", change: ObjectChange{ChangeType: ChangeTypeUndelete}, wantName: EventNameObjectRemovedDelete},
+		{name: "不明な変更タイプ", change: ObjectChange{ChangeType: "UNKNOWN"}, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change.Timestamp = ts
+			tt.change.VersionID = "v1"
+			notification, err := newS3EventNotification(tt.change, "staging", "dir/a b+c.txt", "")
+			if tt.wantError {
+				if err == nil {
+					t.Fatal("エラーになりません")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newS3EventNotification() error = %v", err)
+			}
+
+			record := notification.Records[0]
+			if record.EventName != tt.wantName {
+				t.Errorf("EventName = %s, want %s", record.EventName, tt.wantName)
+			}
+			if record.EventTime != "2025-04-01T12:00:00.000Z" || record.AWSRegion != DefaultEventRegion {
+				t.Errorf("EventTime = %s, AWSRegion = %s", record.EventTime, record.AWSRegion)
+			}
+			object := record.S3.Object
+			if object.Key != "dir/a+b%2Bc.txt" || object.VersionID != "v1" {
+				t.Errorf("Object = %+v", object)
+			}
+			if record.S3.Bucket.ARN != "arn:aws:s3:::staging" {
+				t.Errorf("Bucket = %+v", record.S3.Bucket)
+			}
+			if (object.Size != nil) != tt.wantSize {
+				t.Errorf("Size = %v, want 指定あり=%v", object.Size, tt.wantSize)
+			}
+			if tt.wantSize && (object.ETag == "" || object.ETag[0] == '"') {
+				t.Errorf("ETag = %q", object.ETag)
+			}
+		})
+	}
+}
+
+func TestEventSequencer(t *testing.T) {
+	ts := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	earlier := eventSequencer(ts)
+	later := eventSequencer(ts.Add(time.Millisecond))
+	if len(earlier) != len(later) || earlier >= later {
+		t.Errorf("シーケンサーが時刻の順になりません: %s, %s", earlier, later)
+	}
+}
+
+func TestNewEventSinkInvalid(t *testing.T) {
+	for _, spec := range []string{"queue", "sqs:", "kafka:topic"} {
+		if _, err := NewEventSink(context.Background(), spec, ClientOptions{}); err == nil {
+			t.Errorf("NewEventSink(%q) でエラーになりません", spec)
+		}
+	}
+}
+
+func TestWebhookEventSink(t *testing.T) {
+	var received []S3EventNotification
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var notification S3EventNotification
+		if err := json.Unmarshal(body, &notification); err != nil || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("不正なリクエスト: %s", body)
+		}
+		received = append(received, notification)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink, err := NewEventSink(context.Background(), server.URL, ClientOptions{})
+	if err != nil {
+		t.Fatalf("NewEventSink() error = %v", err)
+	}
+	defer sink.Close()
+
+	notification, _ := newS3EventNotification(ObjectChange{ChangeType: ChangeTypeCreate, Timestamp: time.Now()}, "b", "k", "")
+	if err := sink.Send(context.Background(), notification); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	status = http.StatusInternalServerError
+	if err := sink.Send(context.Background(), notification); err == nil {
+		t.Error("500の応答でエラーになりません")
+	}
+	if len(received) != 2 {
+		t.Errorf("受信したイベント数 = %d, want 2", len(received))
+	}
+}
+
+// TestReplayEventOnly はイベントのみのモードでオブジェクトを変更せずにイベントを書き込むことを確認します
+func TestReplayEventOnly(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	changes := []ObjectChange{
+		{Key: "prod/a.txt", VersionID: "v1", ChangeType: ChangeTypeCreate, Timestamp: base, ETag: `"e1"`, Size: 2},
+		{Key: "prod/a.txt", VersionID: "v2", ChangeType: ChangeTypeDelete, Timestamp: base.Add(time.Second)},
+	}
+	changesFile := filepath.Join(dir, "changes.json")
+	data, _ := json.Marshal(changes)
+	if err := os.WriteFile(changesFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	eventsFile := filepath.Join(dir, "events.ndjson")
+	sink, err := NewEventSink(context.Background(), "ndjson:"+eventsFile, ClientOptions{})
+	if err != nil {
+		t.Fatalf("NewEventSink() error = %v", err)
+	}
+
+	// 宛先のバックエンドは使用しないため、存在しないバケットでもエラーにならない
+	dest, _ := NewLocalBackend(filepath.Join(dir, "dest"))
+	rewriter, _ := NewKeyRewriter([]string{"prefix:prod/=>staging/"})
+	result, err := Replay(context.Background(), ReplayOptions{
+		DestBucket:        "staging",
+		SourceFile:        changesFile,
+		Concurrency:       1,
+		IgnoreTimeWindows: true,
+		KeyRewriter:       rewriter,
+		DestBackend:       dest,
+		EventSink:         sink,
+		EventRegion:       "ap-northeast-1",
+	})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if result.SuccessEvents != 2 {
+		t.Fatalf("成功したイベント数 = %d: %+v", result.SuccessEvents, result.Events)
+	}
+
+	file, err := os.Open(eventsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var notification S3EventNotification
+		if err := json.Unmarshal(scanner.Bytes(), &notification); err != nil {
+			t.Fatalf("イベントを読み込めません: %v", err)
+		}
+		record := notification.Records[0]
+		if record.S3.Object.Key != "staging/a.txt" || record.AWSRegion != "ap-northeast-1" || record.S3.Bucket.Name != "staging" {
+			t.Errorf("レコード = %+v", record)
+		}
+		names = append(names, record.EventName)
+	}
+	if len(names) != 2 || names[0] != EventNameObjectCreatedPut || names[1] != EventNameObjectRemovedDeleteMarkerCreated {
+		t.Errorf("イベント名 = %v", names)
+	}
+
+	if keys, err := dest.ListKeys(context.Background(), "staging", "", 1); err == nil && len(keys) > 0 {
+		t.Errorf("宛先が変更されています: %v", keys)
+	}
+}
@@ -0,0 +1,232 @@
+package s3
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// キーのパターンの種類
+const (
+	KeyPatternGlob  = "glob"  // グロブ（* は / を含まない任意の文字列、** は / を含む任意の文字列）
+	KeyPatternRegex = "regex" // 正規表現
+)
+
+// ChangeFilterOptions はリプレイする変更を絞り込む条件
+// 条件を指定しない項目は絞り込みに使用しません
+type ChangeFilterOptions struct {
+	IncludeTypes []ChangeType // リプレイする変更の種類
+	ExcludeTypes []ChangeType // リプレイしない変更の種類
+	IncludeKeys  []string     // リプレイするキーのパターン（いずれかに一致するキーのみ）
+	ExcludeKeys  []string     // リプレイしないキーのパターン（いずれかに一致するキーを除く）
+	MinSize      int64        // オブジェクトサイズの下限（バイト）
+	MaxSize      int64        // オブジェクトサイズの上限（バイト、0の場合は無制限）
+	From         time.Time    // 変更時刻の範囲の開始（この時刻を含む）
+	To           time.Time    // 変更時刻の範囲の終了（この時刻を含まない）
+}
+
+// ChangeFilter は変更リストからリプレイする変更を絞り込む条件
+// nilの場合は全ての変更に一致します
+type ChangeFilter struct {
+	includeTypes map[ChangeType]bool
+	excludeTypes map[ChangeType]bool
+	includeKeys  []*regexp.Regexp
+	excludeKeys  []*regexp.Regexp
+	minSize      int64
+	maxSize      int64
+	from         time.Time
+	to           time.Time
+}
+
+// NewChangeFilter は絞り込みの条件からChangeFilterを作成します
+//
+// キーのパターンは次のいずれかの形式で指定します（形式を省略した場合はグロブ）:
+//
+//	glob:<グロブ>（例: glob:tenant-a/**/*.json）
+//	regex:<正規表現>（例: regex:^tenant-(a|b)/）
+//
+// グロブはキー全体に一致する必要があり、正規表現はキーの一部に一致すれば一致とみなします
+func NewChangeFilter(opts ChangeFilterOptions) (*ChangeFilter, error) {
+	filter := &ChangeFilter{
+		minSize: opts.MinSize,
+		maxSize: opts.MaxSize,
+		from:    opts.From,
+		to:      opts.To,
+	}
+
+	if opts.MinSize < 0 || opts.MaxSize < 0 {
+		return nil, fmt.Errorf("オブジェクトサイズの範囲には0以上の値を指定してください")
+	}
+	if opts.MaxSize > 0 && opts.MinSize > opts.MaxSize {
+		return nil, fmt.Errorf("オブジェクトサイズの下限 %d が上限 %d より大きくなっています", opts.MinSize, opts.MaxSize)
+	}
+	if !opts.From.IsZero() && !opts.To.IsZero() && !opts.From.Before(opts.To) {
+		return nil, fmt.Errorf("変更時刻の範囲の開始 %s が終了 %s より前になっていません", opts.From.Format(time.RFC3339), opts.To.Format(time.RFC3339))
+	}
+
+	var err error
+	if filter.includeTypes, err = changeTypeSet(opts.IncludeTypes); err != nil {
+		return nil, err
+	}
+	if filter.excludeTypes, err = changeTypeSet(opts.ExcludeTypes); err != nil {
+		return nil, err
+	}
+	if filter.includeKeys, err = compileKeyPatterns(opts.IncludeKeys); err != nil {
+		return nil, err
+	}
+	if filter.excludeKeys, err = compileKeyPatterns(opts.ExcludeKeys); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+// ParseChangeType は文字列から変更の種類を解析します（大文字・小文字は区別しません）
+func ParseChangeType(s string) (ChangeType, error) {
+	changeType := ChangeType(strings.ToUpper(strings.TrimSpace(s)))
+	switch changeType {
+	case ChangeTypeCreate, ChangeTypeUpdate, ChangeTypeDelete, ChangeTypeRecreate, ChangeTypeUndelete:
+		return changeType, nil
+	default:
+		return "", fmt.Errorf("不明な変更タイプです: %s (CREATE, UPDATE, DELETE, RECREATE, UNDELETE のいずれかを指定してください)", s)
+	}
+}
+
+// changeTypeSet は変更の種類の一覧を集合にします（空の場合はnil）
+func changeTypeSet(types []ChangeType) (map[ChangeType]bool, error) {
+	if len(types) == 0 {
+		return nil, nil
+	}
+
+	set := make(map[ChangeType]bool, len(types))
+	for _, changeType := range types {
+		parsed, err := ParseChangeType(string(changeType))
+		if err != nil {
+			return nil, err
+		}
+		set[parsed] = true
+	}
+	return set, nil
+}
+
+// compileKeyPatterns はキーのパターンの一覧を正規表現にします
+func compileKeyPatterns(specs []string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, spec := range specs {
+		pattern, err := compileKeyPattern(spec)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// compileKeyPattern はキーのパターンの指定を正規表現にします
+func compileKeyPattern(spec string) (*regexp.Regexp, error) {
+	kind, body, ok := strings.Cut(spec, ":")
+	if !ok || (kind != KeyPatternGlob && kind != KeyPatternRegex) {
+		kind, body = KeyPatternGlob, spec
+	}
+	if body == "" {
+		return nil, fmt.Errorf("キーのパターン %q が空です", spec)
+	}
+
+	expr := body
+	if kind == KeyPatternGlob {
+		expr = globToRegexp(body)
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("キーのパターン %q が無効です: %w", spec, err)
+	}
+	return pattern, nil
+}
+
+// globToRegexp はグロブをキー全体に一致する正規表現に変換します
+// * と ? は / を含まず、** は / を含む任意の文字列に一致します。[...] は文字クラスとしてそのまま使用します
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				sb.WriteString(".*")
+				i++
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(regexp.QuoteMeta(glob[i:]))
+				i = len(glob)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// Match は変更が絞り込みの条件に一致するかどうかを返します
+// オブジェクトサイズの条件は、コピーを伴わない DELETE には適用しません
+func (f *ChangeFilter) Match(change ObjectChange) bool {
+	if f == nil {
+		return true
+	}
+
+	if f.includeTypes != nil && !f.includeTypes[change.ChangeType] {
+		return false
+	}
+	if f.excludeTypes[change.ChangeType] {
+		return false
+	}
+
+	if !f.from.IsZero() && change.Timestamp.Before(f.from) {
+		return false
+	}
+	if !f.to.IsZero() && !change.Timestamp.Before(f.to) {
+		return false
+	}
+
+	if change.ChangeType != ChangeTypeDelete {
+		if change.Size < f.minSize {
+			return false
+		}
+		if f.maxSize > 0 && change.Size > f.maxSize {
+			return false
+		}
+	}
+
+	if len(f.includeKeys) > 0 && !matchAnyKeyPattern(f.includeKeys, change.Key) {
+		return false
+	}
+	if matchAnyKeyPattern(f.excludeKeys, change.Key) {
+		return false
+	}
+
+	return true
+}
+
+// matchAnyKeyPattern はキーがいずれかのパターンに一致するかどうかを返します
+func matchAnyKeyPattern(patterns []*regexp.Regexp, key string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob string
+		key  string
+		want bool
+	}{
+		{glob: "tenant-a/*.json", key: "tenant-a/x.json", want: true},
+		{glob: "tenant-a/*.json", key: "tenant-a/2025/x.json", want: false},
+		{glob: "tenant-a/**", key: "tenant-a/2025/06/x.json", want: true},
+		{glob: "tenant-a/**/*.json", key: "tenant-a/2025/x.json", want: true},
+		{glob: "logs/?.txt", key: "logs/a.txt", want: true},
+		{glob: "logs/[!ab].txt", key: "logs/a.txt", want: false},
+		{glob: "logs/[!ab].txt", key: "logs/c.txt", want: true},
+		{glob: "a+b(1).txt", key: "a+b(1).txt", want: true},
+		{glob: "tenant-a/", key: "tenant-a/x", want: false},
+	}
+
+	for _, tt := range tests {
+		pattern, err := compileKeyPattern(tt.glob)
+		if err != nil {
+			t.Fatalf("compileKeyPattern(%q) error = %v", tt.glob, err)
+		}
+		if got := pattern.MatchString(tt.key); got != tt.want {
+			t.Errorf("%q に %q が一致 = %v, want %v (%s)", tt.glob, tt.key, got, tt.want, pattern)
+		}
+	}
+}
+
+func TestChangeFilterMatch(t *testing.T) {
+	base := time.Date(2025, 6, 5, 10, 0, 0, 0, time.UTC)
+	filter, err := NewChangeFilter(ChangeFilterOptions{
+		IncludeTypes: []ChangeType{"delete", ChangeTypeCreate},
+		IncludeKeys:  []string{"tenant-a/**", "regex:^tenant-b/"},
+		ExcludeKeys:  []string{"glob:**/*.tmp"},
+		MinSize:      10,
+		MaxSize:      100,
+		From:         base,
+		To:           base.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("NewChangeFilter() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change ObjectChange
+		want   bool
+	}{
+		{name: "一致", change: ObjectChange{Key: "tenant-a/x", ChangeType: ChangeTypeCreate, Size: 50, Timestamp: base}, want: true},
+		{name: "正規表現に一致", change: ObjectChange{Key: "tenant-b/x", ChangeType: ChangeTypeCreate, Size: 50, Timestamp: base}, want: true},
+		{name: "DELETEにはサイズを適用しない", change: ObjectChange{Key: "tenant-a/x", ChangeType: ChangeTypeDelete, Timestamp: base}, want: true},
+		{name: "変更の種類", change: ObjectChange{Key: "tenant-a/x", ChangeType: ChangeTypeUpdate, Size: 50, Timestamp: base}, want: false},
+		{name: "キー", change: ObjectChange{Key: "tenant-c/x", ChangeType: ChangeTypeCreate, Size: 50, Timestamp: base}, want: false},
+		{name: "除外するキー", change: ObjectChange{Key: "tenant-a/x.tmp", ChangeType: ChangeTypeCreate, Size: 50, Timestamp: base}, want: false},
+		{name: "サイズの下限", change: ObjectChange{Key: "tenant-a/x", ChangeType: ChangeTypeCreate, Size: 9, Timestamp: base}, want: false},
+		{name: "サイズの上限", change: ObjectChange{Key: "tenant-a/x", ChangeType: ChangeTypeCreate, Size: 101, Timestamp: base}, want: false},
+		{name: "範囲の開始より前", change: ObjectChange{Key: "tenant-a/x", ChangeType: ChangeTypeCreate, Size: 50, Timestamp: base.Add(-time.Second)}, want: false},
+		{name: "範囲の終了は含まない", change: ObjectChange{Key: "tenant-a/x", ChangeType: ChangeTypeCreate, Size: 50, Timestamp: base.Add(time.Hour)}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filter.Match(tt.change); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	var nilFilter *ChangeFilter
+	if !nilFilter.Match(ObjectChange{Key: "any"}) {
+		t.Error("nilの条件が変更に一致しません")
+	}
+}
+
+func TestNewChangeFilterInvalid(t *testing.T) {
+	base := time.Date(2025, 6, 5, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		opts ChangeFilterOptions
+	}{
+		{name: "不明な変更の種類", opts: ChangeFilterOptions{IncludeTypes: []ChangeType{"MOVE"}}},
+		{name: "無効な正規表現", opts: ChangeFilterOptions{IncludeKeys: []string{"regex:("}}},
+		{name: "空のパターン", opts: ChangeFilterOptions{ExcludeKeys: []string{"glob:"}}},
+		{name: "サイズの範囲", opts: ChangeFilterOptions{MinSize: 100, MaxSize: 10}},
+		{name: "時刻の範囲", opts: ChangeFilterOptions{From: base, To: base}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewChangeFilter(tt.opts); err == nil {
+				t.Error("NewChangeFilter() error = nil, want error")
+			}
+		})
+	}
+}
+
+// TestReplayFilter は絞り込んだ変更のみをリプレイし、位置を絞り込んだ後の順に振ることを確認します
+func TestReplayFilter(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2025, 6, 5, 10, 0, 0, 0, time.UTC)
+	var changes []ObjectChange
+	for i := 0; i < 10; i++ {
+		changeType := ChangeTypeUpdate
+		if i%3 == 0 {
+			changeType = ChangeTypeDelete
+		}
+		changes = append(changes, ObjectChange{Key: fmt.Sprintf("k%d", i), VersionID: fmt.Sprint(i), ChangeType: changeType, Timestamp: base.Add(time.Duration(i) * time.Second)})
+	}
+	changesFile := filepath.Join(dir, "changes.ndjson")
+	writeTestNDJSON(t, changesFile, changes)
+
+	filter, err := NewChangeFilter(ChangeFilterOptions{IncludeTypes: []ChangeType{ChangeTypeDelete}, To: base.Add(9 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Replay(context.Background(), ReplayOptions{
+		DestBucket:        "staging",
+		SourceFile:        changesFile,
+		DryRun:            true,
+		IgnoreTimeWindows: true,
+		Filter:            filter,
+		DestBackend:       newTestLocalBackend(t, filepath.Join(dir, "dest")),
+	})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if result.TotalEvents != 3 || result.FilteredEvents != 7 {
+		t.Errorf("イベント数 = %d、除外 = %d, want 3、7", result.TotalEvents, result.FilteredEvents)
+	}
+
+	keys := make(map[int]string)
+	for _, event := range result.Events {
+		keys[event.Index] = event.Change.Key
+	}
+	for index, want := range []string{"k0", "k3", "k6"} {
+		if keys[index] != want {
+			t.Errorf("位置 %d のキー = %q, want %q", index, keys[index], want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ハンドラーを呼び出す送信先の種類（--event-sink の接頭辞）
+const (
+	EventSinkLambda = "lambda" // lambda:<Runtime Interface EmulatorのURL>
+	EventSinkExec   = "exec"   // exec:<コマンド> [引数...]
+)
+
+// lambdaInvocationPath はRuntime Interface Emulatorの関数呼び出しのパス
+const lambdaInvocationPath = "/2015-03-31/functions/function/invocations"
+
+// maxHandlerResponseSize はリプレイ結果に記録するハンドラーの応答の最大サイズ
+const maxHandlerResponseSize = 4096
+
+// HandlerResult はイベントを処理したハンドラーの実行結果
+type HandlerResult struct {
+	ExitCode   *int          `json:"exitCode,omitempty"`   // 実行ファイルの終了コード
+	StatusCode int           `json:"statusCode,omitempty"` // HTTPのステータスコード
+	Response   string        `json:"response,omitempty"`   // 応答（実行ファイルの場合は標準出力、失敗時は標準エラー出力も含む）
+	Duration   time.Duration `json:"duration"`             // 処理にかかった時間
+}
+
+// String は結果の出力用にハンドラーの実行結果を文字列で返します
+func (r *HandlerResult) String() string {
+	var status string
+	if r.ExitCode != nil {
+		status = fmt.Sprintf("exit=%d", *r.ExitCode)
+	} else {
+		status = fmt.Sprintf("status=%d", r.StatusCode)
+	}
+	response := strings.ReplaceAll(r.Response, "\n", " ")
+	if len(response) > 200 {
+		response = response[:200] + "..."
+	}
+	return fmt.Sprintf("%s duration=%s response=%s", status, r.Duration.Round(time.Millisecond), response)
+}
+
+// HandlerSink はイベント通知でハンドラーを呼び出し、その実行結果を返す送信先
+type HandlerSink interface {
+	EventSink
+
+	// Invoke はイベント通知でハンドラーを呼び出します
+	// ハンドラーが失敗した場合も、実行結果とエラーの両方を返します
+	Invoke(ctx context.Context, notification S3EventNotification) (*HandlerResult, error)
+}
+
+// newLambdaHandlerSink はRuntime Interface Emulator互換のエンドポイントを呼び出す送信先を作成します
+// URLにパスがない場合は関数呼び出しのパスを補います
+func newLambdaHandlerSink(target string) (*lambdaHandlerSink, error) {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Lambdaの呼び出し先 %q はhttp(s)のURLで指定してください", target)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = lambdaInvocationPath
+	}
+	return &lambdaHandlerSink{url: u.String(), client: http.DefaultClient}, nil
+}
+
+// lambdaHandlerSink はRuntime Interface Emulator互換のエンドポイントで関数を呼び出します
+type lambdaHandlerSink struct {
+	url    string
+	client *http.Client
+}
+
+// lambdaFunctionError は関数がエラーを返した場合の応答
+type lambdaFunctionError struct {
+	ErrorType    string `json:"errorType"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+func (s *lambdaHandlerSink) Invoke(ctx context.Context, notification S3EventNotification) (*HandlerResult, error) {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Lambdaの呼び出しに失敗しました: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Lambdaの応答の読み込みに失敗しました: %w", err)
+	}
+
+	result := &HandlerResult{
+		StatusCode: resp.StatusCode,
+		Response:   truncateHandlerResponse(string(body)),
+		Duration:   time.Since(start),
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return result, fmt.Errorf("Lambdaの呼び出しに失敗しました: %s", resp.Status)
+	}
+
+	// 関数のエラーはステータスコード200で、ヘッダーまたはエラーの応答で通知される
+	var functionErr lambdaFunctionError
+	json.Unmarshal(body, &functionErr)
+	if header := resp.Header.Get("X-Amz-Function-Error"); header != "" || (functionErr.ErrorType != "" && functionErr.ErrorMessage != "") {
+		if functionErr.ErrorType == "" {
+			functionErr.ErrorType = header
+		}
+		return result, fmt.Errorf("関数がエラーを返しました: %s: %s", functionErr.ErrorType, functionErr.ErrorMessage)
+	}
+	return result, nil
+}
+
+func (s *lambdaHandlerSink) Send(ctx context.Context, notification S3EventNotification) error {
+	_, err := s.Invoke(ctx, notification)
+	return err
+}
+
+func (s *lambdaHandlerSink) Close() error {
+	return nil
+}
+
+// newExecHandlerSink はイベントごとに実行ファイルを起動する送信先を作成します
+// コマンドは空白で区切って引数に分割します
+func newExecHandlerSink(target string) (*execHandlerSink, error) {
+	args := strings.Fields(target)
+	if len(args) == 0 {
+		return nil, errors.New("実行するコマンドを指定してください")
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return nil, fmt.Errorf("コマンド %s が見つかりません: %w", args[0], err)
+	}
+	return &execHandlerSink{args: args}, nil
+}
+
+// execHandlerSink はイベントごとに実行ファイルを起動し、標準入力にイベント通知のJSONを渡します
+type execHandlerSink struct {
+	args []string
+}
+
+func (s *execHandlerSink) Invoke(ctx context.Context, notification S3EventNotification) (*HandlerResult, error) {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.args[0], s.args[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	runErr := cmd.Run()
+	result := &HandlerResult{
+		Response: strings.TrimSpace(stdout.String()),
+		Duration: time.Since(start),
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case runErr == nil:
+		exitCode := 0
+		result.ExitCode = &exitCode
+	case errors.As(runErr, &exitErr):
+		exitCode := exitErr.ExitCode()
+		result.ExitCode = &exitCode
+		if output := strings.TrimSpace(stderr.String()); output != "" {
+			result.Response = strings.TrimSpace(result.Response + "\n" + output)
+		}
+	default:
+		return nil, fmt.Errorf("コマンドの実行に失敗しました: %w", runErr)
+	}
+	result.Response = truncateHandlerResponse(result.Response)
+
+	if *result.ExitCode != 0 {
+		return result, fmt.Errorf("コマンドが終了コード %d で終了しました", *result.ExitCode)
+	}
+	return result, nil
+}
+
+func (s *execHandlerSink) Send(ctx context.Context, notification S3EventNotification) error {
+	_, err := s.Invoke(ctx, notification)
+	return err
+}
+
+func (s *execHandlerSink) Close() error {
+	return nil
+}
+
+// truncateHandlerResponse はリプレイ結果が大きくなりすぎないように応答を切り詰めます
+func truncateHandlerResponse(response string) string {
+	if len(response) <= maxHandlerResponseSize {
+		return response
+	}
+	return response[:maxHandlerResponseSize] + "...(省略)"
+}
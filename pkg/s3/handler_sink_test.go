@@ -0,0 +1,156 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLambdaHandlerSink(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != lambdaInvocationPath {
+			t.Errorf("パス = %s, want %s", r.URL.Path, lambdaInvocationPath)
+		}
+		body, _ := io.ReadAll(r.Body)
+		var notification S3EventNotification
+		json.Unmarshal(body, &notification)
+		if notification.Records[0].S3.Object.Key == "fail" {
+			io.WriteString(w, `{"errorMessage":"boom","errorType":"RuntimeError"}`)
+			return
+		}
+		io.WriteString(w, `{"processed":1}`)
+	}))
+	defer server.Close()
+
+	sink, err := NewEventSink(context.Background(), "lambda:"+server.URL, ClientOptions{})
+	if err != nil {
+		t.Fatalf("NewEventSink() error = %v", err)
+	}
+	handlerSink, ok := sink.(HandlerSink)
+	if !ok {
+		t.Fatalf("lambda: の送信先がHandlerSinkではありません: %T", sink)
+	}
+
+	change := ObjectChange{ChangeType: ChangeTypeCreate, Timestamp: time.Now()}
+	notification, _ := newS3EventNotification(change, "b", "ok", "")
+	result, err := handlerSink.Invoke(context.Background(), notification)
+	if err != nil {
+		t.Fatalf("Invoke() error = %v", err)
+	}
+	if result.StatusCode != http.StatusOK || result.Response != `{"processed":1}` {
+		t.Errorf("Invoke() = %+v", result)
+	}
+
+	// 関数のエラーはステータスコード200でも失敗として扱う
+	notification, _ = newS3EventNotification(change, "b", "fail", "")
+	result, err = handlerSink.Invoke(context.Background(), notification)
+	if err == nil || !strings.Contains(err.Error(), "RuntimeError") {
+		t.Errorf("Invoke() error = %v, want 関数のエラー", err)
+	}
+	if result == nil || !strings.Contains(result.Response, "boom") {
+		t.Errorf("失敗時の実行結果 = %+v", result)
+	}
+
+	if _, err := NewEventSink(context.Background(), "lambda:localhost:9000", ClientOptions{}); err == nil {
+		t.Error("URLでない呼び出し先でエラーになりません")
+	}
+}
+
+func TestExecHandlerSink(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("/bin/sh がありません")
+	}
+
+	// 標準入力のイベントからキーを取り出して出力し、キーが fail の場合は失敗するハンドラー
+	script := filepath.Join(t.TempDir(), "handler.sh")
+	body := `#!/bin/sh
+input=$(cat)
+case "$input" in
+  *'"key":"fail"'*) echo "failed to process" >&2; exit 3 ;;
+esac
+echo "ok"
+`
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	sink, err := NewEventSink(context.Background(), "exec:/bin/sh "+script, ClientOptions{})
+	if err != nil {
+		t.Fatalf("NewEventSink() error = %v", err)
+	}
+	handlerSink := sink.(HandlerSink)
+
+	change := ObjectChange{ChangeType: ChangeTypeCreate, Timestamp: time.Now()}
+	notification, _ := newS3EventNotification(change, "b", "ok", "")
+	result, err := handlerSink.Invoke(context.Background(), notification)
+	if err != nil {
+		t.Fatalf("Invoke() error = %v", err)
+	}
+	if result.ExitCode == nil || *result.ExitCode != 0 || result.Response != "ok" {
+		t.Errorf("Invoke() = %+v", result)
+	}
+
+	notification, _ = newS3EventNotification(change, "b", "fail", "")
+	result, err = handlerSink.Invoke(context.Background(), notification)
+	if err == nil {
+		t.Fatal("終了コードが0以外でエラーになりません")
+	}
+	if result == nil || result.ExitCode == nil || *result.ExitCode != 3 || !strings.Contains(result.Response, "failed to process") {
+		t.Errorf("失敗時の実行結果 = %+v", result)
+	}
+
+	if _, err := NewEventSink(context.Background(), "exec:trav-missing-command", ClientOptions{}); err == nil {
+		t.Error("存在しないコマンドでエラーになりません")
+	}
+}
+
+// TestReplayHandlerSink はハンドラーの実行結果がリプレイのイベントに記録されることを確認します
+func TestReplayHandlerSink(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), `"eventName":"ObjectRemoved:DeleteMarkerCreated"`) {
+			w.Header().Set("X-Amz-Function-Error", "Unhandled")
+		}
+		io.WriteString(w, `null`)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	base := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	changes := []ObjectChange{
+		{Key: "a.txt", VersionID: "v1", ChangeType: ChangeTypeCreate, Timestamp: base},
+		{Key: "a.txt", VersionID: "v2", ChangeType: ChangeTypeDelete, Timestamp: base.Add(time.Second)},
+	}
+	changesFile := filepath.Join(dir, "changes.json")
+	data, _ := json.Marshal(changes)
+	if err := os.WriteFile(changesFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sink, _ := NewEventSink(context.Background(), "lambda:"+server.URL, ClientOptions{})
+	result, err := Replay(context.Background(), ReplayOptions{
+		DestBucket:        "staging",
+		SourceFile:        changesFile,
+		Concurrency:       1,
+		IgnoreTimeWindows: true,
+		EventSink:         sink,
+	})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if result.SuccessEvents != 1 || result.FailedEvents != 1 {
+		t.Fatalf("成功 %d件, 失敗 %d件: %+v", result.SuccessEvents, result.FailedEvents, result.Events)
+	}
+	for _, event := range result.Events {
+		if event.Handler == nil || event.Handler.StatusCode != http.StatusOK {
+			t.Errorf("ハンドラーの実行結果が記録されていません: %+v", event)
+		}
+	}
+}
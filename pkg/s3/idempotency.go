@@ -0,0 +1,79 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// SourceVersionMetadataKey はストリーミングコピーで書き込んだオブジェクトに記録する変更元のバージョンIDのユーザー定義メタデータのキー
+// S3はユーザー定義メタデータのキーを小文字で返すため、小文字で定義します
+const SourceVersionMetadataKey = "trav-source-version-id"
+
+// appliedChecker は変更が既に宛先に反映されているかを確認し、反映されている場合はその理由を返します
+type appliedChecker func(ctx context.Context, destKey string, change ObjectChange) (string, error)
+
+// newAppliedChecker は宛先のオブジェクトの現在の状態と変更を比較する appliedChecker を作成します
+func newAppliedChecker(dest Backend, destBucket string) appliedChecker {
+	return func(ctx context.Context, destKey string, change ObjectChange) (string, error) {
+		current, err := dest.HeadObject(ctx, destBucket, destKey)
+		if err != nil {
+			return "", fmt.Errorf("宛先のオブジェクトの確認に失敗しました: %w", err)
+		}
+		return appliedReason(current, change), nil
+	}
+}
+
+// appliedReason は宛先のオブジェクトの現在の状態に変更が反映済みであればその理由を返します（未反映の場合は空文字列）
+//
+// 削除は宛先にオブジェクトが存在しなければ反映済みとします
+// コピーする変更は、trav が記録した変更元のバージョンIDが一致するか、ETagとサイズが一致すれば反映済みとします
+// マルチパートアップロードではETagが変わるため、ストリーミングコピーではバージョンIDを記録して判定に使用します
+func appliedReason(current *ObjectInfo, change ObjectChange) string {
+	if change.ChangeType == ChangeTypeDelete {
+		if current == nil {
+			return "宛先にオブジェクトが存在しません"
+		}
+		return ""
+	}
+	if current == nil {
+		return ""
+	}
+
+	versionID := sourceVersionID(change)
+	if versionID != "" && current.Metadata.UserMetadata[SourceVersionMetadataKey] == versionID {
+		return fmt.Sprintf("宛先のオブジェクトは変更元のバージョン %s からコピー済みです", versionID)
+	}
+	if change.ETag != "" && normalizeETag(current.ETag) == normalizeETag(change.ETag) && current.Size == change.Size {
+		return fmt.Sprintf("宛先のオブジェクトのETag %s とサイズ %d が一致します", normalizeETag(change.ETag), change.Size)
+	}
+	return ""
+}
+
+// sourceVersionID は変更でコピーする変更元のバージョンIDを返します（復元は前のバージョン）
+func sourceVersionID(change ObjectChange) string {
+	if change.ChangeType == ChangeTypeUndelete {
+		return change.PreviousVersionID
+	}
+	return change.VersionID
+}
+
+// withSourceVersion はユーザー定義メタデータに変更元のバージョンIDを追加したメタデータを返します
+// 変更元のメタデータのマップは変更しません
+func withSourceVersion(metadata ObjectMetadata, versionID string) ObjectMetadata {
+	if versionID == "" {
+		return metadata
+	}
+	userMetadata := make(map[string]string, len(metadata.UserMetadata)+1)
+	for k, v := range metadata.UserMetadata {
+		userMetadata[k] = v
+	}
+	userMetadata[SourceVersionMetadataKey] = versionID
+	metadata.UserMetadata = userMetadata
+	return metadata
+}
+
+// normalizeETag はETagの前後の引用符を取り除きます
+func normalizeETag(etag string) string {
+	return strings.Trim(etag, `"`)
+}
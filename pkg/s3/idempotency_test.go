@@ -0,0 +1,102 @@
+package s3
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAppliedReason(t *testing.T) {
+	marked := &ObjectInfo{Size: 3, ETag: `"multipart-1"`, Metadata: ObjectMetadata{UserMetadata: map[string]string{SourceVersionMetadataKey: "v2"}}}
+	plain := &ObjectInfo{Size: 3, ETag: `"abc"`}
+
+	tests := []struct {
+		name    string
+		current *ObjectInfo
+		change  ObjectChange
+		applied bool
+	}{
+		{"削除済み", nil, ObjectChange{ChangeType: ChangeTypeDelete}, true},
+		{"削除前", plain, ObjectChange{ChangeType: ChangeTypeDelete}, false},
+		{"存在しないオブジェクトへのコピー", nil, ObjectChange{ChangeType: ChangeTypeCreate, VersionID: "v1", ETag: `"abc"`, Size: 3}, false},
+		{"ETagとサイズが一致", plain, ObjectChange{ChangeType: ChangeTypeUpdate, VersionID: "v1", ETag: "abc", Size: 3}, true},
+		{"ETagが一致してもサイズが異なる", plain, ObjectChange{ChangeType: ChangeTypeUpdate, VersionID: "v1", ETag: `"abc"`, Size: 4}, false},
+		{"ETagが異なる", plain, ObjectChange{ChangeType: ChangeTypeUpdate, VersionID: "v1", ETag: `"def"`, Size: 3}, false},
+		{"記録したバージョンIDが一致", marked, ObjectChange{ChangeType: ChangeTypeCreate, VersionID: "v2", ETag: `"abc"`, Size: 3}, true},
+		{"記録したバージョンIDが異なる", marked, ObjectChange{ChangeType: ChangeTypeCreate, VersionID: "v3", ETag: `"abc"`, Size: 3}, false},
+		{"復元は前のバージョンIDと比較", marked, ObjectChange{ChangeType: ChangeTypeUndelete, VersionID: "dm1", PreviousVersionID: "v2"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := appliedReason(tt.current, tt.change)
+			if (reason != "") != tt.applied {
+				t.Errorf("appliedReason() = %q, want applied %v", reason, tt.applied)
+			}
+		})
+	}
+}
+
+// TestReplaySkipApplied は宛先に反映済みの変更をスキップし、同じ変更リストを再実行しても変更しないことを確認します
+func TestReplaySkipApplied(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	source := newTestLocalBackend(t, filepath.Join(dir, "source"))
+	dest := newTestLocalBackend(t, filepath.Join(dir, "dest"))
+
+	putLocalObject(t, source, "prod", "old.txt", "o1")
+	start := time.Now().UTC()
+	putLocalObject(t, source, "prod", "a.txt", "a1")
+	putLocalObject(t, source, "prod", "b.txt", "b1")
+	// 宛先に存在しないオブジェクトの削除は反映済みと判定する
+	deleteLocalObject(t, source, "prod", "old.txt")
+
+	// 同じ内容のオブジェクトはETagとサイズで反映済みと判定する
+	putLocalObject(t, dest, "staging", "a.txt", "a1")
+
+	changes, changesFile := writeLocalChangesFile(t, source, "prod", start, dir)
+
+	opts := ReplayOptions{
+		SourceBucket:      "prod",
+		DestBucket:        "staging",
+		SourceFile:        changesFile,
+		IgnoreTimeWindows: true,
+		SourceBackend:     source,
+		DestBackend:       dest,
+		SkipApplied:       true,
+	}
+	result, err := Replay(ctx, opts)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if result.SuccessEvents != 1 || result.SkippedEvents != 2 {
+		t.Fatalf("1回目の結果 = 成功 %d、スキップ %d: %+v", result.SuccessEvents, result.SkippedEvents, result.Events)
+	}
+	for _, event := range result.Events {
+		if event.Change.Key != "b.txt" && (event.Status != "SKIPPED" || event.SkipReason == "") {
+			t.Errorf("%s の結果 = %s (%q), want SKIPPED", event.Change.Key, event.Status, event.SkipReason)
+		}
+	}
+
+	// ストリーミングコピーしたオブジェクトには変更元のバージョンIDを記録する
+	info, err := dest.HeadObject(ctx, "staging", "b.txt")
+	if err != nil || info == nil {
+		t.Fatalf("HeadObject() = %+v, %v", info, err)
+	}
+	if info.Metadata.UserMetadata[SourceVersionMetadataKey] == "" || info.Metadata.ContentType != "text/plain" {
+		t.Errorf("b.txt のメタデータ = %+v", info.Metadata)
+	}
+
+	result, err = Replay(ctx, opts)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if result.SkippedEvents != len(changes) || result.SuccessEvents != 0 {
+		t.Errorf("2回目の結果 = 成功 %d、スキップ %d, want スキップ %d", result.SuccessEvents, result.SkippedEvents, len(changes))
+	}
+	versions, _ := dest.ListVersions(ctx, "staging", "b.txt")
+	if len(versions.Versions) != 1 {
+		t.Errorf("b.txt のバージョン数 = %d, want 1", len(versions.Versions))
+	}
+}
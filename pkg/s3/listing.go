@@ -0,0 +1,430 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// listDelimiter はシャードの分割に使用する区切り文字
+	listDelimiter = "/"
+	// maxShardDiscoveryDepth は区切り文字で共通プレフィックスを展開する最大の深さ
+	maxShardDiscoveryDepth = 3
+	// maxKeyRangeSplitDepth はキー範囲の分割で共通部分を延長する最大の文字数
+	maxKeyRangeSplitDepth = 16
+)
+
+// keyRangeSplitChars はキー範囲を分割する境界の候補となる文字（印字可能なASCII文字）
+var keyRangeSplitChars = func() []string {
+	var chars []string
+	for c := byte(0x20); c <= 0x7e; c++ {
+		chars = append(chars, string(c))
+	}
+	return chars
+}()
+
+// listShard はキー一覧の取得を分割した単位
+// Prefix に一致するキーのうち、StartAfter より後で Until より前のキーを担当します
+type listShard struct {
+	Prefix     string
+	StartAfter string // 空の場合は先頭から
+	Until      string // 空の場合は末尾まで
+}
+
+// keyPageInput はキー一覧の1ページの取得条件
+type keyPageInput struct {
+	Prefix     string
+	StartAfter string // 空の場合は先頭から
+	Delimiter  string // 空の場合は共通プレフィックスにまとめない
+	MaxKeys    int32  // 0の場合はAPIのデフォルト
+	Next       *keyPageToken
+}
+
+// keyPageToken は次のページを取得するための位置
+type keyPageToken struct {
+	ContinuationToken *string // ListObjectsV2
+	KeyMarker         *string // ListObjectVersions
+	VersionIDMarker   *string // ListObjectVersions
+}
+
+// keyPage はキー一覧の1ページ
+type keyPage struct {
+	Keys           []string // キー順（同じキーは1つにまとめる）
+	CommonPrefixes []string
+	Next           *keyPageToken // 続きがない場合はnil
+}
+
+// keyPager はキー一覧を1ページずつ取得します
+// 現在のオブジェクトのみを返す ListObjectsV2 と、削除されたキーも返す ListObjectVersions で
+// 同じシャードの分割と並列取得を使用するための抽象化です
+type keyPager interface {
+	listKeyPage(ctx context.Context, input keyPageInput) (keyPage, error)
+}
+
+// keyPagerFunc は関数をkeyPagerとして使用します
+type keyPagerFunc func(ctx context.Context, input keyPageInput) (keyPage, error)
+
+func (f keyPagerFunc) listKeyPage(ctx context.Context, input keyPageInput) (keyPage, error) {
+	return f(ctx, input)
+}
+
+// keyPagerBackend はキー一覧を1ページずつ取得できるストレージ
+// 再実行やリクエスト数の制限を一覧全体ではなくページごとに適用するために使用します
+type keyPagerBackend interface {
+	// keyPager はバケットのキー一覧を取得するkeyPagerを返します
+	// versioned の場合は ListVersionedKeys、それ以外は ListKeys と同じキーを返します
+	keyPager(bucket string, versioned bool) keyPager
+}
+
+// backendKeyPager はストレージがページ単位の取得に対応している場合にkeyPagerを返します（対応していない場合はnil）
+func backendKeyPager(backend Backend, bucket string, versioned bool) keyPager {
+	pagerBackend, ok := backend.(keyPagerBackend)
+	if !ok {
+		return nil
+	}
+	return pagerBackend.keyPager(bucket, versioned)
+}
+
+// objectKeyPager は ListObjectsV2 で現在のオブジェクト（最新が削除マーカーでないもの）のキーを取得します
+type objectKeyPager struct {
+	client s3.ListObjectsV2APIClient
+	bucket string
+}
+
+func (p objectKeyPager) listKeyPage(ctx context.Context, input keyPageInput) (keyPage, error) {
+	params := &s3.ListObjectsV2Input{
+		Bucket: aws.String(p.bucket),
+		Prefix: aws.String(input.Prefix),
+	}
+	if input.StartAfter != "" {
+		params.StartAfter = aws.String(input.StartAfter)
+	}
+	if input.Delimiter != "" {
+		params.Delimiter = aws.String(input.Delimiter)
+	}
+	if input.MaxKeys > 0 {
+		params.MaxKeys = aws.Int32(input.MaxKeys)
+	}
+	if input.Next != nil {
+		params.ContinuationToken = input.Next.ContinuationToken
+	}
+
+	resp, err := p.client.ListObjectsV2(ctx, params)
+	if err != nil {
+		return keyPage{}, err
+	}
+
+	var page keyPage
+	for _, obj := range resp.Contents {
+		page.Keys = append(page.Keys, *obj.Key)
+	}
+	for _, commonPrefix := range resp.CommonPrefixes {
+		page.CommonPrefixes = append(page.CommonPrefixes, *commonPrefix.Prefix)
+	}
+	if resp.IsTruncated != nil && *resp.IsTruncated {
+		page.Next = &keyPageToken{ContinuationToken: resp.NextContinuationToken}
+	}
+	return page, nil
+}
+
+// versionKeyPager は ListObjectVersions でバージョンまたは削除マーカーを持つ全てのキーを取得します
+// 最新が削除マーカーのキーも含むため、期間内に削除されたオブジェクトを変更リストに含められます
+type versionKeyPager struct {
+	client s3.ListObjectVersionsAPIClient
+	bucket string
+}
+
+func (p versionKeyPager) listKeyPage(ctx context.Context, input keyPageInput) (keyPage, error) {
+	params := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(p.bucket),
+		Prefix: aws.String(input.Prefix),
+	}
+	if input.StartAfter != "" {
+		params.KeyMarker = aws.String(input.StartAfter)
+	}
+	if input.Delimiter != "" {
+		params.Delimiter = aws.String(input.Delimiter)
+	}
+	if input.MaxKeys > 0 {
+		params.MaxKeys = aws.Int32(input.MaxKeys)
+	}
+	if input.Next != nil {
+		params.KeyMarker = input.Next.KeyMarker
+		params.VersionIdMarker = input.Next.VersionIDMarker
+	}
+
+	resp, err := p.client.ListObjectVersions(ctx, params)
+	if err != nil {
+		return keyPage{}, err
+	}
+
+	// バージョンと削除マーカーは別々に返されるため、まとめてキー順に並べる
+	var keys []string
+	for _, v := range resp.Versions {
+		keys = append(keys, *v.Key)
+	}
+	for _, dm := range resp.DeleteMarkers {
+		keys = append(keys, *dm.Key)
+	}
+	sort.Strings(keys)
+
+	var page keyPage
+	for _, key := range keys {
+		if n := len(page.Keys); n == 0 || page.Keys[n-1] != key {
+			page.Keys = append(page.Keys, key)
+		}
+	}
+	for _, commonPrefix := range resp.CommonPrefixes {
+		page.CommonPrefixes = append(page.CommonPrefixes, *commonPrefix.Prefix)
+	}
+	if resp.IsTruncated != nil && *resp.IsTruncated {
+		page.Next = &keyPageToken{KeyMarker: resp.NextKeyMarker, VersionIDMarker: resp.NextVersionIdMarker}
+	}
+	return page, nil
+}
+
+// listAllKeys はバケット内の全てのキーを取得します
+// concurrency が2以上の場合はプレフィックスをシャードに分割して並列に取得し、結果はキー順に並べます
+func listAllKeys(ctx context.Context, pager keyPager, prefix string, concurrency int) ([]string, error) {
+	if concurrency <= 1 {
+		return listShardKeys(ctx, pager, listShard{Prefix: prefix})
+	}
+
+	shards, keys, err := discoverShards(ctx, pager, prefix, concurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Debug("キー一覧の取得を分割しました", "prefix", prefix, "shards", len(shards))
+
+	shardCh := make(chan int, len(shards))
+	for i := range shards {
+		shardCh <- i
+	}
+	close(shardCh)
+
+	results := make([][]string, len(shards))
+	errCh := make(chan error, 1)
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency && i < len(shards); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for index := range shardCh {
+				shardKeys, err := listShardKeys(ctx, pager, shards[index])
+				if err != nil {
+					select {
+					case errCh <- err:
+					default:
+						// すでにエラーがある場合は無視
+					}
+					return
+				}
+				results[index] = shardKeys
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	select {
+	case err := <-errCh:
+		return nil, err
+	default:
+	}
+
+	for _, shardKeys := range results {
+		keys = append(keys, shardKeys...)
+	}
+
+	// 逐次取得した場合と同じ順序（UTF-8のバイト順）に揃える
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// listShardKeys はシャードが担当する範囲のキーを取得します
+// 1つのキーのバージョンが複数のページにまたがる場合も、キーは1回だけ返します
+func listShardKeys(ctx context.Context, pager keyPager, shard listShard) ([]string, error) {
+	var keys []string
+	input := keyPageInput{Prefix: shard.Prefix, StartAfter: shard.StartAfter}
+
+	for {
+		page, err := pager.listKeyPage(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range page.Keys {
+			if shard.Until != "" && key >= shard.Until {
+				return keys, nil
+			}
+			if n := len(keys); n > 0 && keys[n-1] == key {
+				continue
+			}
+			keys = append(keys, key)
+		}
+
+		if page.Next == nil {
+			break
+		}
+		input.Next = page.Next
+	}
+
+	return keys, nil
+}
+
+// discoverShards はキー一覧の取得を並列化するためのシャードを求めます
+// 区切り文字で共通プレフィックスに分割できる場合はそれを、できない場合（フラットなプレフィックス）は
+// キー範囲で分割します。探索中に見つかった共通プレフィックス直下のキーは keys として返します
+func discoverShards(ctx context.Context, pager keyPager, prefix string, concurrency int) ([]listShard, []string, error) {
+	var shards []listShard
+	var keys []string
+
+	var discover func(prefix string, depth int) error
+	discover = func(prefix string, depth int) error {
+		page, err := pager.listKeyPage(ctx, keyPageInput{Prefix: prefix, Delimiter: listDelimiter})
+		if err != nil {
+			return err
+		}
+
+		// 1ページに収まらない場合は区切り文字での分割に向かないため、キー範囲で分割する
+		if page.Next != nil {
+			rangeShards, err := splitKeyRange(ctx, pager, prefix, concurrency)
+			if err != nil {
+				return err
+			}
+			shards = append(shards, rangeShards...)
+			return nil
+		}
+
+		keys = append(keys, page.Keys...)
+
+		for _, commonPrefix := range page.CommonPrefixes {
+			// 共通プレフィックスが少ない場合はさらに下の階層まで展開する
+			if len(page.CommonPrefixes) < concurrency && depth < maxShardDiscoveryDepth {
+				if err := discover(commonPrefix, depth+1); err != nil {
+					return err
+				}
+				continue
+			}
+			shards = append(shards, listShard{Prefix: commonPrefix})
+		}
+
+		return nil
+	}
+
+	if err := discover(prefix, 0); err != nil {
+		return nil, nil, fmt.Errorf("キー一覧の分割に失敗しました: %w", err)
+	}
+
+	return shards, keys, nil
+}
+
+// splitKeyRange はフラットなプレフィックスをキー範囲で分割します
+// 境界の候補となる文字列ごとにその直後のキーを1件だけ取得し、実在するキーを境界にすることで
+// 全てのキーがいずれか1つのシャードに含まれるようにします
+func splitKeyRange(ctx context.Context, pager keyPager, prefix string, concurrency int) ([]listShard, error) {
+	base := prefix
+
+	for depth := 0; depth < maxKeyRangeSplitDepth; depth++ {
+		candidates := make([]string, len(keyRangeSplitChars))
+		for i, c := range keyRangeSplitChars {
+			candidates[i] = base + c
+		}
+
+		firstKeys, err := probeFirstKeys(ctx, pager, prefix, candidates, concurrency)
+		if err != nil {
+			return nil, err
+		}
+
+		// 同じキーに行き着く候補は最初のものだけを境界にする
+		var bounds []string // 各シャードの開始位置（このキーより後）
+		var starts []string // 各シャードの最初のキー
+		for i, key := range firstKeys {
+			if key == "" || (len(starts) > 0 && starts[len(starts)-1] == key) {
+				continue
+			}
+			bounds = append(bounds, candidates[i])
+			starts = append(starts, key)
+		}
+
+		if len(starts) == 0 {
+			return []listShard{{Prefix: prefix}}, nil
+		}
+
+		// 全てのキーが同じ文字で始まる場合は、その文字まで共通部分を延長して分割し直す
+		// （S3に不正なUTF-8を渡さないよう、延長するのはASCII文字の場合のみ）
+		if len(starts) == 1 && len(starts[0]) > len(base) && strings.HasPrefix(starts[0], base) && starts[0][len(base)] < 0x80 {
+			base = starts[0][:len(base)+1]
+			continue
+		}
+
+		shards := []listShard{{Prefix: prefix, Until: starts[0]}}
+		for i := range starts {
+			shard := listShard{Prefix: prefix, StartAfter: bounds[i]}
+			if i+1 < len(starts) {
+				shard.Until = starts[i+1]
+			}
+			shards = append(shards, shard)
+		}
+		return shards, nil
+	}
+
+	return []listShard{{Prefix: prefix}}, nil
+}
+
+// probeFirstKeys は候補ごとに、その文字列より後にある最初のキーを取得します
+// 該当するキーがない場合は空文字列になります
+func probeFirstKeys(ctx context.Context, pager keyPager, prefix string, candidates []string, concurrency int) ([]string, error) {
+	results := make([]string, len(candidates))
+	indexCh := make(chan int, len(candidates))
+	for i := range candidates {
+		indexCh <- i
+	}
+	close(indexCh)
+
+	errCh := make(chan error, 1)
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency && i < len(candidates); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for index := range indexCh {
+				page, err := pager.listKeyPage(ctx, keyPageInput{Prefix: prefix, StartAfter: candidates[index], MaxKeys: 1})
+				if err != nil {
+					select {
+					case errCh <- err:
+					default:
+						// すでにエラーがある場合は無視
+					}
+					return
+				}
+
+				if len(page.Keys) > 0 {
+					results[index] = page.Keys[0]
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	select {
+	case err := <-errCh:
+		return nil, err
+	default:
+	}
+
+	return results, nil
+}
@@ -0,0 +1,270 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeListClient はListObjectsV2とListObjectVersionsの動作を再現するインメモリのクライアント
+type fakeListClient struct {
+	keys    []string        // キー順に並べたもの
+	deleted map[string]bool // 最新が削除マーカーのキー（ListObjectsV2には含めない）
+	calls   atomic.Int32
+}
+
+func newFakeListClient(keys []string) *fakeListClient {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	return &fakeListClient{keys: sorted}
+}
+
+func (c *fakeListClient) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	c.calls.Add(1)
+
+	prefix := aws.ToString(params.Prefix)
+	delimiter := aws.ToString(params.Delimiter)
+	after := aws.ToString(params.StartAfter)
+	if params.ContinuationToken != nil {
+		after = *params.ContinuationToken
+	}
+	maxKeys := 1000
+	if params.MaxKeys != nil {
+		maxKeys = int(*params.MaxKeys)
+	}
+
+	out := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(false)}
+	seenPrefixes := make(map[string]bool)
+	count := 0
+	last := ""
+
+	for _, key := range c.keys {
+		if !strings.HasPrefix(key, prefix) || key <= after || c.deleted[key] {
+			continue
+		}
+
+		entry := key
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				entry = key[:len(prefix)+i+len(delimiter)]
+				if seenPrefixes[entry] || entry <= after {
+					continue
+				}
+			}
+		}
+
+		if count == maxKeys {
+			out.IsTruncated = aws.Bool(true)
+			out.NextContinuationToken = aws.String(last)
+			break
+		}
+
+		if entry != key {
+			seenPrefixes[entry] = true
+			out.CommonPrefixes = append(out.CommonPrefixes, s3types.CommonPrefix{Prefix: aws.String(entry)})
+			// 共通プレフィックス配下のキーは続きのページに含めない
+			last = entry + "\xff"
+		} else {
+			out.Contents = append(out.Contents, s3types.Object{Key: aws.String(key)})
+			last = key
+		}
+		count++
+	}
+
+	return out, nil
+}
+
+// ListObjectVersions は各キーのバージョンを1件、削除されたキーは加えて削除マーカーを1件返します
+func (c *fakeListClient) ListObjectVersions(ctx context.Context, params *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	c.calls.Add(1)
+
+	prefix := aws.ToString(params.Prefix)
+	delimiter := aws.ToString(params.Delimiter)
+	afterKey := aws.ToString(params.KeyMarker)
+	afterVersion := aws.ToString(params.VersionIdMarker)
+	maxKeys := 1000
+	if params.MaxKeys != nil {
+		maxKeys = int(*params.MaxKeys)
+	}
+
+	out := &s3.ListObjectVersionsOutput{IsTruncated: aws.Bool(false)}
+	seenPrefixes := make(map[string]bool)
+	count := 0
+	lastKey, lastVersion := "", ""
+
+	truncate := func() {
+		out.IsTruncated = aws.Bool(true)
+		out.NextKeyMarker = aws.String(lastKey)
+		if lastVersion != "" {
+			out.NextVersionIdMarker = aws.String(lastVersion)
+		}
+	}
+
+	for _, key := range c.keys {
+		if !strings.HasPrefix(key, prefix) || key < afterKey || (key == afterKey && afterVersion == "") {
+			continue
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				entry := key[:len(prefix)+i+len(delimiter)]
+				if seenPrefixes[entry] {
+					continue
+				}
+				if count == maxKeys {
+					truncate()
+					break
+				}
+				seenPrefixes[entry] = true
+				out.CommonPrefixes = append(out.CommonPrefixes, s3types.CommonPrefix{Prefix: aws.String(entry)})
+				// 共通プレフィックス配下のキーは続きのページに含めない
+				lastKey, lastVersion = entry+"\xff", ""
+				count++
+				continue
+			}
+		}
+
+		versionIDs := []string{"v1"}
+		if c.deleted[key] {
+			versionIDs = append(versionIDs, "dm1")
+		}
+		for _, versionID := range versionIDs {
+			if key == afterKey && versionID <= afterVersion {
+				continue
+			}
+			if count == maxKeys {
+				truncate()
+				break
+			}
+			if versionID == "dm1" {
+				out.DeleteMarkers = append(out.DeleteMarkers, s3types.DeleteMarkerEntry{Key: aws.String(key), VersionId: aws.String(versionID)})
+			} else {
+				out.Versions = append(out.Versions, s3types.ObjectVersion{Key: aws.String(key), VersionId: aws.String(versionID)})
+			}
+			lastKey, lastVersion = key, versionID
+			count++
+		}
+		if *out.IsTruncated {
+			break
+		}
+	}
+
+	return out, nil
+}
+
+func TestListAllKeys(t *testing.T) {
+	var flat []string
+	for i := 0; i < 2500; i++ {
+		flat = append(flat, fmt.Sprintf("logs/%08x", i*2654435761%(1<<32)))
+	}
+
+	var hierarchical []string
+	for _, tenant := range []string{"a", "b", "c"} {
+		for day := 1; day <= 3; day++ {
+			for i := 0; i < 400; i++ {
+				hierarchical = append(hierarchical, fmt.Sprintf("data/%s/2025-06-0%d/%04d.json", tenant, day, i))
+			}
+		}
+	}
+	hierarchical = append(hierarchical, "data/README", "data/a/index.html", "other/x")
+
+	tests := []struct {
+		name   string
+		keys   []string
+		prefix string
+	}{
+		{name: "フラットなプレフィックス", keys: flat, prefix: "logs/"},
+		{name: "階層のあるプレフィックス", keys: hierarchical, prefix: "data/"},
+		{name: "プレフィックスなし", keys: hierarchical, prefix: ""},
+		{name: "該当するキーなし", keys: flat, prefix: "missing/"},
+		{name: "同じ文字で始まるキー", keys: []string{"k/zzzz1", "k/zzzz2", "k/zzzz3"}, prefix: "k/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeListClient(tt.keys)
+
+			want, err := listAllKeys(context.Background(), objectKeyPager{client: client, bucket: "bucket"}, tt.prefix, 1)
+			if err != nil {
+				t.Fatalf("listAllKeys() error = %v", err)
+			}
+			for _, key := range want {
+				if !strings.HasPrefix(key, tt.prefix) {
+					t.Fatalf("プレフィックスに一致しないキーが含まれています: %s", key)
+				}
+			}
+
+			for _, concurrency := range []int{2, 8, 32} {
+				got, err := listAllKeys(context.Background(), objectKeyPager{client: client, bucket: "bucket"}, tt.prefix, concurrency)
+				if err != nil {
+					t.Fatalf("listAllKeys(concurrency=%d) error = %v", concurrency, err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("concurrency=%d の結果が逐次取得と異なります: got %d keys, want %d keys", concurrency, len(got), len(want))
+				}
+			}
+		})
+	}
+}
+
+func TestDiscoverShards(t *testing.T) {
+	var keys []string
+	for i := 0; i < 20; i++ {
+		keys = append(keys, "data/"+strconv.Itoa(i)+"/file")
+	}
+	client := newFakeListClient(keys)
+
+	shards, rootKeys, err := discoverShards(context.Background(), objectKeyPager{client: client, bucket: "bucket"}, "data/", 4)
+	if err != nil {
+		t.Fatalf("discoverShards() error = %v", err)
+	}
+	if len(shards) != 20 {
+		t.Errorf("シャード数 = %d, want 20", len(shards))
+	}
+	if len(rootKeys) != 0 {
+		t.Errorf("直下のキー = %v, want none", rootKeys)
+	}
+}
+
+// TestListAllVersionedKeys はバージョン一覧から削除されたキーを含めて取得することを確認します
+func TestListAllVersionedKeys(t *testing.T) {
+	var keys []string
+	deleted := make(map[string]bool)
+	for i := 0; i < 1500; i++ {
+		key := fmt.Sprintf("logs/%08x", i*2654435761%(1<<32))
+		keys = append(keys, key)
+		// 一部のキーはバージョンと削除マーカーがページの境界をまたぐ
+		if i%3 == 0 {
+			deleted[key] = true
+		}
+	}
+	client := newFakeListClient(keys)
+	client.deleted = deleted
+
+	current, err := listAllKeys(context.Background(), objectKeyPager{client: client, bucket: "bucket"}, "logs/", 1)
+	if err != nil {
+		t.Fatalf("listAllKeys() error = %v", err)
+	}
+	if len(current) != len(keys)-len(deleted) {
+		t.Errorf("現在のキーの数 = %d, want %d", len(current), len(keys)-len(deleted))
+	}
+
+	for _, concurrency := range []int{1, 8} {
+		got, err := listAllKeys(context.Background(), versionKeyPager{client: client, bucket: "bucket"}, "logs/", concurrency)
+		if err != nil {
+			t.Fatalf("listAllKeys(concurrency=%d) error = %v", concurrency, err)
+		}
+		if !reflect.DeepEqual(got, client.keys) {
+			t.Errorf("concurrency=%d のバージョン一覧のキー = %d keys, want %d keys（削除されたキーを含む）", concurrency, len(got), len(client.keys))
+		}
+	}
+}
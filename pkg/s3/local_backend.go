@@ -0,0 +1,363 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// localIndexFile はキーごとのバージョンの一覧を保存するファイル名
+const localIndexFile = "index.json"
+
+// LocalBackend はローカルのディレクトリにオブジェクトを保存するBackend
+//
+// バケットはルートディレクトリ直下のディレクトリで、キーごとにキーのSHA-256のディレクトリを作成し、
+// 各バージョンの内容をバージョンIDのファイルに、バージョンの一覧とメタデータを index.json に保存します
+//
+//	<root>/<bucket>/<sha256(key)>/index.json
+//	<root>/<bucket>/<sha256(key)>/<versionID>
+//
+// バージョニングは常に有効で、削除すると削除マーカーが追加されます
+type LocalBackend struct {
+	root string
+
+	// インデックスの読み込みから書き込みまでを直列化する
+	mu sync.Mutex
+}
+
+// localObjectIndex はキーのバージョンの一覧（古い順）
+type localObjectIndex struct {
+	Key      string         `json:"key"`
+	Versions []localVersion `json:"versions"`
+}
+
+// localVersion はバージョンまたは削除マーカーの情報
+type localVersion struct {
+	VersionID      string         `json:"versionId"`
+	LastModified   time.Time      `json:"lastModified"`
+	IsDeleteMarker bool           `json:"isDeleteMarker,omitempty"`
+	Size           int64          `json:"size"`
+	ETag           string         `json:"etag,omitempty"`
+	Metadata       ObjectMetadata `json:"metadata"`
+}
+
+// NewLocalBackend はルートディレクトリを使用するLocalBackendを作成します
+// ルートディレクトリが存在しない場合は作成します
+func NewLocalBackend(root string) (*LocalBackend, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("ルートディレクトリ %s の作成に失敗しました: %w", root, err)
+	}
+	return &LocalBackend{root: root}, nil
+}
+
+// ListKeys はプレフィックスに一致する削除されていないオブジェクトのキーを返します
+func (b *LocalBackend) ListKeys(ctx context.Context, bucket, prefix string, concurrency int) ([]string, error) {
+	return b.listKeys(ctx, bucket, prefix, false)
+}
+
+// ListVersionedKeys はプレフィックスに一致する削除されたものを含む全てのオブジェクトのキーを返します
+func (b *LocalBackend) ListVersionedKeys(ctx context.Context, bucket, prefix string, concurrency int) ([]string, error) {
+	return b.listKeys(ctx, bucket, prefix, true)
+}
+
+// listKeys はプレフィックスに一致するキーを返します（includeDeleted が false の場合は最新が削除マーカーのキーを除く）
+func (b *LocalBackend) listKeys(ctx context.Context, bucket, prefix string, includeDeleted bool) ([]string, error) {
+	bucketDir, err := b.bucketDir(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(bucketDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("バケット %s が存在しません", bucket)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("バケット %s の読み込みに失敗しました: %w", bucket, err)
+	}
+
+	var keys []string
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !entry.IsDir() {
+			continue
+		}
+
+		index, err := readLocalIndex(filepath.Join(bucketDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if index == nil || !strings.HasPrefix(index.Key, prefix) {
+			continue
+		}
+		if latest := index.latest(); latest != nil && (includeDeleted || !latest.IsDeleteMarker) {
+			keys = append(keys, index.Key)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ListVersions はキーの全バージョンと削除マーカーを返します
+func (b *LocalBackend) ListVersions(ctx context.Context, bucket, key string) (KeyVersions, error) {
+	var result KeyVersions
+
+	objectDir, err := b.objectDir(bucket, key)
+	if err != nil {
+		return result, err
+	}
+
+	index, err := readLocalIndex(objectDir)
+	if err != nil || index == nil {
+		return result, err
+	}
+
+	for i, v := range index.Versions {
+		isLatest := i == len(index.Versions)-1
+		if v.IsDeleteMarker {
+			result.DeleteMarkers = append(result.DeleteMarkers, s3types.DeleteMarkerEntry{
+				Key:          aws.String(key),
+				VersionId:    aws.String(v.VersionID),
+				LastModified: aws.Time(v.LastModified),
+				IsLatest:     aws.Bool(isLatest),
+			})
+			continue
+		}
+		result.Versions = append(result.Versions, s3types.ObjectVersion{
+			Key:          aws.String(key),
+			VersionId:    aws.String(v.VersionID),
+			LastModified: aws.Time(v.LastModified),
+			IsLatest:     aws.Bool(isLatest),
+			Size:         aws.Int64(v.Size),
+			ETag:         aws.String(v.ETag),
+		})
+	}
+
+	return result, nil
+}
+
+// VersioningEnabled はLocalBackendでは常にtrueを返します
+func (b *LocalBackend) VersioningEnabled(ctx context.Context, bucket string) (bool, error) {
+	return true, nil
+}
+
+// GetObject はバージョンの内容を開きます
+func (b *LocalBackend) GetObject(ctx context.Context, bucket, key, versionID string) (*ObjectContent, error) {
+	objectDir, err := b.objectDir(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := readLocalIndex(objectDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var version *localVersion
+	if index != nil {
+		if versionID == "" {
+			version = index.latest()
+		} else {
+			version = index.find(versionID)
+		}
+	}
+	if version == nil || version.IsDeleteMarker {
+		if versionID == "" {
+			return nil, fmt.Errorf("オブジェクト %s/%s が見つかりません", bucket, key)
+		}
+		return nil, fmt.Errorf("オブジェクト %s/%s のバージョン %s が見つかりません", bucket, key, versionID)
+	}
+
+	file, err := os.Open(filepath.Join(objectDir, version.VersionID))
+	if err != nil {
+		return nil, fmt.Errorf("オブジェクト %s/%s の読み込みに失敗しました: %w", bucket, key, err)
+	}
+
+	return &ObjectContent{Body: file, Metadata: version.Metadata}, nil
+}
+
+// HeadObject は最新のバージョンの情報を返します
+func (b *LocalBackend) HeadObject(ctx context.Context, bucket, key string) (*ObjectInfo, error) {
+	objectDir, err := b.objectDir(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := readLocalIndex(objectDir)
+	if err != nil || index == nil {
+		return nil, err
+	}
+
+	latest := index.latest()
+	if latest == nil || latest.IsDeleteMarker {
+		return nil, nil
+	}
+	return &ObjectInfo{Size: latest.Size, ETag: latest.ETag, Metadata: latest.Metadata}, nil
+}
+
+// PutObject は内容をバージョンのファイルに書き込み、インデックスに追加します
+func (b *LocalBackend) PutObject(ctx context.Context, bucket, key string, body io.Reader, metadata ObjectMetadata) error {
+	objectDir, err := b.objectDir(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(objectDir, 0755); err != nil {
+		return fmt.Errorf("オブジェクト %s/%s のディレクトリの作成に失敗しました: %w", bucket, key, err)
+	}
+
+	// 書き込みが完了するまではインデックスに含めない
+	tempFile, err := os.CreateTemp(objectDir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("オブジェクト %s/%s の書き込みに失敗しました: %w", bucket, key, err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(tempFile, hash), body)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("オブジェクト %s/%s の書き込みに失敗しました: %w", bucket, key, err)
+	}
+
+	version := localVersion{
+		VersionID:    newLocalVersionID(),
+		LastModified: time.Now().UTC(),
+		Size:         size,
+		ETag:         `"` + hex.EncodeToString(hash.Sum(nil)) + `"`,
+		Metadata:     metadata,
+	}
+	if err := os.Rename(tempFile.Name(), filepath.Join(objectDir, version.VersionID)); err != nil {
+		return fmt.Errorf("オブジェクト %s/%s の書き込みに失敗しました: %w", bucket, key, err)
+	}
+
+	return b.appendVersion(objectDir, key, version)
+}
+
+// CopyObject はバージョンの内容を読み込み、新しいバージョンとして書き込みます
+func (b *LocalBackend) CopyObject(ctx context.Context, sourceBucket, sourceKey, versionID, destBucket, destKey string) error {
+	content, err := b.GetObject(ctx, sourceBucket, sourceKey, versionID)
+	if err != nil {
+		return err
+	}
+	defer content.Body.Close()
+
+	return b.PutObject(ctx, destBucket, destKey, content.Body, content.Metadata)
+}
+
+// DeleteObject は削除マーカーを追加します
+func (b *LocalBackend) DeleteObject(ctx context.Context, bucket, key string) error {
+	objectDir, err := b.objectDir(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(objectDir, 0755); err != nil {
+		return fmt.Errorf("オブジェクト %s/%s のディレクトリの作成に失敗しました: %w", bucket, key, err)
+	}
+
+	return b.appendVersion(objectDir, key, localVersion{
+		VersionID:      newLocalVersionID(),
+		LastModified:   time.Now().UTC(),
+		IsDeleteMarker: true,
+	})
+}
+
+// appendVersion はインデックスにバージョンを追加します
+func (b *LocalBackend) appendVersion(objectDir, key string, version localVersion) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	index, err := readLocalIndex(objectDir)
+	if err != nil {
+		return err
+	}
+	if index == nil {
+		index = &localObjectIndex{Key: key}
+	}
+	index.Versions = append(index.Versions, version)
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(objectDir, localIndexFile), data); err != nil {
+		return fmt.Errorf("オブジェクト %s のインデックスの保存に失敗しました: %w", key, err)
+	}
+	return nil
+}
+
+// bucketDir はバケットのディレクトリを返します
+func (b *LocalBackend) bucketDir(bucket string) (string, error) {
+	if bucket == "" || !filepath.IsLocal(bucket) || strings.ContainsAny(bucket, `/\`) {
+		return "", fmt.Errorf("バケット名 %q が無効です", bucket)
+	}
+	return filepath.Join(b.root, bucket), nil
+}
+
+// objectDir はキーのバージョンを保存するディレクトリを返します
+func (b *LocalBackend) objectDir(bucket, key string) (string, error) {
+	bucketDir, err := b.bucketDir(bucket)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(bucketDir, hex.EncodeToString(sum[:])), nil
+}
+
+// readLocalIndex はインデックスを読み込みます（存在しない場合はnil）
+func readLocalIndex(objectDir string) (*localObjectIndex, error) {
+	data, err := os.ReadFile(filepath.Join(objectDir, localIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("インデックスの読み込みに失敗しました: %w", err)
+	}
+
+	var index localObjectIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("インデックス %s のデコードに失敗しました: %w", objectDir, err)
+	}
+	return &index, nil
+}
+
+// latest は最新のバージョンを返します
+func (index *localObjectIndex) latest() *localVersion {
+	if len(index.Versions) == 0 {
+		return nil
+	}
+	return &index.Versions[len(index.Versions)-1]
+}
+
+// find はバージョンIDのバージョンを返します
+func (index *localObjectIndex) find(versionID string) *localVersion {
+	for i := range index.Versions {
+		if index.Versions[i].VersionID == versionID {
+			return &index.Versions[i]
+		}
+	}
+	return nil
+}
+
+// newLocalVersionID は作成順に並ぶバージョンIDを生成します
+func newLocalVersionID() string {
+	return fmt.Sprintf("%016x%08x", time.Now().UnixNano(), rand.Uint32())
+}
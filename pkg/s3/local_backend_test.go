@@ -0,0 +1,269 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestLocalBackend はテスト用のローカルのストレージを作成します
+func newTestLocalBackend(t *testing.T, root string) *LocalBackend {
+	t.Helper()
+
+	backend, err := NewLocalBackend(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+// writeLocalChangesFile は変更元の start 以降の変更リストを取得し、JSON形式で dir/changes.json に書き込みます
+// 変更リストとファイルのパスを返します
+func writeLocalChangesFile(t *testing.T, source *LocalBackend, bucket string, start time.Time, dir string) ([]ObjectChange, string) {
+	t.Helper()
+
+	changes, err := GetChangesList(context.Background(), ReplayListOptions{Bucket: bucket, Timestamp: start, Backend: source})
+	if err != nil {
+		t.Fatalf("GetChangesList() error = %v", err)
+	}
+	data, err := json.Marshal(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changesFile := filepath.Join(dir, "changes.json")
+	if err := os.WriteFile(changesFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return changes, changesFile
+}
+
+// putLocalObject はテスト用にオブジェクトを書き込みます
+func putLocalObject(t *testing.T, backend *LocalBackend, bucket, key, body string) {
+	t.Helper()
+	if err := backend.PutObject(context.Background(), bucket, key, strings.NewReader(body), ObjectMetadata{ContentType: "text/plain"}); err != nil {
+		t.Fatalf("PutObject(%s) error = %v", key, err)
+	}
+	// バージョンの時刻が同じにならないようにする
+	time.Sleep(2 * time.Millisecond)
+}
+
+// readLocalObject は最新のバージョンの内容を返します（存在しない場合は空文字列）
+func readLocalObject(t *testing.T, backend *LocalBackend, bucket, key string) string {
+	t.Helper()
+	content, err := backend.GetObject(context.Background(), bucket, key, "")
+	if err != nil {
+		return ""
+	}
+	defer content.Body.Close()
+	data, err := io.ReadAll(content.Body)
+	if err != nil {
+		t.Fatalf("GetObject(%s) error = %v", key, err)
+	}
+	return string(data)
+}
+
+func TestLocalBackend(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalBackend() error = %v", err)
+	}
+
+	putLocalObject(t, backend, "bucket", "p/a", "a1")
+	putLocalObject(t, backend, "bucket", "p/a", "a2")
+	putLocalObject(t, backend, "bucket", "p/b", "b1")
+	putLocalObject(t, backend, "bucket", "other", "o1")
+	if err := backend.DeleteObject(ctx, "bucket", "p/b"); err != nil {
+		t.Fatalf("DeleteObject() error = %v", err)
+	}
+
+	keys, err := backend.ListKeys(ctx, "bucket", "p/", 1)
+	if err != nil {
+		t.Fatalf("ListKeys() error = %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"p/a"}) {
+		t.Errorf("ListKeys() = %v, want [p/a]", keys)
+	}
+	keys, err = backend.ListVersionedKeys(ctx, "bucket", "p/", 1)
+	if err != nil {
+		t.Fatalf("ListVersionedKeys() error = %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"p/a", "p/b"}) {
+		t.Errorf("ListVersionedKeys() = %v, want [p/a p/b]", keys)
+	}
+
+	versions, err := backend.ListVersions(ctx, "bucket", "p/a")
+	if err != nil {
+		t.Fatalf("ListVersions() error = %v", err)
+	}
+	if len(versions.Versions) != 2 || *versions.Versions[1].IsLatest != true || *versions.Versions[0].Size != 2 {
+		t.Fatalf("ListVersions() = %+v", versions)
+	}
+
+	// 古いバージョンを別のキーにコピー
+	first := *versions.Versions[0].VersionId
+	if err := backend.CopyObject(ctx, "bucket", "p/a", first, "copy", "restored"); err != nil {
+		t.Fatalf("CopyObject() error = %v", err)
+	}
+	if got := readLocalObject(t, backend, "copy", "restored"); got != "a1" {
+		t.Errorf("コピーした内容 = %q, want a1", got)
+	}
+	content, _ := backend.GetObject(ctx, "copy", "restored", "")
+	content.Body.Close()
+	if content.Metadata.ContentType != "text/plain" {
+		t.Errorf("メタデータが引き継がれていません: %+v", content.Metadata)
+	}
+
+	// 削除されたオブジェクトは取得できず、削除マーカーが履歴に残る
+	if _, err := backend.GetObject(ctx, "bucket", "p/b", ""); err == nil {
+		t.Error("削除されたオブジェクトを取得できます")
+	}
+	deleted, _ := backend.ListVersions(ctx, "bucket", "p/b")
+	if len(deleted.DeleteMarkers) != 1 || !*deleted.DeleteMarkers[0].IsLatest {
+		t.Errorf("削除マーカー = %+v", deleted.DeleteMarkers)
+	}
+
+	if _, err := backend.ListKeys(ctx, "missing", "", 1); err == nil {
+		t.Error("存在しないバケットでエラーになりません")
+	}
+	if _, err := backend.ListKeys(ctx, "../bucket", "", 1); err == nil {
+		t.Error("無効なバケット名でエラーになりません")
+	}
+}
+
+// deleteLocalObject はテスト用にオブジェクトを削除します
+func deleteLocalObject(t *testing.T, backend *LocalBackend, bucket, key string) {
+	t.Helper()
+	if err := backend.DeleteObject(context.Background(), bucket, key); err != nil {
+		t.Fatalf("DeleteObject(%s) error = %v", key, err)
+	}
+	// バージョンの時刻が同じにならないようにする
+	time.Sleep(2 * time.Millisecond)
+}
+
+// TestGetChangesListDeletedKeys は最新が削除マーカーのキーの変更も変更リストに含めることを確認します
+func TestGetChangesListDeletedKeys(t *testing.T) {
+	ctx := context.Background()
+	source, err := NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().UTC()
+	putLocalObject(t, source, "prod", "a.txt", "a1")
+	putLocalObject(t, source, "prod", "b.txt", "b1")
+	deleteLocalObject(t, source, "prod", "a.txt")
+	putLocalObject(t, source, "prod", "c.txt", "c1")
+	deleteLocalObject(t, source, "prod", "c.txt")
+	putLocalObject(t, source, "prod", "c.txt", "c2")
+
+	changes, err := GetChangesList(ctx, ReplayListOptions{Bucket: "prod", Timestamp: start, Backend: source})
+	if err != nil {
+		t.Fatalf("GetChangesList() error = %v", err)
+	}
+
+	var types []string
+	for _, change := range changes {
+		types = append(types, change.Key+":"+string(change.ChangeType))
+	}
+	want := []string{"a.txt:CREATE", "b.txt:CREATE", "a.txt:DELETE", "c.txt:CREATE", "c.txt:DELETE", "c.txt:RECREATE"}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("変更リスト = %v, want %v", types, want)
+	}
+	if !changes[2].IsDeleteMarker {
+		t.Errorf("a.txt の削除 = %+v, want 削除マーカー", changes[2])
+	}
+}
+
+// TestLocalBackendEndToEnd は変更リストの取得、リプレイ、ロールバックをローカルのディレクトリで実行します
+func TestLocalBackendEndToEnd(t *testing.T) {
+	ctx := context.Background()
+	source, _ := NewLocalBackend(filepath.Join(t.TempDir(), "source"))
+	dest, _ := NewLocalBackend(filepath.Join(t.TempDir(), "dest"))
+
+	start := time.Now().UTC()
+	time.Sleep(2 * time.Millisecond)
+	putLocalObject(t, source, "prod", "tenant/a.txt", "a1")
+	putLocalObject(t, source, "prod", "tenant/b.txt", "b1")
+	putLocalObject(t, source, "prod", "tenant/a.txt", "a2")
+	if err := source.DeleteObject(ctx, "prod", "tenant/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	putLocalObject(t, source, "prod", "tenant/b.txt", "b2")
+
+	changes, err := GetChangesList(ctx, ReplayListOptions{
+		Bucket:    "prod",
+		Prefix:    "tenant/",
+		Timestamp: start,
+		Backend:   source,
+	})
+	if err != nil {
+		t.Fatalf("GetChangesList() error = %v", err)
+	}
+
+	var types []string
+	for _, change := range changes {
+		types = append(types, change.Key+":"+string(change.ChangeType))
+	}
+	want := []string{"tenant/a.txt:CREATE", "tenant/b.txt:CREATE", "tenant/a.txt:UPDATE", "tenant/b.txt:DELETE", "tenant/b.txt:RECREATE"}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("変更リスト = %v, want %v", types, want)
+	}
+
+	changesFile := filepath.Join(t.TempDir(), "changes.json")
+	data, _ := json.Marshal(changes)
+	if err := os.WriteFile(changesFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rewriter, _ := NewKeyRewriter([]string{"prefix:tenant/=>staging/tenant/"})
+	result, err := Replay(ctx, ReplayOptions{
+		SourceBucket:      "prod",
+		DestBucket:        "staging",
+		SourceFile:        changesFile,
+		Concurrency:       4,
+		IgnoreTimeWindows: true,
+		KeyRewriter:       rewriter,
+		SourceBackend:     source,
+		DestBackend:       dest,
+	})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if result.SuccessEvents != len(changes) {
+		t.Fatalf("成功したイベント数 = %d, want %d: %+v", result.SuccessEvents, len(changes), result.Events)
+	}
+
+	if got := readLocalObject(t, dest, "staging", "staging/tenant/a.txt"); got != "a2" {
+		t.Errorf("staging/tenant/a.txt = %q, want a2", got)
+	}
+	if got := readLocalObject(t, dest, "staging", "staging/tenant/b.txt"); got != "b2" {
+		t.Errorf("staging/tenant/b.txt = %q, want b2", got)
+	}
+
+	// リプレイ後の変更をロールバックすると、更新は戻り、新しく作成したオブジェクトは削除される
+	midway := time.Now().UTC()
+	time.Sleep(2 * time.Millisecond)
+	putLocalObject(t, dest, "staging", "staging/tenant/a.txt", "a3")
+	putLocalObject(t, dest, "staging", "staging/tenant/c.txt", "c1")
+
+	if err := Rollback(ctx, RollbackOptions{Bucket: "staging", Prefix: "staging/", Timestamp: midway, Backend: dest}); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if got := readLocalObject(t, dest, "staging", "staging/tenant/a.txt"); got != "a2" {
+		t.Errorf("ロールバック後の staging/tenant/a.txt = %q, want a2", got)
+	}
+	if got := readLocalObject(t, dest, "staging", "staging/tenant/c.txt"); got != "" {
+		t.Errorf("ロールバック後も staging/tenant/c.txt が残っています: %q", got)
+	}
+	if got := readLocalObject(t, dest, "staging", "staging/tenant/b.txt"); got != "b2" {
+		t.Errorf("ロールバック後の staging/tenant/b.txt = %q, want b2", got)
+	}
+}
@@ -0,0 +1,246 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 進捗ファイルに記録するエントリの状態
+const (
+	progressPending   = 'P' // 未実行
+	progressCompleted = 'C' // 完了
+	progressFailed    = 'F' // 失敗
+)
+
+// progressSaveInterval は進捗ファイルを保存する間隔
+const progressSaveInterval = 5 * time.Second
+
+// ReplayProgress はリプレイの進捗を表す構造体
+// 変更リストを時間順に並べたときの各エントリの状態を記録し、中断したリプレイの再開に使用します
+type ReplayProgress struct {
+	SourceFile  string         `json:"sourceFile"`
+	DestBucket  string         `json:"destBucket"`
+	Fingerprint string         `json:"fingerprint"` // 変更リストの内容のチェックサム（別の変更リストでの再開を防ぐ）
+	TotalEvents int            `json:"totalEvents"`
+	Completed   int            `json:"completed"`
+	Failed      int            `json:"failed"`
+	Pending     int            `json:"pending"`
+	UpdatedAt   time.Time      `json:"updatedAt"`
+	Statuses    string         `json:"statuses"`         // 各エントリの状態（C: 完了、F: 失敗、P: 未実行）を連続する件数で圧縮したもの（例: C1200F1P300）
+	Errors      map[int]string `json:"errors,omitempty"` // 失敗したエントリのエラー
+
+	statuses []byte
+	savedAt  time.Time
+	filePath string
+}
+
+// newReplayProgress は全てのエントリが未実行の進捗を作成します
+func newReplayProgress(filePath, sourceFile, destBucket, fingerprint string, total int) *ReplayProgress {
+	statuses := make([]byte, total)
+	for i := range statuses {
+		statuses[i] = progressPending
+	}
+
+	return &ReplayProgress{
+		SourceFile:  sourceFile,
+		DestBucket:  destBucket,
+		Fingerprint: fingerprint,
+		TotalEvents: total,
+		Errors:      make(map[int]string),
+		statuses:    statuses,
+		filePath:    filePath,
+	}
+}
+
+// LoadReplayProgress は進捗ファイルを読み込みます
+// ファイルが存在しない場合は nil, nil を返します
+func LoadReplayProgress(filePath string) (*ReplayProgress, error) {
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("進捗ファイルの読み込みに失敗しました: %w", err)
+	}
+
+	var progress ReplayProgress
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return nil, fmt.Errorf("進捗ファイルのデコードに失敗しました: %w", err)
+	}
+
+	statuses, err := decodeProgressStatuses(progress.Statuses, progress.TotalEvents)
+	if err != nil {
+		return nil, fmt.Errorf("進捗ファイルが壊れています: %w", err)
+	}
+	if len(statuses) != progress.TotalEvents {
+		return nil, fmt.Errorf("進捗ファイルが壊れています: エントリ数 %d と状態の数 %d が一致しません", progress.TotalEvents, len(statuses))
+	}
+
+	progress.statuses = statuses
+	progress.filePath = filePath
+	if progress.Errors == nil {
+		progress.Errors = make(map[int]string)
+	}
+
+	return &progress, nil
+}
+
+// Validate は進捗が指定された変更リストと宛先のものかを確認します
+func (p *ReplayProgress) Validate(destBucket, fingerprint string, total int) error {
+	if p.Fingerprint != fingerprint || p.TotalEvents != total {
+		return fmt.Errorf("進捗ファイルの変更リスト %s が指定された変更リストと一致しません", p.SourceFile)
+	}
+	if p.DestBucket != destBucket {
+		return fmt.Errorf("進捗ファイルの宛先バケット %s が指定されたバケット %s と一致しません", p.DestBucket, destBucket)
+	}
+	return nil
+}
+
+// IsPending はエントリを再開時に実行するか（未実行または失敗したか）を返します
+func (p *ReplayProgress) IsPending(index int) bool {
+	return p.statuses[index] != progressCompleted
+}
+
+// pendingEntries は再開時に実行するエントリ（未実行または失敗したもの）の一覧とその件数を返します
+// リプレイ中の記録と並行して参照できるよう、現在の状態を複製して返します
+func (p *ReplayProgress) pendingEntries() ([]bool, int) {
+	pending := make([]bool, len(p.statuses))
+	count := 0
+	for i, status := range p.statuses {
+		if status != progressCompleted {
+			pending[i] = true
+			count++
+		}
+	}
+	return pending, count
+}
+
+// Record はイベントの実行結果を記録します
+// 完了（宛先に反映済みでスキップしたイベントを含む）と失敗のみを記録し、ドライランや中断されたイベントは未実行のままにします
+func (p *ReplayProgress) Record(event ReplayEvent) {
+	switch event.Status {
+	case "SUCCESS", "SKIPPED":
+		p.statuses[event.Index] = progressCompleted
+		// 再開時に再実行して成功した場合は前回のエラーを消す
+		delete(p.Errors, event.Index)
+	case "FAILED":
+		p.statuses[event.Index] = progressFailed
+		p.Errors[event.Index] = event.ErrorMessage
+	}
+}
+
+// SaveIfDue は前回の保存から一定時間が経過していれば進捗ファイルを保存します
+func (p *ReplayProgress) SaveIfDue() error {
+	if time.Since(p.savedAt) < progressSaveInterval {
+		return nil
+	}
+	return p.Save()
+}
+
+// Save は進捗ファイルを保存します
+// 状態は連続する件数で圧縮するため、先頭から順に完了していく数百万件の変更リストでも進捗ファイルは小さくなります
+func (p *ReplayProgress) Save() error {
+	p.Statuses = encodeProgressStatuses(p.statuses)
+	p.Completed, p.Failed, p.Pending = 0, 0, 0
+	for _, status := range p.statuses {
+		switch status {
+		case progressCompleted:
+			p.Completed++
+		case progressFailed:
+			p.Failed++
+		default:
+			p.Pending++
+		}
+	}
+	p.UpdatedAt = time.Now().UTC()
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(p.filePath, data); err != nil {
+		return fmt.Errorf("進捗ファイルの保存に失敗しました: %w", err)
+	}
+
+	p.savedAt = time.Now()
+	return nil
+}
+
+// encodeProgressStatuses は各エントリの状態を、状態の文字と連続する件数の組に圧縮します（件数が1の場合は省略）
+func encodeProgressStatuses(statuses []byte) string {
+	var sb strings.Builder
+	for i := 0; i < len(statuses); {
+		run := 1
+		for i+run < len(statuses) && statuses[i+run] == statuses[i] {
+			run++
+		}
+		sb.WriteByte(statuses[i])
+		if run > 1 {
+			sb.WriteString(strconv.Itoa(run))
+		}
+		i += run
+	}
+	return sb.String()
+}
+
+// decodeProgressStatuses はencodeProgressStatusesで圧縮した状態を展開します
+// 件数を省略した状態は1件とみなすため、圧縮していない状態の文字列もそのまま読み込めます
+// 壊れたファイルで大量のメモリを確保しないよう、エントリ数 total を超える場合はエラーにします
+func decodeProgressStatuses(encoded string, total int) ([]byte, error) {
+	var statuses []byte
+	for i := 0; i < len(encoded); {
+		status := encoded[i]
+		if status != progressPending && status != progressCompleted && status != progressFailed {
+			return nil, fmt.Errorf("不明な状態 %q が含まれています", status)
+		}
+		i++
+
+		end := i
+		for end < len(encoded) && encoded[end] >= '0' && encoded[end] <= '9' {
+			end++
+		}
+		run := 1
+		if end > i {
+			n, err := strconv.Atoi(encoded[i:end])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("状態の件数 %q が無効です", encoded[i:end])
+			}
+			run = n
+		}
+		if run > total-len(statuses) {
+			return nil, fmt.Errorf("状態の数がエントリ数 %d を超えています", total)
+		}
+		statuses = append(statuses, bytes.Repeat([]byte{status}, run)...)
+		i = end
+	}
+	return statuses, nil
+}
+
+// writeFileAtomic はファイルを書き込みます
+// 書き込み途中で中断しても元のファイルが壊れないよう、一時ファイルに書き込んでから置き換えます
+func writeFileAtomic(filePath string, data []byte) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return err
+	}
+
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tempFile.Name(), filePath)
+}
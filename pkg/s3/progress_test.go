@@ -0,0 +1,157 @@
+package s3
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplayProgressRoundTrip(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "progress.json")
+
+	progress := newReplayProgress(filePath, "changes.json", "dest", "fp", 4)
+	progress.Record(ReplayEvent{Index: 0, Status: "SUCCESS"})
+	progress.Record(ReplayEvent{Index: 2, Status: "FAILED", ErrorMessage: "access denied"})
+	progress.Record(ReplayEvent{Index: 3, Status: "CANCELED"})
+	if err := progress.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded, err := LoadReplayProgress(filePath)
+	if err != nil {
+		t.Fatalf("LoadReplayProgress() error = %v", err)
+	}
+	if loaded.Statuses != "CPFP" {
+		t.Errorf("Statuses = %s, want CPFP", loaded.Statuses)
+	}
+	if loaded.Completed != 1 || loaded.Failed != 1 || loaded.Pending != 2 {
+		t.Errorf("件数 = %d/%d/%d, want 1/1/2", loaded.Completed, loaded.Failed, loaded.Pending)
+	}
+	if loaded.Errors[2] != "access denied" {
+		t.Errorf("Errors = %v", loaded.Errors)
+	}
+	// 失敗したイベントは再開時に再実行する
+	for i, want := range []bool{false, true, true, true} {
+		if got := loaded.IsPending(i); got != want {
+			t.Errorf("IsPending(%d) = %v, want %v", i, got, want)
+		}
+	}
+
+	if err := loaded.Validate("dest", "fp", 4); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if err := loaded.Validate("other", "fp", 4); err == nil {
+		t.Error("宛先バケットが異なる場合にエラーになりません")
+	}
+	if err := loaded.Validate("dest", "changed", 4); err == nil {
+		t.Error("変更リストが異なる場合にエラーになりません")
+	}
+}
+
+func TestReplayProgressStatusesEncoding(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "progress.json")
+
+	progress := newReplayProgress(filePath, "changes.json", "dest", "fp", 10000)
+	for i := 0; i < 9000; i++ {
+		progress.Record(ReplayEvent{Index: i, Status: "SUCCESS"})
+	}
+	progress.Record(ReplayEvent{Index: 42, Status: "FAILED", ErrorMessage: "access denied"})
+	if err := progress.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	// 状態は連続する件数で圧縮して保存する
+	if want := "C42FC8957P1000"; progress.Statuses != want {
+		t.Errorf("Statuses = %s, want %s", progress.Statuses, want)
+	}
+
+	loaded, err := LoadReplayProgress(filePath)
+	if err != nil {
+		t.Fatalf("LoadReplayProgress() error = %v", err)
+	}
+	if loaded.Completed != 8999 || loaded.Failed != 1 || loaded.Pending != 1000 {
+		t.Errorf("件数 = %d/%d/%d, want 8999/1/1000", loaded.Completed, loaded.Failed, loaded.Pending)
+	}
+	pending, count := loaded.pendingEntries()
+	if count != 1001 || !pending[42] || pending[43] || !pending[9000] {
+		t.Errorf("pendingEntries() = %d件, want 1001件（失敗した42番目を含む）", count)
+	}
+
+	// 再実行して成功した場合は失敗の記録を消す
+	loaded.Record(ReplayEvent{Index: 42, Status: "SUCCESS"})
+	if loaded.IsPending(42) || loaded.Errors[42] != "" {
+		t.Errorf("再実行後の42番目 = pending %v, error %q", loaded.IsPending(42), loaded.Errors[42])
+	}
+}
+
+func TestLoadReplayProgress(t *testing.T) {
+	dir := t.TempDir()
+
+	progress, err := LoadReplayProgress(filepath.Join(dir, "missing.json"))
+	if progress != nil || err != nil {
+		t.Errorf("存在しないファイル: LoadReplayProgress() = %v, %v, want nil, nil", progress, err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "状態の数が異なる", content: `{"totalEvents": 3, "statuses": "CP"}`},
+		{name: "不明な状態", content: `{"totalEvents": 2, "statuses": "CX"}`},
+		{name: "件数が0", content: `{"totalEvents": 2, "statuses": "C0P2"}`},
+		{name: "件数がエントリ数を超える", content: `{"totalEvents": 3, "statuses": "C999999999"}`},
+		{name: "JSONではない", content: `not json`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(dir, "progress.json")
+			if err := os.WriteFile(filePath, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadReplayProgress(filePath); err == nil {
+				t.Error("壊れた進捗ファイルでエラーになりません")
+			}
+		})
+	}
+}
+
+// TestScanChangeListFingerprint はリプレイの再開に使用する変更リストのチェックサムを確認します
+func TestScanChangeListFingerprint(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	base := time.Date(2025, 6, 5, 10, 0, 0, 0, time.UTC)
+	changes := []ObjectChange{
+		{Key: "a", VersionID: "a1", ChangeType: ChangeTypeCreate, Timestamp: base},
+		{Key: "b", VersionID: "b1", ChangeType: ChangeTypeCreate, Timestamp: base.Add(time.Minute)},
+	}
+
+	fingerprint := func(name string, changes []ObjectChange) string {
+		t.Helper()
+		filePath := filepath.Join(dir, name)
+		writeTestNDJSON(t, filePath, changes)
+		count, fingerprint, err := scanChangeList(ctx, ReplayOptions{SourceFile: filePath})
+		if err != nil {
+			t.Fatalf("scanChangeList() error = %v", err)
+		}
+		if count != len(changes) {
+			t.Errorf("scanChangeList() の件数 = %d, want %d", count, len(changes))
+		}
+		return fingerprint
+	}
+
+	first := fingerprint("first.ndjson", changes)
+	if second := fingerprint("second.ndjson", changes); first != second {
+		t.Errorf("同じ変更リストのチェックサムが異なります: %s != %s", first, second)
+	}
+	// 時間順に並べた内容のチェックサムのため、ファイル内の順序には依存しない
+	if reordered := fingerprint("reordered.ndjson", []ObjectChange{changes[1], changes[0]}); reordered != first {
+		t.Errorf("順序の異なる変更リストのチェックサムが異なります: %s != %s", reordered, first)
+	}
+
+	changes[1].VersionID = "b2"
+	if changed := fingerprint("changed.ndjson", changes); changed == first {
+		t.Error("変更リストを変更してもチェックサムが変わりません")
+	}
+}
@@ -0,0 +1,309 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPrefixDepth はプレフィックスごとのリクエスト数の制限で、プレフィックスとみなすキーの階層数
+const DefaultPrefixDepth = 1
+
+// RateLimitOptions はリクエスト数と転送量の制限
+// 0の項目は制限しません
+type RateLimitOptions struct {
+	RequestsPerSecond       float64 // 全体の1秒あたりのリクエスト数
+	PrefixRequestsPerSecond float64 // プレフィックスごとの1秒あたりのリクエスト数
+	PrefixDepth             int     // プレフィックスとみなすキーの階層数（0の場合はDefaultPrefixDepth）
+	BytesPerSecond          float64 // 1秒あたりのコピーするバイト数
+}
+
+// Enabled はいずれかの制限が指定されているかどうかを返します
+func (o RateLimitOptions) Enabled() bool {
+	return o.RequestsPerSecond > 0 || o.PrefixRequestsPerSecond > 0 || o.BytesPerSecond > 0
+}
+
+// RateLimiter はトークンバケットでリクエスト数と転送量を制限します
+// nilの場合は制限しません
+type RateLimiter struct {
+	requests    *tokenBucket
+	bytes       *tokenBucket
+	prefixRate  float64
+	prefixDepth int
+
+	mu       sync.Mutex
+	prefixes map[string]*tokenBucket
+}
+
+// NewRateLimiter は制限からRateLimiterを作成します
+// 制限が指定されていない場合はnilを返します
+func NewRateLimiter(opts RateLimitOptions) *RateLimiter {
+	if !opts.Enabled() {
+		return nil
+	}
+	if opts.PrefixDepth <= 0 {
+		opts.PrefixDepth = DefaultPrefixDepth
+	}
+
+	return &RateLimiter{
+		requests:    newTokenBucket(opts.RequestsPerSecond),
+		bytes:       newTokenBucket(opts.BytesPerSecond),
+		prefixRate:  opts.PrefixRequestsPerSecond,
+		prefixDepth: opts.PrefixDepth,
+		prefixes:    make(map[string]*tokenBucket),
+	}
+}
+
+// WaitRequest はキーへのリクエストを送信できるようになるまで待機します
+// 全体とキーのプレフィックスの両方の制限を受けます
+func (l *RateLimiter) WaitRequest(ctx context.Context, key string) error {
+	if l == nil {
+		return nil
+	}
+	if err := l.requests.wait(ctx, 1); err != nil {
+		return err
+	}
+	if err := l.prefixBucket(key).wait(ctx, 1); err != nil {
+		// リクエストを送信しないため、取得した全体のトークンも戻す
+		l.requests.refund(1)
+		return err
+	}
+	return nil
+}
+
+// WaitBytes は指定したバイト数をコピーできるようになるまで待機します
+// 1秒あたりの上限より大きいオブジェクトもコピーでき、その分だけ後続のコピーが待機します
+func (l *RateLimiter) WaitBytes(ctx context.Context, n int64) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	return l.bytes.wait(ctx, float64(n))
+}
+
+// prefixBucket はキーのプレフィックスのトークンバケットを返します
+func (l *RateLimiter) prefixBucket(key string) *tokenBucket {
+	if l.prefixRate <= 0 {
+		return nil
+	}
+
+	prefix := keyPrefix(key, l.prefixDepth)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.prefixes[prefix]
+	if !ok {
+		bucket = newTokenBucket(l.prefixRate)
+		l.prefixes[prefix] = bucket
+	}
+	return bucket
+}
+
+// keyPrefix はキーの先頭から depth 階層分のプレフィックスを返します（末尾の / を含む）
+// 階層が depth より浅いキーは、最後の / までをプレフィックスとします
+func keyPrefix(key string, depth int) string {
+	end := 0
+	for i := 0; i < depth; i++ {
+		next := strings.Index(key[end:], "/")
+		if next < 0 {
+			break
+		}
+		end += next + 1
+	}
+	return key[:end]
+}
+
+// tokenBucket は1秒あたり rate 個のトークンを補充するトークンバケット
+// バケットの容量は1秒分（最小1）で、容量を超える要求は不足分を前借りして待機します
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket はトークンバケットを作成します。rateが0以下の場合はnil（無制限）を返します
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := math.Max(rate, 1)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait は n 個のトークンを取得し、不足している場合は補充されるまで待機します
+// 待機中にコンテキストが終了した場合は取得したトークンを戻します
+func (b *tokenBucket) wait(ctx context.Context, n float64) error {
+	if b == nil {
+		return nil
+	}
+
+	delay := b.reserve(n)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.refund(n)
+		return ctx.Err()
+	}
+}
+
+// refund は取得した n 個のトークンを戻します（バケットの容量を超えては戻しません）
+func (b *tokenBucket) refund(n float64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+n)
+}
+
+// reserve は n 個のトークンを取得し、トークンが補充されるまでの待機時間を返します
+// 先に予約した要求の不足分も含めて待機するため、要求は到着した順に処理されます
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// rateLimitedBackend はリクエストごとにRateLimiterで待機するストレージ
+type rateLimitedBackend struct {
+	Backend
+	limiter *RateLimiter
+}
+
+// withRateLimit はストレージへのリクエストを制限します
+func withRateLimit(backend Backend, limiter *RateLimiter) Backend {
+	if limiter == nil {
+		return backend
+	}
+	return &rateLimitedBackend{Backend: backend, limiter: limiter}
+}
+
+func (b *rateLimitedBackend) ListKeys(ctx context.Context, bucket, prefix string, concurrency int) ([]string, error) {
+	if pager := b.keyPager(bucket, false); pager != nil {
+		return listAllKeys(ctx, pager, prefix, concurrency)
+	}
+	if err := b.limiter.WaitRequest(ctx, prefix); err != nil {
+		return nil, err
+	}
+	return b.Backend.ListKeys(ctx, bucket, prefix, concurrency)
+}
+
+func (b *rateLimitedBackend) ListVersionedKeys(ctx context.Context, bucket, prefix string, concurrency int) ([]string, error) {
+	if pager := b.keyPager(bucket, true); pager != nil {
+		return listAllKeys(ctx, pager, prefix, concurrency)
+	}
+	if err := b.limiter.WaitRequest(ctx, prefix); err != nil {
+		return nil, err
+	}
+	return b.Backend.ListVersionedKeys(ctx, bucket, prefix, concurrency)
+}
+
+// keyPager はキー一覧の1ページの取得ごとに制限を受けるkeyPagerを返します
+// ページ単位で取得できないストレージでは、一覧全体を1回のリクエストとして制限します
+func (b *rateLimitedBackend) keyPager(bucket string, versioned bool) keyPager {
+	pager := backendKeyPager(b.Backend, bucket, versioned)
+	if pager == nil {
+		return nil
+	}
+	return keyPagerFunc(func(ctx context.Context, input keyPageInput) (keyPage, error) {
+		if err := b.limiter.WaitRequest(ctx, input.Prefix); err != nil {
+			return keyPage{}, err
+		}
+		return pager.listKeyPage(ctx, input)
+	})
+}
+
+func (b *rateLimitedBackend) ListVersions(ctx context.Context, bucket, key string) (KeyVersions, error) {
+	if err := b.limiter.WaitRequest(ctx, key); err != nil {
+		return KeyVersions{}, err
+	}
+	return b.Backend.ListVersions(ctx, bucket, key)
+}
+
+func (b *rateLimitedBackend) GetObject(ctx context.Context, bucket, key, versionID string) (*ObjectContent, error) {
+	if err := b.limiter.WaitRequest(ctx, key); err != nil {
+		return nil, err
+	}
+	return b.Backend.GetObject(ctx, bucket, key, versionID)
+}
+
+func (b *rateLimitedBackend) HeadObject(ctx context.Context, bucket, key string) (*ObjectInfo, error) {
+	if err := b.limiter.WaitRequest(ctx, key); err != nil {
+		return nil, err
+	}
+	return b.Backend.HeadObject(ctx, bucket, key)
+}
+
+func (b *rateLimitedBackend) PutObject(ctx context.Context, bucket, key string, body io.Reader, metadata ObjectMetadata) error {
+	if err := b.limiter.WaitRequest(ctx, key); err != nil {
+		return err
+	}
+	return b.Backend.PutObject(ctx, bucket, key, body, metadata)
+}
+
+func (b *rateLimitedBackend) CopyObject(ctx context.Context, srcBucket, srcKey, versionID, destBucket, destKey string) error {
+	if err := b.limiter.WaitRequest(ctx, destKey); err != nil {
+		return err
+	}
+	return b.Backend.CopyObject(ctx, srcBucket, srcKey, versionID, destBucket, destKey)
+}
+
+func (b *rateLimitedBackend) DeleteObject(ctx context.Context, bucket, key string) error {
+	if err := b.limiter.WaitRequest(ctx, key); err != nil {
+		return err
+	}
+	return b.Backend.DeleteObject(ctx, bucket, key)
+}
+
+// byteUnits はバイト数の単位と倍率
+var byteUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// ParseByteSize は "50MiB" や "1.5GB" のような単位付きのバイト数を解析します
+// 単位を省略した場合はバイトとみなします
+func ParseByteSize(s string) (float64, error) {
+	value := strings.TrimSpace(s)
+	multiplier := 1.0
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(strings.ToUpper(value), strings.ToUpper(unit.suffix)) {
+			value = strings.TrimSpace(value[:len(value)-len(unit.suffix)])
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("バイト数 %q が無効です（例: 1048576、50MiB、1.5GB）", s)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,210 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyPrefix(t *testing.T) {
+	tests := []struct {
+		key   string
+		depth int
+		want  string
+	}{
+		{key: "logs/2025/04/a.json", depth: 1, want: "logs/"},
+		{key: "logs/2025/04/a.json", depth: 2, want: "logs/2025/"},
+		{key: "logs/a.json", depth: 3, want: "logs/"},
+		{key: "a.json", depth: 1, want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := keyPrefix(tt.key, tt.depth); got != tt.want {
+			t.Errorf("keyPrefix(%q, %d) = %q, want %q", tt.key, tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    float64
+		wantErr bool
+	}{
+		{input: "1024", want: 1024},
+		{input: "50MiB", want: 50 << 20},
+		{input: "1.5GB", want: 1.5e9},
+		{input: "10 kb", want: 10e3},
+		{input: "2M", want: 2 << 20},
+		{input: "fast", wantErr: true},
+		{input: "-1MiB", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseByteSize(tt.input)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("ParseByteSize(%q) = %v, %v, want %v (エラー: %v)", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(100)
+
+	// 1秒分の容量までは待機しない
+	if delay := bucket.reserve(100); delay != 0 {
+		t.Errorf("reserve(100) = %s, want 0", delay)
+	}
+	// 不足分が補充されるまで待機する
+	if delay := bucket.reserve(50); delay < 450*time.Millisecond || delay > 500*time.Millisecond {
+		t.Errorf("reserve(50) = %s, want 約500ms", delay)
+	}
+	// 後から予約した要求は先の要求の不足分も待機する
+	if delay := bucket.reserve(50); delay < 950*time.Millisecond {
+		t.Errorf("reserve(50) = %s, want 約1s", delay)
+	}
+
+	if newTokenBucket(0) != nil {
+		t.Error("制限なしのトークンバケットが作成されます")
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	if NewRateLimiter(RateLimitOptions{}) != nil {
+		t.Fatal("制限なしでRateLimiterが作成されます")
+	}
+	var nilLimiter *RateLimiter
+	if err := nilLimiter.WaitRequest(context.Background(), "a"); err != nil {
+		t.Errorf("nilのRateLimiterで待機します: %v", err)
+	}
+
+	limiter := NewRateLimiter(RateLimitOptions{BytesPerSecond: 1024})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// 容量を大きく超えるコピーは待機し、中断されたら打ち切る
+	start := time.Now()
+	if err := limiter.WaitBytes(ctx, 1<<20); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitBytes() error = %v, want DeadlineExceeded", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("中断後も待機しています: %s", time.Since(start))
+	}
+}
+
+func TestRateLimiterPrefix(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitOptions{PrefixRequestsPerSecond: 1})
+	ctx := context.Background()
+
+	// 別のプレフィックスはそれぞれの制限を受ける
+	for _, key := range []string{"a/1", "b/1"} {
+		if err := limiter.WaitRequest(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if delay := limiter.prefixBucket("a/2").reserve(1); delay < 900*time.Millisecond {
+		t.Errorf("同じプレフィックスの2件目の待機時間 = %s, want 約1s", delay)
+	}
+	if delay := limiter.prefixBucket("c/1").reserve(1); delay != 0 {
+		t.Errorf("新しいプレフィックスの待機時間 = %s, want 0", delay)
+	}
+}
+
+// bucketTokens はトークンバケットの現在のトークン数を返します
+func bucketTokens(b *tokenBucket) float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens
+}
+
+func TestTokenBucketRefund(t *testing.T) {
+	bucket := newTokenBucket(10)
+	bucket.reserve(3)
+	// 容量を超えては戻さない
+	bucket.refund(5)
+	if tokens := bucketTokens(bucket); tokens != 10 {
+		t.Errorf("戻した後のトークン数 = %v, want 10", tokens)
+	}
+}
+
+// TestRateLimiterPrefixCancel はプレフィックスの待機が中断された場合に全体のトークンも戻すことを確認します
+func TestRateLimiterPrefixCancel(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitOptions{RequestsPerSecond: 10, PrefixRequestsPerSecond: 1})
+	if err := limiter.WaitRequest(context.Background(), "a/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := limiter.WaitRequest(ctx, "a/2"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitRequest() error = %v, want DeadlineExceeded", err)
+	}
+	if tokens := bucketTokens(limiter.requests); tokens < 8.9 {
+		t.Errorf("全体のトークン数 = %v, want 9", tokens)
+	}
+}
+
+// TestRateLimitListKeysPage はキー一覧の1ページごとにリクエスト数の制限を受けることを確認します
+func TestRateLimitListKeysPage(t *testing.T) {
+	var keys []string
+	for i := 0; i < 2500; i++ {
+		keys = append(keys, fmt.Sprintf("logs/%05d", i))
+	}
+	limiter := NewRateLimiter(RateLimitOptions{RequestsPerSecond: 100})
+	backend := withRateLimit(&pagedListBackend{client: newFakeListClient(keys)}, limiter)
+
+	got, err := backend.ListKeys(context.Background(), "bucket", "logs/", 1)
+	if err != nil {
+		t.Fatalf("ListKeys() error = %v", err)
+	}
+	if len(got) != len(keys) {
+		t.Errorf("キーの数 = %d, want %d", len(got), len(keys))
+	}
+	// 1000件ずつの3ページで3回分のトークンを使用する
+	if tokens := bucketTokens(limiter.requests); tokens < 96 || tokens > 98 {
+		t.Errorf("全体のトークン数 = %v, want 約97", tokens)
+	}
+}
+
+// TestReplayRateLimit はリクエスト数の制限でリプレイが遅れることを確認します
+func TestReplayRateLimit(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	var changes []ObjectChange
+	for i := 0; i < 13; i++ {
+		changes = append(changes, ObjectChange{Key: fmt.Sprintf("k%d", i), ChangeType: ChangeTypeCreate, Timestamp: base})
+	}
+	changesFile := filepath.Join(dir, "changes.json")
+	data, _ := json.Marshal(changes)
+	if err := os.WriteFile(changesFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sink, _ := NewEventSink(context.Background(), "ndjson:"+filepath.Join(dir, "events.ndjson"), ClientOptions{})
+	defer sink.Close()
+
+	start := time.Now()
+	result, err := Replay(context.Background(), ReplayOptions{
+		DestBucket:        "staging",
+		SourceFile:        changesFile,
+		Concurrency:       4,
+		IgnoreTimeWindows: true,
+		EventSink:         sink,
+		RateLimit:         RateLimitOptions{RequestsPerSecond: 10},
+	})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if result.SuccessEvents != len(changes) {
+		t.Fatalf("成功したイベント数 = %d, want %d", result.SuccessEvents, len(changes))
+	}
+	// 最初の10件は容量の範囲内、残りの3件は0.1秒ずつ待機する
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("所要時間 = %s, want 0.3秒程度", elapsed)
+	}
+}
@@ -3,76 +3,195 @@ package s3
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	"os"
-	"sort"
+	"strings"
 	"sync"
 	"time"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 // ReplayOptions はリプレイのオプション
 type ReplayOptions struct {
-	SourceBucket      string    // 変更元のバケット
-	DestBucket        string    // 変更先のバケット
-	SourceFile        string    // 変更リストのファイルパス
-	Concurrency       int       // 並列処理数
-	SpeedFactor       float64   // 再生速度の倍率 (1.0 = 実時間、2.0 = 2倍速)
-	DryRun            bool      // 実際に変更を適用せずに実行
-	StartTime         time.Time // 開始時間（指定しない場合は現在時刻）
-	IgnoreTimeWindows bool      // 時間間隔を無視して即時実行
+	SourceBucket      string              // 変更元のバケット（空の場合は変更リストのヘッダー、なければ宛先バケット）
+	DestBucket        string              // 変更先のバケット
+	SourceFile        string              // 変更リストのファイルパス（s3://bucket/key 形式も可）
+	Compression       CompressionType     // 変更リストの圧縮形式（空の場合は自動判定）
+	Concurrency       int                 // 並列処理数
+	SpeedFactor       float64             // 再生速度の倍率 (1.0 = 実時間、2.0 = 2倍速)
+	DryRun            bool                // 実際に変更を適用せずに実行
+	StartTime         time.Time           // 開始時間（指定しない場合は現在時刻）
+	IgnoreTimeWindows bool                // 時間間隔を無視して即時実行
+	DrainTimeout      time.Duration       // 中断時に実行中のイベントの完了を待つ時間（0の場合はDefaultDrainTimeout）
+	ProgressFile      string              // 進捗ファイルのパス（指定した場合は各イベントの完了・失敗を記録）
+	Resume            bool                // 進捗ファイルの未実行のイベントから再開
+	KeyRewriter       *KeyRewriter        // 宛先のキーの書き換えルール（nilの場合は変更元と同じキー）
+	Source            ClientOptions       // 変更元の接続設定（s3:// の変更リストの読み込みにも使用）
+	Dest              ClientOptions       // 宛先の接続設定
+	SourceBackend     Backend             // 変更元のストレージ（nilの場合はSourceの設定のS3）
+	DestBackend       Backend             // 宛先のストレージ（nilの場合はDestの設定のS3）
+	EventSink         EventSink           // イベント通知の送信先（指定した場合はオブジェクトを変更せずにイベント通知のみを送信）
+	EventRegion       string              // イベント通知のリージョン（空の場合はDefaultEventRegion）
+	Retry             RetryPolicy         // 一時的なエラーで失敗したイベントの再実行の方針
+	RateLimit         RateLimitOptions    // リクエスト数と転送量の制限
+	Lookahead         int                 // 時刻の順に並べ替えるために先読みする変更の件数（0の場合はDefaultLookahead）
+	EventsFile        string              // 各イベントの結果を書き込むファイル（指定した場合は結果をメモリに保持しない）
+	Filter            *ChangeFilter       // リプレイする変更の絞り込みの条件（nilの場合は全ての変更）
+	MaxGap            time.Duration       // 再生時の変更の間隔の上限（0の場合は無制限、長い空白を縮める）
+	SpeedProfile      []SpeedProfileStep  // 最初の変更からの元の時間ごとの再生速度（指定した区間ではSpeedFactorの代わりに使用）
+	Jitter            time.Duration       // 各イベントの予定の時刻を前後にばらつかせる最大の時間
+	Destinations      []ReplayDestination // 複数の宛先（指定した場合はDestBucket、KeyRewriter、Dest、DestBackend、EventSink、EventRegionの代わりに使用）
+	SkipApplied       bool                // 宛先に既に反映されている変更をスキップ（イベント通知のみの宛先には適用しない）
+	Verify            bool                // リプレイの完了後に宛先の各キーの状態が変更リストの最後の変更と一致するかを検証
+	Transformer       ObjectTransformer   // コピーするオブジェクトの内容を宛先に書き込む前に変換（指定した場合は常にストリーミングコピー）
+	Amplify           AmplifyOptions      // 負荷試験のために各変更を複製し、変更リストを繰り返す
+	SkipPrevalidation bool                // エンベロープ形式の変更リストのサマリーの件数とチェックサムを実行前に検証しない（最後の変更を読み込んだ後にのみ検証）
+}
+
+// changeListOptions は変更リストを読み込むオプションを返します
+// s3:// の変更リストは変更元のバケットと同じアカウントに置くことが多いため、変更元の接続設定で読み込みます
+func (opts ReplayOptions) changeListOptions() ChangesFileOptions {
+	return ChangesFileOptions{Compression: opts.Compression, Client: opts.Source}
 }
 
 // ReplayEvent はリプレイ中のイベントを表す構造体
 type ReplayEvent struct {
-	Change       ObjectChange
-	ScheduledAt  time.Time
-	ExecutedAt   time.Time
-	Status       string
-	ErrorMessage string
+	Index        int            `json:"index"` // 変更リストを時間順に並べたときの位置
+	Change       ObjectChange   `json:"change"`
+	DestKey      string         `json:"destKey"` // 書き換え後の宛先のキー
+	ScheduledAt  time.Time      `json:"scheduledAt"`
+	ExecutedAt   time.Time      `json:"executedAt"`
+	Status       string         `json:"status"`
+	ErrorMessage string         `json:"errorMessage,omitempty"`
+	Attempts     int            `json:"attempts,omitempty"`    // 実行した回数（再実行した場合は2以上）
+	Lag          time.Duration  `json:"lag"`                   // 予定の時刻から実行を開始するまでの遅延
+	Duration     time.Duration  `json:"duration"`              // 実行を開始してから完了するまでの時間（再実行の待機を含む）
+	Handler      *HandlerResult `json:"handler,omitempty"`     // ハンドラーを呼び出した場合の実行結果
+	Destination  string         `json:"destination,omitempty"` // 複数の宛先にリプレイした場合の宛先の名前
+	SkipReason   string         `json:"skipReason,omitempty"`  // 宛先に反映済みのためスキップした理由
+	Copy         int            `json:"copy,omitempty"`        // 増幅した場合の複製の番号
+	Loop         int            `json:"loop,omitempty"`        // 増幅した場合の変更リストを繰り返した回数
 }
 
 // ReplayResult はリプレイの結果を表す構造体
 type ReplayResult struct {
-	TotalEvents     int
-	SuccessEvents   int
-	FailedEvents    int
-	SkippedEvents   int
-	StartTime       time.Time
-	EndTime         time.Time
-	Events          []ReplayEvent
-	DetailedResults bool
+	TotalEvents      int                 `json:"totalEvents"`
+	SuccessEvents    int                 `json:"successEvents"`
+	FailedEvents     int                 `json:"failedEvents"`
+	SkippedEvents    int                 `json:"skippedEvents"`
+	CanceledEvents   int                 `json:"canceledEvents"` // 中断により実行されなかったイベント数
+	Interrupted      bool                `json:"interrupted"`    // 中断されたかどうか
+	StartTime        time.Time           `json:"startTime"`
+	EndTime          time.Time           `json:"endTime"`
+	AverageLag       time.Duration       `json:"averageLag"`                 // 実行したイベントの予定の時刻からの平均の遅延
+	MaxLag           time.Duration       `json:"maxLag"`                     // 実行したイベントの予定の時刻からの最大の遅延
+	OutOfOrderEvents int                 `json:"outOfOrderEvents,omitempty"` // 先読みの範囲を超えて時刻の順序が逆転していた変更の数
+	FilteredEvents   int                 `json:"filteredEvents,omitempty"`   // 絞り込みの条件に一致せずにリプレイしなかった変更の数
+	Destinations     []DestinationResult `json:"destinations,omitempty"`     // 複数の宛先にリプレイした場合の宛先ごとの結果
+	Verification     *VerifyResult       `json:"verification,omitempty"`     // リプレイ後に宛先の状態を検証した結果
+	Loops            int                 `json:"loops,omitempty"`            // 増幅した場合に変更リストを読み込んだ回数
+	Events           []ReplayEvent       `json:"events"`
+	EventsFile       string              `json:"eventsFile,omitempty"` // 各イベントの結果を書き込んだファイル
+	DetailedResults  bool                `json:"-"`
 }
 
 // Replay は変更リストを元にS3イベントを再現します
-func Replay(opts ReplayOptions) (*ReplayResult, error) {
-	// AWS設定の読み込み
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+func Replay(ctx context.Context, opts ReplayOptions) (*ReplayResult, error) {
+
+	// 変更リストを先頭から順に読み込む（全体をメモリに読み込まない）
+	reader, err := openChangeListReader(ctx, opts.SourceFile, opts.changeListOptions())
 	if err != nil {
-		slog.Error("AWS設定の読み込みに失敗しました", "error", err)
-		return nil, fmt.Errorf("AWS設定の読み込みに失敗しました: %w", err)
+		return nil, err
+	}
+	defer reader.Close()
+	header := reader.Header
+
+	slog.Info("変更リストのヘッダーを読み込みました",
+		"schemaVersion", header.SchemaVersion,
+		"sourceBucket", header.SourceBucket,
+		"sourcePrefix", header.SourcePrefix,
+		"windowStart", header.WindowStart,
+		"windowEnd", header.WindowEnd,
+		"toolVersion", header.ToolVersion)
+
+	// ソースバケットが指定されていない場合はヘッダーの変更元バケット、それもなければ（最初の）宛先バケットを使用
+	if opts.SourceBucket == "" {
+		opts.SourceBucket = header.SourceBucket
 	}
+	if opts.SourceBucket == "" {
+		opts.SourceBucket = opts.DestBucket
+	}
+	if opts.SourceBucket == "" && len(opts.Destinations) > 0 {
+		opts.SourceBucket = opts.Destinations[0].Bucket
+	}
+	slog.Info("変更元のバケットを決定しました", "sourceBucket", opts.SourceBucket)
 
-	// S3クライアントの作成
-	client := s3.NewFromConfig(cfg)
+	if err := opts.Amplify.withDefaults().validate(opts); err != nil {
+		return nil, err
+	}
 
-	// 変更リストの読み込み
-	changes, err := loadChangesFromFile(opts.SourceFile)
+	// 各宛先への変更の反映方法を準備（イベントのみのモードではオブジェクトを変更せずにイベント通知を送信）
+	targets, err := newReplayTargets(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
+	if len(targets) > 1 {
+		slog.Info("複数の宛先にリプレイします", "destinations", destinationsLabel(targets))
+	}
+	limiter := NewRateLimiter(opts.RateLimit)
+	if limiter != nil {
+		slog.Info("リクエスト数と転送量を制限します",
+			"requestsPerSecond", opts.RateLimit.RequestsPerSecond,
+			"prefixRequestsPerSecond", opts.RateLimit.PrefixRequestsPerSecond,
+			"bytesPerSecond", opts.RateLimit.BytesPerSecond)
+	}
 
-	// 変更リストを時間順にソート
-	sort.Slice(changes, func(i, j int) bool {
-		return changes[i].Timestamp.Before(changes[j].Timestamp)
-	})
+	// 先読みのデフォルト値を設定
+	lookahead := opts.Lookahead
+	if lookahead <= 0 {
+		lookahead = DefaultLookahead
+	}
+
+	// エンベロープ形式の変更リストは、途中で切れたり書き換えられたりした変更リストの一部を反映しないように、
+	// 変更を実行する前に全体を読み込んでサマリーの件数とチェックサムを検証する
+	// 変更リストを2回読み込む（s3:// の場合は2回ダウンロードする）ため、SkipPrevalidation で省略できる
+	// 進捗ファイルを使用する場合は、進捗ファイルと照合する件数とチェックサムの計算で同時に検証する
+	prevalidate := reader.format == changeListFormatEnvelope && !opts.SkipPrevalidation
+	var count int
+	var fingerprint string
+	if opts.ProgressFile != "" || prevalidate {
+		count, fingerprint, err = scanChangeList(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("変更リストを読み込みました", "count", count)
+	}
+
+	// 進捗ファイルを準備し、再開する場合は未実行のイベントのみを対象にする
+	// 進捗ファイルと照合するため、リプレイの前に変更リスト全体の件数とチェックサムを計算する
+	var progress *ReplayProgress
+	var pending []bool
+	total := -1
+	if opts.ProgressFile != "" {
+		progress, err = prepareReplayProgress(opts, destinationsLabel(targets), fingerprint, count)
+		if err != nil {
+			return nil, err
+		}
+		pending, total = progress.pendingEntries()
+		total *= len(targets)
+	}
 
-	slog.Info("変更リストを読み込みました", "count", len(changes))
+	// 各イベントの結果をファイルに書き込む場合は、結果をメモリに保持しない
+	var eventsWriter *replayEventsWriter
+	if opts.EventsFile != "" {
+		// 中断された場合も未実行のイベントまで書き込むため、アップロードは中断しない
+		eventsWriter, err = newReplayEventsWriter(context.WithoutCancel(ctx), opts.EventsFile)
+		if err != nil {
+			return nil, err
+		}
+		defer eventsWriter.Close()
+	}
 
 	// 並列処理数のデフォルト値を設定
 	concurrency := opts.Concurrency
@@ -86,6 +205,16 @@ func Replay(opts ReplayOptions) (*ReplayResult, error) {
 		speedFactor = 1.0
 	}
 
+	// 中断時の待機時間のデフォルト値を設定
+	drainTimeout := opts.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = DefaultDrainTimeout
+	}
+
+	// 中断後も実行中のイベントは猶予時間内であれば完了させる
+	execCtx, cancelExec := drainContext(ctx, drainTimeout)
+	defer cancelExec()
+
 	// 開始時間のデフォルト値を設定
 	startTime := opts.StartTime
 	if startTime.IsZero() {
@@ -94,31 +223,138 @@ func Replay(opts ReplayOptions) (*ReplayResult, error) {
 
 	// 結果の初期化
 	result := &ReplayResult{
-		TotalEvents:     len(changes),
 		SuccessEvents:   0,
 		FailedEvents:    0,
 		SkippedEvents:   0,
 		StartTime:       startTime,
-		DetailedResults: true,
+		EventsFile:      opts.EventsFile,
+		DetailedResults: eventsWriter == nil,
 	}
 
-	// 同一キーへの操作を直列化するためのマップ
-	keyMutexes := sync.Map{}
-
-	// イベントチャネル
-	eventCh := make(chan ObjectChange, concurrency)
+	// 複数の宛先にリプレイする場合は宛先ごとの結果を集計する
+	destResults := make(map[string]*DestinationResult)
+	if len(targets) > 1 {
+		result.Destinations = make([]DestinationResult, len(targets))
+		for i, target := range targets {
+			result.Destinations[i] = DestinationResult{Name: target.Name, Bucket: target.Bucket}
+			destResults[target.Name] = &result.Destinations[i]
+		}
+	}
+	outcomes := newEntryOutcomes(len(targets))
 
 	// 完了チャネル
-	doneCh := make(chan ReplayEvent, len(changes))
+	doneCh := make(chan ReplayEvent, concurrency)
+
+	// 変更リストを絞り込み、時間順に並べ替えながら読み込み、各イベントの実行時間を計算してスケジューラーに渡す
+	// 進捗ファイルで位置を使うため、同時刻の変更は元の順序を保つ
+	// 増幅する場合は各変更を複製の数だけ渡し、変更リストを繰り返す
+	ordered := newTimeOrderedChanges(reader, lookahead, opts.Filter)
+	amplify := opts.Amplify.withDefaults()
+	copies, loops := amplify.copies(), amplify.loops()
+	feedCh := make(chan *scheduledEvent)
+	feedDone := make(chan struct{})
+	var fed int // 読み込んだ変更の件数（繰り返した場合は各回の件数の合計）
+	var loopsStarted int
+	var outOfOrder, filtered int // 先読みの範囲を超えた逆転と絞り込んだ変更の件数（繰り返した場合は各回の合計）
+	var feedErr error
+	go func() {
+		defer close(feedDone)
+		defer close(feedCh)
+
+		// feedLoop は変更リストを1回読み込み、各変更を全ての宛先に複製の数だけ渡します
+		// 繰り返した場合も同一キーのイベントを区別できるように、位置は前の回の件数だけずらします
+		// 読み込んだ変更の件数と最後の変更の予定の時刻を返し、中断または読み込みに失敗した場合は ok が false になります
+		feedLoop := func(source *timeOrderedChanges, loop, offset int, clock *replayClock) (count int, last time.Time, ok bool) {
+			for {
+				entry, err := source.Next()
+				if errors.Is(err, io.EOF) {
+					return count, last, true
+				}
+				if err != nil {
+					feedErr = err
+					return count, last, false
+				}
+				if pending != nil && (entry.Index >= len(pending) || !pending[entry.Index]) {
+					continue
+				}
+				count++
+
+				// 時間間隔を無視する場合はゼロ値（即時）
+				var scheduledAt time.Time
+				if !opts.IgnoreTimeWindows {
+					// 直前のイベントからの間隔に再生速度と間隔の上限を適用し、開始時間からの時間を計算
+					scheduledAt = clock.Schedule(entry.Change.Timestamp)
+					last = scheduledAt
+				}
+				entry.Index += offset
+
+				// 全ての宛先に同じ予定の時刻でイベントを渡す（複製は複製ごとに予定の時刻をずらす）
+				for c := 0; c < copies; c++ {
+					for i, target := range targets {
+						// 宛先のキーを決定（変更元のキーとバージョンはそのまま読み込む）
+						// 書き換えにより複数のキーが同じキーになる場合も、同じ宛先のキーへの操作として直列化される
+						destKey, rewriteErr := target.KeyRewriter.Rewrite(entry.Change)
+						if rewriteErr != nil {
+							destKey = entry.Change.Key
+						}
+						destKey = amplify.variantKey(destKey, c, loop)
+
+						event := &scheduledEvent{entry: entry, destination: i, copy: c, loop: loop, destKey: destKey, rewriteErr: rewriteErr, scheduledAt: amplify.shift(scheduledAt, c)}
+						select {
+						case feedCh <- event:
+							// 途中の宛先や複製で中断した場合も、残りのイベントを未実行として数える
+							if i == 0 && c == 0 {
+								fed++
+							}
+						case <-ctx.Done():
+							return count, last, false
+						}
+					}
+				}
+			}
+		}
 
-	// エラーチャネル
-	errCh := make(chan error, 1)
+		// 最初のイベントの時間を基準に予定の時刻を計算する（再開した場合は最初の未実行のイベントを基準にし、残りのイベントの間隔を保つ）
+		// 繰り返す場合は、前の回の最後のイベントの予定の時刻から次の回を始める
+		loopStart := startTime
+		offset := 0
+		for loop := 0; amplify.Forever || loop < loops; loop++ {
+			source := ordered
+			var loopReader *changeListReader
+			if loop > 0 {
+				var err error
+				loopReader, err = openChangeListReader(ctx, opts.SourceFile, opts.changeListOptions())
+				if err != nil {
+					feedErr = err
+					return
+				}
+				source = newTimeOrderedChanges(loopReader, lookahead, opts.Filter)
+				slog.Info("変更リストを繰り返します", "loop", loop)
+			}
+			loopsStarted++
 
-	// 最初のイベントの時間
-	var firstEventTime time.Time
-	if len(changes) > 0 {
-		firstEventTime = changes[0].Timestamp
-	}
+			count, last, ok := feedLoop(source, loop, offset, newReplayClock(loopStart, speedFactor, opts))
+			outOfOrder += source.outOfOrder
+			filtered += source.filtered
+			if loopReader != nil {
+				loopReader.Close()
+			}
+			if !ok || count == 0 {
+				return
+			}
+			offset += count
+			if !last.IsZero() {
+				loopStart = last
+			}
+		}
+	}()
+
+	// スケジューラーは予定の時刻になったイベントをワーカーに渡し、同一キーのイベントは前のイベントの完了後に渡す
+	// 保持するイベントは先読みの件数までとし、メモリの使用量を一定に保つ
+	workCh := make(chan *scheduledEvent)
+	completeCh := make(chan string, concurrency)
+	scheduler := newReplayScheduler()
+	go scheduler.run(ctx, feedCh, lookahead, workCh, completeCh)
 
 	// ワーカーゴルーチンを起動
 	var wg sync.WaitGroup
@@ -127,157 +363,383 @@ func Replay(opts ReplayOptions) (*ReplayResult, error) {
 		go func() {
 			defer wg.Done()
 
-			for change := range eventCh {
-				// 同一キーへの操作を直列化するためのミューテックスを取得
-				var mu sync.Mutex
-				mutexIf, _ := keyMutexes.LoadOrStore(change.Key, &mu)
-				mutex := mutexIf.(*sync.Mutex)
-
-				// イベントの実行時間を計算
-				var scheduledAt time.Time
-				if opts.IgnoreTimeWindows {
-					scheduledAt = time.Now()
-				} else {
-					// 最初のイベントからの相対時間を計算
-					relativeTime := change.Timestamp.Sub(firstEventTime)
-					// 再生速度を適用
-					adjustedTime := time.Duration(float64(relativeTime) / speedFactor)
-					// 開始時間からの相対時間を計算
-					scheduledAt = startTime.Add(adjustedTime)
-				}
-
-				// 現在時刻がスケジュール時間より前なら待機
-				if time.Now().Before(scheduledAt) && !opts.IgnoreTimeWindows {
-					sleepTime := scheduledAt.Sub(time.Now())
-					slog.Debug("イベント実行を待機します", "key", change.Key, "sleepTime", sleepTime)
-					time.Sleep(sleepTime)
+			for scheduled := range workCh {
+				// 中断後は新しいイベントを実行しない
+				if ctx.Err() != nil {
+					completeCh <- scheduled.queueKey()
+					continue
 				}
 
-				// 同一キーへの操作はロックを取得して直列化
-				mutex.Lock()
-
 				// イベントを実行
-				event := ReplayEvent{
-					Change:      change,
-					ScheduledAt: scheduledAt,
-					ExecutedAt:  time.Now(),
-				}
-
-				slog.Info("イベントを実行します", "key", change.Key, "changeType", change.ChangeType)
-
-				if !opts.DryRun {
-					err := executeChange(client, opts.SourceBucket, opts.DestBucket, change)
-					if err != nil {
-						event.Status = "FAILED"
-						event.ErrorMessage = err.Error()
-						slog.Error("イベントの実行に失敗しました", "key", change.Key, "error", err)
-					} else {
-						event.Status = "SUCCESS"
-						slog.Info("イベントの実行が完了しました", "key", change.Key)
-					}
-				} else {
-					event.Status = "DRYRUN"
-					slog.Info("ドライラン: イベントをスキップしました", "key", change.Key)
-				}
-
-				// ロックを解放
-				mutex.Unlock()
+				event := executeReplayEvent(ctx, execCtx, opts, targets[scheduled.destination], limiter, scheduled)
 
-				// 結果を送信
+				// 結果を送信してから完了を通知し、同一キーの次のイベントが結果の後に並ぶようにする
 				doneCh <- event
+				completeCh <- scheduled.queueKey()
 			}
 		}()
 	}
 
-	// イベントをチャネルに送信
+	// ワーカーの完了後に完了チャネルを閉じる
 	go func() {
-		for _, change := range changes {
-			select {
-			case eventCh <- change:
-			case err := <-errCh:
-				slog.Error("エラーが発生したため処理を中断します", "error", err)
-				close(eventCh)
-				return
-			}
-		}
-		close(eventCh)
+		wg.Wait()
+		close(doneCh)
 	}()
 
-	// 結果を収集
-	go func() {
-		for i := 0; i < len(changes); i++ {
-			event := <-doneCh
-			switch event.Status {
-			case "SUCCESS":
-				result.SuccessEvents++
-			case "FAILED":
-				result.FailedEvents++
-			case "DRYRUN":
-				result.SkippedEvents++
+	// 結果を収集（件数と遅延は逐次集計し、各イベントの結果はファイルまたはメモリに記録する）
+	var processed int
+	var totalLag time.Duration
+	for event := range doneCh {
+		switch event.Status {
+		case "SUCCESS":
+			result.SuccessEvents++
+		case "FAILED":
+			result.FailedEvents++
+		case "DRYRUN", "SKIPPED":
+			result.SkippedEvents++
+		case "CANCELED":
+			result.CanceledEvents++
+		}
+		if destResult, ok := destResults[event.Destination]; ok {
+			destResult.record(event)
+		}
+		processed++
+		if eventsWriter != nil {
+			if err := eventsWriter.Write(event); err != nil {
+				slog.Warn("イベントの結果を書き込めませんでした", "index", event.Index, "error", err)
 			}
+		} else {
 			result.Events = append(result.Events, event)
 		}
-		close(doneCh)
-	}()
+		if event.Lag > result.MaxLag {
+			result.MaxLag = event.Lag
+		}
+		totalLag += event.Lag
 
-	// ワーカーの完了を待機
-	wg.Wait()
+		// 進捗を記録し、定期的に保存する（複数の宛先の場合は全ての宛先の結果が揃ってから記録する）
+		if progress != nil {
+			if entryEvent, ok := outcomes.add(event); ok {
+				progress.Record(entryEvent)
+			}
+			if err := progress.SaveIfDue(); err != nil {
+				slog.Warn("進捗ファイルの保存に失敗しました", "error", err)
+			}
+		}
+	}
 
-	// 結果を返す
+	if progress != nil {
+		if err := progress.Save(); err != nil {
+			slog.Error("進捗ファイルの保存に失敗しました", "error", err)
+		} else {
+			slog.Info("進捗ファイルを保存しました", "file", opts.ProgressFile, "completed", progress.Completed, "failed", progress.Failed, "pending", progress.Pending)
+		}
+	}
+
+	if eventsWriter != nil {
+		if err := eventsWriter.Close(); err != nil {
+			slog.Error("イベントの結果のファイルの保存に失敗しました", "file", opts.EventsFile, "error", err)
+		}
+	}
+
+	// 変更リストの読み込みの終了を待つ（中断した場合も読み込み中の変更の後に終了する）
+	<-feedDone
 	result.EndTime = time.Now()
+	result.OutOfOrderEvents = outOfOrder
+	result.FilteredEvents = filtered
+	result.TotalEvents = fed * len(targets) * copies
+	if amplify.enabled() {
+		result.Loops = loopsStarted
+	}
+	if total >= 0 {
+		result.TotalEvents = total
+	}
+	if processed > 0 {
+		result.AverageLag = totalLag / time.Duration(processed)
+	}
+
+	// 中断された場合は途中までの結果を返す
+	if ctx.Err() != nil {
+		result.Interrupted = true
+		result.cancelRemaining(processed)
+		return result, fmt.Errorf("リプレイが中断されました: %w", ctx.Err())
+	}
+
+	// 変更リストの途中で読み込みに失敗した場合は、それまでの結果とエラーを返す
+	if feedErr != nil {
+		result.cancelRemaining(processed)
+		return result, fmt.Errorf("変更リストの読み込みに失敗しました: %w", feedErr)
+	}
+
+	// 宛先の状態を検証する（ドライランでは宛先を変更しないため検証しない）
+	if opts.Verify && !opts.DryRun {
+		verification, err := verifyReplay(ctx, opts, targets, limiter, concurrency)
+		if err != nil {
+			return result, fmt.Errorf("リプレイ後の検証に失敗しました: %w", err)
+		}
+		result.Verification = verification
+	}
+
+	// 結果を返す
 	return result, nil
 }
 
-// loadChangesFromFile はファイルから変更リストを読み込みます
-func loadChangesFromFile(filePath string) ([]ObjectChange, error) {
-	file, err := os.Open(filePath)
+// replayEntry はリプレイするイベントと変更リスト内の位置
+type replayEntry struct {
+	Index  int
+	Change ObjectChange
+}
+
+// prepareReplayProgress は進捗ファイルを読み込むか、新しく作成します
+// destBucket は宛先のバケット（複数の宛先の場合はカンマ区切り）です
+func prepareReplayProgress(opts ReplayOptions, destBucket, fingerprint string, total int) (*ReplayProgress, error) {
+	existing, err := LoadReplayProgress(opts.ProgressFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing == nil {
+		if opts.Resume {
+			slog.Warn("進捗ファイルが存在しないため、最初からリプレイします", "file", opts.ProgressFile)
+		}
+		return newReplayProgress(opts.ProgressFile, opts.SourceFile, destBucket, fingerprint, total), nil
+	}
+
+	if !opts.Resume {
+		return nil, fmt.Errorf("進捗ファイル %s が既に存在します。中断したリプレイを再開する場合は --resume を指定してください", opts.ProgressFile)
+	}
+
+	if err := existing.Validate(destBucket, fingerprint, total); err != nil {
+		return nil, err
+	}
+
+	slog.Info("進捗ファイルからリプレイを再開します",
+		"file", opts.ProgressFile,
+		"completed", existing.Completed,
+		"failed", existing.Failed,
+		"pending", existing.Pending)
+	return existing, nil
+}
+
+// SaveReplayResult はリプレイ結果をJSON形式で保存します
+// 出力先はローカルファイルまたは s3://bucket/key 形式のURIで、拡張子が .gz、.zst の場合は圧縮されます
+func SaveReplayResult(ctx context.Context, filePath string, result *ReplayResult) error {
+	file, err := createChangesOutput(ctx, filePath, ChangesFileOptions{})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		file.Close()
+		return fmt.Errorf("リプレイ結果の書き込みに失敗しました: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("リプレイ結果の書き込みに失敗しました: %w", err)
+	}
+
+	return nil
+}
+
+// loadChangeList はファイルからヘッダーを含む変更リストを読み込みます
+// filePath には s3://bucket/key 形式のURIも指定でき、gzip/zstdで圧縮されたファイルは自動的に伸長されます
+func loadChangeList(ctx context.Context, filePath string, opts ChangesFileOptions) (*ChangeList, error) {
+	file, err := openChangesInput(ctx, filePath, opts)
 	if err != nil {
 		return nil, fmt.Errorf("ファイルのオープンに失敗しました: %w", err)
 	}
 	defer file.Close()
 
-	var changes []ObjectChange
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&changes); err != nil {
-		return nil, fmt.Errorf("JSONのデコードに失敗しました: %w", err)
+	return readChangeList(file)
+}
+
+// replayExecutor は1件の変更をリプレイ先に反映する関数
+// ハンドラーを呼び出した場合はその実行結果も返します
+type replayExecutor func(ctx context.Context, destKey string, change ObjectChange) (*HandlerResult, error)
+
+// newReplayExecutor はオプションと宛先に応じて変更の反映方法を作成します
+// オブジェクトを変更する場合は宛先のストレージも返します（イベント通知のみの宛先ではnil）
+func newReplayExecutor(ctx context.Context, opts ReplayOptions, target ReplayDestination, backends *backendCache) (replayExecutor, Backend, error) {
+	if handlerSink, ok := target.EventSink.(HandlerSink); ok {
+		slog.Info("イベントごとにハンドラーを呼び出します。オブジェクトは変更しません", "destBucket", target.Bucket)
+		return func(ctx context.Context, destKey string, change ObjectChange) (*HandlerResult, error) {
+			notification, err := newS3EventNotification(change, target.Bucket, destKey, target.EventRegion)
+			if err != nil {
+				return nil, err
+			}
+			return handlerSink.Invoke(ctx, notification)
+		}, nil, nil
+	}
+	if target.EventSink != nil {
+		slog.Info("イベントのみのモードでリプレイします。オブジェクトは変更しません", "destBucket", target.Bucket)
+		return func(ctx context.Context, destKey string, change ObjectChange) (*HandlerResult, error) {
+			return nil, sendChangeEvent(ctx, target.EventSink, target.Bucket, destKey, target.EventRegion, change)
+		}, nil, nil
+	}
+
+	// ストレージの準備（変更元と宛先で接続設定が同じ場合は同じクライアントを使用）
+	var err error
+	dest := target.Backend
+	if dest == nil {
+		dest, err = backends.get(target.Client)
+		if err != nil {
+			slog.Error("宛先のS3クライアントの作成に失敗しました", "destBucket", target.Bucket, "error", err)
+			return nil, nil, err
+		}
 	}
+	source := opts.SourceBackend
+	if source == nil {
+		source, err = backends.get(opts.Source)
+		if err != nil {
+			slog.Error("変更元のS3クライアントの作成に失敗しました", "error", err)
+			return nil, nil, err
+		}
+	}
+	if source != dest {
+		slog.Info("変更元と宛先で異なるストレージの設定を使用します", "source", opts.Source.String(), "dest", target.Client.String(), "destBucket", target.Bucket)
+	}
+	copier := newObjectCopier(source, dest)
+	copier.setTransform(opts.Transformer)
 
-	return changes, nil
+	// 反映済みの変更のスキップや検証のために、コピーした変更元のバージョンIDを記録する
+	copier.markSourceVersion = opts.SkipApplied || opts.Verify
+
+	return func(ctx context.Context, destKey string, change ObjectChange) (*HandlerResult, error) {
+		return nil, executeChange(ctx, copier, opts.SourceBucket, target.Bucket, destKey, change)
+	}, dest, nil
 }
 
-// executeChange は変更を実行します
-func executeChange(client *s3.Client, sourceBucket, destBucket string, change ObjectChange) error {
-	ctx := context.TODO()
+// sendChangeEvent は変更をS3イベント通知にして送信します
+func sendChangeEvent(ctx context.Context, sink EventSink, bucket, key, region string, change ObjectChange) error {
+	notification, err := newS3EventNotification(change, bucket, key, region)
+	if err != nil {
+		return err
+	}
+	return sink.Send(ctx, notification)
+}
+
+// executeReplayEvent はスケジューラーから渡されたイベントを実行し、その結果を返します
+// 制限の待機は中断されたらすぐに打ち切り、実行中の操作は execCtx の猶予時間まで完了を待ちます
+func executeReplayEvent(ctx, execCtx context.Context, opts ReplayOptions, target *replayTarget, limiter *RateLimiter, scheduled *scheduledEvent) ReplayEvent {
+	change := scheduled.entry.Change
+	destKey := scheduled.destKey
+
+	event := ReplayEvent{
+		Index:       scheduled.entry.Index,
+		Change:      change,
+		DestKey:     destKey,
+		ScheduledAt: scheduled.scheduledAt,
+		ExecutedAt:  time.Now(),
+		Destination: target.Name,
+		Copy:        scheduled.copy,
+		Loop:        scheduled.loop,
+	}
+	event.Lag = event.ExecutedAt.Sub(event.ScheduledAt)
+
+	slog.Info("イベントを実行します", "key", change.Key, "destBucket", target.Bucket, "destKey", destKey, "changeType", change.ChangeType, "lag", event.Lag)
+
+	if scheduled.rewriteErr != nil {
+		event.Status = "FAILED"
+		event.ErrorMessage = scheduled.rewriteErr.Error()
+		slog.Error("宛先のキーの決定に失敗しました", "key", change.Key, "error", scheduled.rewriteErr)
+		return event
+	}
+
+	if opts.DryRun {
+		event.Status = "DRYRUN"
+		slog.Info("ドライラン: イベントをスキップしました", "key", change.Key)
+		return event
+	}
+
+	// 宛先に反映済みの変更は実行しない（確認に失敗した場合は変更を反映する）
+	if target.checkApplied != nil {
+		var reason string
+		_, err := opts.Retry.Do(execCtx, "HeadObject "+target.Bucket+"/"+destKey, func() error {
+			if err := limiter.WaitRequest(ctx, destKey); err != nil {
+				return err
+			}
+			var err error
+			reason, err = target.checkApplied(execCtx, destKey, change)
+			return err
+		})
+		switch {
+		case err != nil && (execCtx.Err() != nil || errors.Is(err, context.Canceled)):
+			event.Status = "CANCELED"
+			event.ErrorMessage = err.Error()
+			event.Duration = time.Since(event.ExecutedAt)
+			slog.Warn("中断によりイベントの実行が打ち切られました", "key", change.Key, "error", err)
+			return event
+		case err != nil:
+			slog.Warn("宛先に反映済みかを確認できなかったため、変更を反映します", "key", change.Key, "destKey", destKey, "error", err)
+		case reason != "":
+			event.Status = "SKIPPED"
+			event.SkipReason = reason
+			event.Duration = time.Since(event.ExecutedAt)
+			slog.Info("宛先に反映済みのためイベントをスキップしました", "key", change.Key, "destKey", destKey, "reason", reason)
+			return event
+		}
+	}
+
+	attempts, err := opts.Retry.Do(execCtx, string(change.ChangeType)+" "+target.Bucket+"/"+destKey, func() error {
+		if err := limiter.WaitRequest(ctx, destKey); err != nil {
+			return err
+		}
+		if target.EventSink == nil {
+			if err := limiter.WaitBytes(ctx, copiedBytes(change)); err != nil {
+				return err
+			}
+		}
+		handler, err := target.execute(execCtx, destKey, change)
+		event.Handler = handler
+		return err
+	})
+	event.Attempts = attempts
+	event.Duration = time.Since(event.ExecutedAt)
+
+	switch {
+	case err != nil && (execCtx.Err() != nil || errors.Is(err, context.Canceled)):
+		// 中断や猶予時間を過ぎて打ち切られたイベントは失敗ではなく未実行として扱う
+		event.Status = "CANCELED"
+		event.ErrorMessage = err.Error()
+		slog.Warn("中断によりイベントの実行が打ち切られました", "key", change.Key, "error", err)
+	case err != nil:
+		event.Status = "FAILED"
+		event.ErrorMessage = err.Error()
+		slog.Error("イベントの実行に失敗しました", "key", change.Key, "error", err)
+	default:
+		event.Status = "SUCCESS"
+		slog.Info("イベントの実行が完了しました", "key", change.Key)
+	}
+	return event
+}
+
+// copiedBytes は変更の反映でコピーするバイト数を返します
+func copiedBytes(change ObjectChange) int64 {
+	if change.ChangeType == ChangeTypeDelete {
+		return 0
+	}
+	return change.Size
+}
 
+// executeChange は変更を実行します
+func executeChange(ctx context.Context, copier *objectCopier, sourceBucket, destBucket, destKey string, change ObjectChange) error {
 	switch change.ChangeType {
-	case ChangeTypeCreate, ChangeTypeUpdate:
-		return copyObject(ctx, client, sourceBucket, destBucket, change)
+	case ChangeTypeCreate, ChangeTypeUpdate, ChangeTypeRecreate:
+		return copyObject(ctx, copier, sourceBucket, destBucket, destKey, change)
 	case ChangeTypeDelete:
-		return deleteObject(ctx, client, destBucket, change)
+		return deleteObject(ctx, copier.dest, destBucket, destKey)
 	case ChangeTypeUndelete:
-		return undeleteObject(ctx, client, sourceBucket, destBucket, change)
+		return undeleteObject(ctx, copier, sourceBucket, destBucket, destKey, change)
 	default:
 		return fmt.Errorf("不明な変更タイプです: %s", change.ChangeType)
 	}
 }
 
 // copyObject はオブジェクトをコピーします
-func copyObject(ctx context.Context, client *s3.Client, sourceBucket, destBucket string, change ObjectChange) error {
+func copyObject(ctx context.Context, copier *objectCopier, sourceBucket, destBucket, destKey string, change ObjectChange) error {
 	// バージョンIDが指定されている場合はそのバージョンをコピー
-	var copySource string
-	if change.VersionID != "" {
-		copySource = fmt.Sprintf("%s/%s?versionId=%s", sourceBucket, change.Key, change.VersionID)
-	} else {
-		copySource = fmt.Sprintf("%s/%s", sourceBucket, change.Key)
-	}
-
-	_, err := client.CopyObject(ctx, &s3.CopyObjectInput{
-		Bucket:     aws.String(destBucket),
-		Key:        aws.String(change.Key),
-		CopySource: aws.String(copySource),
-	})
-
-	if err != nil {
+	if err := copier.copyVersion(ctx, sourceBucket, change.Key, change.VersionID, destBucket, destKey); err != nil {
 		return fmt.Errorf("オブジェクトのコピーに失敗しました: %w", err)
 	}
 
@@ -285,13 +747,8 @@ func copyObject(ctx context.Context, client *s3.Client, sourceBucket, destBucket
 }
 
 // deleteObject はオブジェクトを削除します
-func deleteObject(ctx context.Context, client *s3.Client, destBucket string, change ObjectChange) error {
-	_, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
-		Bucket: aws.String(destBucket),
-		Key:    aws.String(change.Key),
-	})
-
-	if err != nil {
+func deleteObject(ctx context.Context, dest Backend, destBucket, destKey string) error {
+	if err := dest.DeleteObject(ctx, destBucket, destKey); err != nil {
 		return fmt.Errorf("オブジェクトの削除に失敗しました: %w", err)
 	}
 
@@ -299,21 +756,13 @@ func deleteObject(ctx context.Context, client *s3.Client, destBucket string, cha
 }
 
 // undeleteObject は削除されたオブジェクトを復元します
-func undeleteObject(ctx context.Context, client *s3.Client, sourceBucket, destBucket string, change ObjectChange) error {
+func undeleteObject(ctx context.Context, copier *objectCopier, sourceBucket, destBucket, destKey string, change ObjectChange) error {
 	// 前のバージョンIDが指定されている場合はそのバージョンをコピー
 	if change.PreviousVersionID == "" {
 		return fmt.Errorf("復元するバージョンIDが指定されていません")
 	}
 
-	copySource := fmt.Sprintf("%s/%s?versionId=%s", sourceBucket, change.Key, change.PreviousVersionID)
-
-	_, err := client.CopyObject(ctx, &s3.CopyObjectInput{
-		Bucket:     aws.String(destBucket),
-		Key:        aws.String(change.Key),
-		CopySource: aws.String(copySource),
-	})
-
-	if err != nil {
+	if err := copier.copyVersion(ctx, sourceBucket, change.Key, change.PreviousVersionID, destBucket, destKey); err != nil {
 		return fmt.Errorf("オブジェクトの復元に失敗しました: %w", err)
 	}
 
@@ -332,28 +781,83 @@ func PrintReplayResult(result *ReplayResult, writer io.Writer) {
 	fmt.Fprintf(writer, "  成功: %d\n", result.SuccessEvents)
 	fmt.Fprintf(writer, "  失敗: %d\n", result.FailedEvents)
 	fmt.Fprintf(writer, "  スキップ: %d\n", result.SkippedEvents)
+	if result.Interrupted {
+		fmt.Fprintf(writer, "  中断により未実行: %d\n", result.CanceledEvents)
+	}
+	if result.OutOfOrderEvents > 0 {
+		fmt.Fprintf(writer, "  先読みの範囲外で順序が逆転した変更: %d\n", result.OutOfOrderEvents)
+	}
+	if result.FilteredEvents > 0 {
+		fmt.Fprintf(writer, "  絞り込みにより除外: %d\n", result.FilteredEvents)
+	}
+	if result.Loops > 0 {
+		fmt.Fprintf(writer, "  変更リストの繰り返し: %d回\n", result.Loops)
+	}
+	for _, dest := range result.Destinations {
+		fmt.Fprintf(writer, "  宛先 %s (%s): 成功 %d、失敗 %d、スキップ %d、未実行 %d\n",
+			dest.Name, dest.Bucket, dest.SuccessEvents, dest.FailedEvents, dest.SkippedEvents, dest.CanceledEvents)
+	}
+	if result.SuccessEvents+result.FailedEvents+result.SkippedEvents > 0 {
+		fmt.Fprintf(writer, "  スケジュールの遅延: 平均 %s、最大 %s\n", result.AverageLag.Round(time.Millisecond), result.MaxLag.Round(time.Millisecond))
+	}
 	
+	if result.EventsFile != "" {
+		fmt.Fprintf(writer, "  各イベントの結果: %s\n", result.EventsFile)
+	}
+	if v := result.Verification; v != nil {
+		fmt.Fprintf(writer, "  検証: %dキー中 %dキーが不一致\n", v.CheckedKeys, v.MismatchedKeys)
+		if len(v.SkippedTargets) > 0 {
+			fmt.Fprintf(writer, "    イベント通知のみのため検証しなかった宛先: %s\n", strings.Join(v.SkippedTargets, ", "))
+		}
+		for i, mismatch := range v.Mismatches {
+			// 件数が多い場合は先頭の20件のみを出力
+			if i == 20 {
+				fmt.Fprintf(writer, "    ... 省略 (%d件) ...\n", len(v.Mismatches)-20)
+				break
+			}
+			key := mismatch.Key
+			if mismatch.Destination != "" {
+				key = fmt.Sprintf("[%s] %s", mismatch.Destination, key)
+			}
+			fmt.Fprintf(writer, "    %s - %s %s - %s\n", key, mismatch.ChangeType, mismatch.VersionID, mismatch.Reason)
+		}
+	}
+
 	if result.DetailedResults && len(result.Events) > 0 {
 		fmt.Fprintf(writer, "\n詳細結果:\n")
-		for i, event := range result.Events {
-			if i >= 10 && len(result.Events) > 20 {
+		for i := 0; i < len(result.Events); i++ {
+			// 件数が多い場合は先頭と末尾の10件のみを出力
+			if i == 10 && len(result.Events) > 20 {
 				fmt.Fprintf(writer, "  ... 省略 (%d件) ...\n", len(result.Events)-20)
 				i = len(result.Events) - 10
-				event = result.Events[i]
 			}
+			event := result.Events[i]
 			
+			key := event.Change.Key
+			if event.DestKey != "" && event.DestKey != key {
+				key = fmt.Sprintf("%s -> %s", key, event.DestKey)
+			}
+			if event.Destination != "" {
+				key = fmt.Sprintf("[%s] %s", event.Destination, key)
+			}
+
 			fmt.Fprintf(writer, "  %s - %s - %s - %s\n", 
 				event.ExecutedAt.Format(time.RFC3339),
-				event.Change.Key,
+				key,
 				event.Change.ChangeType,
 				event.Status)
 				
 			if event.Status == "FAILED" {
 				fmt.Fprintf(writer, "    エラー: %s\n", event.ErrorMessage)
 			}
-			
-			if i >= len(result.Events) - 10 {
-				break
+			if event.SkipReason != "" {
+				fmt.Fprintf(writer, "    スキップの理由: %s\n", event.SkipReason)
+			}
+			if event.Attempts > 1 {
+				fmt.Fprintf(writer, "    試行回数: %d\n", event.Attempts)
+			}
+			if event.Handler != nil {
+				fmt.Fprintf(writer, "    ハンドラー: %s\n", event.Handler)
 			}
 		}
 	}
@@ -4,14 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
-	"os"
 	"sort"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
@@ -23,6 +22,7 @@ const (
 	ChangeTypeCreate    ChangeType = "CREATE"    // オブジェクトの作成
 	ChangeTypeUpdate    ChangeType = "UPDATE"    // オブジェクトの更新
 	ChangeTypeDelete    ChangeType = "DELETE"    // オブジェクトの削除
+	ChangeTypeRecreate  ChangeType = "RECREATE"  // 削除されたオブジェクトの再作成
 	ChangeTypeUndelete  ChangeType = "UNDELETE"  // 削除マーカーの削除（復元）
 )
 
@@ -46,6 +46,19 @@ type ReplayListOptions struct {
 	Concurrency int       // 並列処理数
 	BatchSize   int       // バッチサイズ（一度に処理するオブジェクト数）
 	Writer      ChangesWriter // 変更リストの書き込み先
+	Since       *Watermark    // 前回の取得結果（指定した場合はその続きから取得）
+	Backend     Backend       // 対象のストレージ（nilの場合はデフォルトの設定のS3）
+	Retry       RetryPolicy   // 一覧の取得が一時的なエラーで失敗した場合の再実行の方針
+}
+
+// EffectiveTimestamp は実際に取得を開始する時刻を返します
+// Since が指定されている場合は、Timestamp とウォーターマークの再取得開始時刻のうち遅い方になります
+func (opts ReplayListOptions) EffectiveTimestamp() time.Time {
+	timestamp := opts.Timestamp
+	if opts.Since != nil && opts.Since.Start().After(timestamp) {
+		timestamp = opts.Since.Start()
+	}
+	return timestamp
 }
 
 // ChangesWriter は変更リストを書き込むインターフェース
@@ -57,7 +70,7 @@ type ChangesWriter interface {
 // GetChangesList は指定された時間以降のオブジェクト変更リストを取得します
 // 注意: この関数は後方互換性のために残していますが、大量のデータを処理する場合は
 // ProcessChangesStreamingを使用することを推奨します
-func GetChangesList(opts ReplayListOptions) ([]ObjectChange, error) {
+func GetChangesList(ctx context.Context, opts ReplayListOptions) ([]ObjectChange, error) {
 	var changes []ObjectChange
 	
 	// 変更を受け取るコールバック関数
@@ -75,7 +88,7 @@ func GetChangesList(opts ReplayListOptions) ([]ObjectChange, error) {
 	}
 	
 	// ストリーミング処理を実行
-	err := ProcessChangesStreaming(opts, callback)
+	err := ProcessChangesStreaming(ctx, opts, callback)
 	if err != nil {
 		return nil, err
 	}
@@ -84,25 +97,26 @@ func GetChangesList(opts ReplayListOptions) ([]ObjectChange, error) {
 }
 
 // ProcessChangesStreaming は指定された時間以降のオブジェクト変更リストをストリーミング処理します
-func ProcessChangesStreaming(opts ReplayListOptions, callback func([]ObjectChange) error) error {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		slog.Error("AWS設定の読み込みに失敗しました", "error", err)
-		return fmt.Errorf("AWS設定の読み込みに失敗しました: %w", err)
+func ProcessChangesStreaming(ctx context.Context, opts ReplayListOptions, callback func([]ObjectChange) error) error {
+	backend := opts.Backend
+	if backend == nil {
+		s3Backend, err := NewS3Backend(ctx, ClientOptions{})
+		if err != nil {
+			slog.Error("AWS設定の読み込みに失敗しました", "error", err)
+			return err
+		}
+		backend = s3Backend
 	}
-
-	client := s3.NewFromConfig(cfg)
+	backend = withRetry(backend, opts.Retry)
 
 	// バケットのバージョニングが有効かチェック
-	versioningResp, err := client.GetBucketVersioning(context.TODO(), &s3.GetBucketVersioningInput{
-		Bucket: aws.String(opts.Bucket),
-	})
+	versioningEnabled, err := backend.VersioningEnabled(ctx, opts.Bucket)
 	if err != nil {
 		slog.Error("バケットのバージョニング設定の取得に失敗しました", "error", err)
 		return fmt.Errorf("バケットのバージョニング設定の取得に失敗しました: %w", err)
 	}
 
-	if versioningResp.Status != s3types.BucketVersioningStatusEnabled {
+	if !versioningEnabled {
 		slog.Warn("バケットのバージョニングが有効になっていません。完全な変更履歴を取得できない可能性があります")
 	}
 
@@ -118,11 +132,20 @@ func ProcessChangesStreaming(opts ReplayListOptions, callback func([]ObjectChang
 		batchSize = 1000
 	}
 
+	// 前回の取得結果がある場合はその続きから取得する
+	timestamp := opts.EffectiveTimestamp()
+	if opts.Since != nil {
+		if err := opts.Since.Validate(opts.Bucket, opts.Prefix); err != nil {
+			return err
+		}
+		slog.Info("前回の取得結果から増分を取得します", "watermark", opts.Since.Timestamp, "timestamp", timestamp)
+	}
+
 	// オブジェクトのバージョン一覧を取得
 	slog.Info("バージョン一覧を取得します", "bucket", opts.Bucket, "prefix", opts.Prefix)
 	
-	// キーのリストを取得
-	keyList, err := listAllKeys(client, opts.Bucket, opts.Prefix)
+	// キーのリストを取得（期間内に削除されたキーを含めるため、バージョン一覧から取得する）
+	keyList, err := backend.ListVersionedKeys(ctx, opts.Bucket, opts.Prefix, concurrency)
 	if err != nil {
 		slog.Error("キー一覧の取得に失敗しました", "error", err)
 		return fmt.Errorf("キー一覧の取得に失敗しました: %w", err)
@@ -153,7 +176,7 @@ func ProcessChangesStreaming(opts ReplayListOptions, callback func([]ObjectChang
 			
 			for key := range keyCh {
 				// キーの変更リストを取得
-				changes, err := getChangesForKey(client, opts.Bucket, key, opts.Timestamp)
+				changes, err := getChangesForKey(ctx, backend, opts.Bucket, key, timestamp, opts.Since)
 				if err != nil {
 					select {
 					case errCh <- fmt.Errorf("キー %s の変更リスト取得に失敗しました: %w", key, err):
@@ -163,6 +186,11 @@ func ProcessChangesStreaming(opts ReplayListOptions, callback func([]ObjectChang
 					return
 				}
 				
+				// 前回までに取得済みの変更を除外
+				if opts.Since != nil {
+					changes = opts.Since.Filter(changes)
+				}
+				
 				if len(changes) > 0 {
 					resultCh <- changes
 				}
@@ -216,32 +244,36 @@ func ProcessChangesStreaming(opts ReplayListOptions, callback func([]ObjectChang
 		close(doneCh)
 	}()
 	
+	// ワーカーと結果収集のゴルーチンを終了させる
+	// 中断やエラーで戻る場合も、呼び出し元がライターを閉じる前にコールバックの呼び出しを終わらせる
+	shutdown := func() {
+		// キーチャネルを閉じる
+		close(keyCh)
+		
+		// ワーカーの完了を待機
+		wg.Wait()
+		
+		// 結果チャネルを閉じる
+		close(resultCh)
+		
+		// 結果収集の完了を待機
+		<-doneCh
+	}
+	
 	// キーをチャネルに送信
 	for _, key := range keyList {
 		select {
 		case keyCh <- key:
 		case err := <-errCh:
-			close(keyCh)
+			shutdown()
 			return err
+		case <-ctx.Done():
+			shutdown()
+			return fmt.Errorf("変更リストの取得が中断されました: %w", ctx.Err())
 		}
 	}
 	
-	// キーチャネルを閉じる
-	close(keyCh)
-	
-	// ワーカーの完了を待機
-	wg.Wait()
-	
-	// 結果チャネルを閉じる
-	close(resultCh)
-	
-	// 結果収集の完了を待機
-	select {
-	case <-doneCh:
-		// 正常終了
-	case err := <-errCh:
-		return err
-	}
+	shutdown()
 	
 	// エラーがあれば返す
 	select {
@@ -255,136 +287,26 @@ func ProcessChangesStreaming(opts ReplayListOptions, callback func([]ObjectChang
 	return nil
 }
 
-// listAllKeys はバケット内の全てのキーを取得します
-func listAllKeys(client *s3.Client, bucket, prefix string) ([]string, error) {
-	var keys []string
-	var continuationToken *string
-	
-	for {
-		resp, err := client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-			Bucket: aws.String(bucket),
-			Prefix: aws.String(prefix),
-			ContinuationToken: continuationToken,
-		})
-		
-		if err != nil {
-			return nil, err
-		}
-		
-		for _, obj := range resp.Contents {
-			keys = append(keys, *obj.Key)
-		}
-		
-		if resp.IsTruncated == nil || !*resp.IsTruncated {
-			break
-		}
-		
-		continuationToken = resp.NextContinuationToken
-	}
-	
-	return keys, nil
-}
-
 // getChangesForKey は指定されたキーの変更リストを取得します
-func getChangesForKey(client *s3.Client, bucket, key string, timestamp time.Time) ([]ObjectChange, error) {
+// 変更の種類はキーの全バージョン履歴から判定します。前回の取得結果がある場合は、
+// その時点で最新だった削除マーカーが消えていれば削除マーカーの削除（復元）として扱います
+func getChangesForKey(ctx context.Context, backend Backend, bucket, key string, timestamp time.Time, since *Watermark) ([]ObjectChange, error) {
 	// キーの全バージョンを取得
-	allKeyVersions, err := getAllVersionsForKey(client, bucket, key)
+	allKeyVersions, err := backend.ListVersions(ctx, bucket, key)
 	if err != nil {
 		return nil, err
 	}
-	
-	// 指定された時間以降のバージョンをフィルタリング
-	var filteredVersions []s3types.ObjectVersion
-	var filteredDeleteMarkers []s3types.DeleteMarkerEntry
-	
-	for _, v := range allKeyVersions.Versions {
-		if !v.LastModified.Before(timestamp) {
-			filteredVersions = append(filteredVersions, v)
-		}
-	}
-	
-	for _, dm := range allKeyVersions.DeleteMarkers {
-		if !dm.LastModified.Before(timestamp) {
-			filteredDeleteMarkers = append(filteredDeleteMarkers, dm)
-		}
-	}
-	
-	// 変更リストを作成
-	var changes []ObjectChange
-	
-	// 通常のバージョンを処理
-	for _, v := range filteredVersions {
-		change := ObjectChange{
-			Key:        *v.Key,
-			VersionID:  *v.VersionId,
-			Timestamp:  *v.LastModified,
-			IsDeleteMarker: false,
-		}
-		
-		if v.Size != nil {
-			change.Size = *v.Size
-		}
-		
-		if v.ETag != nil {
-			change.ETag = *v.ETag
-		}
-		
-		// 変更タイプの判定（最初のバージョンかどうかは後で判定）
-		change.ChangeType = ChangeTypeUpdate
-		
-		changes = append(changes, change)
-		slog.Debug("バージョンを追加しました", "key", *v.Key, "versionId", *v.VersionId, "lastModified", *v.LastModified)
-	}
-	
-	// 削除マーカーを処理
-	for _, dm := range filteredDeleteMarkers {
-		change := ObjectChange{
-			Key:           *dm.Key,
-			VersionID:     *dm.VersionId,
-			ChangeType:    ChangeTypeDelete,
-			Timestamp:     *dm.LastModified,
-			IsDeleteMarker: true,
-		}
-		
-		changes = append(changes, change)
-		slog.Debug("削除マーカーを追加しました", "key", *dm.Key, "versionId", *dm.VersionId, "lastModified", *dm.LastModified)
+
+	history := versionHistory(allKeyVersions)
+	if since != nil {
+		history = since.restoreRemovedDeleteMarker(key, history)
 	}
-	
-	// 時間順にソート
-	sort.Slice(changes, func(i, j int) bool {
-		return changes[i].Timestamp.Before(changes[j].Timestamp)
-	})
-	
-	// 変更タイプの詳細な判定
-	for i := range changes {
-		if i == 0 {
-			// 最初の変更の場合
-			if isFirstVersionOfKey(allKeyVersions.Versions, changes[i].VersionID) {
-				changes[i].ChangeType = ChangeTypeCreate
-			} else if !changes[i].IsDeleteMarker {
-				// 前のバージョンを探す
-				prevVersion := findLatestVersionBeforeTimestamp(allKeyVersions.Versions, key, timestamp)
-				if prevVersion != "" {
-					changes[i].PreviousVersionID = prevVersion
-				}
-			}
-		} else if !changes[i].IsDeleteMarker && i > 0 && changes[i-1].IsDeleteMarker {
-			// 削除マーカーの後の変更は復元
-			changes[i].ChangeType = ChangeTypeUndelete
-			
-			// 前のバージョンを探す（削除マーカーの前）
-			for j := i - 2; j >= 0; j-- {
-				if !changes[j].IsDeleteMarker {
-					changes[i].PreviousVersionID = changes[j].VersionID
-					break
-				}
-			}
-		} else if !changes[i].IsDeleteMarker && i > 0 && !changes[i-1].IsDeleteMarker {
-			// 通常の更新
-			changes[i].PreviousVersionID = changes[i-1].VersionID
-		}
+
+	changes := inferChanges(key, history, timestamp)
+	for _, change := range changes {
+		slog.Debug("変更を追加しました", "key", change.Key, "versionId", change.VersionID, "changeType", change.ChangeType, "timestamp", change.Timestamp)
 	}
-	
+
 	return changes, nil
 }
 
@@ -395,15 +317,16 @@ type KeyVersions struct {
 }
 
 // getAllVersionsForKey は指定されたキーの全バージョンを取得します
-func getAllVersionsForKey(client *s3.Client, bucket, key string) (KeyVersions, error) {
+func getAllVersionsForKey(ctx context.Context, client s3.ListObjectVersionsAPIClient, bucket, key string) (KeyVersions, error) {
 	var result KeyVersions
-	var continuationToken *string
+	var continuationToken, versionIDMarker *string
 	
 	for {
-		resp, err := client.ListObjectVersions(context.TODO(), &s3.ListObjectVersionsInput{
+		resp, err := client.ListObjectVersions(ctx, &s3.ListObjectVersionsInput{
 			Bucket: aws.String(bucket),
 			Prefix: aws.String(key),
 			KeyMarker: continuationToken,
+			VersionIdMarker: versionIDMarker,
 		})
 
 		if err != nil {
@@ -430,72 +353,55 @@ func getAllVersionsForKey(client *s3.Client, bucket, key string) (KeyVersions, e
 		}
 		
 		continuationToken = resp.NextKeyMarker
+		versionIDMarker = resp.NextVersionIdMarker
 	}
 
 	return result, nil
 }
 
-// isFirstVersionOfKey は指定されたバージョンIDがキーの最初のバージョンかどうかを判定します
-func isFirstVersionOfKey(versions []s3types.ObjectVersion, versionID string) bool {
-	if len(versions) == 0 {
-		return false
-	}
-	
-	var oldestVersion *s3types.ObjectVersion
-	var oldestTime time.Time
-	
-	for i, v := range versions {
-		if i == 0 || v.LastModified.Before(oldestTime) {
-			oldestVersion = &versions[i]
-			oldestTime = *v.LastModified
-		}
-	}
-	
-	return oldestVersion != nil && *oldestVersion.VersionId == versionID
-}
-
-// findLatestVersionBeforeTimestamp は指定された時間より前の最新バージョンを探します
-func findLatestVersionBeforeTimestamp(versions []s3types.ObjectVersion, key string, timestamp time.Time) string {
-	var latestVersion string
-	var latestTime time.Time
-	
-	for _, v := range versions {
-		if *v.Key == key && v.LastModified.Before(timestamp) {
-			if latestVersion == "" || v.LastModified.After(latestTime) {
-				latestVersion = *v.VersionId
-				latestTime = *v.LastModified
-			}
-		}
-	}
-	
-	return latestVersion
-}
-
 // FileChangesWriter はファイルに変更リストを書き込むための構造体
+// 出力先はローカルファイルまたは s3://bucket/key 形式のURIで、gzip/zstdでの圧縮に対応しています
+// 変更リストはヘッダーとサマリー（件数とチェックサム）を持つエンベロープ形式で書き込まれます
 type FileChangesWriter struct {
-	file    *os.File
-	encoder *json.Encoder
-	first   bool
-	mu      sync.Mutex
+	file     io.WriteCloser
+	first    bool
+	count    int
+	checksum *changeListChecksum
+	mu       sync.Mutex
 }
 
 // NewFileChangesWriter は新しいFileChangesWriterを作成します
+// 圧縮形式は拡張子（.gz, .zst）から判定します
 func NewFileChangesWriter(filePath string) (*FileChangesWriter, error) {
-	file, err := os.Create(filePath)
+	return NewFileChangesWriterWithOptions(context.Background(), filePath, ChangesFileOptions{})
+}
+
+// NewFileChangesWriterWithOptions はオプションを指定して新しいFileChangesWriterを作成します
+// s3:// の出力先へのアップロードは ctx が中断されると中止します
+func NewFileChangesWriterWithOptions(ctx context.Context, filePath string, opts ChangesFileOptions) (*FileChangesWriter, error) {
+	file, err := createChangesOutput(ctx, filePath, opts)
 	if err != nil {
 		return nil, err
 	}
 	
-	// JSONの配列開始を書き込む
-	if _, err := file.Write([]byte("[\n")); err != nil {
+	var header ChangeListHeader
+	if opts.Header != nil {
+		header = *opts.Header
+	}
+	if header.GeneratedAt.IsZero() {
+		header.GeneratedAt = time.Now().UTC()
+	}
+	
+	// ヘッダーとJSONの配列開始を書き込む
+	if err := writeChangeListPreamble(file, header); err != nil {
 		file.Close()
 		return nil, err
 	}
 	
 	return &FileChangesWriter{
-		file:    file,
-		encoder: json.NewEncoder(file),
-		first:   true,
+		file:     file,
+		first:    true,
+		checksum: newChangeListChecksum(),
 	}, nil
 }
 
@@ -519,7 +425,6 @@ func (w *FileChangesWriter) WriteChanges(changes []ObjectChange) error {
 			return err
 		}
 		
-		// JSONエンコーダーはデフォルトで改行を追加するので、それを削除
 		jsonData, err := json.Marshal(change)
 		if err != nil {
 			return err
@@ -528,15 +433,21 @@ func (w *FileChangesWriter) WriteChanges(changes []ObjectChange) error {
 		if _, err := w.file.Write(jsonData); err != nil {
 			return err
 		}
+		
+		w.checksum.add(jsonData)
+		w.count++
 	}
 	
 	return nil
 }
 
-// Close はファイルを閉じます
+// Close はサマリーを書き込んでファイルを閉じます
 func (w *FileChangesWriter) Close() error {
-	// JSONの配列終了を書き込む
-	if _, err := w.file.Write([]byte("\n]\n")); err != nil {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	
+	// JSONの配列終了とサマリーを書き込む
+	if err := writeChangeListTrailer(w.file, w.count, w.checksum.String()); err != nil {
 		w.file.Close()
 		return err
 	}
@@ -0,0 +1,169 @@
+package s3
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// DefaultLookahead は変更リストを時刻の順に並べ替えるために先読みする変更の件数
+const DefaultLookahead = 100000
+
+// orderedChange は先読みした変更と読み込んだ順番
+type orderedChange struct {
+	change ObjectChange
+	seq    int
+}
+
+// orderedChangeHeap は時刻が早い順（同時刻は読み込んだ順）に変更を取り出すヒープ
+type orderedChangeHeap []orderedChange
+
+func (h orderedChangeHeap) Len() int { return len(h) }
+
+func (h orderedChangeHeap) Less(i, j int) bool {
+	if !h[i].change.Timestamp.Equal(h[j].change.Timestamp) {
+		return h[i].change.Timestamp.Before(h[j].change.Timestamp)
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h orderedChangeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *orderedChangeHeap) Push(x any) { *h = append(*h, x.(orderedChange)) }
+
+func (h *orderedChangeHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// timeOrderedChanges は変更リストを一定の件数だけ先読みし、時刻の順に並べ替えて返します
+// 絞り込みの条件に一致しない変更は読み込んだ時点で除き、位置にも数えません
+// 変更リストが時刻の順に並んでいれば、先読みの件数に関わらず全体を並べ替えた場合と同じ順になります
+// 先読みの範囲を超えて順序が逆転している変更は、並べ替えられずに遅れて返されます
+type timeOrderedChanges struct {
+	reader     *changeListReader
+	lookahead  int
+	filter     *ChangeFilter
+	pending    orderedChangeHeap
+	seq        int
+	emitted    int
+	latest     time.Time // これまでに返した変更の最も遅い時刻
+	outOfOrder int       // 先読みの範囲を超えて順序が逆転していた変更の件数
+	filtered   int       // 絞り込みの条件に一致せずに除いた変更の件数
+	eof        bool
+}
+
+func newTimeOrderedChanges(reader *changeListReader, lookahead int, filter *ChangeFilter) *timeOrderedChanges {
+	if lookahead <= 0 {
+		lookahead = DefaultLookahead
+	}
+	return &timeOrderedChanges{reader: reader, lookahead: lookahead, filter: filter}
+}
+
+// Next は次の変更と、時刻の順に並べたときの位置を返します。全ての変更を返した場合は io.EOF を返します
+func (o *timeOrderedChanges) Next() (replayEntry, error) {
+	for !o.eof && len(o.pending) < o.lookahead {
+		change, err := o.reader.Next()
+		if errors.Is(err, io.EOF) {
+			o.eof = true
+			break
+		}
+		if err != nil {
+			return replayEntry{}, err
+		}
+		if !o.filter.Match(change) {
+			o.filtered++
+			continue
+		}
+
+		if o.emitted > 0 && change.Timestamp.Before(o.latest) {
+			o.outOfOrder++
+			slog.Warn("先読みの範囲を超えて時刻の順序が逆転している変更があります。予定より遅れて実行されます",
+				"key", change.Key, "timestamp", change.Timestamp, "lookahead", o.lookahead)
+		}
+		heap.Push(&o.pending, orderedChange{change: change, seq: o.seq})
+		o.seq++
+	}
+
+	if len(o.pending) == 0 {
+		return replayEntry{}, io.EOF
+	}
+
+	item := heap.Pop(&o.pending).(orderedChange)
+	entry := replayEntry{Index: o.emitted, Change: item.change}
+	o.emitted++
+	if item.change.Timestamp.After(o.latest) {
+		o.latest = item.change.Timestamp
+	}
+	return entry, nil
+}
+
+// scanChangeList は変更リストを先頭から読み込み、時刻の順に並べたときの件数とチェックサムを計算します
+// 進捗ファイルとの照合とエンベロープ形式のサマリーの検証のために、リプレイの前に変更リスト全体をメモリに読み込まずに確認します
+// 絞り込みの条件に一致する変更のみを数えるため、条件を変えて再開すると進捗ファイルと一致しません
+func scanChangeList(ctx context.Context, opts ReplayOptions) (int, string, error) {
+	reader, err := openChangeListReader(ctx, opts.SourceFile, opts.changeListOptions())
+	if err != nil {
+		return 0, "", err
+	}
+	defer reader.Close()
+
+	ordered := newTimeOrderedChanges(reader, opts.Lookahead, opts.Filter)
+	checksum := newChangeListChecksum()
+	count := 0
+	for {
+		entry, err := ordered.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, "", err
+		}
+
+		record, err := json.Marshal(entry.Change)
+		if err != nil {
+			return 0, "", err
+		}
+		checksum.add(record)
+		count++
+	}
+
+	return count, checksum.String(), nil
+}
+
+// replayEventsWriter は各イベントの結果を1行に1件のJSONとしてファイルに書き込みます
+type replayEventsWriter struct {
+	output  io.WriteCloser
+	encoder *json.Encoder
+}
+
+// newReplayEventsWriter はイベントの結果の書き込み先を作成します
+// filePath には s3://bucket/key 形式のURIも指定でき、拡張子が .gz または .zst の場合は圧縮します
+func newReplayEventsWriter(ctx context.Context, filePath string) (*replayEventsWriter, error) {
+	output, err := createChangesOutput(ctx, filePath, ChangesFileOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("イベントの結果のファイルの作成に失敗しました: %w", err)
+	}
+	return &replayEventsWriter{output: output, encoder: json.NewEncoder(output)}, nil
+}
+
+// Write はイベントの結果を書き込みます
+func (w *replayEventsWriter) Write(event ReplayEvent) error {
+	if err := w.encoder.Encode(event); err != nil {
+		return fmt.Errorf("イベントの結果の書き込みに失敗しました: %w", err)
+	}
+	return nil
+}
+
+// Close はファイルを閉じます
+func (w *replayEventsWriter) Close() error {
+	return w.output.Close()
+}
@@ -0,0 +1,171 @@
+package s3
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestNDJSON は変更を1行に1件のJSONとして書き込みます
+func writeTestNDJSON(t *testing.T, filePath string, changes []ObjectChange) {
+	t.Helper()
+
+	var sb strings.Builder
+	for _, change := range changes {
+		data, err := json.Marshal(change)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb.Write(data)
+		sb.WriteByte('\n')
+	}
+	if err := os.WriteFile(filePath, []byte(sb.String()), 0o644); err != nil {
+		t.Fatalf("ファイルの書き込みに失敗しました: %v", err)
+	}
+}
+
+func TestTimeOrderedChanges(t *testing.T) {
+	base := time.Date(2025, 6, 5, 10, 0, 0, 0, time.UTC)
+	// 先読みの範囲内の逆転は並べ替え、範囲外の逆転（k5）は読み込んだ時点で最も早い変更として遅れて返す
+	offsets := []int{1, 0, 3, 2, 4, 6, 7, 8, 9, 5}
+	var changes []ObjectChange
+	for i, offset := range offsets {
+		changes = append(changes, ObjectChange{Key: fmt.Sprintf("k%d", offset), VersionID: fmt.Sprint(i), ChangeType: ChangeTypeCreate, Timestamp: base.Add(time.Duration(offset) * time.Second)})
+	}
+	filePath := filepath.Join(t.TempDir(), "changes.ndjson")
+	writeTestNDJSON(t, filePath, changes)
+
+	reader, err := openChangeListReader(context.Background(), filePath, ChangesFileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	ordered := newTimeOrderedChanges(reader, 2, nil)
+	var keys []string
+	for {
+		entry, err := ordered.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if entry.Index != len(keys) {
+			t.Errorf("Index = %d, want %d", entry.Index, len(keys))
+		}
+		keys = append(keys, entry.Change.Key)
+	}
+
+	want := "k0,k1,k2,k3,k4,k6,k7,k8,k5,k9"
+	if got := strings.Join(keys, ","); got != want {
+		t.Errorf("順序 = %s, want %s", got, want)
+	}
+	if ordered.outOfOrder != 1 {
+		t.Errorf("順序が逆転した変更の数 = %d, want 1", ordered.outOfOrder)
+	}
+}
+
+// TestReplayEventsFile は各イベントの結果をファイルに書き込み、メモリに保持しないことを確認します
+func TestReplayEventsFile(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2025, 6, 5, 10, 0, 0, 0, time.UTC)
+	var changes []ObjectChange
+	for i := 0; i < 50; i++ {
+		changes = append(changes, ObjectChange{Key: fmt.Sprintf("k%d", i%7), VersionID: fmt.Sprint(i), ChangeType: ChangeTypeUpdate, Timestamp: base.Add(time.Duration(i) * time.Millisecond)})
+	}
+	changesFile := filepath.Join(dir, "changes.ndjson")
+	writeTestNDJSON(t, changesFile, changes)
+	eventsFile := filepath.Join(dir, "events.ndjson")
+
+	result, err := Replay(context.Background(), ReplayOptions{
+		DestBucket:        "staging",
+		SourceFile:        changesFile,
+		Concurrency:       4,
+		DryRun:            true,
+		IgnoreTimeWindows: true,
+		Lookahead:         5,
+		EventsFile:        eventsFile,
+		DestBackend:       newTestLocalBackend(t, filepath.Join(dir, "dest")),
+	})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if result.TotalEvents != len(changes) || result.SkippedEvents != len(changes) {
+		t.Errorf("イベント数 = %d (スキップ %d), want %d", result.TotalEvents, result.SkippedEvents, len(changes))
+	}
+	if result.Events != nil || result.EventsFile != eventsFile {
+		t.Errorf("イベントの結果がメモリに保持されています: %d件", len(result.Events))
+	}
+
+	file, err := os.Open(eventsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// 同一キーのイベントは変更リストの順に書き込まれる
+	last := make(map[string]int)
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event ReplayEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("イベントの結果のデコードに失敗しました: %v", err)
+		}
+		if prev, ok := last[event.Change.Key]; ok && event.Index < prev {
+			t.Errorf("%s: イベント %d が %d より後に書き込まれました", event.Change.Key, prev, event.Index)
+		}
+		last[event.Change.Key] = event.Index
+		count++
+	}
+	if count != len(changes) {
+		t.Errorf("書き込まれたイベント数 = %d, want %d", count, len(changes))
+	}
+}
+
+// TestReplayStreamChecksumMismatch はチェックサムが一致しない場合に変更を実行せずにエラーを返すことを確認します
+// 実行前の検証を省略した場合は、最後に検証したチェックサムが一致しなければ途中までの結果とともにエラーを返します
+func TestReplayStreamChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	changesFile := filepath.Join(dir, "changes.json")
+	content := `{"schemaVersion": 2, "header": {}, "changes": [{"key": "a", "versionId": "v1", "changeType": "CREATE", "timestamp": "2025-06-05T10:00:00Z", "isDeleteMarker": false}],
+"summary": {"recordCount": 1, "checksum": "sha256:0000"}}`
+	if err := os.WriteFile(changesFile, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := ReplayOptions{
+		DestBucket:        "staging",
+		SourceFile:        changesFile,
+		DryRun:            true,
+		IgnoreTimeWindows: true,
+		Lookahead:         1,
+		DestBackend:       newTestLocalBackend(t, filepath.Join(dir, "dest")),
+	}
+
+	result, err := Replay(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), "チェックサムが一致しません") {
+		t.Fatalf("Replay() error = %v, want checksum error", err)
+	}
+	if result != nil {
+		t.Errorf("実行前の検証で失敗した場合に結果が返されました: %+v", result)
+	}
+
+	opts.SkipPrevalidation = true
+	result, err = Replay(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), "チェックサムが一致しません") {
+		t.Fatalf("SkipPrevalidation: Replay() error = %v, want checksum error", err)
+	}
+	if result == nil || result.SkippedEvents != 1 {
+		t.Errorf("途中までの結果 = %+v", result)
+	}
+}
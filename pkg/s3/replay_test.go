@@ -4,105 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 )
 
-// S3ClientMock はS3クライアントのモック
-type S3ClientMock struct {
-	CopyObjectCalls    []CopyObjectInput
-	DeleteObjectCalls  []DeleteObjectInput
-	GetObjectCalls     []GetObjectInput
-	CopyObjectFunc     func(ctx context.Context, params *CopyObjectInput) (*CopyObjectOutput, error)
-	DeleteObjectFunc   func(ctx context.Context, params *DeleteObjectInput) (*DeleteObjectOutput, error)
-	GetObjectFunc      func(ctx context.Context, params *GetObjectInput) (*GetObjectOutput, error)
-}
-
-type CopyObjectInput struct {
-	Bucket     string
-	Key        string
-	CopySource string
-}
-
-type DeleteObjectInput struct {
-	Bucket string
-	Key    string
-}
-
-type GetObjectInput struct {
-	Bucket    string
-	Key       string
-	VersionId string
-}
-
-type CopyObjectOutput struct{}
-type DeleteObjectOutput struct{}
-type GetObjectOutput struct{}
-
-func (m *S3ClientMock) CopyObject(ctx context.Context, params *CopyObjectInput) (*CopyObjectOutput, error) {
-	m.CopyObjectCalls = append(m.CopyObjectCalls, *params)
-	if m.CopyObjectFunc != nil {
-		return m.CopyObjectFunc(ctx, params)
-	}
-	return &CopyObjectOutput{}, nil
-}
-
-func (m *S3ClientMock) DeleteObject(ctx context.Context, params *DeleteObjectInput) (*DeleteObjectOutput, error) {
-	m.DeleteObjectCalls = append(m.DeleteObjectCalls, *params)
-	if m.DeleteObjectFunc != nil {
-		return m.DeleteObjectFunc(ctx, params)
-	}
-	return &DeleteObjectOutput{}, nil
-}
-
-func (m *S3ClientMock) GetObject(ctx context.Context, params *GetObjectInput) (*GetObjectOutput, error) {
-	m.GetObjectCalls = append(m.GetObjectCalls, *params)
-	if m.GetObjectFunc != nil {
-		return m.GetObjectFunc(ctx, params)
-	}
-	return &GetObjectOutput{}, nil
-}
-
-// mockExecuteChange はテスト用のexecuteChange関数
-func mockExecuteChange(client interface{}, sourceBucket, destBucket string, change ObjectChange) error {
-	mock, ok := client.(*S3ClientMock)
-	if !ok {
-		return nil
-	}
-
-	switch change.ChangeType {
-	case ChangeTypeCreate, ChangeTypeUpdate:
-		copySource := fmt.Sprintf("%s/%s", sourceBucket, change.Key)
-		if change.VersionID != "" {
-			copySource += "?versionId=" + change.VersionID
-		}
-		mock.CopyObject(context.TODO(), &CopyObjectInput{
-			Bucket:     destBucket,
-			Key:        change.Key,
-			CopySource: copySource,
-		})
-	case ChangeTypeDelete:
-		mock.DeleteObject(context.TODO(), &DeleteObjectInput{
-			Bucket: destBucket,
-			Key:    change.Key,
-		})
-	case ChangeTypeUndelete:
-		if change.PreviousVersionID != "" {
-			copySource := fmt.Sprintf("%s/%s?versionId=%s", sourceBucket, change.Key, change.PreviousVersionID)
-			mock.CopyObject(context.TODO(), &CopyObjectInput{
-				Bucket:     destBucket,
-				Key:        change.Key,
-				CopySource: copySource,
-			})
-		}
-	}
-	return nil
-}
-
 // テスト用の変更リストを作成する関数
 func createTestChangesList(t *testing.T) string {
 	// テスト用の時間を設定
@@ -157,14 +68,15 @@ func createTestChangesList(t *testing.T) string {
 	return filePath
 }
 
-// TestLoadChangesFromFile は変更リストの読み込みをテストする
-func TestLoadChangesFromFile(t *testing.T) {
+// TestLoadChangeList_Array はヘッダーを持たない配列の変更リストの読み込みをテストする
+func TestLoadChangeList_Array(t *testing.T) {
 	filePath := createTestChangesList(t)
 
-	changes, err := loadChangesFromFile(filePath)
+	list, err := loadChangeList(context.Background(), filePath, ChangesFileOptions{})
 	if err != nil {
 		t.Fatalf("変更リストの読み込みに失敗しました: %v", err)
 	}
+	changes := list.Changes
 
 	if len(changes) != 3 {
 		t.Errorf("変更リストの長さが期待と異なります: got %d, want %d", len(changes), 3)
@@ -182,99 +94,117 @@ func TestLoadChangesFromFile(t *testing.T) {
 	}
 }
 
-// TestExecuteChange は変更の実行をテストする
-func TestExecuteChange(t *testing.T) {
-	tests := []struct {
-		name       string
-		change     ObjectChange
-		wantCopy   bool
-		wantDelete bool
-	}{
-		{
-			name: "CREATE操作",
-			change: ObjectChange{
-				Key:        "test/file1.txt",
-				VersionID:  "v1",
-				ChangeType: ChangeTypeCreate,
-			},
-			wantCopy:   true,
-			wantDelete: false,
-		},
-		{
-			name: "UPDATE操作",
-			change: ObjectChange{
-				Key:        "test/file2.txt",
-				VersionID:  "v2",
-				ChangeType: ChangeTypeUpdate,
-			},
-			wantCopy:   true,
-			wantDelete: false,
-		},
-		{
-			name: "DELETE操作",
-			change: ObjectChange{
-				Key:        "test/file3.txt",
-				VersionID:  "v3",
-				ChangeType: ChangeTypeDelete,
-			},
-			wantCopy:   false,
-			wantDelete: true,
-		},
-		{
-			name: "UNDELETE操作",
-			change: ObjectChange{
-				Key:              "test/file4.txt",
-				VersionID:        "v4",
-				ChangeType:       ChangeTypeUndelete,
-				PreviousVersionID: "v3",
-			},
-			wantCopy:   true,
-			wantDelete: false,
-		},
+// localHistory はキーのバージョンの履歴を古い順に返します（削除マーカーは "<削除>"、それ以外は内容）
+func localHistory(t *testing.T, backend *LocalBackend, bucket, key string) []string {
+	t.Helper()
+	versions, err := backend.ListVersions(context.Background(), bucket, key)
+	if err != nil {
+		t.Fatalf("ListVersions(%s) error = %v", key, err)
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			// モックの作成
-			mock := &S3ClientMock{}
+	type entry struct {
+		versionID string
+		deleted   bool
+	}
+	var entries []entry
+	for _, v := range versions.Versions {
+		entries = append(entries, entry{versionID: *v.VersionId})
+	}
+	for _, dm := range versions.DeleteMarkers {
+		entries = append(entries, entry{versionID: *dm.VersionId, deleted: true})
+	}
+	// LocalBackend のバージョンIDは作成順に並ぶ
+	sort.Slice(entries, func(i, j int) bool { return entries[i].versionID < entries[j].versionID })
 
-			// テスト対象の関数を実行
-			err := mockExecuteChange(mock, "source-bucket", "dest-bucket", tt.change)
-			if err != nil {
-				t.Fatalf("executeChange() error = %v", err)
-			}
+	var history []string
+	for _, e := range entries {
+		if e.deleted {
+			history = append(history, "<削除>")
+			continue
+		}
+		content, err := backend.GetObject(context.Background(), bucket, key, e.versionID)
+		if err != nil {
+			t.Fatalf("GetObject(%s, %s) error = %v", key, e.versionID, err)
+		}
+		data, err := io.ReadAll(content.Body)
+		content.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		history = append(history, string(data))
+	}
+	return history
+}
 
-			// 期待通りの関数が呼ばれたか確認
-			if tt.wantCopy && len(mock.CopyObjectCalls) == 0 {
-				t.Errorf("CopyObject()が呼ばれませんでした")
-			}
-			if tt.wantDelete && len(mock.DeleteObjectCalls) == 0 {
-				t.Errorf("DeleteObject()が呼ばれませんでした")
-			}
+// TestExecuteChange は変更の種類ごとに宛先に反映したバージョンの履歴を確認します
+func TestExecuteChange(t *testing.T) {
+	ctx := context.Background()
+	source, err := NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	putLocalObject(t, source, "prod", "a.txt", "one")
+	putLocalObject(t, source, "prod", "a.txt", "two")
+	deleteLocalObject(t, source, "prod", "a.txt")
+	putLocalObject(t, source, "prod", "a.txt", "three")
+	versions, err := source.ListVersions(ctx, "prod", "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, v2, v3 := *versions.Versions[0].VersionId, *versions.Versions[1].VersionId, *versions.Versions[2].VersionId
+	dm := *versions.DeleteMarkers[0].VersionId
 
-			// CopyObjectの引数を確認
-			if tt.wantCopy && len(mock.CopyObjectCalls) > 0 {
-				call := mock.CopyObjectCalls[0]
-				if call.Bucket != "dest-bucket" {
-					t.Errorf("CopyObject()の宛先バケットが期待と異なります: got %s, want %s", call.Bucket, "dest-bucket")
-				}
-				if call.Key != tt.change.Key {
-					t.Errorf("CopyObject()のキーが期待と異なります: got %s, want %s", call.Key, tt.change.Key)
-				}
+	// 同じ変更を順に反映し、変更ごとに宛先の履歴を確認する
+	steps := []struct {
+		name   string
+		change ObjectChange
+		want   []string
+	}{
+		{"CREATE", ObjectChange{Key: "a.txt", VersionID: v1, ChangeType: ChangeTypeCreate}, []string{"one"}},
+		{"UPDATE", ObjectChange{Key: "a.txt", VersionID: v2, ChangeType: ChangeTypeUpdate, PreviousVersionID: v1}, []string{"one", "two"}},
+		{"DELETE", ObjectChange{Key: "a.txt", VersionID: dm, ChangeType: ChangeTypeDelete, IsDeleteMarker: true}, []string{"one", "two", "<削除>"}},
+		{"RECREATE", ObjectChange{Key: "a.txt", VersionID: v3, ChangeType: ChangeTypeRecreate}, []string{"one", "two", "<削除>", "three"}},
+		{"UNDELETE", ObjectChange{Key: "a.txt", VersionID: dm, ChangeType: ChangeTypeUndelete, PreviousVersionID: v2}, []string{"one", "two", "<削除>", "three", "two"}},
+	}
+
+	tests := []struct {
+		name string
+		dest func() *LocalBackend
+	}{
+		// 同じストレージへはサーバーサイドコピー、別のストレージへはストリーミングコピーで反映する
+		{"同じストレージ", func() *LocalBackend { return source }},
+		{"別のストレージ", func() *LocalBackend {
+			dest, err := NewLocalBackend(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
 			}
+			return dest
+		}},
+	}
 
-			// DeleteObjectの引数を確認
-			if tt.wantDelete && len(mock.DeleteObjectCalls) > 0 {
-				call := mock.DeleteObjectCalls[0]
-				if call.Bucket != "dest-bucket" {
-					t.Errorf("DeleteObject()の宛先バケットが期待と異なります: got %s, want %s", call.Bucket, "dest-bucket")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := tt.dest()
+			copier := newObjectCopier(source, dest)
+			for _, step := range steps {
+				if err := executeChange(ctx, copier, "prod", "staging", "stg/a.txt", step.change); err != nil {
+					t.Fatalf("%s: executeChange() error = %v", step.name, err)
 				}
-				if call.Key != tt.change.Key {
-					t.Errorf("DeleteObject()のキーが期待と異なります: got %s, want %s", call.Key, tt.change.Key)
+				if got := localHistory(t, dest, "staging", "stg/a.txt"); !reflect.DeepEqual(got, step.want) {
+					t.Fatalf("%s の後の履歴 = %v, want %v", step.name, got, step.want)
 				}
 			}
 		})
 	}
+
+	// 復元するバージョンがない場合と不明な変更タイプはエラーになる
+	copier := newObjectCopier(source, source)
+	if err := executeChange(ctx, copier, "prod", "staging", "x.txt", ObjectChange{Key: "a.txt", ChangeType: ChangeTypeUndelete}); err == nil {
+		t.Error("前のバージョンIDのない復元のエラー = nil, want error")
+	}
+	if err := executeChange(ctx, copier, "prod", "staging", "x.txt", ObjectChange{Key: "a.txt", ChangeType: "MOVE"}); err == nil {
+		t.Error("不明な変更タイプのエラー = nil, want error")
+	}
 }
 
 // TestReplayResult はリプレイ結果を出力するテスト
@@ -0,0 +1,405 @@
+package s3
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportFormat はリプレイのレポートの形式を表す列挙型
+type ReportFormat string
+
+const (
+	ReportFormatJSON  ReportFormat = "json"  // 全てのイベントを含むReplayResultのJSON
+	ReportFormatCSV   ReportFormat = "csv"   // 1行に1件のイベント
+	ReportFormatJUnit ReportFormat = "junit" // 1件のイベントを1つのテストケースとするJUnit XML
+)
+
+// ReportSpec はレポートの形式と出力先
+type ReportSpec struct {
+	Format ReportFormat
+	Path   string // ファイルパスまたは s3://bucket/key（拡張子が .gz、.zst の場合は圧縮）
+}
+
+// ParseReportSpec は "形式:パス" またはパスのみの文字列からレポートの出力先を解析します
+// 形式を省略した場合は拡張子（.json、.csv、.xml、圧縮の拡張子を除く）から判定します
+func ParseReportSpec(spec string) (ReportSpec, error) {
+	if kind, path, ok := strings.Cut(spec, ":"); ok {
+		switch format := ReportFormat(strings.ToLower(kind)); format {
+		case ReportFormatJSON, ReportFormatCSV, ReportFormatJUnit:
+			if path == "" {
+				return ReportSpec{}, fmt.Errorf("レポート %q の出力先が指定されていません", spec)
+			}
+			return ReportSpec{Format: format, Path: path}, nil
+		}
+	}
+
+	format, ok := reportFormatFromPath(spec)
+	if !ok {
+		return ReportSpec{}, fmt.Errorf("レポート %q の形式を判定できません。json:、csv:、junit: で形式を指定するか、拡張子を .json、.csv、.xml にしてください", spec)
+	}
+	return ReportSpec{Format: format, Path: spec}, nil
+}
+
+// reportFormatFromPath は拡張子からレポートの形式を判定します
+func reportFormatFromPath(path string) (ReportFormat, bool) {
+	lower := strings.ToLower(path)
+	for _, ext := range []string{".gz", ".gzip", ".zst", ".zstd"} {
+		lower = strings.TrimSuffix(lower, ext)
+	}
+	switch {
+	case strings.HasSuffix(lower, ".json"):
+		return ReportFormatJSON, true
+	case strings.HasSuffix(lower, ".csv"):
+		return ReportFormatCSV, true
+	case strings.HasSuffix(lower, ".xml"):
+		return ReportFormatJUnit, true
+	default:
+		return "", false
+	}
+}
+
+// WriteReplayReport はリプレイ結果をレポートの形式で書き込みます
+// 各イベントの結果をファイルに書き込んだ場合は、そのファイルから順に読み込んで出力します
+func WriteReplayReport(ctx context.Context, spec ReportSpec, result *ReplayResult) error {
+	output, err := createChangesOutput(ctx, spec.Path, ChangesFileOptions{})
+	if err != nil {
+		return fmt.Errorf("レポートのファイルの作成に失敗しました: %w", err)
+	}
+
+	switch spec.Format {
+	case ReportFormatJSON:
+		err = writeJSONReport(ctx, output, result)
+	case ReportFormatCSV:
+		err = writeCSVReport(ctx, output, result)
+	case ReportFormatJUnit:
+		err = writeJUnitReport(ctx, output, result)
+	default:
+		err = fmt.Errorf("不明なレポートの形式です: %s", spec.Format)
+	}
+	if err != nil {
+		output.Close()
+		return fmt.Errorf("レポートの書き込みに失敗しました: %w", err)
+	}
+
+	if err := output.Close(); err != nil {
+		return fmt.Errorf("レポートの書き込みに失敗しました: %w", err)
+	}
+	return nil
+}
+
+// forEachReplayEvent はリプレイ結果の各イベントを順に渡します
+// 結果をメモリに保持していない場合は、イベントの結果のファイルを先頭から読み込みます
+func forEachReplayEvent(ctx context.Context, result *ReplayResult, fn func(ReplayEvent) error) error {
+	if result.EventsFile == "" {
+		for _, event := range result.Events {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	input, err := openChangesInput(ctx, result.EventsFile, ChangesFileOptions{})
+	if err != nil {
+		return fmt.Errorf("イベントの結果のファイルのオープンに失敗しました: %w", err)
+	}
+	defer input.Close()
+
+	decoder := json.NewDecoder(input)
+	for {
+		var event ReplayEvent
+		if err := decoder.Decode(&event); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("イベントの結果の読み込みに失敗しました: %w", err)
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+}
+
+// writeJSONReport は全てのイベントを含むリプレイ結果をJSONで書き込みます
+// イベントは1行に1件ずつ書き込み、全体をメモリに保持しません
+func writeJSONReport(ctx context.Context, w io.Writer, result *ReplayResult) error {
+	// 集計の項目のみを先に書き込む（浅い階層の events が ReplayResult の events より優先される）
+	summary, err := json.Marshal(struct {
+		*ReplayResult
+		Events []ReplayEvent `json:"events,omitempty"`
+	}{ReplayResult: result})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(summary[:len(summary)-1]); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, `,"events":[`); err != nil {
+		return err
+	}
+
+	first := true
+	err = forEachReplayEvent(ctx, result, func(event ReplayEvent) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		separator := ",\n"
+		if first {
+			separator = "\n"
+			first = false
+		}
+		if _, err := io.WriteString(w, separator); err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n]}\n")
+	return err
+}
+
+// csvReportHeader はCSVのレポートの列
+var csvReportHeader = []string{
+	"index", "key", "destKey", "versionId", "changeType", "timestamp",
+	"scheduledAt", "executedAt", "status", "attempts", "lagMs", "durationMs", "errorMessage", "destination", "skipReason",
+}
+
+// writeCSVReport は1行に1件のイベントをCSVで書き込みます
+func writeCSVReport(ctx context.Context, w io.Writer, result *ReplayResult) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvReportHeader); err != nil {
+		return err
+	}
+
+	err := forEachReplayEvent(ctx, result, func(event ReplayEvent) error {
+		return writer.Write([]string{
+			strconv.Itoa(event.Index),
+			event.Change.Key,
+			event.DestKey,
+			event.Change.VersionID,
+			string(event.Change.ChangeType),
+			formatReportTime(event.Change.Timestamp),
+			formatReportTime(event.ScheduledAt),
+			formatReportTime(event.ExecutedAt),
+			event.Status,
+			strconv.Itoa(event.Attempts),
+			strconv.FormatInt(event.Lag.Milliseconds(), 10),
+			strconv.FormatInt(event.Duration.Milliseconds(), 10),
+			event.ErrorMessage,
+			event.Destination,
+			event.SkipReason,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// formatReportTime はレポートに出力する時刻を整形します（ゼロ値は空文字列）
+func formatReportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// junitTestCase はJUnit XMLのテストケース
+type junitTestCase struct {
+	XMLName   xml.Name      `xml:"testcase"`
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+// junitMessage はJUnit XMLの失敗、エラー、スキップの内容
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// junitSuiteName はJUnit XMLのテストスイートの名前
+const junitSuiteName = "trav replay"
+
+// writeJUnitReport は1件のイベントを1つのテストケースとしてJUnit XMLで書き込みます
+// 失敗したイベントは failure、ドライラン、反映済みのためスキップしたイベント、中断で打ち切られたイベントは skipped になります
+// 中断された場合は、未実行のイベントの件数を error のテストケースとして追加します
+// リプレイ後に検証した場合は、不一致だったキーを failure のテストケースとして追加します
+func writeJUnitReport(ctx context.Context, w io.Writer, result *ReplayResult) error {
+	// テストスイートの属性に件数を書き込むため、先にイベントを数える
+	var tests, failures, skipped int
+	err := forEachReplayEvent(ctx, result, func(event ReplayEvent) error {
+		tests++
+		switch event.Status {
+		case "FAILED":
+			failures++
+		case "DRYRUN", "SKIPPED", "CANCELED":
+			skipped++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	errorCount := 0
+	if result.Interrupted {
+		tests++
+		errorCount++
+	}
+	if result.Verification != nil {
+		tests += len(result.Verification.Mismatches)
+		failures += len(result.Verification.Mismatches)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+
+	suitesStart := xml.StartElement{Name: xml.Name{Local: "testsuites"}}
+	suiteStart := xml.StartElement{
+		Name: xml.Name{Local: "testsuite"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "name"}, Value: junitSuiteName},
+			{Name: xml.Name{Local: "tests"}, Value: strconv.Itoa(tests)},
+			{Name: xml.Name{Local: "failures"}, Value: strconv.Itoa(failures)},
+			{Name: xml.Name{Local: "errors"}, Value: strconv.Itoa(errorCount)},
+			{Name: xml.Name{Local: "skipped"}, Value: strconv.Itoa(skipped)},
+			{Name: xml.Name{Local: "time"}, Value: formatJUnitSeconds(result.EndTime.Sub(result.StartTime))},
+			{Name: xml.Name{Local: "timestamp"}, Value: result.StartTime.Format(time.RFC3339)},
+		},
+	}
+	if err := encoder.EncodeToken(suitesStart); err != nil {
+		return err
+	}
+	if err := encoder.EncodeToken(suiteStart); err != nil {
+		return err
+	}
+
+	err = forEachReplayEvent(ctx, result, func(event ReplayEvent) error {
+		return encoder.Encode(newJUnitTestCase(event))
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.Verification != nil {
+		for _, mismatch := range result.Verification.Mismatches {
+			if err := encoder.Encode(newJUnitVerifyTestCase(mismatch)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if result.Interrupted {
+		testCase := junitTestCase{
+			Name:      "replay",
+			ClassName: junitSuiteName,
+			Time:      formatJUnitSeconds(0),
+			Error: &junitMessage{
+				Message: fmt.Sprintf("リプレイが中断されました（未実行: %d件）", result.CanceledEvents),
+				Type:    "INTERRUPTED",
+			},
+		}
+		if err := encoder.Encode(testCase); err != nil {
+			return err
+		}
+	}
+
+	if err := encoder.EncodeToken(suiteStart.End()); err != nil {
+		return err
+	}
+	if err := encoder.EncodeToken(suitesStart.End()); err != nil {
+		return err
+	}
+	if err := encoder.Flush(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// newJUnitTestCase はイベントの結果からテストケースを作成します
+func newJUnitTestCase(event ReplayEvent) junitTestCase {
+	key := event.Change.Key
+	if event.DestKey != "" && event.DestKey != key {
+		key = fmt.Sprintf("%s -> %s", key, event.DestKey)
+	}
+
+	// 複数の宛先にリプレイした場合は宛先ごとにクラスを分ける
+	className := junitSuiteName
+	if event.Destination != "" {
+		className = junitSuiteName + "." + event.Destination
+	}
+
+	testCase := junitTestCase{
+		Name:      fmt.Sprintf("#%d %s %s", event.Index, event.Change.ChangeType, key),
+		ClassName: className,
+		Time:      formatJUnitSeconds(event.Duration),
+	}
+
+	var details []string
+	details = append(details, fmt.Sprintf("versionId: %s", event.Change.VersionID))
+	details = append(details, fmt.Sprintf("timestamp: %s", formatReportTime(event.Change.Timestamp)))
+	details = append(details, fmt.Sprintf("lag: %s", event.Lag))
+	if event.Attempts > 1 {
+		details = append(details, fmt.Sprintf("attempts: %d", event.Attempts))
+	}
+	if event.Handler != nil {
+		details = append(details, fmt.Sprintf("handler: %s", event.Handler))
+	}
+	testCase.SystemOut = strings.Join(details, "\n")
+
+	switch event.Status {
+	case "FAILED":
+		testCase.Failure = &junitMessage{Message: event.ErrorMessage, Type: event.Status, Text: event.ErrorMessage}
+	case "DRYRUN":
+		testCase.Skipped = &junitMessage{Message: "ドライラン"}
+	case "SKIPPED":
+		testCase.Skipped = &junitMessage{Message: "宛先に反映済み: " + event.SkipReason}
+	case "CANCELED":
+		testCase.Skipped = &junitMessage{Message: "中断により打ち切られました: " + event.ErrorMessage}
+	}
+	return testCase
+}
+
+// newJUnitVerifyTestCase はリプレイ後の検証で不一致だったキーを失敗したテストケースにします
+func newJUnitVerifyTestCase(mismatch VerifyMismatch) junitTestCase {
+	className := junitSuiteName + ".verify"
+	if mismatch.Destination != "" {
+		className += "." + mismatch.Destination
+	}
+
+	return junitTestCase{
+		Name:      fmt.Sprintf("verify %s", mismatch.Key),
+		ClassName: className,
+		Time:      formatJUnitSeconds(0),
+		Failure:   &junitMessage{Message: mismatch.Reason, Type: "MISMATCH", Text: mismatch.Reason},
+		SystemOut: fmt.Sprintf("sourceKey: %s\nchangeType: %s\nversionId: %s", mismatch.SourceKey, mismatch.ChangeType, mismatch.VersionID),
+	}
+}
+
+// formatJUnitSeconds はJUnit XMLの time 属性の秒数を整形します
+func formatJUnitSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,184 @@
+package s3
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestReplayResult はレポートのテスト用のリプレイ結果を作成します
+func newTestReplayResult() *ReplayResult {
+	base := time.Date(2025, 6, 5, 10, 0, 0, 0, time.UTC)
+	return &ReplayResult{
+		TotalEvents:   3,
+		SuccessEvents: 1,
+		FailedEvents:  1,
+		SkippedEvents: 1,
+		StartTime:     base,
+		EndTime:       base.Add(3 * time.Second),
+		Events: []ReplayEvent{
+			{Index: 0, Change: ObjectChange{Key: "a.txt", VersionID: "v1", ChangeType: ChangeTypeCreate, Timestamp: base}, DestKey: "a.txt", ExecutedAt: base, Status: "SUCCESS", Lag: 5 * time.Millisecond, Duration: 120 * time.Millisecond},
+			{Index: 1, Change: ObjectChange{Key: "b.txt", VersionID: "v2", ChangeType: ChangeTypeDelete, Timestamp: base}, DestKey: "copy/b.txt", ExecutedAt: base, Status: "FAILED", ErrorMessage: "access denied <403>", Attempts: 3, Duration: 2 * time.Second},
+			{Index: 2, Change: ObjectChange{Key: "c.txt", VersionID: "v3", ChangeType: ChangeTypeUpdate, Timestamp: base}, DestKey: "c.txt", Status: "DRYRUN"},
+		},
+		DetailedResults: true,
+	}
+}
+
+func TestParseReportSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    ReportSpec
+		wantErr bool
+	}{
+		{spec: "junit:out/report.txt", want: ReportSpec{Format: ReportFormatJUnit, Path: "out/report.txt"}},
+		{spec: "CSV:s3://bucket/report.csv", want: ReportSpec{Format: ReportFormatCSV, Path: "s3://bucket/report.csv"}},
+		{spec: "report.json.gz", want: ReportSpec{Format: ReportFormatJSON, Path: "report.json.gz"}},
+		{spec: "s3://bucket/report.xml", want: ReportSpec{Format: ReportFormatJUnit, Path: "s3://bucket/report.xml"}},
+		{spec: "report.txt", wantErr: true},
+		{spec: "json:", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseReportSpec(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseReportSpec(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseReportSpec(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestWriteReplayReportJSON(t *testing.T) {
+	result := newTestReplayResult()
+	filePath := filepath.Join(t.TempDir(), "report.json")
+	if err := WriteReplayReport(context.Background(), ReportSpec{Format: ReportFormatJSON, Path: filePath}, result); err != nil {
+		t.Fatalf("WriteReplayReport() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ReplayResult
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("レポートがJSONとして読み込めません: %v\n%s", err, data)
+	}
+	if got.TotalEvents != 3 || got.FailedEvents != 1 {
+		t.Errorf("集計 = %+v", got)
+	}
+	if len(got.Events) != 3 {
+		t.Fatalf("len(Events) = %d, want 3", len(got.Events))
+	}
+	if got.Events[0].Duration != 120*time.Millisecond || got.Events[0].Lag != 5*time.Millisecond {
+		t.Errorf("Events[0] = %+v", got.Events[0])
+	}
+}
+
+func TestWriteReplayReportCSVFromEventsFile(t *testing.T) {
+	// 各イベントの結果をファイルに書き込んだ場合は、そのファイルから読み込んで出力する
+	dir := t.TempDir()
+	result := newTestReplayResult()
+	result.EventsFile = filepath.Join(dir, "events.ndjson.gz")
+	writer, err := newReplayEventsWriter(context.Background(), result.EventsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range result.Events {
+		if err := writer.Write(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	result.Events = nil
+	result.DetailedResults = false
+
+	filePath := filepath.Join(dir, "report.csv")
+	if err := WriteReplayReport(context.Background(), ReportSpec{Format: ReportFormatCSV, Path: filePath}, result); err != nil {
+		t.Fatalf("WriteReplayReport() error = %v", err)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("len(records) = %d, want 4", len(records))
+	}
+	if got := strings.Join(records[0], ","); got != strings.Join(csvReportHeader, ",") {
+		t.Errorf("header = %s", got)
+	}
+	failed := records[2]
+	if failed[2] != "copy/b.txt" || failed[8] != "FAILED" || failed[9] != "3" || failed[11] != "2000" || failed[12] != "access denied <403>" {
+		t.Errorf("records[2] = %v", failed)
+	}
+	if records[3][7] != "" {
+		t.Errorf("未実行のイベントの executedAt = %q, want empty", records[3][7])
+	}
+}
+
+func TestWriteReplayReportJUnit(t *testing.T) {
+	result := newTestReplayResult()
+	result.Interrupted = true
+	result.CanceledEvents = 2
+	filePath := filepath.Join(t.TempDir(), "report.xml")
+	if err := WriteReplayReport(context.Background(), ReportSpec{Format: ReportFormatJUnit, Path: filePath}, result); err != nil {
+		t.Fatalf("WriteReplayReport() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suites struct {
+		Suites []struct {
+			Tests     int             `xml:"tests,attr"`
+			Failures  int             `xml:"failures,attr"`
+			Errors    int             `xml:"errors,attr"`
+			Skipped   int             `xml:"skipped,attr"`
+			TestCases []junitTestCase `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+	if err := xml.Unmarshal(data, &suites); err != nil {
+		t.Fatalf("レポートがXMLとして読み込めません: %v\n%s", err, data)
+	}
+	if len(suites.Suites) != 1 {
+		t.Fatalf("len(testsuite) = %d, want 1", len(suites.Suites))
+	}
+	suite := suites.Suites[0]
+	if suite.Tests != 4 || suite.Failures != 1 || suite.Errors != 1 || suite.Skipped != 1 {
+		t.Errorf("testsuite = tests %d, failures %d, errors %d, skipped %d", suite.Tests, suite.Failures, suite.Errors, suite.Skipped)
+	}
+	if len(suite.TestCases) != 4 {
+		t.Fatalf("len(testcase) = %d, want 4", len(suite.TestCases))
+	}
+
+	failed := suite.TestCases[1]
+	if failed.Name != "#1 DELETE b.txt -> copy/b.txt" || failed.Time != "2.000" {
+		t.Errorf("testcase = %+v", failed)
+	}
+	if failed.Failure == nil || failed.Failure.Message != "access denied <403>" {
+		t.Errorf("failure = %+v", failed.Failure)
+	}
+	if suite.TestCases[2].Skipped == nil {
+		t.Error("ドライランのイベントが skipped になっていません")
+	}
+	if suite.TestCases[3].Error == nil {
+		t.Error("中断のテストケースが error になっていません")
+	}
+}
@@ -0,0 +1,293 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/smithy-go"
+)
+
+// リトライのデフォルト値
+const (
+	DefaultMaxAttempts    = 5
+	DefaultInitialBackoff = 200 * time.Millisecond
+	DefaultMaxBackoff     = 20 * time.Second
+)
+
+// throttleBackoffFactor はスロットリングされた場合に待機時間を延ばす倍率
+// パーティションの分割が進むまで、通常のエラーより長く待つ
+const throttleBackoffFactor = 4
+
+// throttlingErrorCodes はリクエストの頻度を下げるべきエラーコード
+var throttlingErrorCodes = map[string]bool{
+	"SlowDown":                 true,
+	"Throttling":               true,
+	"ThrottlingException":      true,
+	"ThrottledException":       true,
+	"TooManyRequests":          true,
+	"TooManyRequestsException": true,
+	"RequestLimitExceeded":     true,
+	"RequestThrottled":         true,
+}
+
+// transientErrorCodes は時間をおいて再実行すれば成功する可能性のあるエラーコード
+var transientErrorCodes = map[string]bool{
+	"InternalError":           true,
+	"ServiceUnavailable":      true,
+	"RequestTimeout":          true,
+	"RequestTimeoutException": true,
+	"OperationAborted":        true,
+}
+
+// RetryPolicy は一時的なエラーで失敗した操作を再実行する方針
+// 待機時間は試行ごとに2倍になり（最大MaxBackoff）、その半分から全体の範囲でランダムに決まります
+type RetryPolicy struct {
+	MaxAttempts    int           // 最大試行回数（1の場合は再実行しない。0の場合はDefaultMaxAttempts）
+	InitialBackoff time.Duration // 最初の再実行までの待機時間（0の場合はDefaultInitialBackoff）
+	MaxBackoff     time.Duration // 待機時間の上限（0の場合はDefaultMaxBackoff）
+}
+
+// DefaultRetryPolicy はデフォルトのリトライの方針を返します
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    DefaultMaxAttempts,
+		InitialBackoff: DefaultInitialBackoff,
+		MaxBackoff:     DefaultMaxBackoff,
+	}
+}
+
+// withDefaults は指定されていない値をデフォルト値で補います
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultMaxAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DefaultInitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultMaxBackoff
+	}
+	return p
+}
+
+// Do は操作を実行し、再実行できるエラーで失敗した場合は待機してから再実行します
+// 試行回数と最後のエラーを返します。コンテキストが終了した場合は待機を打ち切ります
+func (p RetryPolicy) Do(ctx context.Context, operation string, fn func() error) (int, error) {
+	p = p.withDefaults()
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || ctx.Err() != nil || !isRetryableError(err) {
+			return attempt, err
+		}
+
+		delay := p.backoff(attempt, isThrottlingError(err))
+		slog.Warn("一時的なエラーのため再実行します",
+			"operation", operation,
+			"attempt", attempt,
+			"maxAttempts", p.MaxAttempts,
+			"delay", delay,
+			"error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		}
+	}
+}
+
+// backoff は attempt 回目の試行が失敗した後の待機時間を返します
+func (p RetryPolicy) backoff(attempt int, throttled bool) time.Duration {
+	delay := p.InitialBackoff
+	if throttled {
+		delay *= throttleBackoffFactor
+	}
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	// 同時に失敗したリクエストが一斉に再実行しないようにばらつかせる
+	half := delay / 2
+	return half + time.Duration(rand.Int64N(int64(half)+1))
+}
+
+// isThrottlingError はエラーがスロットリング（503 SlowDownなど）によるものかどうかを判定します
+func isThrottlingError(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && throttlingErrorCodes[apiErr.ErrorCode()] {
+		return true
+	}
+
+	// SDKのリトライで再実行の上限に達した場合
+	var quotaErr ratelimit.QuotaExceededError
+	if errors.As(err, &quotaErr) {
+		return true
+	}
+
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) {
+		code := statusErr.HTTPStatusCode()
+		return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+// isRetryableError はエラーが一時的なもので、再実行すれば成功する可能性があるかどうかを判定します
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if isThrottlingError(err) {
+		return true
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && transientErrorCodes[apiErr.ErrorCode()] {
+		return true
+	}
+
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) && statusErr.HTTPStatusCode() >= http.StatusInternalServerError {
+		return true
+	}
+
+	// 接続の切断やタイムアウトなどのネットワークのエラー
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryingBackend は一時的なエラーで失敗した操作を再実行するストレージ
+// 本文を読み込みながら書き込むPutObjectは再実行できないため、そのまま実行します
+type retryingBackend struct {
+	Backend
+	policy RetryPolicy
+}
+
+// withRetry はストレージの操作を方針に従って再実行するようにします
+func withRetry(backend Backend, policy RetryPolicy) Backend {
+	if policy.withDefaults().MaxAttempts <= 1 {
+		return backend
+	}
+	return &retryingBackend{Backend: backend, policy: policy}
+}
+
+func (b *retryingBackend) ListKeys(ctx context.Context, bucket, prefix string, concurrency int) ([]string, error) {
+	if pager := b.keyPager(bucket, false); pager != nil {
+		return listAllKeys(ctx, pager, prefix, concurrency)
+	}
+
+	var keys []string
+	_, err := b.policy.Do(ctx, "ListKeys", func() error {
+		var err error
+		keys, err = b.Backend.ListKeys(ctx, bucket, prefix, concurrency)
+		return err
+	})
+	return keys, err
+}
+
+func (b *retryingBackend) ListVersionedKeys(ctx context.Context, bucket, prefix string, concurrency int) ([]string, error) {
+	if pager := b.keyPager(bucket, true); pager != nil {
+		return listAllKeys(ctx, pager, prefix, concurrency)
+	}
+
+	var keys []string
+	_, err := b.policy.Do(ctx, "ListVersionedKeys", func() error {
+		var err error
+		keys, err = b.Backend.ListVersionedKeys(ctx, bucket, prefix, concurrency)
+		return err
+	})
+	return keys, err
+}
+
+// keyPager はキー一覧の各ページの取得を再実行するkeyPagerを返します
+// 1ページの失敗で一覧全体を取得し直さないように、ページ単位で取得できるストレージではページごとに再実行します
+func (b *retryingBackend) keyPager(bucket string, versioned bool) keyPager {
+	pager := backendKeyPager(b.Backend, bucket, versioned)
+	if pager == nil {
+		return nil
+	}
+
+	operation := "ListKeys"
+	if versioned {
+		operation = "ListVersionedKeys"
+	}
+	return keyPagerFunc(func(ctx context.Context, input keyPageInput) (keyPage, error) {
+		var page keyPage
+		_, err := b.policy.Do(ctx, operation, func() error {
+			var err error
+			page, err = pager.listKeyPage(ctx, input)
+			return err
+		})
+		return page, err
+	})
+}
+
+func (b *retryingBackend) ListVersions(ctx context.Context, bucket, key string) (KeyVersions, error) {
+	var versions KeyVersions
+	_, err := b.policy.Do(ctx, "ListVersions", func() error {
+		var err error
+		versions, err = b.Backend.ListVersions(ctx, bucket, key)
+		return err
+	})
+	return versions, err
+}
+
+func (b *retryingBackend) VersioningEnabled(ctx context.Context, bucket string) (bool, error) {
+	var enabled bool
+	_, err := b.policy.Do(ctx, "VersioningEnabled", func() error {
+		var err error
+		enabled, err = b.Backend.VersioningEnabled(ctx, bucket)
+		return err
+	})
+	return enabled, err
+}
+
+func (b *retryingBackend) GetObject(ctx context.Context, bucket, key, versionID string) (*ObjectContent, error) {
+	var content *ObjectContent
+	_, err := b.policy.Do(ctx, "GetObject", func() error {
+		var err error
+		content, err = b.Backend.GetObject(ctx, bucket, key, versionID)
+		return err
+	})
+	return content, err
+}
+
+func (b *retryingBackend) HeadObject(ctx context.Context, bucket, key string) (*ObjectInfo, error) {
+	var info *ObjectInfo
+	_, err := b.policy.Do(ctx, "HeadObject", func() error {
+		var err error
+		info, err = b.Backend.HeadObject(ctx, bucket, key)
+		return err
+	})
+	return info, err
+}
+
+func (b *retryingBackend) CopyObject(ctx context.Context, srcBucket, srcKey, versionID, destBucket, destKey string) error {
+	_, err := b.policy.Do(ctx, "CopyObject", func() error {
+		return b.Backend.CopyObject(ctx, srcBucket, srcKey, versionID, destBucket, destKey)
+	})
+	return err
+}
+
+func (b *retryingBackend) DeleteObject(ctx context.Context, bucket, key string) error {
+	_, err := b.policy.Do(ctx, "DeleteObject", func() error {
+		return b.Backend.DeleteObject(ctx, bucket, key)
+	})
+	return err
+}
@@ -0,0 +1,237 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/smithy-go"
+)
+
+func TestRetryableErrorClassification(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantRetryable bool
+		wantThrottled bool
+	}{
+		{name: "SlowDown", err: &smithy.GenericAPIError{Code: "SlowDown"}, wantRetryable: true, wantThrottled: true},
+		{name: "ラップされたSlowDown", err: fmt.Errorf("コピーに失敗: %w", &smithy.GenericAPIError{Code: "SlowDown"}), wantRetryable: true, wantThrottled: true},
+		{name: "SDKの再実行の上限", err: ratelimit.QuotaExceededError{Available: 0, Requested: 5}, wantRetryable: true, wantThrottled: true},
+		{name: "InternalError", err: &smithy.GenericAPIError{Code: "InternalError"}, wantRetryable: true},
+		{name: "ネットワークのエラー", err: &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, wantRetryable: true},
+		{name: "途中で切断", err: io.ErrUnexpectedEOF, wantRetryable: true},
+		{name: "NoSuchKey", err: &smithy.GenericAPIError{Code: "NoSuchKey"}},
+		{name: "AccessDenied", err: &smithy.GenericAPIError{Code: "AccessDenied"}},
+		{name: "中断", err: fmt.Errorf("失敗: %w", context.Canceled)},
+		{name: "その他のエラー", err: errors.New("不明なエラー")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableError(tt.err); got != tt.wantRetryable {
+				t.Errorf("isRetryableError() = %v, want %v", got, tt.wantRetryable)
+			}
+			if got := isThrottlingError(tt.err); got != tt.wantThrottled {
+				t.Errorf("isThrottlingError() = %v, want %v", got, tt.wantThrottled)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	slowDown := &smithy.GenericAPIError{Code: "SlowDown"}
+
+	// 一時的なエラーの後に成功する
+	calls := 0
+	attempts, err := policy.Do(context.Background(), "test", func() error {
+		calls++
+		if calls < 3 {
+			return slowDown
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("Do() = %d, %v, want 3, nil", attempts, err)
+	}
+
+	// 最大試行回数に達した場合は最後のエラーを返す
+	attempts, err = policy.Do(context.Background(), "test", func() error { return slowDown })
+	if !errors.Is(err, slowDown) || attempts != 3 {
+		t.Errorf("Do() = %d, %v, want 3, SlowDown", attempts, err)
+	}
+
+	// 再実行できないエラーはすぐに返す
+	attempts, err = policy.Do(context.Background(), "test", func() error { return errors.New("permanent") })
+	if err == nil || attempts != 1 {
+		t.Errorf("Do() = %d, %v, want 1, error", attempts, err)
+	}
+
+	// 中断された場合は待機を打ち切る
+	ctx, cancel := context.WithCancel(context.Background())
+	long := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	attempts, _ = long.Do(ctx, "test", func() error { return slowDown })
+	if attempts != 1 || time.Since(start) > time.Second {
+		t.Errorf("中断後も待機しています: attempts=%d, elapsed=%s", attempts, time.Since(start))
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}.withDefaults()
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		delay := policy.backoff(attempt, false)
+		if delay > policy.MaxBackoff {
+			t.Errorf("backoff(%d) = %s, 上限 %s を超えています", attempt, delay, policy.MaxBackoff)
+		}
+	}
+	if delay := policy.backoff(1, false); delay < 50*time.Millisecond || delay > 100*time.Millisecond {
+		t.Errorf("backoff(1) = %s, want 50ms〜100ms", delay)
+	}
+	if delay := policy.backoff(1, true); delay < 200*time.Millisecond {
+		t.Errorf("スロットリングのbackoff(1) = %s, want 200ms以上", delay)
+	}
+}
+
+// flakyBackend は最初の数回の書き込みをSlowDownで失敗させるストレージ
+type flakyBackend struct {
+	Backend
+	failures int
+}
+
+func (b *flakyBackend) PutObject(ctx context.Context, bucket, key string, body io.Reader, metadata ObjectMetadata) error {
+	if b.failures > 0 {
+		b.failures--
+		return &smithy.GenericAPIError{Code: "SlowDown", Message: "Please reduce your request rate."}
+	}
+	return b.Backend.PutObject(ctx, bucket, key, body, metadata)
+}
+
+func (b *flakyBackend) ListKeys(ctx context.Context, bucket, prefix string, concurrency int) ([]string, error) {
+	if b.failures > 0 {
+		b.failures--
+		return nil, &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}
+	}
+	return b.Backend.ListKeys(ctx, bucket, prefix, concurrency)
+}
+
+func TestReplayRetry(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	source, _ := NewLocalBackend(filepath.Join(dir, "source"))
+	local, _ := NewLocalBackend(filepath.Join(dir, "dest"))
+	dest := &flakyBackend{Backend: local, failures: 2}
+
+	putLocalObject(t, source, "prod", "a.txt", "a1")
+	versions, _ := source.ListVersions(ctx, "prod", "a.txt")
+	changes := []ObjectChange{
+		{Key: "a.txt", VersionID: *versions.Versions[0].VersionId, ChangeType: ChangeTypeCreate, Timestamp: time.Now()},
+	}
+	changesFile := filepath.Join(dir, "changes.json")
+	data, _ := json.Marshal(changes)
+	if err := os.WriteFile(changesFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Replay(ctx, ReplayOptions{
+		SourceBucket:      "prod",
+		DestBucket:        "staging",
+		SourceFile:        changesFile,
+		Concurrency:       1,
+		IgnoreTimeWindows: true,
+		SourceBackend:     source,
+		DestBackend:       dest,
+		Retry:             RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if result.SuccessEvents != 1 || result.Events[0].Attempts != 3 {
+		t.Fatalf("成功 %d件, 試行回数 %d, want 1件, 3回: %+v", result.SuccessEvents, result.Events[0].Attempts, result.Events)
+	}
+	if got := readLocalObject(t, local, "staging", "a.txt"); got != "a1" {
+		t.Errorf("staging/a.txt = %q, want a1", got)
+	}
+}
+
+func TestRollbackRetry(t *testing.T) {
+	local, _ := NewLocalBackend(t.TempDir())
+	putLocalObject(t, local, "bucket", "a.txt", "a1")
+	midway := time.Now().UTC()
+	time.Sleep(2 * time.Millisecond)
+	putLocalObject(t, local, "bucket", "a.txt", "a2")
+
+	// 一覧の取得が一時的に失敗しても、再実行してロールバックする
+	backend := &flakyBackend{Backend: local, failures: 1}
+	err := Rollback(context.Background(), RollbackOptions{
+		Bucket:    "bucket",
+		Timestamp: midway,
+		Backend:   backend,
+		Retry:     RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if got := readLocalObject(t, local, "bucket", "a.txt"); got != "a1" {
+		t.Errorf("ロールバック後の a.txt = %q, want a1", got)
+	}
+}
+
+// pagedListBackend はキー一覧をページ単位で取得し、指定した回数目のページの取得を一時的に失敗させるストレージ
+type pagedListBackend struct {
+	Backend
+	client   *fakeListClient
+	failPage int32 // 失敗させるページの取得の回数目（0の場合は失敗させない）
+	pages    atomic.Int32
+}
+
+func (b *pagedListBackend) ListKeys(ctx context.Context, bucket, prefix string, concurrency int) ([]string, error) {
+	return listAllKeys(ctx, b.keyPager(bucket, false), prefix, concurrency)
+}
+
+func (b *pagedListBackend) keyPager(bucket string, versioned bool) keyPager {
+	pager := objectKeyPager{client: b.client, bucket: bucket}
+	return keyPagerFunc(func(ctx context.Context, input keyPageInput) (keyPage, error) {
+		if b.pages.Add(1) == b.failPage {
+			return keyPage{}, &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}
+		}
+		return pager.listKeyPage(ctx, input)
+	})
+}
+
+// TestRetryListKeysPage は一覧の途中のページの失敗でそのページだけを再実行することを確認します
+func TestRetryListKeysPage(t *testing.T) {
+	var keys []string
+	for i := 0; i < 2500; i++ {
+		keys = append(keys, fmt.Sprintf("logs/%05d", i))
+	}
+	client := newFakeListClient(keys)
+	backend := withRetry(&pagedListBackend{client: client, failPage: 2}, RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	got, err := backend.ListKeys(context.Background(), "bucket", "logs/", 1)
+	if err != nil {
+		t.Fatalf("ListKeys() error = %v", err)
+	}
+	if len(got) != len(keys) {
+		t.Errorf("キーの数 = %d, want %d", len(got), len(keys))
+	}
+	// 1000件ずつの3ページを取得し、失敗したページ以外は取得し直さない
+	if calls := client.calls.Load(); calls != 3 {
+		t.Errorf("ListObjectsV2の呼び出し回数 = %d, want 3", calls)
+	}
+}
@@ -0,0 +1,196 @@
+package s3
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// キーの書き換えルールの種類
+const (
+	RewriteRulePrefix   = "prefix"   // プレフィックスの置き換え
+	RewriteRuleRegex    = "regex"    // 正規表現による置き換え（キャプチャグループを使用可能）
+	RewriteRuleTemplate = "template" // テンプレートによるキーの生成
+)
+
+// rewriteRuleSeparator は書き換えルールの置き換え前と置き換え後の区切り
+const rewriteRuleSeparator = "=>"
+
+// rewriteRule は宛先のキーを書き換えるルール
+type rewriteRule struct {
+	kind        string
+	prefix      string
+	pattern     *regexp.Regexp
+	replacement *template.Template
+}
+
+// KeyRewriter はリプレイ先のキーを書き換えるルールの一覧
+// ルールは指定された順に適用され、各ルールには前のルールで書き換えたキーが渡されます
+type KeyRewriter struct {
+	rules []rewriteRule
+}
+
+// rewriteTemplateData はテンプレートで参照できる変更の情報
+type rewriteTemplateData struct {
+	Key        string    // 書き換え中のキー
+	SourceKey  string    // 変更元のキー
+	Dir        string    // キーのディレクトリ部分
+	Base       string    // キーのファイル名部分
+	VersionID  string    // 変更元のバージョンID
+	ChangeType string    // 変更タイプ
+	Timestamp  time.Time // 変更の時間
+}
+
+// rewriteTemplateFuncs はテンプレートで使用できる関数
+var rewriteTemplateFuncs = template.FuncMap{
+	"trimPrefix": func(s, prefix string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(s, suffix string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(s, old, new string) string { return strings.ReplaceAll(s, old, new) },
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+}
+
+// NewKeyRewriter は書き換えルールの指定からKeyRewriterを作成します
+//
+// ルールは次のいずれかの形式で指定します:
+//
+//	prefix:<元のプレフィックス>=><新しいプレフィックス>
+//	regex:<正規表現>=><置き換え後のキー>（$1、${name} でキャプチャグループを参照）
+//	template:<テンプレート>
+//
+// キーには year=2025 のように = が含まれる場合があるため、区切りには => を使用します
+// 新しいプレフィックス、置き換え後のキー、テンプレートには text/template の構文で
+// {{.Timestamp.Format "2006-01"}} のように変更の情報を埋め込めます
+func NewKeyRewriter(specs []string) (*KeyRewriter, error) {
+	rewriter := &KeyRewriter{}
+	for _, spec := range specs {
+		rule, err := parseRewriteRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rewriter.rules = append(rewriter.rules, rule)
+	}
+	return rewriter, nil
+}
+
+// parseRewriteRule は書き換えルールの指定を解析します
+func parseRewriteRule(spec string) (rewriteRule, error) {
+	kind, body, ok := strings.Cut(spec, ":")
+	if !ok {
+		return rewriteRule{}, fmt.Errorf("書き換えルール %q の形式が無効です。prefix:、regex:、template: のいずれかで指定してください", spec)
+	}
+
+	rule := rewriteRule{kind: kind}
+	var replacement string
+
+	switch kind {
+	case RewriteRulePrefix:
+		from, to, ok := strings.Cut(body, rewriteRuleSeparator)
+		if !ok || from == "" {
+			return rewriteRule{}, fmt.Errorf("書き換えルール %q の形式が無効です。prefix:<元のプレフィックス>=><新しいプレフィックス> で指定してください", spec)
+		}
+		rule.prefix = from
+		replacement = to
+	case RewriteRuleRegex:
+		expr, to, ok := strings.Cut(body, rewriteRuleSeparator)
+		if !ok || expr == "" {
+			return rewriteRule{}, fmt.Errorf("書き換えルール %q の形式が無効です。regex:<正規表現>=><置き換え後のキー> で指定してください", spec)
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return rewriteRule{}, fmt.Errorf("書き換えルール %q の正規表現が無効です: %w", spec, err)
+		}
+		rule.pattern = pattern
+		replacement = to
+	case RewriteRuleTemplate:
+		if body == "" {
+			return rewriteRule{}, fmt.Errorf("書き換えルール %q のテンプレートが空です", spec)
+		}
+		replacement = body
+	default:
+		return rewriteRule{}, fmt.Errorf("書き換えルール %q の種類 %s が無効です。prefix、regex、template のいずれかを指定してください", spec, kind)
+	}
+
+	tmpl, err := template.New(kind).Funcs(rewriteTemplateFuncs).Option("missingkey=error").Parse(replacement)
+	if err != nil {
+		return rewriteRule{}, fmt.Errorf("書き換えルール %q のテンプレートが無効です: %w", spec, err)
+	}
+	rule.replacement = tmpl
+
+	return rule, nil
+}
+
+// Rewrite は変更の宛先のキーを返します
+// ルールがない場合は変更元と同じキーを返します
+func (r *KeyRewriter) Rewrite(change ObjectChange) (string, error) {
+	key := change.Key
+	if r == nil {
+		return key, nil
+	}
+
+	for _, rule := range r.rules {
+		rewritten, err := rule.apply(key, change)
+		if err != nil {
+			return "", fmt.Errorf("キー %s の書き換えに失敗しました: %w", change.Key, err)
+		}
+		key = rewritten
+	}
+
+	if key == "" {
+		return "", fmt.Errorf("キー %s の書き換え後のキーが空です", change.Key)
+	}
+
+	return key, nil
+}
+
+// apply はルールに一致する場合にキーを書き換えます
+func (rule rewriteRule) apply(key string, change ObjectChange) (string, error) {
+	switch rule.kind {
+	case RewriteRulePrefix:
+		if !strings.HasPrefix(key, rule.prefix) {
+			return key, nil
+		}
+		replacement, err := rule.render(key, change)
+		if err != nil {
+			return "", err
+		}
+		return replacement + strings.TrimPrefix(key, rule.prefix), nil
+	case RewriteRuleRegex:
+		match := rule.pattern.FindStringSubmatchIndex(key)
+		if match == nil {
+			return key, nil
+		}
+		replacement, err := rule.render(key, change)
+		if err != nil {
+			return "", err
+		}
+		// 一致した部分のみを置き換え、キャプチャグループを展開する
+		expanded := rule.pattern.ExpandString(nil, replacement, key, match)
+		return key[:match[0]] + string(expanded) + key[match[1]:], nil
+	default:
+		return rule.render(key, change)
+	}
+}
+
+// render はテンプレートに変更の情報を埋め込みます
+func (rule rewriteRule) render(key string, change ObjectChange) (string, error) {
+	data := rewriteTemplateData{
+		Key:        key,
+		SourceKey:  change.Key,
+		Dir:        path.Dir(key),
+		Base:       path.Base(key),
+		VersionID:  change.VersionID,
+		ChangeType: string(change.ChangeType),
+		Timestamp:  change.Timestamp,
+	}
+
+	var buf bytes.Buffer
+	if err := rule.replacement.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
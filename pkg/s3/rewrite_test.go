@@ -0,0 +1,94 @@
+package s3
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyRewriter(t *testing.T) {
+	change := ObjectChange{
+		Key:        "prod/tenant-x/2025/data.json",
+		VersionID:  "v1",
+		ChangeType: ChangeTypeUpdate,
+		Timestamp:  time.Date(2026, 10, 3, 12, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name  string
+		rules []string
+		want  string
+	}{
+		{name: "ルールなし", want: "prod/tenant-x/2025/data.json"},
+		{name: "プレフィックスの置き換え", rules: []string{"prefix:prod/=>staging/"}, want: "staging/tenant-x/2025/data.json"},
+		{name: "一致しないプレフィックス", rules: []string{"prefix:dev/=>staging/"}, want: "prod/tenant-x/2025/data.json"},
+		{
+			name:  "プレフィックスにテンプレートを使用",
+			rules: []string{`prefix:prod/=>staging/replay-{{.Timestamp.Format "2006-01"}}/`},
+			want:  "staging/replay-2026-10/tenant-x/2025/data.json",
+		},
+		{
+			name:  "正規表現のキャプチャグループ",
+			rules: []string{`regex:^prod/(?P<tenant>[^/]+)/(\d+)/=>archive/${tenant}/year=$2/`},
+			want:  "archive/tenant-x/year=2025/data.json",
+		},
+		{
+			name:  "テンプレート",
+			rules: []string{`template:{{.ChangeType | lower}}/{{.Timestamp.Format "2006/01/02"}}/{{.Base}}`},
+			want:  "update/2026/10/03/data.json",
+		},
+		{
+			name:  "複数のルールを順に適用",
+			rules: []string{"prefix:prod/=>staging/", `regex:/(\d+)/=>/y$1/`},
+			want:  "staging/tenant-x/y2025/data.json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rewriter, err := NewKeyRewriter(tt.rules)
+			if err != nil {
+				t.Fatalf("NewKeyRewriter() error = %v", err)
+			}
+			got, err := rewriter.Rewrite(change)
+			if err != nil {
+				t.Fatalf("Rewrite() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Rewrite() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	var nilRewriter *KeyRewriter
+	if got, _ := nilRewriter.Rewrite(change); got != change.Key {
+		t.Errorf("nilのRewrite() = %s, want %s", got, change.Key)
+	}
+}
+
+func TestNewKeyRewriterInvalid(t *testing.T) {
+	for _, rule := range []string{
+		"prod/=>staging/",
+		"suffix:a=>b",
+		"prefix:=>staging/",
+		"regex:([a-z=>x",
+		"regex:year=2025",
+		"template:",
+		"template:{{.Key",
+	} {
+		if _, err := NewKeyRewriter([]string{rule}); err == nil {
+			t.Errorf("NewKeyRewriter(%q) がエラーになりません", rule)
+		}
+	}
+
+	rewriter, err := NewKeyRewriter([]string{"template:{{.Missing}}"})
+	if err == nil {
+		if _, err := rewriter.Rewrite(ObjectChange{Key: "a"}); err == nil {
+			t.Error("存在しないフィールドを参照してもエラーになりません")
+		}
+	}
+
+	rewriter, _ = NewKeyRewriter([]string{"regex:.*=>"})
+	if _, err := rewriter.Rewrite(ObjectChange{Key: "a"}); err == nil {
+		t.Error("書き換え後のキーが空でもエラーになりません")
+	}
+}
@@ -5,39 +5,48 @@ import (
 	"fmt"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 // デフォルトの並列処理数
 const DefaultConcurrency = 10
 
 type RollbackOptions struct {
-	Bucket      string
-	Prefix      string
-	Timestamp   time.Time
-	Concurrency int // 並列処理数
+	Bucket       string
+	Prefix       string
+	Timestamp    time.Time
+	Concurrency  int              // 並列処理数
+	DrainTimeout time.Duration    // 中断時に実行中のロールバックの完了を待つ時間（0の場合はDefaultDrainTimeout）
+	Backend      Backend          // 対象のストレージ（nilの場合はデフォルトの設定のS3）
+	Retry        RetryPolicy      // 一時的なエラーで失敗した操作の再実行の方針
+	RateLimit    RateLimitOptions // リクエスト数と転送量の制限
 }
 
 // Rollback は指定されたS3オブジェクトを指定時間以前のバージョンにロールバックします
-func Rollback(opts RollbackOptions) error {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		slog.Error("AWS設定の読み込みに失敗しました", "error", err)
-		return fmt.Errorf("AWS設定の読み込みに失敗しました: %w", err)
+func Rollback(ctx context.Context, opts RollbackOptions) error {
+	backend := opts.Backend
+	if backend == nil {
+		s3Backend, err := NewS3Backend(ctx, ClientOptions{})
+		if err != nil {
+			slog.Error("AWS設定の読み込みに失敗しました", "error", err)
+			return err
+		}
+		backend = s3Backend
 	}
-
-	client := s3.NewFromConfig(cfg)
+	// リクエストごとに制限を受け、一覧の取得やバージョンのコピーがスロットリングなどで失敗した場合は再実行する
+	limiter := NewRateLimiter(opts.RateLimit)
+	backend = withRetry(withRateLimit(backend, limiter), opts.Retry)
 
 	// 並列処理数が指定されていない場合はデフォルト値を使用
 	if opts.Concurrency <= 0 {
 		opts.Concurrency = DefaultConcurrency
 	}
 
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = DefaultDrainTimeout
+	}
+
 	// prefixが空の場合はバケット全体を対象とする
 	prefix := opts.Prefix
 	if prefix == "" {
@@ -46,43 +55,46 @@ func Rollback(opts RollbackOptions) error {
 		slog.Info("プレフィックスに一致するオブジェクトを対象としています", "bucket", opts.Bucket, "prefix", prefix)
 	}
 
-	return rollbackMultipleObjects(client, opts.Bucket, prefix, opts.Timestamp, opts.Concurrency)
+	return rollbackMultipleObjects(ctx, backend, limiter, opts.Bucket, prefix, opts.Timestamp, opts.Concurrency, opts.DrainTimeout)
 }
 
 // rollbackMultipleObjects はプレフィックスに一致する複数のオブジェクトを並列でロールバックします
-func rollbackMultipleObjects(client *s3.Client, bucket, prefix string, timestamp time.Time, concurrency int) error {
+func rollbackMultipleObjects(ctx context.Context, backend Backend, limiter *RateLimiter, bucket, prefix string, timestamp time.Time, concurrency int, drainTimeout time.Duration) error {
 	// プレフィックスに一致するオブジェクトの一覧を取得
 	slog.Debug("オブジェクト一覧を取得しています", "bucket", bucket, "prefix", prefix)
 	
-	resp, err := client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(prefix),
-	})
-	
+	keys, err := backend.ListKeys(ctx, bucket, prefix, concurrency)
 	if err != nil {
 		slog.Error("オブジェクト一覧の取得に失敗しました", "error", err)
 		return fmt.Errorf("オブジェクト一覧の取得に失敗しました: %w", err)
 	}
 
-	if len(resp.Contents) == 0 {
+	if len(keys) == 0 {
 		slog.Info("対象オブジェクトが見つかりませんでした", "prefix", prefix)
 		return nil
 	}
 
-	slog.Info("ロールバック処理を開始します", "対象数", len(resp.Contents), "並列数", concurrency)
+	slog.Info("ロールバック処理を開始します", "対象数", len(keys), "並列数", concurrency)
 
 	// エラーを格納するチャネル
-	errCh := make(chan error, len(resp.Contents))
+	errCh := make(chan error, len(keys))
 	
 	// 処理するオブジェクトのキーを格納するチャネル
-	keyCh := make(chan string, len(resp.Contents))
+	keyCh := make(chan string, len(keys))
 	
 	// 全てのキーをチャネルに送信
-	for _, obj := range resp.Contents {
-		keyCh <- *obj.Key
+	for _, key := range keys {
+		keyCh <- key
 	}
 	close(keyCh)
 	
+	// 中断後も実行中のロールバックは猶予時間内であれば完了させる
+	execCtx, cancelExec := drainContext(ctx, drainTimeout)
+	defer cancelExec()
+
+	// 処理済みのオブジェクト数
+	var processed atomic.Int64
+
 	// WaitGroupで並列処理の完了を待機
 	var wg sync.WaitGroup
 	
@@ -94,8 +106,13 @@ func rollbackMultipleObjects(client *s3.Client, bucket, prefix string, timestamp
 			
 			// チャネルからキーを取得して処理
 			for key := range keyCh {
+				// 中断後は新しいオブジェクトを処理しない
+				if ctx.Err() != nil {
+					return
+				}
+
 				slog.Debug("オブジェクト処理開始", "worker", workerID, "key", key)
-				err := rollbackSingleObject(client, bucket, key, timestamp)
+				err := rollbackSingleObject(execCtx, backend, limiter, bucket, key, timestamp)
 				
 				if err != nil {
 					slog.Error("オブジェクト処理失敗", "worker", workerID, "key", key, "error", err)
@@ -103,6 +120,7 @@ func rollbackMultipleObjects(client *s3.Client, bucket, prefix string, timestamp
 					return
 				}
 				
+				processed.Add(1)
 				slog.Debug("オブジェクト処理完了", "worker", workerID, "key", key)
 			}
 		}(i)
@@ -116,32 +134,26 @@ func rollbackMultipleObjects(client *s3.Client, bucket, prefix string, timestamp
 	for err := range errCh {
 		return err
 	}
+
+	if ctx.Err() != nil {
+		slog.Warn("ロールバック処理が中断されました", "処理数", processed.Load(), "対象数", len(keys))
+		return fmt.Errorf("ロールバック処理が中断されました（処理済み: %d/%d）: %w", processed.Load(), len(keys), ctx.Err())
+	}
 	
-	slog.Info("ロールバック処理が完了しました", "処理数", len(resp.Contents))
+	slog.Info("ロールバック処理が完了しました", "処理数", len(keys))
 	return nil
 }
 
 // rollbackSingleObject は単一のオブジェクトをロールバックします
-func rollbackSingleObject(client *s3.Client, bucket, key string, timestamp time.Time) error {
-	// オブジェクトのバージョン一覧を取得
+func rollbackSingleObject(ctx context.Context, backend Backend, limiter *RateLimiter, bucket, key string, timestamp time.Time) error {
+	// オブジェクトのバージョン一覧を取得（指定されたキーに完全一致するもののみ）
 	slog.Debug("バージョン一覧取得", "bucket", bucket, "key", key)
-	resp, err := client.ListObjectVersions(context.TODO(), &s3.ListObjectVersionsInput{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(key),
-	})
-
+	keyVersions, err := backend.ListVersions(ctx, bucket, key)
 	if err != nil {
 		slog.Error("バージョン一覧の取得に失敗しました", "error", err)
 		return fmt.Errorf("バージョン一覧の取得に失敗しました: %w", err)
 	}
-
-	// 指定されたキーに完全一致するバージョンのみをフィルタリング
-	var versions []s3types.ObjectVersion
-	for _, v := range resp.Versions {
-		if *v.Key == key {
-			versions = append(versions, v)
-		}
-	}
+	versions := keyVersions.Versions
 
 	if len(versions) == 0 {
 		slog.Debug("オブジェクトが見つかりませんでした", "key", key)
@@ -181,11 +193,7 @@ func rollbackSingleObject(client *s3.Client, bucket, key string, timestamp time.
 	// 指定された時間以降に最初に作成された場合は削除
 	if isCreatedAfterTimestamp {
 		slog.Debug("オブジェクト削除開始", "bucket", bucket, "key", key)
-		_, err := client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
-		})
-		if err != nil {
+		if err := backend.DeleteObject(ctx, bucket, key); err != nil {
 			slog.Error("オブジェクトの削除に失敗しました", "key", key, "error", err)
 			return fmt.Errorf("オブジェクトの削除に失敗しました: %w", err)
 		}
@@ -195,25 +203,24 @@ func rollbackSingleObject(client *s3.Client, bucket, key string, timestamp time.
 
 	// 指定された時間より前の最新バージョンを検索
 	slog.Debug("過去バージョン検索", "key", key, "timestamp", timestamp)
-	versionID, err := findVersionBeforeTimestamp(client, bucket, key, timestamp)
+	entry, err := findVersionBeforeTimestamp(ctx, backend, bucket, key, timestamp)
 	if err != nil {
 		slog.Error("バージョン検索に失敗しました", "key", key, "error", err)
 		return err
 	}
-	slog.Debug("過去バージョン発見", "key", key, "versionID", versionID)
+	slog.Debug("過去バージョン発見", "key", key, "versionID", entry.VersionID)
+
+	// コピーするバージョンのサイズ分だけ転送量の制限を受ける
+	if err := limiter.WaitBytes(ctx, entry.Size); err != nil {
+		return err
+	}
 	
-	return copySpecificVersion(client, bucket, key, versionID)
+	return copySpecificVersion(ctx, backend, bucket, key, entry.VersionID)
 }
 
-func copySpecificVersion(client *s3.Client, bucket, key, versionID string) error {
+func copySpecificVersion(ctx context.Context, backend Backend, bucket, key, versionID string) error {
 	slog.Debug("バージョンコピー開始", "bucket", bucket, "key", key, "versionID", versionID)
-	_, err := client.CopyObject(context.TODO(), &s3.CopyObjectInput{
-		Bucket:     aws.String(bucket),
-		Key:        aws.String(key),
-		CopySource: aws.String(fmt.Sprintf("%s/%s?versionId=%s", bucket, key, versionID)),
-	})
-
-	if err != nil {
+	if err := backend.CopyObject(ctx, bucket, key, versionID, bucket, key); err != nil {
 		slog.Error("オブジェクトのコピーに失敗しました", "key", key, "error", err)
 		return fmt.Errorf("オブジェクトのコピーに失敗しました: %w", err)
 	}
@@ -222,36 +229,21 @@ func copySpecificVersion(client *s3.Client, bucket, key, versionID string) error
 	return nil
 }
 
-func findVersionBeforeTimestamp(client *s3.Client, bucket, key string, timestamp time.Time) (string, error) {
-	resp, err := client.ListObjectVersions(context.TODO(), &s3.ListObjectVersionsInput{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(key),
-	})
-
+func findVersionBeforeTimestamp(ctx context.Context, backend Backend, bucket, key string, timestamp time.Time) (versionEntry, error) {
+	keyVersions, err := backend.ListVersions(ctx, bucket, key)
 	if err != nil {
 		slog.Error("バージョン一覧の取得に失敗しました", "error", err)
-		return "", fmt.Errorf("バージョン一覧の取得に失敗しました: %w", err)
-	}
-
-	var latestVersionBeforeTimestamp *string
-	var latestLastModified time.Time
-
-	for _, v := range resp.Versions {
-		if *v.Key == key && v.LastModified.Before(timestamp) {
-			slog.Debug("対象バージョン検出", "key", key, "versionID", *v.VersionId, "lastModified", *v.LastModified)
-			if latestVersionBeforeTimestamp == nil || v.LastModified.After(latestLastModified) {
-				latestVersionBeforeTimestamp = v.VersionId
-				latestLastModified = *v.LastModified
-				slog.Debug("より新しいバージョン発見", "key", key, "versionID", *v.VersionId, "lastModified", *v.LastModified)
-			}
-		}
+		return versionEntry{}, fmt.Errorf("バージョン一覧の取得に失敗しました: %w", err)
 	}
 
-	if latestVersionBeforeTimestamp == nil {
+	// ロールバックでは削除マーカーを除いた通常のバージョンのみを対象にする
+	history := versionHistory(KeyVersions{Versions: keyVersions.Versions})
+	entry, ok := versionAtTimestamp(history, timestamp)
+	if !ok {
 		slog.Error("指定された時間より前のバージョンが見つかりませんでした", "key", key, "timestamp", timestamp)
-		return "", fmt.Errorf("指定された時間 %v より前のバージョンが見つかりませんでした", timestamp)
+		return versionEntry{}, fmt.Errorf("指定された時間 %v より前のバージョンが見つかりませんでした", timestamp)
 	}
 
-	slog.Debug("最適バージョン決定", "key", key, "versionID", *latestVersionBeforeTimestamp, "lastModified", latestLastModified)
-	return *latestVersionBeforeTimestamp, nil
+	slog.Debug("最適バージョン決定", "key", key, "versionID", entry.VersionID, "lastModified", entry.Timestamp)
+	return entry, nil
 }
@@ -2,456 +2,162 @@ package s3
 
 import (
 	"context"
-	"errors"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
-	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/mock"
 )
 
-// S3ClientInterface はテスト用のS3クライアントインターフェース
-type S3ClientInterface interface {
-	ListObjectVersions(ctx context.Context, params *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error)
-	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
-	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
-	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
-}
-
-// S3RollbackClientMock はS3クライアントのモック
-type S3RollbackClientMock struct {
-	mock.Mock
-}
-
-func (m *S3RollbackClientMock) ListObjectVersions(ctx context.Context, params *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
-	args := m.Called(ctx, params)
-	if args.Get(0) == nil {
-		return nil, args.Error(1)
-	}
-	return args.Get(0).(*s3.ListObjectVersionsOutput), args.Error(1)
-}
-
-func (m *S3RollbackClientMock) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
-	args := m.Called(ctx, params)
-	if args.Get(0) == nil {
-		return nil, args.Error(1)
-	}
-	return args.Get(0).(*s3.ListObjectsV2Output), args.Error(1)
-}
-
-func (m *S3RollbackClientMock) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
-	args := m.Called(ctx, params)
-	if args.Get(0) == nil {
-		return nil, args.Error(1)
-	}
-	return args.Get(0).(*s3.CopyObjectOutput), args.Error(1)
-}
-
-func (m *S3RollbackClientMock) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
-	args := m.Called(ctx, params)
-	if args.Get(0) == nil {
-		return nil, args.Error(1)
+// newRollbackBackend はロールバックのテスト用のLocalBackendを作成します
+func newRollbackBackend(t *testing.T) *LocalBackend {
+	t.Helper()
+	backend, err := NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalBackend() error = %v", err)
 	}
-	return args.Get(0).(*s3.DeleteObjectOutput), args.Error(1)
+	return backend
 }
 
-// rollbackSingleObjectTest はテスト用のラッパー関数
-func rollbackSingleObjectTest(client S3ClientInterface, bucket, key string, timestamp time.Time) error {
-	return rollbackSingleObjectWithClient(client, bucket, key, timestamp)
-}
+func TestFindVersionBeforeTimestamp(t *testing.T) {
+	ctx := context.Background()
+	backend := newRollbackBackend(t)
 
-// rollbackSingleObjectWithClient はテスト可能なバージョン
-func rollbackSingleObjectWithClient(client S3ClientInterface, bucket, key string, timestamp time.Time) error {
-	// オブジェクトのバージョン一覧を取得
-	resp, err := client.ListObjectVersions(context.TODO(), &s3.ListObjectVersionsInput{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(key),
-	})
+	beforeFirst := time.Now().UTC()
+	putLocalObject(t, backend, "bucket", "key", "v1")
+	afterFirst := time.Now().UTC()
+	putLocalObject(t, backend, "bucket", "key", "v2")
+	afterSecond := time.Now().UTC()
+	// 削除マーカーはロールバック先の対象にしない
+	deleteLocalObject(t, backend, "bucket", "key")
+	afterDelete := time.Now().UTC()
 
+	versions, err := backend.ListVersions(ctx, "bucket", "key")
 	if err != nil {
-		return errors.New("バージョン一覧の取得に失敗しました: " + err.Error())
+		t.Fatalf("ListVersions() error = %v", err)
+	}
+	history := versionHistory(KeyVersions{Versions: versions.Versions})
+	first, second := history[0].VersionID, history[1].VersionID
+
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		want      string
+	}{
+		{"最初のバージョンの後", afterFirst, first},
+		{"2番目のバージョンの後", afterSecond, second},
+		{"削除の後", afterDelete, second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := findVersionBeforeTimestamp(ctx, backend, "bucket", "key", tt.timestamp)
+			if err != nil {
+				t.Fatalf("findVersionBeforeTimestamp() error = %v", err)
+			}
+			if entry.VersionID != tt.want {
+				t.Errorf("findVersionBeforeTimestamp() = %s, want %s", entry.VersionID, tt.want)
+			}
+		})
 	}
 
-	// 指定されたキーに完全一致するバージョンのみをフィルタリング
-	var versions []s3types.ObjectVersion
-	for _, v := range resp.Versions {
-		if *v.Key == key {
-			versions = append(versions, v)
-		}
+	if _, err := findVersionBeforeTimestamp(ctx, backend, "bucket", "key", beforeFirst); err == nil {
+		t.Error("最初のバージョンより前の時間でエラーになりません")
 	}
-
-	if len(versions) == 0 {
-		return errors.New("指定されたオブジェクト " + key + " が見つかりませんでした")
+	if _, err := findVersionBeforeTimestamp(ctx, backend, "missing", "key", afterFirst); err == nil {
+		t.Error("存在しないバケットでエラーになりません")
 	}
+}
 
-	// 指定された時間以降に変更があるか確認
-	var hasChangesAfterTimestamp bool
-	var isCreatedAfterTimestamp bool
-	var firstVersionTime *time.Time
-
-	for _, v := range versions {
-		// 最初のバージョンの時間を記録
-		if firstVersionTime == nil || v.LastModified.Before(*firstVersionTime) {
-			firstVersionTime = v.LastModified
-		}
+func TestRollbackSingleObject_NoChangesAfterTimestamp(t *testing.T) {
+	backend := newRollbackBackend(t)
+	putLocalObject(t, backend, "bucket", "key", "v1")
+	timestamp := time.Now().UTC()
 
-		// 指定された時間以降に変更があるか確認
-		if !v.LastModified.Before(timestamp) {
-			hasChangesAfterTimestamp = true
-		}
+	if err := rollbackSingleObject(context.Background(), backend, nil, "bucket", "key", timestamp); err != nil {
+		t.Fatalf("rollbackSingleObject() error = %v", err)
 	}
-
-	// 最初のバージョンが指定された時間以降に作成された場合
-	if firstVersionTime != nil && !firstVersionTime.Before(timestamp) {
-		isCreatedAfterTimestamp = true
+	if got := localHistory(t, backend, "bucket", "key"); !reflect.DeepEqual(got, []string{"v1"}) {
+		t.Errorf("履歴 = %v, want [v1]", got)
 	}
+}
 
-	// 指定された時間以降に変更がない場合はロールバック不要
-	if !hasChangesAfterTimestamp {
-		return nil
-	}
+func TestRollbackSingleObject_CreatedAfterTimestamp(t *testing.T) {
+	backend := newRollbackBackend(t)
+	timestamp := time.Now().UTC()
+	putLocalObject(t, backend, "bucket", "key", "v1")
 
-	// 指定された時間以降に最初に作成された場合は削除
-	if isCreatedAfterTimestamp {
-		_, err := client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
-		})
-		if err != nil {
-			return errors.New("オブジェクトの削除に失敗しました: " + err.Error())
-		}
-		return nil
+	if err := rollbackSingleObject(context.Background(), backend, nil, "bucket", "key", timestamp); err != nil {
+		t.Fatalf("rollbackSingleObject() error = %v", err)
 	}
-
-	// 指定された時間より前の最新バージョンを検索
-	versionID, err := findVersionBeforeTimestampTest(client, bucket, key, timestamp)
-	if err != nil {
-		return err
+	if got := localHistory(t, backend, "bucket", "key"); !reflect.DeepEqual(got, []string{"v1", "<削除>"}) {
+		t.Errorf("履歴 = %v, want [v1 <削除>]", got)
 	}
-	
-	return copySpecificVersionTest(client, bucket, key, versionID)
 }
 
-// findVersionBeforeTimestampTest はテスト用のラッパー関数
-func findVersionBeforeTimestampTest(client S3ClientInterface, bucket, key string, timestamp time.Time) (string, error) {
-	resp, err := client.ListObjectVersions(context.TODO(), &s3.ListObjectVersionsInput{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(key),
-	})
+func TestRollbackSingleObject_RollbackToPreviousVersion(t *testing.T) {
+	backend := newRollbackBackend(t)
+	putLocalObject(t, backend, "bucket", "key", "v1")
+	timestamp := time.Now().UTC()
+	putLocalObject(t, backend, "bucket", "key", "v2")
 
-	if err != nil {
-		return "", errors.New("バージョン一覧の取得に失敗しました: " + err.Error())
+	if err := rollbackSingleObject(context.Background(), backend, nil, "bucket", "key", timestamp); err != nil {
+		t.Fatalf("rollbackSingleObject() error = %v", err)
 	}
-
-	var latestVersionBeforeTimestamp *string
-	var latestLastModified time.Time
-
-	for _, v := range resp.Versions {
-		if *v.Key == key && v.LastModified.Before(timestamp) {
-			if latestVersionBeforeTimestamp == nil || v.LastModified.After(latestLastModified) {
-				latestVersionBeforeTimestamp = v.VersionId
-				latestLastModified = *v.LastModified
-			}
-		}
-	}
-
-	if latestVersionBeforeTimestamp == nil {
-		return "", errors.New("指定された時間より前のバージョンが見つかりませんでした")
+	if got := localHistory(t, backend, "bucket", "key"); !reflect.DeepEqual(got, []string{"v1", "v2", "v1"}) {
+		t.Errorf("履歴 = %v, want [v1 v2 v1]", got)
 	}
-
-	return *latestVersionBeforeTimestamp, nil
 }
 
-// copySpecificVersionTest はテスト用のラッパー関数
-func copySpecificVersionTest(client S3ClientInterface, bucket, key, versionID string) error {
-	_, err := client.CopyObject(context.TODO(), &s3.CopyObjectInput{
-		Bucket:     aws.String(bucket),
-		Key:        aws.String(key),
-		CopySource: aws.String(bucket + "/" + key + "?versionId=" + versionID),
-	})
+func TestRollbackSingleObject_NotFound(t *testing.T) {
+	backend := newRollbackBackend(t)
+	putLocalObject(t, backend, "bucket", "other", "o1")
 
-	if err != nil {
-		return errors.New("オブジェクトのコピーに失敗しました: " + err.Error())
+	if err := rollbackSingleObject(context.Background(), backend, nil, "bucket", "key", time.Now().UTC()); err == nil {
+		t.Error("存在しないオブジェクトでエラーになりません")
 	}
-
-	return nil
 }
 
-// rollbackMultipleObjectsTest はテスト用のラッパー関数
-func rollbackMultipleObjectsTest(client S3ClientInterface, bucket, prefix string, timestamp time.Time, concurrency int) error {
-	// プレフィックスに一致するオブジェクトの一覧を取得
-	resp, err := client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(prefix),
-	})
-	
-	if err != nil {
-		return errors.New("オブジェクト一覧の取得に失敗しました: " + err.Error())
-	}
-
-	if len(resp.Contents) == 0 {
-		return nil
-	}
-
-	// テストでは並列処理をシミュレートするために逐次処理
-	for _, obj := range resp.Contents {
-		err := rollbackSingleObjectTest(client, bucket, *obj.Key, timestamp)
-		if err != nil {
-			return errors.New("オブジェクト " + *obj.Key + " のロールバックに失敗しました: " + err.Error())
+func TestRollbackMultipleObjects(t *testing.T) {
+	backend := newRollbackBackend(t)
+	putLocalObject(t, backend, "bucket", "test-prefix/key1", "k1")
+	putLocalObject(t, backend, "bucket", "test-prefix/key3", "k3-1")
+	putLocalObject(t, backend, "bucket", "other/key", "o1")
+	timestamp := time.Now().UTC()
+	putLocalObject(t, backend, "bucket", "test-prefix/key2", "k2")
+	putLocalObject(t, backend, "bucket", "test-prefix/key3", "k3-2")
+	putLocalObject(t, backend, "bucket", "other/key", "o2")
+
+	if err := rollbackMultipleObjects(context.Background(), backend, nil, "bucket", "test-prefix/", timestamp, 2, DefaultDrainTimeout); err != nil {
+		t.Fatalf("rollbackMultipleObjects() error = %v", err)
+	}
+
+	want := map[string]string{
+		"test-prefix/key1": "k1",
+		"test-prefix/key2": "",
+		"test-prefix/key3": "k3-1",
+		// プレフィックスに一致しないオブジェクトは変更しない
+		"other/key": "o2",
+	}
+	for key, content := range want {
+		if got := readLocalObject(t, backend, "bucket", key); got != content {
+			t.Errorf("%s = %q, want %q", key, got, content)
 		}
 	}
-	
-	return nil
-}
-
-func TestRollbackSingleObject_NoChangesAfterTimestamp(t *testing.T) {
-	// モックの準備
-	mockClient := new(S3RollbackClientMock)
-	
-	// テストデータ
-	bucket := "test-bucket"
-	key := "test-key"
-	timestamp := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
-	
-	// バージョン一覧のモック応答
-	lastModified := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
-	versionID := "v1"
-	isLatest := true
-	
-	mockClient.On("ListObjectVersions", mock.Anything, &s3.ListObjectVersionsInput{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(key),
-	}).Return(&s3.ListObjectVersionsOutput{
-		Versions: []s3types.ObjectVersion{
-			{
-				Key:         aws.String(key),
-				VersionId:   aws.String(versionID),
-				IsLatest:    aws.Bool(isLatest),
-				LastModified: aws.Time(lastModified),
-			},
-		},
-	}, nil)
-	
-	// テスト実行
-	err := rollbackSingleObjectTest(mockClient, bucket, key, timestamp)
-	
-	// 検証
-	assert.NoError(t, err)
-	mockClient.AssertExpectations(t)
-}
-
-func TestRollbackSingleObject_CreatedAfterTimestamp(t *testing.T) {
-	// モックの準備
-	mockClient := new(S3RollbackClientMock)
-	
-	// テストデータ
-	bucket := "test-bucket"
-	key := "test-key"
-	timestamp := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
-	
-	// バージョン一覧のモック応答
-	lastModified := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
-	versionID := "v1"
-	isLatest := true
-	
-	mockClient.On("ListObjectVersions", mock.Anything, &s3.ListObjectVersionsInput{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(key),
-	}).Return(&s3.ListObjectVersionsOutput{
-		Versions: []s3types.ObjectVersion{
-			{
-				Key:         aws.String(key),
-				VersionId:   aws.String(versionID),
-				IsLatest:    aws.Bool(isLatest),
-				LastModified: aws.Time(lastModified),
-			},
-		},
-	}, nil)
-	
-	mockClient.On("DeleteObject", mock.Anything, &s3.DeleteObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	}).Return(&s3.DeleteObjectOutput{}, nil)
-	
-	// テスト実行
-	err := rollbackSingleObjectTest(mockClient, bucket, key, timestamp)
-	
-	// 検証
-	assert.NoError(t, err)
-	mockClient.AssertExpectations(t)
-}
-
-func TestRollbackSingleObject_RollbackToPreviousVersion(t *testing.T) {
-	// モックの準備
-	mockClient := new(S3RollbackClientMock)
-	
-	// テストデータ
-	bucket := "test-bucket"
-	key := "test-key"
-	timestamp := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
-	
-	// バージョン一覧のモック応答
-	lastModifiedV1 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
-	lastModifiedV2 := time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC)
-	versionIDV1 := "v1"
-	versionIDV2 := "v2"
-	
-	mockClient.On("ListObjectVersions", mock.Anything, &s3.ListObjectVersionsInput{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(key),
-	}).Return(&s3.ListObjectVersionsOutput{
-		Versions: []s3types.ObjectVersion{
-			{
-				Key:         aws.String(key),
-				VersionId:   aws.String(versionIDV2),
-				IsLatest:    aws.Bool(true),
-				LastModified: aws.Time(lastModifiedV2),
-			},
-			{
-				Key:         aws.String(key),
-				VersionId:   aws.String(versionIDV1),
-				IsLatest:    aws.Bool(false),
-				LastModified: aws.Time(lastModifiedV1),
-			},
-		},
-	}, nil).Times(2)
-	
-	mockClient.On("CopyObject", mock.Anything, &s3.CopyObjectInput{
-		Bucket:     aws.String(bucket),
-		Key:        aws.String(key),
-		CopySource: aws.String(bucket + "/" + key + "?versionId=" + versionIDV1),
-	}).Return(&s3.CopyObjectOutput{}, nil)
-	
-	// テスト実行
-	err := rollbackSingleObjectTest(mockClient, bucket, key, timestamp)
-	
-	// 検証
-	assert.NoError(t, err)
-	mockClient.AssertExpectations(t)
-}
-
-func TestRollbackMultipleObjects(t *testing.T) {
-	// モックの準備
-	mockClient := new(S3RollbackClientMock)
-	
-	// テストデータ
-	bucket := "test-bucket"
-	prefix := "test-prefix"
-	timestamp := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
-	concurrency := 2
-	
-	// オブジェクト一覧のモック応答
-	key1 := prefix + "/key1"
-	key2 := prefix + "/key2"
-	
-	mockClient.On("ListObjectsV2", mock.Anything, &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(prefix),
-	}).Return(&s3.ListObjectsV2Output{
-		Contents: []s3types.Object{
-			{
-				Key: aws.String(key1),
-			},
-			{
-				Key: aws.String(key2),
-			},
-		},
-	}, nil)
-	
-	// key1のバージョン一覧
-	lastModifiedV1 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
-	versionIDV1 := "v1"
-	
-	mockClient.On("ListObjectVersions", mock.Anything, &s3.ListObjectVersionsInput{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(key1),
-	}).Return(&s3.ListObjectVersionsOutput{
-		Versions: []s3types.ObjectVersion{
-			{
-				Key:         aws.String(key1),
-				VersionId:   aws.String(versionIDV1),
-				IsLatest:    aws.Bool(true),
-				LastModified: aws.Time(lastModifiedV1),
-			},
-		},
-	}, nil)
-	
-	// key2のバージョン一覧
-	lastModifiedV2 := time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC)
-	versionIDV2 := "v2"
-	
-	mockClient.On("ListObjectVersions", mock.Anything, &s3.ListObjectVersionsInput{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(key2),
-	}).Return(&s3.ListObjectVersionsOutput{
-		Versions: []s3types.ObjectVersion{
-			{
-				Key:         aws.String(key2),
-				VersionId:   aws.String(versionIDV2),
-				IsLatest:    aws.Bool(true),
-				LastModified: aws.Time(lastModifiedV2),
-			},
-		},
-	}, nil)
-	
-	// key2の削除
-	mockClient.On("DeleteObject", mock.Anything, &s3.DeleteObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key2),
-	}).Return(&s3.DeleteObjectOutput{}, nil)
-	
-	// テスト実行
-	err := rollbackMultipleObjectsTest(mockClient, bucket, prefix, timestamp, concurrency)
-	
-	// 検証
-	assert.NoError(t, err)
-	mockClient.AssertExpectations(t)
 }
 
 func TestRollbackMultipleObjects_EmptyList(t *testing.T) {
-	// モックの準備
-	mockClient := new(S3RollbackClientMock)
-	
-	// テストデータ
-	bucket := "test-bucket"
-	prefix := "test-prefix"
-	timestamp := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
-	concurrency := 2
-	
-	// 空のオブジェクト一覧のモック応答
-	mockClient.On("ListObjectsV2", mock.Anything, mock.Anything).Return(&s3.ListObjectsV2Output{
-		Contents: []s3types.Object{},
-	}, nil)
-	
-	// テスト実行
-	err := rollbackMultipleObjectsTest(mockClient, bucket, prefix, timestamp, concurrency)
-	
-	// 検証
-	assert.NoError(t, err)
-	mockClient.AssertExpectations(t)
+	backend := newRollbackBackend(t)
+	putLocalObject(t, backend, "bucket", "other/key", "o1")
+
+	if err := rollbackMultipleObjects(context.Background(), backend, nil, "bucket", "test-prefix/", time.Now().UTC(), 2, DefaultDrainTimeout); err != nil {
+		t.Errorf("rollbackMultipleObjects() error = %v", err)
+	}
 }
 
 func TestRollbackMultipleObjects_ErrorListingObjects(t *testing.T) {
-	// モックの準備
-	mockClient := new(S3RollbackClientMock)
-	
-	// テストデータ
-	bucket := "test-bucket"
-	prefix := "test-prefix"
-	timestamp := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
-	concurrency := 2
-	
-	// エラー応答のモック
-	mockClient.On("ListObjectsV2", mock.Anything, mock.Anything).Return(nil, errors.New("list objects error"))
-	
-	// テスト実行
-	err := rollbackMultipleObjectsTest(mockClient, bucket, prefix, timestamp, concurrency)
-	
-	// 検証
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "オブジェクト一覧の取得に失敗しました")
-	mockClient.AssertExpectations(t)
+	backend := newRollbackBackend(t)
+
+	err := rollbackMultipleObjects(context.Background(), backend, nil, "missing", "test-prefix/", time.Now().UTC(), 2, DefaultDrainTimeout)
+	if err == nil || !strings.Contains(err.Error(), "オブジェクト一覧の取得に失敗しました") {
+		t.Errorf("rollbackMultipleObjects() error = %v, want オブジェクト一覧の取得に失敗しました", err)
+	}
 }
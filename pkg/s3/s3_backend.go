@@ -0,0 +1,194 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// S3Backend はS3を使用するBackend
+type S3Backend struct {
+	client   *s3.Client
+	uploader *manager.Uploader
+	options  ClientOptions // 作成した接続設定（サーバーサイドコピーができるかの判定に使用）
+}
+
+// NewS3Backend は接続設定からS3Backendを作成します
+func NewS3Backend(ctx context.Context, opts ClientOptions) (*S3Backend, error) {
+	client, err := NewClient(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	backend := newS3Backend(client)
+	backend.options = opts
+	return backend, nil
+}
+
+// newS3Backend はS3クライアントからS3Backendを作成します
+func newS3Backend(client *s3.Client) *S3Backend {
+	return &S3Backend{
+		client:   client,
+		uploader: manager.NewUploader(client),
+	}
+}
+
+// ListKeys はプレフィックスに一致するオブジェクトのキーを並列で取得します
+func (b *S3Backend) ListKeys(ctx context.Context, bucket, prefix string, concurrency int) ([]string, error) {
+	return listAllKeys(ctx, b.keyPager(bucket, false), prefix, concurrency)
+}
+
+// ListVersionedKeys は削除されたキーを含め、プレフィックスに一致するキーをバージョン一覧から並列で取得します
+func (b *S3Backend) ListVersionedKeys(ctx context.Context, bucket, prefix string, concurrency int) ([]string, error) {
+	return listAllKeys(ctx, b.keyPager(bucket, true), prefix, concurrency)
+}
+
+// keyPager はバケットのキー一覧を1ページずつ取得するkeyPagerを返します
+func (b *S3Backend) keyPager(bucket string, versioned bool) keyPager {
+	if versioned {
+		return versionKeyPager{client: b.client, bucket: bucket}
+	}
+	return objectKeyPager{client: b.client, bucket: bucket}
+}
+
+// ListVersions はキーの全バージョンを取得します
+func (b *S3Backend) ListVersions(ctx context.Context, bucket, key string) (KeyVersions, error) {
+	return getAllVersionsForKey(ctx, b.client, bucket, key)
+}
+
+// VersioningEnabled はバケットのバージョニング設定を取得します
+func (b *S3Backend) VersioningEnabled(ctx context.Context, bucket string) (bool, error) {
+	resp, err := b.client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return false, err
+	}
+	return resp.Status == s3types.BucketVersioningStatusEnabled, nil
+}
+
+// GetObject はオブジェクトのバージョンを取得します
+func (b *S3Backend) GetObject(ctx context.Context, bucket, key, versionID string) (*ObjectContent, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+
+	resp, err := b.client.GetObject(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectContent{
+		Body: resp.Body,
+		Metadata: ObjectMetadata{
+			ContentType:        aws.ToString(resp.ContentType),
+			ContentEncoding:    aws.ToString(resp.ContentEncoding),
+			ContentDisposition: aws.ToString(resp.ContentDisposition),
+			ContentLanguage:    aws.ToString(resp.ContentLanguage),
+			CacheControl:       aws.ToString(resp.CacheControl),
+			UserMetadata:       resp.Metadata,
+		},
+	}, nil
+}
+
+// HeadObject はオブジェクトのメタデータを取得します
+// オブジェクトが存在しない場合や最新が削除マーカーの場合、S3は404を返すためnilを返します
+func (b *S3Backend) HeadObject(ctx context.Context, bucket, key string) (*ObjectInfo, error) {
+	resp, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectInfo{
+		Size: aws.ToInt64(resp.ContentLength),
+		ETag: aws.ToString(resp.ETag),
+		Metadata: ObjectMetadata{
+			ContentType:        aws.ToString(resp.ContentType),
+			ContentEncoding:    aws.ToString(resp.ContentEncoding),
+			ContentDisposition: aws.ToString(resp.ContentDisposition),
+			ContentLanguage:    aws.ToString(resp.ContentLanguage),
+			CacheControl:       aws.ToString(resp.CacheControl),
+			UserMetadata:       resp.Metadata,
+		},
+	}, nil
+}
+
+// PutObject はオブジェクトをアップロードします
+// 大きなオブジェクトはマルチパートアップロードで書き込みます
+func (b *S3Backend) PutObject(ctx context.Context, bucket, key string, body io.Reader, metadata ObjectMetadata) error {
+	_, err := b.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:             aws.String(bucket),
+		Key:                aws.String(key),
+		Body:               body,
+		ContentType:        optionalString(metadata.ContentType),
+		ContentEncoding:    optionalString(metadata.ContentEncoding),
+		ContentDisposition: optionalString(metadata.ContentDisposition),
+		ContentLanguage:    optionalString(metadata.ContentLanguage),
+		CacheControl:       optionalString(metadata.CacheControl),
+		Metadata:           metadata.UserMetadata,
+	})
+	return err
+}
+
+// CopyObject はCopyObjectでサーバーサイドコピーします
+func (b *S3Backend) CopyObject(ctx context.Context, sourceBucket, sourceKey, versionID, destBucket, destKey string) error {
+	copySource := fmt.Sprintf("%s/%s", sourceBucket, url.PathEscape(sourceKey))
+	if versionID != "" {
+		copySource += "?versionId=" + url.QueryEscape(versionID)
+	}
+
+	_, err := b.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(destBucket),
+		Key:        aws.String(destKey),
+		CopySource: aws.String(copySource),
+	})
+	return err
+}
+
+// DeleteObject はオブジェクトを削除します
+func (b *S3Backend) DeleteObject(ctx context.Context, bucket, key string) error {
+	_, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// isNotFound はエラーがオブジェクトが存在しないことによるものかを返します
+func isNotFound(err error) bool {
+	var notFound *s3types.NotFound
+	if errors.As(err, &notFound) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchKey"
+	}
+	return false
+}
+
+// optionalString は空文字列の場合にnilを返します
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
@@ -0,0 +1,206 @@
+package s3
+
+import (
+	"container/heap"
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// scheduledEvent はスケジューラーが実行の時刻を管理するイベント
+type scheduledEvent struct {
+	entry       replayEntry
+	destination int       // 宛先の位置（複数の宛先にリプレイする場合）
+	copy        int       // 複製の番号（増幅する場合）
+	loop        int       // 変更リストを繰り返した回数（増幅する場合）
+	destKey     string    // 同一キーの直列化に使用する宛先のキー
+	rewriteErr  error     // 宛先のキーの書き換えに失敗した場合のエラー
+	scheduledAt time.Time // 実行する予定の時刻（ゼロ値の場合は即時）
+}
+
+// queueKey は同一キーの直列化に使用するキーを返します
+// 宛先ごとに直列化するため、2番目以降の宛先では宛先の位置をキーに含めます
+func (e *scheduledEvent) queueKey() string {
+	if e.destination == 0 {
+		return e.destKey
+	}
+	return strconv.Itoa(e.destination) + "\x00" + e.destKey
+}
+
+// scheduleHeap は実行の予定時刻が早い順（同時刻は変更リストの順）に取り出すヒープ
+type scheduleHeap []*scheduledEvent
+
+func (h scheduleHeap) Len() int { return len(h) }
+
+func (h scheduleHeap) Less(i, j int) bool {
+	if !h[i].scheduledAt.Equal(h[j].scheduledAt) {
+		return h[i].scheduledAt.Before(h[j].scheduledAt)
+	}
+	return h[i].entry.Index < h[j].entry.Index
+}
+
+func (h scheduleHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *scheduleHeap) Push(x any) { *h = append(*h, x.(*scheduledEvent)) }
+
+func (h *scheduleHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// keyQueue は同一キーのイベントを変更リストの順に1件ずつ実行するためのキュー
+type keyQueue struct {
+	order   []int                   // 未実行のイベントの位置（変更リストの順）
+	due     map[int]*scheduledEvent // 実行の時刻になったイベント
+	running bool                    // イベントを実行中かどうか
+}
+
+// replayScheduler はイベントを予定の時刻にワーカーへ渡すスケジューラー
+// 同一キーのイベントは、前のイベントの実行が完了してから変更リストの順に渡します
+type replayScheduler struct {
+	pending  scheduleHeap         // 実行の時刻になっていないイベント
+	keys     map[string]*keyQueue // キーごとの未実行のイベント
+	runnable []*scheduledEvent    // ワーカーに渡せるイベント（渡した順に実行が始まる）
+	running  int                  // ワーカーで実行中のイベント数
+	queued   int                  // 追加されて実行が完了していないイベント数
+	stopped  bool                 // 中断されて新しいイベントを渡さないかどうか
+}
+
+func newReplayScheduler() *replayScheduler {
+	return &replayScheduler{keys: make(map[string]*keyQueue)}
+}
+
+// push はイベントを追加します。同一キーのイベントは変更リストの順に追加してください
+func (s *replayScheduler) push(event *scheduledEvent) {
+	key := event.queueKey()
+	queue, ok := s.keys[key]
+	if !ok {
+		queue = &keyQueue{due: make(map[int]*scheduledEvent)}
+		s.keys[key] = queue
+	}
+	queue.order = append(queue.order, event.entry.Index)
+	heap.Push(&s.pending, event)
+	s.queued++
+}
+
+// nextDue は次のイベントの実行の予定時刻を返します
+func (s *replayScheduler) nextDue() (time.Time, bool) {
+	if len(s.pending) == 0 {
+		return time.Time{}, false
+	}
+	return s.pending[0].scheduledAt, true
+}
+
+// popDue は予定の時刻を過ぎたイベントを取り出し、実行できるものをワーカーに渡すキューに入れます
+func (s *replayScheduler) popDue(now time.Time) {
+	for len(s.pending) > 0 && !s.pending[0].scheduledAt.After(now) {
+		event := heap.Pop(&s.pending).(*scheduledEvent)
+		if event.scheduledAt.IsZero() {
+			event.scheduledAt = now
+		}
+		key := event.queueKey()
+		queue := s.keys[key]
+		queue.due[event.entry.Index] = event
+		s.release(key, queue)
+	}
+}
+
+// release はキーの次のイベントが実行の時刻になっていて、実行中のイベントがなければワーカーに渡すキューに入れます
+func (s *replayScheduler) release(key string, queue *keyQueue) {
+	if s.stopped || queue.running || len(queue.order) == 0 {
+		return
+	}
+	event, ok := queue.due[queue.order[0]]
+	if !ok {
+		return
+	}
+	delete(queue.due, queue.order[0])
+	queue.order = queue.order[1:]
+	queue.running = true
+	s.runnable = append(s.runnable, event)
+}
+
+// complete はキーのイベントの実行が完了したことを記録し、同じキーの次のイベントを実行できるようにします
+func (s *replayScheduler) complete(key string) {
+	s.running--
+	s.queued--
+	queue := s.keys[key]
+	queue.running = false
+	if len(queue.order) == 0 {
+		delete(s.keys, key)
+		return
+	}
+	s.release(key, queue)
+}
+
+// idle はすべてのイベントの実行が完了したかどうかを返します
+func (s *replayScheduler) idle() bool {
+	return len(s.pending) == 0 && len(s.runnable) == 0 && s.running == 0
+}
+
+// run はすべてのイベントの実行が完了するまで、予定の時刻になったイベントを workCh に送ります
+// feed から受け取ったイベントを追加し、feed が閉じられるまで終了しません（nil の場合は追加しません）
+// 保持するイベントが capacity 件に達している間は feed から受け取りません（0 以下の場合は制限しません）
+// ワーカーはイベントの実行が完了したら、そのキーを completeCh に送ります
+// 中断された場合は新しいイベントを送らず、実行中のイベントの完了を待ってから workCh を閉じます
+func (s *replayScheduler) run(ctx context.Context, feed <-chan *scheduledEvent, capacity int, workCh chan<- *scheduledEvent, completeCh <-chan string) {
+	defer close(workCh)
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	done := ctx.Done()
+	for feed != nil || !s.idle() {
+		s.popDue(time.Now())
+
+		// 保持するイベント数に余裕がある場合のみ新しいイベントを受け取る
+		var feedCh <-chan *scheduledEvent
+		if capacity <= 0 || s.queued < capacity {
+			feedCh = feed
+		}
+
+		// ワーカーに渡せるイベントがある場合のみ送信する
+		var sendCh chan<- *scheduledEvent
+		var next *scheduledEvent
+		if len(s.runnable) > 0 {
+			sendCh = workCh
+			next = s.runnable[0]
+		}
+
+		// 次のイベントの予定時刻にタイマーを設定（ワーカーが空いていなくても遅延を正しく記録するため）
+		var timerCh <-chan time.Time
+		if due, ok := s.nextDue(); ok {
+			timer.Reset(time.Until(due))
+			timerCh = timer.C
+		}
+
+		select {
+		case sendCh <- next:
+			s.runnable = s.runnable[1:]
+			s.running++
+		case event, ok := <-feedCh:
+			if !ok {
+				feed = nil
+				break
+			}
+			s.push(event)
+		case key := <-completeCh:
+			s.complete(key)
+		case <-timerCh:
+		case <-done:
+			slog.Warn("中断されたため新しいイベントのスケジュールを停止します", "未実行", len(s.pending)+len(s.runnable))
+			s.pending = nil
+			s.runnable = nil
+			s.stopped = true
+			feed = nil
+			done = nil
+		}
+		timer.Stop()
+	}
+}
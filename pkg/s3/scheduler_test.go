@@ -0,0 +1,156 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+)
+
+// executedEvent はテストのワーカーが実行したイベントの記録
+type executedEvent struct {
+	index      int
+	key        string
+	start, end time.Time
+}
+
+// runTestScheduler はスケジューラーを実行し、ワーカーが実行したイベントを実行の開始順に返します
+func runTestScheduler(ctx context.Context, t *testing.T, events []*scheduledEvent, concurrency int, work func(*scheduledEvent)) []executedEvent {
+	t.Helper()
+
+	scheduler := newReplayScheduler()
+	for _, event := range events {
+		scheduler.push(event)
+	}
+
+	workCh := make(chan *scheduledEvent)
+	completeCh := make(chan string, concurrency)
+	finished := make(chan struct{})
+	go func() {
+		scheduler.run(ctx, nil, 0, workCh, completeCh)
+		close(finished)
+	}()
+
+	var mu sync.Mutex
+	var executed []executedEvent
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for event := range workCh {
+				start := time.Now()
+				work(event)
+				mu.Lock()
+				executed = append(executed, executedEvent{index: event.entry.Index, key: event.destKey, start: start, end: time.Now()})
+				mu.Unlock()
+				completeCh <- event.destKey
+			}
+		}()
+	}
+
+	select {
+	case <-finished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("スケジューラーが終了しません")
+	}
+	wg.Wait()
+	return executed
+}
+
+func TestReplaySchedulerKeyOrdering(t *testing.T) {
+	var events []*scheduledEvent
+	for i := 0; i < 200; i++ {
+		events = append(events, &scheduledEvent{entry: replayEntry{Index: i}, destKey: fmt.Sprintf("key-%d", i%5)})
+	}
+
+	executed := runTestScheduler(context.Background(), t, events, 8, func(*scheduledEvent) {
+		time.Sleep(time.Duration(rand.IntN(500)) * time.Microsecond)
+	})
+	if len(executed) != len(events) {
+		t.Fatalf("実行したイベント数 = %d, want %d", len(executed), len(events))
+	}
+
+	// 同一キーのイベントは変更リストの順に、前のイベントの完了後に実行される
+	last := make(map[string]executedEvent)
+	for _, event := range executed {
+		if prev, ok := last[event.key]; ok {
+			if event.index < prev.index {
+				t.Errorf("%s: イベント %d が %d より後に実行されました", event.key, prev.index, event.index)
+			}
+			if event.start.Before(prev.end) {
+				t.Errorf("%s: イベント %d が %d の完了前に開始しました", event.key, event.index, prev.index)
+			}
+		}
+		last[event.key] = event
+	}
+}
+
+// TestReplaySchedulerNoStall は同一キーの実行待ちのイベントがワーカーを占有しないことを確認します
+func TestReplaySchedulerNoStall(t *testing.T) {
+	now := time.Now()
+	events := []*scheduledEvent{
+		{entry: replayEntry{Index: 0}, destKey: "a", scheduledAt: now},
+		{entry: replayEntry{Index: 1}, destKey: "a", scheduledAt: now.Add(10 * time.Millisecond)},
+		{entry: replayEntry{Index: 2}, destKey: "b", scheduledAt: now.Add(20 * time.Millisecond)},
+	}
+
+	executed := runTestScheduler(context.Background(), t, events, 2, func(event *scheduledEvent) {
+		if event.entry.Index == 0 {
+			time.Sleep(300 * time.Millisecond)
+		}
+	})
+
+	for _, event := range executed {
+		lag := event.start.Sub(events[event.index].scheduledAt)
+		switch event.index {
+		case 1:
+			if event.start.Before(executed[0].end) {
+				t.Errorf("イベント1がイベント0の完了前に開始しました")
+			}
+		case 2:
+			if lag > 100*time.Millisecond {
+				t.Errorf("別のキーのイベント2が %s 遅れました", lag)
+			}
+		}
+	}
+}
+
+func TestReplaySchedulerTiming(t *testing.T) {
+	now := time.Now()
+	events := []*scheduledEvent{
+		{entry: replayEntry{Index: 0}, destKey: "a", scheduledAt: now.Add(80 * time.Millisecond)},
+		{entry: replayEntry{Index: 1}, destKey: "b", scheduledAt: now.Add(20 * time.Millisecond)},
+	}
+
+	executed := runTestScheduler(context.Background(), t, events, 1, func(*scheduledEvent) {})
+	if len(executed) != 2 || executed[0].index != 1 || executed[1].index != 0 {
+		t.Fatalf("実行順 = %+v, want 予定時刻の順", executed)
+	}
+	for _, event := range executed {
+		if event.start.Before(events[event.index].scheduledAt) {
+			t.Errorf("イベント %d が予定の時刻より前に実行されました", event.index)
+		}
+	}
+}
+
+func TestReplaySchedulerCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	events := []*scheduledEvent{
+		{entry: replayEntry{Index: 0}, destKey: "a", scheduledAt: now},
+		{entry: replayEntry{Index: 1}, destKey: "a", scheduledAt: now},
+		{entry: replayEntry{Index: 2}, destKey: "b", scheduledAt: now.Add(time.Hour)},
+	}
+
+	// 最初のイベントの実行中に中断すると、実行中のイベントの完了後に終了する
+	executed := runTestScheduler(ctx, t, events, 2, func(event *scheduledEvent) {
+		cancel()
+		time.Sleep(20 * time.Millisecond)
+	})
+	if len(executed) != 1 || executed[0].index != 0 {
+		t.Errorf("中断後に実行されたイベント = %+v", executed)
+	}
+}
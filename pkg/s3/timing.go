@@ -0,0 +1,131 @@
+package s3
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SpeedProfileStep は再生速度のプロファイルの1区間
+// 最初の変更からの元の時間が Offset 以降の変更の間隔を Factor 倍速で再生します
+type SpeedProfileStep struct {
+	Offset time.Duration
+	Factor float64
+}
+
+// ParseSpeedProfile は "0s=1,1h=10,3h30m=2" のような再生速度のプロファイルを解析します
+// 各区間は <最初の変更からの元の時間>=<再生速度の倍率> で指定し、時間の順に並べ替えます
+func ParseSpeedProfile(spec string) ([]SpeedProfileStep, error) {
+	var steps []SpeedProfileStep
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		offsetStr, factorStr, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("再生速度のプロファイルの区間 %q の形式が無効です（例: 1h=10）", part)
+		}
+		offset, err := time.ParseDuration(strings.TrimSpace(offsetStr))
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("再生速度のプロファイルの区間 %q の時間が無効です（例: 0s、90m、1h30m）", part)
+		}
+		factor, err := strconv.ParseFloat(strings.TrimSpace(factorStr), 64)
+		if err != nil || factor <= 0 {
+			return nil, fmt.Errorf("再生速度のプロファイルの区間 %q の倍率には0より大きい値を指定してください", part)
+		}
+		steps = append(steps, SpeedProfileStep{Offset: offset, Factor: factor})
+	}
+
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].Offset < steps[j].Offset })
+	return steps, nil
+}
+
+// replayClock は変更の時刻からリプレイの予定の時刻を計算します
+//
+// 直前の変更からの間隔を、その区間の再生速度で割ってから上限で切り詰め、開始時間からの時間に積み上げます
+// 夜間のような長い空白は上限の間隔に縮め、バーストは元の間隔のまま再生できます
+// ゆらぎは各イベントの予定の時刻にのみ加え、後続のイベントの予定の時刻には影響しません
+type replayClock struct {
+	start       time.Time
+	speedFactor float64            // プロファイルの最初の区間より前の再生速度
+	profile     []SpeedProfileStep // 時間の順に並べた再生速度のプロファイル
+	maxGap      time.Duration      // 再生時の間隔の上限（0の場合は無制限）
+	jitter      time.Duration      // 予定の時刻に加えるゆらぎの最大値（前後にばらつかせる）
+	randN       func(n int64) int64
+
+	first   time.Time     // 最初の変更の時刻
+	prev    time.Time     // 直前の変更の時刻
+	elapsed time.Duration // 直前の変更の開始時間からの再生時の時間
+}
+
+// newReplayClock はリプレイのオプションから予定の時刻の計算方法を作成します
+func newReplayClock(start time.Time, speedFactor float64, opts ReplayOptions) *replayClock {
+	return &replayClock{
+		start:       start,
+		speedFactor: speedFactor,
+		profile:     opts.SpeedProfile,
+		maxGap:      opts.MaxGap,
+		jitter:      opts.Jitter,
+		randN:       rand.Int64N,
+	}
+}
+
+// Schedule は変更の時刻からリプレイの予定の時刻を返します。変更は時刻の順に渡してください
+// 直前の変更より前の時刻（先読みの範囲を超えて順序が逆転した変更）は直前の変更と同じ時刻とみなします
+func (c *replayClock) Schedule(timestamp time.Time) time.Time {
+	if c.first.IsZero() {
+		c.first = timestamp
+		c.prev = timestamp
+	}
+
+	if timestamp.After(c.prev) {
+		gap := c.scaledSpan(c.prev.Sub(c.first), timestamp.Sub(c.first))
+		if c.maxGap > 0 && gap > c.maxGap {
+			gap = c.maxGap
+		}
+		c.elapsed += gap
+		c.prev = timestamp
+	}
+
+	scheduledAt := c.start.Add(c.elapsed)
+	if c.jitter > 0 {
+		scheduledAt = scheduledAt.Add(time.Duration(c.randN(2*int64(c.jitter)+1)) - c.jitter)
+		if scheduledAt.Before(c.start) {
+			scheduledAt = c.start
+		}
+	}
+	return scheduledAt
+}
+
+// scaledSpan は最初の変更からの元の時間 from から to までを、区間ごとの再生速度で割った再生時の時間を返します
+func (c *replayClock) scaledSpan(from, to time.Duration) time.Duration {
+	var scaled float64
+	for from < to {
+		factor, next := c.factorAt(from)
+		end := to
+		if next > from && next < to {
+			end = next
+		}
+		scaled += float64(end-from) / factor
+		from = end
+	}
+	return time.Duration(scaled)
+}
+
+// factorAt は最初の変更からの元の時間 offset での再生速度と、次の区間の開始時間を返します
+// 次の区間がない場合、開始時間は0になります
+func (c *replayClock) factorAt(offset time.Duration) (float64, time.Duration) {
+	factor := c.speedFactor
+	for _, step := range c.profile {
+		if step.Offset > offset {
+			return factor, step.Offset
+		}
+		factor = step.Factor
+	}
+	return factor, 0
+}
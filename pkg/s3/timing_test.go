@@ -0,0 +1,109 @@
+package s3
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSpeedProfile(t *testing.T) {
+	steps, err := ParseSpeedProfile("2h=60, 0s=1,8h=0.5")
+	if err != nil {
+		t.Fatalf("ParseSpeedProfile() error = %v", err)
+	}
+	want := []SpeedProfileStep{{Offset: 0, Factor: 1}, {Offset: 2 * time.Hour, Factor: 60}, {Offset: 8 * time.Hour, Factor: 0.5}}
+	if len(steps) != len(want) {
+		t.Fatalf("ParseSpeedProfile() = %+v, want %+v", steps, want)
+	}
+	for i := range want {
+		if steps[i] != want[i] {
+			t.Errorf("steps[%d] = %+v, want %+v", i, steps[i], want[i])
+		}
+	}
+
+	if steps, err := ParseSpeedProfile(""); err != nil || steps != nil {
+		t.Errorf("ParseSpeedProfile(\"\") = %+v, %v, want nil", steps, err)
+	}
+
+	for _, spec := range []string{"1h", "x=2", "1h=0", "-1h=2", "1h=fast"} {
+		if _, err := ParseSpeedProfile(spec); err == nil {
+			t.Errorf("ParseSpeedProfile(%q) error = nil, want error", spec)
+		}
+	}
+}
+
+func TestReplayClock(t *testing.T) {
+	start := time.Date(2025, 6, 6, 0, 0, 0, 0, time.UTC)
+	base := time.Date(2025, 6, 5, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		opts    ReplayOptions
+		speed   float64
+		offsets []time.Duration // 最初の変更からの元の時間
+		want    []time.Duration // 開始時間からの予定の時間
+	}{
+		{
+			name:    "一定の再生速度",
+			speed:   2,
+			offsets: []time.Duration{0, 10 * time.Second, 30 * time.Second},
+			want:    []time.Duration{0, 5 * time.Second, 15 * time.Second},
+		},
+		{
+			name:    "長い空白を上限に縮める",
+			speed:   1,
+			opts:    ReplayOptions{MaxGap: 5 * time.Second},
+			offsets: []time.Duration{0, time.Second, 8 * time.Hour, 8*time.Hour + 2*time.Second},
+			want:    []time.Duration{0, time.Second, 6 * time.Second, 8 * time.Second},
+		},
+		{
+			name:    "区間をまたぐ間隔は区間ごとの再生速度で計算",
+			speed:   1,
+			opts:    ReplayOptions{SpeedProfile: []SpeedProfileStep{{Offset: time.Minute, Factor: 10}, {Offset: 11 * time.Minute, Factor: 1}}},
+			offsets: []time.Duration{0, 30 * time.Second, 2 * time.Minute, 12 * time.Minute},
+			// 30s、30s + 30s + 1m/10、66s + 9m/10 + 1m
+			want: []time.Duration{0, 30 * time.Second, 66 * time.Second, 3 * time.Minute},
+		},
+		{
+			name:    "順序が逆転した変更は直前の変更と同じ時刻",
+			speed:   1,
+			offsets: []time.Duration{0, 10 * time.Second, 5 * time.Second, 12 * time.Second},
+			want:    []time.Duration{0, 10 * time.Second, 10 * time.Second, 12 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newReplayClock(start, tt.speed, tt.opts)
+			for i, offset := range tt.offsets {
+				got := clock.Schedule(base.Add(offset)).Sub(start)
+				if got != tt.want[i] {
+					t.Errorf("%d件目の予定の時間 = %s, want %s", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReplayClockJitter(t *testing.T) {
+	start := time.Date(2025, 6, 6, 0, 0, 0, 0, time.UTC)
+	base := time.Date(2025, 6, 5, 10, 0, 0, 0, time.UTC)
+
+	clock := newReplayClock(start, 1, ReplayOptions{Jitter: time.Second})
+	values := []int64{0, 2 * int64(time.Second), int64(time.Second)}
+	clock.randN = func(n int64) int64 {
+		if n != 2*int64(time.Second)+1 {
+			t.Errorf("randN(%d), want %d", n, 2*int64(time.Second)+1)
+		}
+		v := values[0]
+		values = values[1:]
+		return v
+	}
+
+	// ゆらぎは開始時間より前にはならず、後続のイベントの予定の時刻にも影響しない
+	want := []time.Duration{0, 11 * time.Second, 20 * time.Second}
+	for i, offset := range []time.Duration{0, 10 * time.Second, 20 * time.Second} {
+		if got := clock.Schedule(base.Add(offset)).Sub(start); got != want[i] {
+			t.Errorf("%d件目の予定の時間 = %s, want %s", i, got, want[i])
+		}
+	}
+}
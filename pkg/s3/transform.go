@@ -0,0 +1,249 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// 変換の種類（--transform の接頭辞）
+const (
+	TransformExec     = "exec"     // exec:<コマンド> [引数...]
+	TransformTruncate = "truncate" // truncate:<バイト数>
+)
+
+// maxTransformStderrSize はエラーに含めるコマンドの標準エラー出力の最大サイズ
+const maxTransformStderrSize = 4096
+
+// ObjectTransformer はリプレイでコピーするオブジェクトの内容を宛先に書き込む前に変換します
+// 個人情報の匿名化や大きなファイルの切り詰めなど、変更元の内容をそのまま宛先に書き込めない場合に使用します
+// ライブラリとして使用する場合は、このインターフェースを実装して ReplayOptions.Transformer に指定できます
+type ObjectTransformer interface {
+	// Transform は変更元の内容を読み込みながら変換した内容を返します
+	// 入力の Body は呼び出し側が閉じます。返された Body は呼び出し側が閉じる必要があり、
+	// 変換に失敗した場合は Body の読み込みでエラーを返します（宛先への書き込みを中止させるため）
+	Transform(ctx context.Context, object TransformObject, content *ObjectContent) (*ObjectContent, error)
+}
+
+// TransformObject は変換するオブジェクトの情報
+type TransformObject struct {
+	SourceBucket string
+	SourceKey    string
+	VersionID    string
+	DestBucket   string
+	DestKey      string
+}
+
+// NewObjectTransformer は変換の指定から ObjectTransformer を作成します
+// 複数指定した場合は指定した順に適用します（指定がない場合はnil）
+//
+//	exec:<コマンド> [引数...]  コマンドの標準入力に内容を渡し、標準出力を変換後の内容とする
+//	truncate:<バイト数>        先頭から指定したバイト数までに切り詰める（例: truncate:1MiB）
+func NewObjectTransformer(specs []string) (ObjectTransformer, error) {
+	var transformers []ObjectTransformer
+	for _, spec := range specs {
+		kind, target, ok := strings.Cut(spec, ":")
+		if !ok || target == "" {
+			return nil, fmt.Errorf("変換 %q の形式が無効です。exec: または truncate: で指定してください", spec)
+		}
+
+		switch kind {
+		case TransformExec:
+			transformer, err := newCommandTransformer(target)
+			if err != nil {
+				return nil, err
+			}
+			transformers = append(transformers, transformer)
+		case TransformTruncate:
+			limit, err := ParseByteSize(target)
+			if err != nil {
+				return nil, err
+			}
+			transformers = append(transformers, truncateTransformer{limit: int64(limit)})
+		default:
+			return nil, fmt.Errorf("変換の種類 %s が無効です。exec、truncate のいずれかを指定してください", kind)
+		}
+	}
+
+	switch len(transformers) {
+	case 0:
+		return nil, nil
+	case 1:
+		return transformers[0], nil
+	default:
+		return transformerChain(transformers), nil
+	}
+}
+
+// transformerChain は複数の変換を順に適用します
+type transformerChain []ObjectTransformer
+
+func (c transformerChain) Transform(ctx context.Context, object TransformObject, content *ObjectContent) (*ObjectContent, error) {
+	// 途中の変換の出力は、最後の変換の出力を閉じる際にまとめて閉じる
+	var closers []io.Closer
+	for _, transformer := range c {
+		transformed, err := transformer.Transform(ctx, object, content)
+		if err != nil {
+			closeAll(closers)
+			return nil, err
+		}
+		closers = append(closers, transformed.Body)
+		content = transformed
+	}
+	return &ObjectContent{Body: &chainBody{Reader: content.Body, closers: closers}, Metadata: content.Metadata}, nil
+}
+
+// chainBody は最後の変換の出力を読み込み、閉じる際は全ての変換の出力を閉じます
+type chainBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *chainBody) Close() error {
+	return closeAll(b.closers)
+}
+
+// closeAll は後に作成したものから順に閉じ、最初のエラーを返します
+func closeAll(closers []io.Closer) error {
+	var firstErr error
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// truncateTransformer は内容を先頭から指定したバイト数までに切り詰めます
+type truncateTransformer struct {
+	limit int64
+}
+
+func (t truncateTransformer) Transform(ctx context.Context, object TransformObject, content *ObjectContent) (*ObjectContent, error) {
+	return &ObjectContent{Body: io.NopCloser(io.LimitReader(content.Body, t.limit)), Metadata: content.Metadata}, nil
+}
+
+// newCommandTransformer はオブジェクトごとにコマンドを実行する変換を作成します
+// コマンドは空白で区切って引数に分割します
+func newCommandTransformer(target string) (*commandTransformer, error) {
+	args := strings.Fields(target)
+	if len(args) == 0 {
+		return nil, errors.New("変換に使用するコマンドを指定してください")
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return nil, fmt.Errorf("コマンド %s が見つかりません: %w", args[0], err)
+	}
+	return &commandTransformer{args: args}, nil
+}
+
+// commandTransformer はオブジェクトごとにコマンドを起動し、標準入力に内容を渡して標準出力を変換後の内容とします
+// オブジェクトの情報は環境変数 TRAV_SOURCE_BUCKET、TRAV_SOURCE_KEY、TRAV_VERSION_ID、TRAV_DEST_BUCKET、
+// TRAV_DEST_KEY、TRAV_CONTENT_TYPE で渡します
+type commandTransformer struct {
+	args []string
+}
+
+func (t *commandTransformer) Transform(ctx context.Context, object TransformObject, content *ObjectContent) (*ObjectContent, error) {
+	cmd := exec.CommandContext(ctx, t.args[0], t.args[1:]...)
+	cmd.Stdin = content.Body
+	cmd.Env = append(os.Environ(),
+		"TRAV_SOURCE_BUCKET="+object.SourceBucket,
+		"TRAV_SOURCE_KEY="+object.SourceKey,
+		"TRAV_VERSION_ID="+object.VersionID,
+		"TRAV_DEST_BUCKET="+object.DestBucket,
+		"TRAV_DEST_KEY="+object.DestKey,
+		"TRAV_CONTENT_TYPE="+content.Metadata.ContentType,
+	)
+	output := &commandOutput{cmd: cmd, stderr: limitedBuffer{limit: maxTransformStderrSize}}
+	cmd.Stderr = &output.stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("変換のコマンドの起動に失敗しました: %w", err)
+	}
+	output.stdout = stdout
+
+	return &ObjectContent{Body: output, Metadata: content.Metadata}, nil
+}
+
+// commandOutput は変換のコマンドの標準出力
+// 最後まで読み込んだ際にコマンドの終了を待ち、失敗した場合は io.EOF の代わりにエラーを返します
+type commandOutput struct {
+	cmd     *exec.Cmd
+	stdout  io.ReadCloser
+	stderr  limitedBuffer
+	done    bool
+	waitErr error
+}
+
+func (o *commandOutput) Read(p []byte) (int, error) {
+	if o.done {
+		if o.waitErr != nil {
+			return 0, o.waitErr
+		}
+		return 0, io.EOF
+	}
+
+	n, err := o.stdout.Read(p)
+	if errors.Is(err, io.EOF) {
+		if waitErr := o.wait(); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// Close は最後まで読み込まずに閉じた場合はコマンドを終了させます
+func (o *commandOutput) Close() error {
+	if o.done {
+		return nil
+	}
+	o.cmd.Process.Kill()
+	o.wait()
+	return nil
+}
+
+// wait はコマンドの終了を待ち、失敗した場合は標準エラー出力を含むエラーを返します
+func (o *commandOutput) wait() error {
+	o.done = true
+	if err := o.cmd.Wait(); err != nil {
+		o.waitErr = fmt.Errorf("変換のコマンドが失敗しました: %w", err)
+		if stderr := strings.TrimSpace(o.stderr.buf.String()); stderr != "" {
+			if o.stderr.truncated {
+				stderr += "...(省略)"
+			}
+			o.waitErr = fmt.Errorf("%w: %s", o.waitErr, stderr)
+		}
+	}
+	return o.waitErr
+}
+
+// limitedBuffer は最大サイズまでの書き込みを保持し、超えた分を捨てるio.Writer
+// コマンドが大量の標準エラー出力を書き込んでもメモリを使い続けないようにします
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool // 最大サイズを超えて捨てた書き込みがあるかどうか
+}
+
+// Write は最大サイズまでを保持し、コマンドへの書き込みを失敗させないように常に全て書き込んだとして返します
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	remaining := b.limit - b.buf.Len()
+	if len(p) > remaining {
+		b.truncated = true
+		if remaining > 0 {
+			b.buf.Write(p[:remaining])
+		}
+		return len(p), nil
+	}
+	b.buf.Write(p)
+	return len(p), nil
+}
@@ -0,0 +1,131 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// transformString は文字列を変換した結果を返します
+func transformString(t *testing.T, transformer ObjectTransformer, body string) (string, error) {
+	t.Helper()
+	input := &ObjectContent{Body: io.NopCloser(strings.NewReader(body))}
+	content, err := transformer.Transform(context.Background(), TransformObject{SourceKey: "a.txt", DestKey: "stg/a.txt"}, input)
+	if err != nil {
+		return "", err
+	}
+	defer content.Body.Close()
+	data, err := io.ReadAll(content.Body)
+	return string(data), err
+}
+
+func TestNewObjectTransformer(t *testing.T) {
+	if transformer, err := NewObjectTransformer(nil); err != nil || transformer != nil {
+		t.Errorf("NewObjectTransformer(nil) = %v, %v, want nil", transformer, err)
+	}
+
+	for _, specs := range [][]string{{"exec:"}, {"gzip:9"}, {"truncate:abc"}, {"exec:trav-no-such-command"}} {
+		if _, err := NewObjectTransformer(specs); err == nil {
+			t.Errorf("NewObjectTransformer(%q) error = nil, want error", specs)
+		}
+	}
+
+	// 指定した順に適用する
+	transformer, err := NewObjectTransformer([]string{"exec:tr a-z A-Z", "truncate:5"})
+	if err != nil {
+		t.Fatalf("NewObjectTransformer() error = %v", err)
+	}
+	got, err := transformString(t, transformer, "hello world")
+	if err != nil || got != "HELLO" {
+		t.Errorf("変換の結果 = %q, %v, want HELLO", got, err)
+	}
+}
+
+func TestCommandTransformer(t *testing.T) {
+	// オブジェクトの情報は環境変数で渡す
+	transformer, err := newCommandTransformer("printenv TRAV_DEST_KEY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := transformString(t, transformer, "")
+	if err != nil || got != "stg/a.txt\n" {
+		t.Errorf("変換の結果 = %q, %v, want stg/a.txt", got, err)
+	}
+
+	// コマンドが失敗した場合は読み込みでエラーを返す
+	transformer, err = newCommandTransformer("false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transformString(t, transformer, "hello"); err == nil {
+		t.Error("失敗したコマンドの変換のエラー = nil, want error")
+	}
+
+	// 標準エラー出力は最大サイズまでをエラーに含める
+	transformer = &commandTransformer{args: []string{"sh", "-c", "head -c 100000 /dev/zero | tr '\\0' x >&2; exit 1"}}
+	_, err = transformString(t, transformer, "")
+	if err == nil || !strings.Contains(err.Error(), "xxx...(省略)") {
+		t.Fatalf("失敗したコマンドの変換のエラー = %v, want 省略した標準エラー出力", err)
+	}
+	if len(err.Error()) > maxTransformStderrSize+100 {
+		t.Errorf("エラーの長さ = %d, want %d 程度", len(err.Error()), maxTransformStderrSize)
+	}
+}
+
+// TestReplayTransform は変換した内容を宛先に書き込み、変換の失敗はイベントの失敗にすることを確認します
+func TestReplayTransform(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	source := newTestLocalBackend(t, filepath.Join(dir, "source"))
+
+	start := time.Now().UTC()
+	putLocalObject(t, source, "prod", "a.txt", "secret")
+
+	_, changesFile := writeLocalChangesFile(t, source, "prod", start, dir)
+
+	// 同じストレージへのコピーでも変換するため、サーバーサイドコピーは使用しない
+	transformer, _ := NewObjectTransformer([]string{"exec:tr a-z *", "truncate:3"})
+	result, err := Replay(ctx, ReplayOptions{
+		SourceBucket:      "prod",
+		DestBucket:        "staging",
+		SourceFile:        changesFile,
+		IgnoreTimeWindows: true,
+		SourceBackend:     source,
+		DestBackend:       source,
+		Transformer:       transformer,
+		Verify:            true,
+	})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if result.SuccessEvents != 1 {
+		t.Fatalf("結果 = %+v", result.Events)
+	}
+	if got := readLocalObject(t, source, "staging", "a.txt"); got != "***" {
+		t.Errorf("宛先の内容 = %q, want ***", got)
+	}
+	// 変換によりサイズが変わっても、記録した変更元のバージョンIDで検証する
+	if result.Verification == nil || result.Verification.MismatchedKeys != 0 {
+		t.Errorf("検証の結果 = %+v", result.Verification)
+	}
+
+	transformer, _ = NewObjectTransformer([]string{"exec:false"})
+	result, _ = Replay(ctx, ReplayOptions{
+		SourceBucket:      "prod",
+		DestBucket:        "failed",
+		SourceFile:        changesFile,
+		IgnoreTimeWindows: true,
+		SourceBackend:     source,
+		DestBackend:       source,
+		Transformer:       transformer,
+	})
+	if result.FailedEvents != 1 {
+		t.Errorf("変換に失敗したイベント = %+v, want FAILED", result.Events)
+	}
+	if got := readLocalObject(t, source, "failed", "a.txt"); got != "" {
+		t.Errorf("変換に失敗したオブジェクトが書き込まれました: %q", got)
+	}
+}
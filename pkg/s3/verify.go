@@ -0,0 +1,237 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// VerifyResult はリプレイ後に宛先の状態を検証した結果
+type VerifyResult struct {
+	CheckedKeys    int              `json:"checkedKeys"`              // 確認した宛先のキーの数
+	MismatchedKeys int              `json:"mismatchedKeys"`           // 期待する状態と異なった（または確認できなかった）キーの数
+	SkippedTargets []string         `json:"skippedTargets,omitempty"` // オブジェクトを変更しないため検証しなかった宛先
+	Mismatches     []VerifyMismatch `json:"mismatches,omitempty"`
+}
+
+// VerifyMismatch は宛先の状態が変更リストから求めた最終的な状態と異なったキー
+type VerifyMismatch struct {
+	Destination string     `json:"destination,omitempty"` // 複数の宛先にリプレイした場合の宛先の名前
+	Key         string     `json:"key"`                   // 宛先のキー
+	SourceKey   string     `json:"sourceKey"`             // 最後の変更の変更元のキー
+	ChangeType  ChangeType `json:"changeType"`            // 最後の変更の種類
+	VersionID   string     `json:"versionId"`             // 最後の変更のバージョンID
+	Reason      string     `json:"reason"`
+}
+
+// verifyJob は検証する宛先のキーと、そのキーへの最後の変更
+type verifyJob struct {
+	target  *replayTarget
+	destKey string
+	change  ObjectChange
+}
+
+// verifyReplay は変更リストから宛先のキーごとの最終的な状態を求め、宛先のオブジェクトと一致するかを確認します
+// 変更リストはリプレイと同じ条件で絞り込み、同じ順序で読み込むため、最後に反映された変更が期待する状態になります
+// イベント通知のみの宛先はオブジェクトを変更しないため検証しません
+func verifyReplay(ctx context.Context, opts ReplayOptions, targets []*replayTarget, limiter *RateLimiter, concurrency int) (*VerifyResult, error) {
+	result := &VerifyResult{}
+	var verifiable []*replayTarget
+	for _, target := range targets {
+		if target.dest == nil {
+			result.SkippedTargets = append(result.SkippedTargets, target.Name)
+			continue
+		}
+		verifiable = append(verifiable, target)
+	}
+
+	jobs, err := expectedFinalStates(ctx, opts, verifiable)
+	if err != nil {
+		return nil, err
+	}
+	result.CheckedKeys = len(jobs)
+	slog.Info("リプレイ後の宛先の状態を検証します", "keys", len(jobs))
+
+	jobCh := make(chan verifyJob)
+	mismatchCh := make(chan VerifyMismatch)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobCh {
+				reason := verifyDestKey(ctx, opts, limiter, job)
+				if reason == "" {
+					continue
+				}
+				mismatchCh <- VerifyMismatch{
+					Destination: job.target.Name,
+					Key:         job.destKey,
+					SourceKey:   job.change.Key,
+					ChangeType:  job.change.ChangeType,
+					VersionID:   job.change.VersionID,
+					Reason:      reason,
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobCh)
+		for _, job := range jobs {
+			select {
+			case jobCh <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(mismatchCh)
+	}()
+
+	for mismatch := range mismatchCh {
+		slog.Warn("宛先の状態が変更リストと一致しません", "destination", mismatch.Destination, "key", mismatch.Key, "reason", mismatch.Reason)
+		result.Mismatches = append(result.Mismatches, mismatch)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("検証が中断されました: %w", err)
+	}
+
+	// 出力を安定させるため、宛先の順、キーの順に並べる
+	order := make(map[string]int, len(targets))
+	for i, target := range targets {
+		order[target.Name] = i
+	}
+	sort.Slice(result.Mismatches, func(i, j int) bool {
+		a, b := result.Mismatches[i], result.Mismatches[j]
+		if a.Destination != b.Destination {
+			return order[a.Destination] < order[b.Destination]
+		}
+		return a.Key < b.Key
+	})
+	result.MismatchedKeys = len(result.Mismatches)
+
+	// 1つの宛先の場合は結果に宛先の名前を記録しない（ReplayEvent と同じ）
+	if len(targets) == 1 {
+		for i := range result.Mismatches {
+			result.Mismatches[i].Destination = ""
+		}
+	}
+	return result, nil
+}
+
+// expectedFinalStates は変更リストを読み込み、宛先ごとに各キーへの最後の変更を求めます
+// 書き換えにより複数のキーが同じ宛先のキーになる場合は、最後に反映された変更を使用します
+// 宛先ごとに全てのキーの最後の変更をメモリに保持するため、使用するメモリは宛先の数と変更リストの異なるキーの数に比例します
+// （1キーあたり数百バイト程度）。数千万キーを超える変更リストでは、Filter で対象を絞って検証してください
+func expectedFinalStates(ctx context.Context, opts ReplayOptions, targets []*replayTarget) ([]verifyJob, error) {
+	reader, err := openChangeListReader(ctx, opts.SourceFile, opts.changeListOptions())
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	finals := make([]map[string]ObjectChange, len(targets))
+	for i := range finals {
+		finals[i] = make(map[string]ObjectChange)
+	}
+
+	ordered := newTimeOrderedChanges(reader, opts.Lookahead, opts.Filter)
+	for {
+		entry, err := ordered.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("変更リストの読み込みに失敗しました: %w", err)
+		}
+
+		for i, target := range targets {
+			// 宛先のキーを決定できなかった変更はリプレイでも失敗しているため検証しない
+			destKey, err := target.KeyRewriter.Rewrite(entry.Change)
+			if err != nil {
+				continue
+			}
+			finals[i][destKey] = entry.Change
+		}
+	}
+
+	var jobs []verifyJob
+	for i, target := range targets {
+		keys := make([]string, 0, len(finals[i]))
+		for key := range finals[i] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			jobs = append(jobs, verifyJob{target: target, destKey: key, change: finals[i][key]})
+		}
+	}
+	return jobs, nil
+}
+
+// verifyDestKey は宛先のキーの現在の状態を確認し、期待する状態と異なる場合はその理由を返します（一致した場合は空文字列）
+func verifyDestKey(ctx context.Context, opts ReplayOptions, limiter *RateLimiter, job verifyJob) string {
+	var current *ObjectInfo
+	_, err := opts.Retry.Do(ctx, "HeadObject "+job.target.Bucket+"/"+job.destKey, func() error {
+		if err := limiter.WaitRequest(ctx, job.destKey); err != nil {
+			return err
+		}
+		var err error
+		current, err = job.target.dest.HeadObject(ctx, job.target.Bucket, job.destKey)
+		return err
+	})
+	if err != nil {
+		return fmt.Sprintf("宛先のオブジェクトの確認に失敗しました: %v", err)
+	}
+	return verifyReason(current, job.change)
+}
+
+// verifyReason は宛先のオブジェクトの現在の状態が最後の変更を反映した状態と異なる場合にその理由を返します
+//
+// 削除された場合はオブジェクトが存在しないこと、それ以外はオブジェクトが存在することを確認します
+// trav が変更元のバージョンIDを記録している場合はバージョンIDを比較し、記録していない場合はサイズとETagを比較します
+// マルチパートアップロードのETagはパートの分割により変わるため、どちらかがマルチパートのETagの場合は比較しません
+func verifyReason(current *ObjectInfo, change ObjectChange) string {
+	if change.ChangeType == ChangeTypeDelete {
+		if current != nil {
+			return "削除されたオブジェクトが宛先に存在します"
+		}
+		return ""
+	}
+	if current == nil {
+		return "宛先にオブジェクトが存在しません"
+	}
+
+	// 変換してコピーした場合はサイズとETagが変わるため、記録したバージョンIDのみを比較する
+	versionID := sourceVersionID(change)
+	if marked, ok := current.Metadata.UserMetadata[SourceVersionMetadataKey]; ok && versionID != "" {
+		if marked != versionID {
+			return fmt.Sprintf("宛先のオブジェクトは変更元の別のバージョンからコピーされています（期待 %s、宛先 %s）", versionID, marked)
+		}
+		return ""
+	}
+
+	if current.Size != change.Size {
+		return fmt.Sprintf("サイズが異なります（期待 %d、宛先 %d）", change.Size, current.Size)
+	}
+
+	expected, actual := normalizeETag(change.ETag), normalizeETag(current.ETag)
+	if expected != "" && expected != actual && !isMultipartETag(expected) && !isMultipartETag(actual) {
+		return fmt.Sprintf("ETagが異なります（期待 %s、宛先 %s）", expected, actual)
+	}
+	return ""
+}
+
+// isMultipartETag はマルチパートアップロードで作成されたオブジェクトのETag（-<パート数> が付く）かを返します
+func isMultipartETag(etag string) bool {
+	return strings.Contains(etag, "-")
+}
@@ -0,0 +1,102 @@
+package s3
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyReason(t *testing.T) {
+	tests := []struct {
+		name    string
+		current *ObjectInfo
+		change  ObjectChange
+		want    string // 理由に含まれる文字列（空の場合は一致）
+	}{
+		{"削除済み", nil, ObjectChange{ChangeType: ChangeTypeDelete}, ""},
+		{"削除されていない", &ObjectInfo{}, ObjectChange{ChangeType: ChangeTypeDelete}, "存在します"},
+		{"存在しない", nil, ObjectChange{ChangeType: ChangeTypeCreate, Size: 1}, "存在しません"},
+		{"サイズが異なる", &ObjectInfo{Size: 2, ETag: `"a"`}, ObjectChange{ChangeType: ChangeTypeUpdate, Size: 1, ETag: `"a"`}, "サイズ"},
+		{"ETagが一致", &ObjectInfo{Size: 1, ETag: `"a"`}, ObjectChange{ChangeType: ChangeTypeUpdate, Size: 1, ETag: "a"}, ""},
+		{"ETagが異なる", &ObjectInfo{Size: 1, ETag: `"b"`}, ObjectChange{ChangeType: ChangeTypeUpdate, Size: 1, ETag: `"a"`}, "ETag"},
+		{"マルチパートのETagは比較しない", &ObjectInfo{Size: 1, ETag: `"b-2"`}, ObjectChange{ChangeType: ChangeTypeCreate, Size: 1, ETag: `"a"`}, ""},
+		{
+			"記録したバージョンIDが異なる",
+			&ObjectInfo{Size: 1, ETag: `"a"`, Metadata: ObjectMetadata{UserMetadata: map[string]string{SourceVersionMetadataKey: "v1"}}},
+			ObjectChange{ChangeType: ChangeTypeUpdate, VersionID: "v2", Size: 1, ETag: `"a"`},
+			"バージョン",
+		},
+		{
+			"記録したバージョンIDが一致すればETagは比較しない",
+			&ObjectInfo{Size: 1, ETag: `"b"`, Metadata: ObjectMetadata{UserMetadata: map[string]string{SourceVersionMetadataKey: "v2"}}},
+			ObjectChange{ChangeType: ChangeTypeUpdate, VersionID: "v2", Size: 1, ETag: `"a"`},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := verifyReason(tt.current, tt.change)
+			if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+				t.Errorf("verifyReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestReplayVerify はリプレイ後の宛先の状態を変更リストの最後の変更と比較することを確認します
+func TestReplayVerify(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	source := newTestLocalBackend(t, filepath.Join(dir, "source"))
+	dest := newTestLocalBackend(t, filepath.Join(dir, "dest"))
+
+	putLocalObject(t, source, "prod", "c.txt", "c1")
+	start := time.Now().UTC()
+	putLocalObject(t, source, "prod", "a.txt", "a1")
+	putLocalObject(t, source, "prod", "b.txt", "b1")
+	putLocalObject(t, source, "prod", "a.txt", "a22")
+	deleteLocalObject(t, source, "prod", "c.txt")
+
+	_, changesFile := writeLocalChangesFile(t, source, "prod", start, dir)
+
+	opts := ReplayOptions{
+		SourceBucket:      "prod",
+		DestBucket:        "staging",
+		SourceFile:        changesFile,
+		IgnoreTimeWindows: true,
+		SourceBackend:     source,
+		DestBackend:       dest,
+		Verify:            true,
+	}
+	result, err := Replay(ctx, opts)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if result.Verification == nil || result.Verification.MismatchedKeys != 0 {
+		t.Fatalf("検証の結果 = %+v, want 不一致なし", result.Verification)
+	}
+	if result.Verification.CheckedKeys != 3 {
+		t.Errorf("確認したキーの数 = %d, want 3", result.Verification.CheckedKeys)
+	}
+
+	// 宛先を変更すると不一致として報告する
+	putLocalObject(t, dest, "staging", "a.txt", "a1")
+	putLocalObject(t, dest, "staging", "c.txt", "c1")
+	targets, err := newReplayTargets(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verification, err := verifyReplay(ctx, opts, targets, nil, 2)
+	if err != nil {
+		t.Fatalf("verifyReplay() error = %v", err)
+	}
+	if verification.MismatchedKeys != 2 {
+		t.Fatalf("検証の結果 = %+v, want 2件の不一致", verification)
+	}
+	if verification.Mismatches[0].Key != "a.txt" || verification.Mismatches[1].Key != "c.txt" {
+		t.Errorf("不一致のキー = %+v", verification.Mismatches)
+	}
+}
@@ -0,0 +1,270 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// DefaultWatermarkOverlap は増分取得時に前回のウォーターマークから遡って再取得する期間のデフォルト値
+// S3のLastModifiedはアップロード開始時刻のため、前回の一覧取得中に完了したアップロードを取りこぼさないよう
+// この期間の変更は再取得し、前回までに確認済みのバージョンIDで重複を除外します
+const DefaultWatermarkOverlap = time.Hour
+
+// Watermark は前回までに取得した変更リストの到達点を表す構造体
+type Watermark struct {
+	Bucket    string                    `json:"bucket"`
+	Prefix    string                    `json:"prefix"`
+	Timestamp time.Time                 `json:"timestamp"`              // 確認済みの最新の変更時刻
+	Overlap   time.Duration             `json:"overlap"`                // 再取得する期間
+	Keys      map[string]WatermarkEntry `json:"keys"`                   // キーごとの最後に確認した変更
+	Seen      map[string]time.Time      `json:"seenVersions,omitempty"` // 再取得期間内の確認済みバージョン（キーとバージョンID → 変更時刻）
+}
+
+// WatermarkEntry はキーごとの最後に確認した変更
+type WatermarkEntry struct {
+	VersionID      string    `json:"versionId"`
+	Timestamp      time.Time `json:"timestamp"`
+	IsDeleteMarker bool      `json:"isDeleteMarker,omitempty"`
+}
+
+// NewWatermark は新しいWatermarkを作成します
+func NewWatermark(bucket, prefix string, timestamp time.Time, overlap time.Duration) *Watermark {
+	if overlap < 0 {
+		overlap = 0
+	}
+
+	return &Watermark{
+		Bucket:    bucket,
+		Prefix:    prefix,
+		Timestamp: timestamp,
+		Overlap:   overlap,
+		Keys:      make(map[string]WatermarkEntry),
+		Seen:      make(map[string]time.Time),
+	}
+}
+
+// watermarkBatchSize はWatermarkFromChangeList で変更リストを読み込みながらWatermarkに反映する変更の件数
+const watermarkBatchSize = 1000
+
+// WatermarkFromChangeList は前回の変更リストからWatermarkを作成します
+// 変更リスト全体をメモリに読み込まずに、先頭から一定の件数ずつWatermarkに反映します
+func WatermarkFromChangeList(ctx context.Context, filePath string, opts ChangesFileOptions, overlap time.Duration) (*Watermark, error) {
+	reader, err := openChangeListReader(ctx, filePath, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	w := NewWatermark(reader.Header.SourceBucket, reader.Header.SourcePrefix, watermarkWindowEnd(reader.Header), overlap)
+	batch := make([]ObjectChange, 0, watermarkBatchSize)
+	for {
+		change, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		batch = append(batch, change)
+		if len(batch) == watermarkBatchSize {
+			w.Advance(batch)
+			batch = batch[:0]
+		}
+	}
+	w.Advance(batch)
+
+	// エンベロープのヘッダーが changes より後にある場合は、全て読み込んだ後のヘッダーを使用する
+	w.Bucket = reader.Header.SourceBucket
+	w.Prefix = reader.Header.SourcePrefix
+	w.AdvanceTo(watermarkWindowEnd(reader.Header))
+	return w, nil
+}
+
+// watermarkWindowEnd は変更リストのヘッダーから確認済みとみなす時刻を返します
+// 変更がない場合でも取得対象期間の終了時刻までは確認済みとみなします
+func watermarkWindowEnd(header ChangeListHeader) time.Time {
+	if !header.WindowEnd.IsZero() {
+		return header.WindowEnd
+	}
+	return header.WindowStart
+}
+
+// LoadWatermark は状態ファイルからWatermarkを読み込みます
+// ファイルが存在しない場合は nil, nil を返します
+func LoadWatermark(filePath string) (*Watermark, error) {
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("状態ファイルの読み込みに失敗しました: %w", err)
+	}
+
+	var w Watermark
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, fmt.Errorf("状態ファイルのデコードに失敗しました: %w", err)
+	}
+
+	if w.Keys == nil {
+		w.Keys = make(map[string]WatermarkEntry)
+	}
+	if w.Seen == nil {
+		w.Seen = make(map[string]time.Time)
+	}
+
+	return &w, nil
+}
+
+// Save はWatermarkを状態ファイルに保存します
+// 書き込み途中で中断しても前回の状態が壊れないよう、一時ファイルに書き込んでから置き換えます
+func (w *Watermark) Save(filePath string) error {
+	data, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(filePath, data); err != nil {
+		return fmt.Errorf("状態ファイルの保存に失敗しました: %w", err)
+	}
+
+	return nil
+}
+
+// Start は次回の取得開始時刻（ウォーターマークから再取得期間を遡った時刻）を返します
+func (w *Watermark) Start() time.Time {
+	return w.Timestamp.Add(-w.Overlap)
+}
+
+// Validate はWatermarkが指定されたバケットとプレフィックスのものかを確認します
+func (w *Watermark) Validate(bucket, prefix string) error {
+	if w.Bucket != "" && w.Bucket != bucket {
+		return fmt.Errorf("前回の変更リストのバケット %s が指定されたバケット %s と一致しません", w.Bucket, bucket)
+	}
+	if w.Bucket != "" && w.Prefix != prefix {
+		return fmt.Errorf("前回の変更リストのプレフィックス %q が指定されたプレフィックス %q と一致しません", w.Prefix, prefix)
+	}
+	return nil
+}
+
+// Filter は確認済みの変更を除外し、キーごとの最初の新しい変更を前回確認したバージョンに紐付けます
+// changes は同一キーの変更を時間順に並べたものです
+func (w *Watermark) Filter(changes []ObjectChange) []ObjectChange {
+	var filtered []ObjectChange
+	for _, change := range changes {
+		// バージョニングが無効なバケットではバージョンIDが常に "null" のため、変更時刻も比較する
+		if seenAt, ok := w.Seen[seenVersionKey(change)]; ok && seenAt.Equal(change.Timestamp) {
+			continue
+		}
+		filtered = append(filtered, change)
+	}
+
+	if len(filtered) == 0 {
+		return nil
+	}
+
+	// 前のバージョンが判明していない更新は、前回の最後の変更（通常のバージョン）を前のバージョンとする
+	first := &filtered[0]
+	if last, ok := w.Keys[first.Key]; ok && !last.IsDeleteMarker && first.ChangeType == ChangeTypeUpdate && first.PreviousVersionID == "" {
+		first.PreviousVersionID = last.VersionID
+	}
+
+	return filtered
+}
+
+// Advance は取得した変更でWatermarkを更新します
+func (w *Watermark) Advance(changes []ObjectChange) {
+	for _, change := range changes {
+		if change.Timestamp.After(w.Timestamp) {
+			w.Timestamp = change.Timestamp
+		}
+
+		if last, ok := w.Keys[change.Key]; !ok || !change.Timestamp.Before(last.Timestamp) {
+			entry := WatermarkEntry{
+				VersionID:      change.VersionID,
+				Timestamp:      change.Timestamp,
+				IsDeleteMarker: change.IsDeleteMarker,
+			}
+			// 復元の場合は復元されたバージョンが最新になる
+			if change.ChangeType == ChangeTypeUndelete {
+				entry.VersionID = change.PreviousVersionID
+			}
+			w.Keys[change.Key] = entry
+		}
+
+		w.Seen[seenVersionKey(change)] = change.Timestamp
+	}
+
+	w.pruneSeen()
+}
+
+// restoreRemovedDeleteMarker は前回の取得時点で最新だった削除マーカーがバージョン履歴から消えている場合に、
+// その削除マーカーと削除マーカーの削除をバージョン履歴に補います
+func (w *Watermark) restoreRemovedDeleteMarker(key string, history []versionEntry) []versionEntry {
+	last, ok := w.Keys[key]
+	if !ok || !last.IsDeleteMarker {
+		return history
+	}
+
+	for _, entry := range history {
+		if entry.IsDeleteMarker && entry.VersionID == last.VersionID {
+			return history
+		}
+	}
+
+	// 削除された時刻は分からないため、前回の到達点の時点で削除されたものとみなす
+	removedAt := w.Timestamp
+	if removedAt.Before(last.Timestamp) {
+		removedAt = last.Timestamp
+	}
+
+	restored := append([]versionEntry(nil), history...)
+	restored = append(restored,
+		versionEntry{VersionID: last.VersionID, Timestamp: last.Timestamp, IsDeleteMarker: true},
+		versionEntry{VersionID: last.VersionID, Timestamp: removedAt, IsDeleteMarker: true, Removed: true},
+	)
+	sortVersionHistory(restored)
+	return restored
+}
+
+// AdvanceTo は一覧取得を開始した時刻までを確認済みとしてWatermarkを進めます
+func (w *Watermark) AdvanceTo(timestamp time.Time) {
+	if timestamp.After(w.Timestamp) {
+		w.Timestamp = timestamp
+	}
+
+	w.pruneSeen()
+}
+
+// Clone はWatermarkのコピーを作成します
+func (w *Watermark) Clone() *Watermark {
+	clone := NewWatermark(w.Bucket, w.Prefix, w.Timestamp, w.Overlap)
+	for key, entry := range w.Keys {
+		clone.Keys[key] = entry
+	}
+	for key, timestamp := range w.Seen {
+		clone.Seen[key] = timestamp
+	}
+	return clone
+}
+
+// pruneSeen は再取得期間より古い確認済みバージョンを破棄します
+// これらは次回の取得対象にならないため、重複除外に使われることはありません
+func (w *Watermark) pruneSeen() {
+	start := w.Start()
+	for key, timestamp := range w.Seen {
+		if timestamp.Before(start) {
+			delete(w.Seen, key)
+		}
+	}
+}
+
+// seenVersionKey は確認済みバージョンを識別するキーを返します
+func seenVersionKey(change ObjectChange) string {
+	return change.Key + "?versionId=" + change.VersionID
+}
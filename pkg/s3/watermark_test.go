@@ -0,0 +1,157 @@
+package s3
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatermarkFilter(t *testing.T) {
+	base := time.Date(2025, 6, 5, 10, 0, 0, 0, time.UTC)
+
+	w := NewWatermark("bucket", "prefix/", base, 10*time.Minute)
+	w.Advance([]ObjectChange{
+		{Key: "prefix/a", VersionID: "a1", ChangeType: ChangeTypeCreate, Timestamp: base.Add(-20 * time.Minute)},
+		{Key: "prefix/a", VersionID: "a2", ChangeType: ChangeTypeUpdate, Timestamp: base.Add(-5 * time.Minute)},
+		{Key: "prefix/b", VersionID: "b1", ChangeType: ChangeTypeDelete, Timestamp: base.Add(-1 * time.Minute), IsDeleteMarker: true},
+	})
+
+	if !w.Timestamp.Equal(base) {
+		t.Errorf("Timestamp = %v, want %v", w.Timestamp, base)
+	}
+	if !w.Start().Equal(base.Add(-10 * time.Minute)) {
+		t.Errorf("Start() = %v, want %v", w.Start(), base.Add(-10*time.Minute))
+	}
+
+	// 再取得期間より古い a1 は重複除外の対象から外れている
+	if _, ok := w.Seen["prefix/a?versionId=a1"]; ok {
+		t.Errorf("再取得期間より古いバージョンが残っています")
+	}
+
+	t.Run("取得済みの変更を除外し前回のバージョンに紐付ける", func(t *testing.T) {
+		got := w.Filter([]ObjectChange{
+			{Key: "prefix/a", VersionID: "a2", ChangeType: ChangeTypeUpdate, Timestamp: base.Add(-5 * time.Minute)},
+			{Key: "prefix/a", VersionID: "a3", ChangeType: ChangeTypeUpdate, Timestamp: base.Add(5 * time.Minute), PreviousVersionID: "a2"},
+		})
+
+		if len(got) != 1 || got[0].VersionID != "a3" {
+			t.Fatalf("Filter() = %+v, want only a3", got)
+		}
+		if got[0].PreviousVersionID != "a2" {
+			t.Errorf("PreviousVersionID = %s, want a2", got[0].PreviousVersionID)
+		}
+	})
+
+	t.Run("前回の最後の変更が削除マーカーの場合は紐付けない", func(t *testing.T) {
+		got := w.Filter([]ObjectChange{
+			{Key: "prefix/b", VersionID: "b2", ChangeType: ChangeTypeUpdate, Timestamp: base.Add(5 * time.Minute)},
+		})
+
+		if len(got) != 1 || got[0].PreviousVersionID != "" {
+			t.Errorf("Filter() = %+v, want b2 without previous version", got)
+		}
+	})
+
+	t.Run("バージョンIDがnullでも時刻が異なれば新しい変更とみなす", func(t *testing.T) {
+		w := NewWatermark("bucket", "", base, time.Hour)
+		w.Advance([]ObjectChange{{Key: "k", VersionID: "null", ChangeType: ChangeTypeCreate, Timestamp: base}})
+
+		got := w.Filter([]ObjectChange{
+			{Key: "k", VersionID: "null", ChangeType: ChangeTypeCreate, Timestamp: base},
+			{Key: "k", VersionID: "null", ChangeType: ChangeTypeUpdate, Timestamp: base.Add(time.Minute)},
+		})
+
+		if len(got) != 1 || !got[0].Timestamp.Equal(base.Add(time.Minute)) {
+			t.Errorf("Filter() = %+v, want only the newer change", got)
+		}
+	})
+}
+
+func TestWatermarkFromChangeListAndState(t *testing.T) {
+	dir := t.TempDir()
+	listPath := filepath.Join(dir, "previous.json")
+	windowEnd := time.Date(2025, 6, 5, 11, 0, 0, 0, time.UTC)
+	writeTestChangesFile(t, listPath, ChangesFileOptions{Header: &ChangeListHeader{
+		SourceBucket: "bucket",
+		SourcePrefix: "test/",
+		WindowStart:  time.Date(2025, 6, 5, 0, 0, 0, 0, time.UTC),
+		WindowEnd:    windowEnd,
+	}})
+
+	w, err := WatermarkFromChangeList(context.Background(), listPath, ChangesFileOptions{}, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("WatermarkFromChangeList() error = %v", err)
+	}
+
+	// 変更がなかった期間も一覧取得の開始時刻までは確認済みとみなす
+	if !w.Timestamp.Equal(windowEnd) {
+		t.Errorf("Timestamp = %v, want %v", w.Timestamp, windowEnd)
+	}
+	if err := w.Validate("bucket", "test/"); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if err := w.Validate("other-bucket", "test/"); err == nil {
+		t.Errorf("Validate() should fail for a different bucket")
+	}
+	if entry := w.Keys["test/file1.txt"]; entry.VersionID != "v2" || !entry.IsDeleteMarker {
+		t.Errorf("Keys[test/file1.txt] = %+v, want delete marker v2", entry)
+	}
+
+	statePath := filepath.Join(dir, "state.json")
+	if loaded, err := LoadWatermark(statePath); err != nil || loaded != nil {
+		t.Fatalf("LoadWatermark() for missing file = %v, %v, want nil, nil", loaded, err)
+	}
+
+	w.AdvanceTo(windowEnd.Add(time.Hour))
+	if err := w.Save(statePath); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded, err := LoadWatermark(statePath)
+	if err != nil {
+		t.Fatalf("LoadWatermark() error = %v", err)
+	}
+	if !loaded.Timestamp.Equal(windowEnd.Add(time.Hour)) || loaded.Overlap != 30*time.Minute {
+		t.Errorf("LoadWatermark() = %+v", loaded)
+	}
+	if len(loaded.Keys) != len(w.Keys) {
+		t.Errorf("Keys = %d, want %d", len(loaded.Keys), len(w.Keys))
+	}
+}
+
+func TestWatermarkDetectsRemovedDeleteMarker(t *testing.T) {
+	base := time.Date(2025, 6, 5, 10, 0, 0, 0, time.UTC)
+
+	// 前回の取得時点では削除マーカーが最新だった
+	w := NewWatermark("bucket", "", base.Add(time.Hour), 10*time.Minute)
+	w.Advance([]ObjectChange{
+		{Key: "k", VersionID: "v1", ChangeType: ChangeTypeCreate, Timestamp: base},
+		{Key: "k", VersionID: "d1", ChangeType: ChangeTypeDelete, Timestamp: base.Add(time.Minute), IsDeleteMarker: true},
+	})
+
+	// 今回の一覧では削除マーカーが消えている
+	history := w.restoreRemovedDeleteMarker("k", []versionEntry{{VersionID: "v1", Timestamp: base, IsLatest: true}})
+	changes := w.Filter(inferChanges("k", history, w.Start()))
+
+	if len(changes) != 1 || changes[0].ChangeType != ChangeTypeUndelete {
+		t.Fatalf("changes = %+v, want a single UNDELETE", changes)
+	}
+	if changes[0].PreviousVersionID != "v1" || !changes[0].Timestamp.Equal(w.Timestamp) {
+		t.Errorf("UNDELETE = %+v, want restore of v1 at %v", changes[0], w.Timestamp)
+	}
+
+	// 復元後は復元されたバージョンが最新として記録される
+	w.Advance(changes)
+	if entry := w.Keys["k"]; entry.VersionID != "v1" || entry.IsDeleteMarker {
+		t.Errorf("Keys[k] = %+v, want live v1", entry)
+	}
+
+	// 削除マーカーが残っている場合は何も補わない
+	kept := []versionEntry{{VersionID: "v1", Timestamp: base}, {VersionID: "d1", Timestamp: base.Add(time.Minute), IsDeleteMarker: true}}
+	deleted := NewWatermark("bucket", "", base.Add(time.Hour), 0)
+	deleted.Advance([]ObjectChange{{Key: "k", VersionID: "d1", ChangeType: ChangeTypeDelete, Timestamp: base.Add(time.Minute), IsDeleteMarker: true}})
+	if got := deleted.restoreRemovedDeleteMarker("k", kept); len(got) != len(kept) {
+		t.Errorf("restoreRemovedDeleteMarker() = %+v, want unchanged", got)
+	}
+}